@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/hex"
 	"flag"
@@ -14,6 +13,8 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/timartiny/v4vsv6/pkg/pacer"
+	"github.com/timartiny/v4vsv6/pkg/prober"
 )
 
 // If we want to add a response, do it here
@@ -23,7 +24,7 @@ type Result struct {
 	resp []byte
 }
 
-func sendDnsProbe(ip net.IP, timeout time.Duration, verbose bool) (Result, error) {
+func sendDnsProbe(pr *prober.Prober, ip net.IP, wait bool, verbose bool) (Result, error) {
 	m := &dns.Msg{
 		MsgHdr: dns.MsgHdr{
 			Authoritative:     false,
@@ -41,61 +42,39 @@ func sendDnsProbe(ip net.IP, timeout time.Duration, verbose bool) (Result, error
 	}
 	m.Id = dns.Id()
 
-	out, err := m.Pack()
-	if err != nil {
-		if verbose {
-			log.Printf("%s - Error creating UDP packet: %v\n", ip.String(), err)
+	if !wait {
+		out, err := pr.Send(m, ip)
+		if err != nil {
+			if verbose {
+				log.Printf("%s - Error sending: %v\n", ip.String(), err)
+			}
+			return Result{}, err
 		}
-		return Result{}, err
-	}
-	addr := ip.String() + ":53"
-	if ip.To16() != nil {
-		addr = "[" + ip.String() + "]:53"
-	}
-
-	conn, err := net.Dial("udp", addr)
-	if err != nil {
 		if verbose {
-			log.Printf("%s - Error creating UDP socket(?): %v\n", ip.String(), err)
+			log.Printf("Sent %s - %s\n", ip.String(), hex.EncodeToString(out))
 		}
-		return Result{}, err
-	}
-
-	defer conn.Close()
-
-	conn.Write(out)
-	if verbose {
-		log.Printf("Sent %s - %s\n", ip.String(), hex.EncodeToString(out))
-	}
-
-	if timeout == 0 {
 		return Result{ip: ip}, nil
 	}
 
-	conn.SetReadDeadline(time.Now().Add(timeout))
-	resp := make([]byte, 1024)
-	n, err := conn.Read(resp)
-	if err != nil {
-		if verbose {
-			log.Printf("%s - ReadErr: %v\n", ip.String(), err)
-		}
-		return Result{}, err
+	resp, err := pr.Exchange(m, ip)
+	if verbose && !resp.Sent.IsZero() {
+		log.Printf("Sent %s - %s\n", ip.String(), hex.EncodeToString(resp.Query))
 	}
-
-	var r dns.Msg
-	err = r.Unpack(resp)
 	if err != nil {
 		if verbose {
-			log.Printf("%s - ParseErr: %v\n", ip.String(), err)
+			log.Printf("%s - Error: %v\n", ip.String(), err)
+		}
+		if resp.Raw == nil {
+			return Result{}, err
 		}
 		return Result{
 			ip:   ip,
 			err:  err,
-			resp: resp[:n],
+			resp: resp.Raw,
 		}, err
 	}
 	if verbose {
-
+		r := resp.Msg
 		ans := "??"
 		if res, ok := dns.RcodeToString[r.Rcode]; ok {
 			ans = res
@@ -104,17 +83,17 @@ func sendDnsProbe(ip net.IP, timeout time.Duration, verbose bool) (Result, error
 			// Take first answer
 			ans += ": " + r.Answer[0].String()
 		}
-		log.Printf("%s - Response (%d bytes): %s - %s\n", ip.String(), n, hex.EncodeToString(resp[:n]), ans)
+		log.Printf("%s - Response (%d bytes): %s - %s\n", ip.String(), len(resp.Raw), hex.EncodeToString(resp.Raw), ans)
 		//fmt.Printf("%s\n", r.String())
 	}
 
 	return Result{
 		ip:   ip,
 		err:  nil,
-		resp: resp[:n]}, nil
+		resp: resp.Raw}, nil
 }
 
-func dnsWorker(timeout time.Duration, verbose bool, iplines <-chan string, wg *sync.WaitGroup) {
+func dnsWorker(pr *prober.Prober, wait bool, verbose bool, p *pacer.Pacer, iplines <-chan string, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for line := range iplines {
@@ -123,8 +102,10 @@ func dnsWorker(timeout time.Duration, verbose bool, iplines <-chan string, wg *s
 		v6 := net.ParseIP(ips[0])
 		//cc := ips[2]
 
-		v4res, err4 := sendDnsProbe(v4, timeout, verbose)
-		v6res, err6 := sendDnsProbe(v6, timeout, verbose)
+		p.Wait(v4)
+		v4res, err4 := sendDnsProbe(pr, v4, wait, verbose)
+		p.Wait(v6)
+		v6res, err6 := sendDnsProbe(pr, v6, wait, verbose)
 
 		if err4 != nil || err6 != nil || len(v4res.resp) <= 2 || len(v6res.resp) <= 2 {
 			log.Printf("RESULT %s - error\n", line)
@@ -152,27 +133,36 @@ func main() {
 	nWorkers := flag.Uint("workers", 50, "Number worker threads")
 	timeout := flag.Duration("timeout", 5*time.Second, "Duration to wait for DNS response")
 	verbose := flag.Bool("verbose", true, "Verbose prints sent/received DNS packets/info")
+	retries := flag.Int("retries", 0, "Number of times to resend a query that isn't answered within the timeout")
+	sockets := flag.Int("sockets", 4, "Number of UDP sockets per address family to send queries from")
+	var pacerConfig pacer.Config
+	pacerConfig.RegisterFlags(flag.CommandLine)
 
 	flag.Parse()
+	p := pacer.New(pacerConfig)
+	pr := prober.New(prober.Config{
+		Sockets: *sockets,
+		Timeout: *timeout,
+		Retries: *retries,
+	})
+	defer pr.Close()
 
 	jobs := make(chan string, *nWorkers*10)
 	var wg sync.WaitGroup
 
 	for w := uint(0); w < *nWorkers; w++ {
 		wg.Add(1)
-		go dnsWorker(*timeout, *verbose, jobs, &wg)
+		go dnsWorker(pr, *timeout > 0, *verbose, p, jobs, &wg)
 	}
 
 	nJobs := 0
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
+	err := pacer.ShuffleLines(os.Stdin, pacerConfig, func(line string) {
 		jobs <- line
 		nJobs += 1
-	}
+	})
 	close(jobs)
 
-	if err := scanner.Err(); err != nil {
+	if err != nil {
 		log.Println(err)
 	}
 
@@ -0,0 +1,319 @@
+package v4vsv6
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Names of the rules a Verdict can come from
+const (
+	RuleTLS           = "tls"
+	RuleBlockpageIP   = "blockpage-ip"
+	RuleRCode         = "rcode"
+	RuleASN           = "asn"
+	RuleHTTPBlockpage = "http-blockpage"
+	// RuleControlDomain is recorded for control domains, which are always
+	// marked censored so they are never counted with the test domains
+	RuleControlDomain = "control-domain"
+)
+
+// Verdict is a classifier's decision on whether a round was censored, along
+// with the rule that decided and why.
+type Verdict struct {
+	Censored bool
+	Rule     string
+	Reason   string
+}
+
+// Classifier decides whether the answers a resolver gave for a domain in one
+// round were censored.
+type Classifier interface {
+	// Classify returns the verdict on rr, a round of drr, or false if the
+	// classifier has no opinion on it.
+	Classify(drr *DomainResolverResult, rr *RoundResult) (Verdict, bool)
+}
+
+// ClassifyRound records c's verdict on rr, a round of drr. A round no
+// classifier has an opinion on is recorded as uncensored.
+func (drr *DomainResolverResult) ClassifyRound(rr *RoundResult, c Classifier) Verdict {
+	verdict, ok := c.Classify(drr, rr)
+	if !ok {
+		verdict = Verdict{}
+	}
+	rr.Censored = verdict.Censored
+	rr.Rule = verdict.Rule
+	rr.Reason = verdict.Reason
+
+	return verdict
+}
+
+// Classifiers combines classifiers: a round is censored if any of them say it
+// is, and the first to say so is the rule recorded. Otherwise the first
+// classifier with an opinion decides.
+type Classifiers []Classifier
+
+// Classify implements Classifier.
+func (cs Classifiers) Classify(drr *DomainResolverResult, rr *RoundResult) (Verdict, bool) {
+	var uncensored *Verdict
+	for _, c := range cs {
+		verdict, ok := c.Classify(drr, rr)
+		if !ok {
+			continue
+		}
+		if verdict.Censored {
+			return verdict, true
+		}
+		if uncensored == nil {
+			uncensored = &verdict
+		}
+	}
+	if uncensored == nil {
+		return Verdict{}, false
+	}
+
+	return *uncensored, true
+}
+
+// TLSValidity says a round is censored unless one of the addresses returned
+// presented a valid certificate for the domain, the original rule of the
+// study.
+type TLSValidity struct{}
+
+// Classify implements Classifier.
+func (TLSValidity) Classify(drr *DomainResolverResult, rr *RoundResult) (Verdict, bool) {
+	if len(rr.Results) == 0 || rr.Results[0] == nil {
+		return Verdict{Censored: true, Rule: RuleTLS, Reason: "no addresses returned"}, true
+	}
+	var failures []string
+	for _, ar := range rr.Results {
+		if ar != nil && ar.SupportsTLS {
+			return Verdict{Rule: RuleTLS, Reason: ar.IP + " supports TLS"}, true
+		}
+		if ar != nil && ar.TLS != nil && ar.TLS.Failure != "" {
+			failures = append(failures, ar.IP+": "+ar.TLS.Failure)
+		}
+	}
+	reason := "no address supports TLS"
+	if len(failures) > 0 {
+		reason += " (" + strings.Join(failures, ", ") + ")"
+	}
+
+	return Verdict{Censored: true, Rule: RuleTLS, Reason: reason}, true
+}
+
+// BlockpageIPs says a round is censored if any address returned is a known
+// blockpage server. It has no opinion on other rounds.
+type BlockpageIPs struct {
+	nets []*net.IPNet
+}
+
+// ParseBlockpageIPs reads a list of blockpage addresses or prefixes, one per
+// line. Blank lines and lines starting with # are ignored.
+func ParseBlockpageIPs(r io.Reader) (*BlockpageIPs, error) {
+	ret := new(BlockpageIPs)
+	scanner := bufio.NewScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "/") {
+			ip := net.ParseIP(line)
+			if ip == nil {
+				return nil, fmt.Errorf("line %d: invalid address %q", lineNum, line)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			ret.nets = append(ret.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		ret.nets = append(ret.nets, ipNet)
+	}
+
+	return ret, scanner.Err()
+}
+
+// ReadBlockpageIPs reads a list of blockpage addresses from the file at path.
+func ReadBlockpageIPs(path string) (*BlockpageIPs, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret, err := ParseBlockpageIPs(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return ret, nil
+}
+
+// Contains reports whether ip is a blockpage address.
+func (b *BlockpageIPs) Contains(ip net.IP) bool {
+	for _, ipNet := range b.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Classify implements Classifier.
+func (b *BlockpageIPs) Classify(drr *DomainResolverResult, rr *RoundResult) (Verdict, bool) {
+	for _, ar := range rr.Results {
+		if ar != nil && b.Contains(net.ParseIP(ar.IP)) {
+			return Verdict{
+				Censored: true,
+				Rule:     RuleBlockpageIP,
+				Reason:   ar.IP + " is a known blockpage address",
+			}, true
+		}
+	}
+
+	return Verdict{}, false
+}
+
+// RCodes says a round is censored if the resolver answered with one of the
+// listed statuses, such as NXDOMAIN for a domain known to exist. It has no
+// opinion on other rounds.
+type RCodes map[string]bool
+
+// DefaultRCodes are the statuses treated as censorship when none are given.
+var DefaultRCodes = RCodes{"NXDOMAIN": true}
+
+// Classify implements Classifier.
+func (rc RCodes) Classify(drr *DomainResolverResult, rr *RoundResult) (Verdict, bool) {
+	if rc[rr.Status] {
+		return Verdict{
+			Censored: true,
+			Rule:     RuleRCode,
+			Reason:   "resolver answered " + rr.Status,
+		}, true
+	}
+
+	return Verdict{}, false
+}
+
+// ASNConsistency says a round is censored if none of the addresses returned
+// are in an ASN the domain resolved into through the control resolvers, and
+// uncensored if any are. It has no opinion on domains without control
+// answers, or rounds where no address has a known ASN.
+type ASNConsistency struct {
+	// Lookup returns the ASN of an address, and false if it isn't known.
+	Lookup func(net.IP) (uint, bool)
+	// Control maps each domain to the ASNs of the control resolvers'
+	// answers for it.
+	Control map[string]map[uint]bool
+}
+
+// Classify implements Classifier.
+func (a ASNConsistency) Classify(drr *DomainResolverResult, rr *RoundResult) (Verdict, bool) {
+	control, ok := a.Control[drr.Domain]
+	if !ok {
+		return Verdict{}, false
+	}
+	var known []string
+	for _, ar := range rr.Results {
+		if ar == nil {
+			continue
+		}
+		asn, ok := a.Lookup(net.ParseIP(ar.IP))
+		if !ok {
+			continue
+		}
+		if control[asn] {
+			return Verdict{
+				Rule:   RuleASN,
+				Reason: fmt.Sprintf("%s is in AS%d as the control answers are", ar.IP, asn),
+			}, true
+		}
+		known = append(known, fmt.Sprintf("%s (AS%d)", ar.IP, asn))
+	}
+	if len(known) == 0 {
+		return Verdict{}, false
+	}
+
+	return Verdict{
+		Censored: true,
+		Rule:     RuleASN,
+		Reason:   "no control answer shares an ASN with " + strings.Join(known, ", "),
+	}, true
+}
+
+// HTTPBlockpage says a round is censored if the HTTP response of any address
+// returned matched a blockpage fingerprint. It has no opinion on other rounds.
+type HTTPBlockpage struct{}
+
+// Classify implements Classifier.
+func (HTTPBlockpage) Classify(drr *DomainResolverResult, rr *RoundResult) (Verdict, bool) {
+	for _, ar := range rr.Results {
+		if ar != nil && ar.Blockpage != nil {
+			return Verdict{
+				Censored: true,
+				Rule:     RuleHTTPBlockpage,
+				Reason:   ar.IP + " served the " + ar.Blockpage.Fingerprint + " blockpage",
+			}, true
+		}
+	}
+
+	return Verdict{}, false
+}
+
+// ClassifierOptions hold what the classifiers named in NewClassifiers need.
+type ClassifierOptions struct {
+	BlockpageIPs *BlockpageIPs
+	RCodes       RCodes
+	ASN          *ASNConsistency
+}
+
+// NewClassifiers returns the named classifiers, in order, combined. With no
+// names the TLS validity rule is used alone.
+func NewClassifiers(names []string, opts ClassifierOptions) (Classifiers, error) {
+	if len(names) == 0 {
+		names = []string{RuleTLS}
+	}
+	var ret Classifiers
+	for _, name := range names {
+		switch name {
+		case RuleTLS:
+			ret = append(ret, TLSValidity{})
+		case RuleBlockpageIP:
+			if opts.BlockpageIPs == nil {
+				return nil, fmt.Errorf("the %s classifier needs a list of blockpage addresses", name)
+			}
+			ret = append(ret, opts.BlockpageIPs)
+		case RuleRCode:
+			rcodes := opts.RCodes
+			if len(rcodes) == 0 {
+				rcodes = DefaultRCodes
+			}
+			ret = append(ret, rcodes)
+		case RuleASN:
+			if opts.ASN == nil {
+				return nil, fmt.Errorf("the %s classifier needs ASN data and control answers", name)
+			}
+			ret = append(ret, *opts.ASN)
+		case RuleHTTPBlockpage:
+			ret = append(ret, HTTPBlockpage{})
+		default:
+			return nil, fmt.Errorf("unknown classifier: %s", name)
+		}
+	}
+
+	return ret, nil
+}
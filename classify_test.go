@@ -0,0 +1,138 @@
+package v4vsv6
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestClassifiers checks that a censored verdict from any classifier wins, and
+// that otherwise the first classifier with an opinion decides
+func TestClassifiers(t *testing.T) {
+	blockpages, err := ParseBlockpageIPs(strings.NewReader(
+		"# known blockpages\n\n10.10.34.34\n192.0.2.0/24\n",
+	))
+	if err != nil {
+		t.Fatalf("ParseBlockpageIPs: %v", err)
+	}
+	cs, err := NewClassifiers(
+		[]string{RuleRCode, RuleBlockpageIP, RuleTLS},
+		ClassifierOptions{BlockpageIPs: blockpages},
+	)
+	if err != nil {
+		t.Fatalf("NewClassifiers: %v", err)
+	}
+
+	drr := &DomainResolverResult{Domain: "example.test"}
+	for _, tc := range []struct {
+		name     string
+		round    RoundResult
+		censored bool
+		rule     string
+	}{
+		{
+			name:     "nxdomain",
+			round:    RoundResult{Status: "NXDOMAIN"},
+			censored: true,
+			rule:     RuleRCode,
+		},
+		{
+			name: "blockpage address",
+			round: RoundResult{Status: "NOERROR", Results: []*AddressResult{
+				{IP: "192.0.2.7", SupportsTLS: true},
+			}},
+			censored: true,
+			rule:     RuleBlockpageIP,
+		},
+		{
+			name: "valid certificate",
+			round: RoundResult{Status: "NOERROR", Results: []*AddressResult{
+				{IP: "198.51.100.1", SupportsTLS: true},
+			}},
+			censored: false,
+			rule:     RuleTLS,
+		},
+		{
+			name: "no valid certificate",
+			round: RoundResult{Status: "NOERROR", Results: []*AddressResult{
+				{IP: "198.51.100.1"},
+			}},
+			censored: true,
+			rule:     RuleTLS,
+		},
+	} {
+		rr := tc.round
+		verdict := drr.ClassifyRound(&rr, cs)
+		if verdict.Censored != tc.censored || rr.Censored != tc.censored {
+			t.Errorf("%s: censored is %v, should be %v", tc.name, rr.Censored, tc.censored)
+		}
+		if rr.Rule != tc.rule {
+			t.Errorf("%s: rule is %q, should be %q", tc.name, rr.Rule, tc.rule)
+		}
+		if rr.Reason == "" {
+			t.Errorf("%s: no reason recorded", tc.name)
+		}
+	}
+}
+
+// TestASNConsistency checks rounds are judged against the ASNs of the control
+// answers, and that the classifier stays quiet when it knows nothing
+func TestASNConsistency(t *testing.T) {
+	asns := map[string]uint{"198.51.100.1": 64500, "203.0.113.1": 64501}
+	a := ASNConsistency{
+		Lookup: func(ip net.IP) (uint, bool) {
+			asn, ok := asns[ip.String()]
+			return asn, ok
+		},
+		Control: map[string]map[uint]bool{"example.test": {64500: true}},
+	}
+
+	round := func(ips ...string) *RoundResult {
+		rr := new(RoundResult)
+		for _, ip := range ips {
+			rr.Results = append(rr.Results, &AddressResult{IP: ip})
+		}
+		return rr
+	}
+	example := &DomainResolverResult{Domain: "example.test"}
+
+	verdict, ok := a.Classify(example, round("203.0.113.1", "198.51.100.1"))
+	if !ok || verdict.Censored {
+		t.Errorf("an answer in a control ASN should be uncensored, got %+v, %v", verdict, ok)
+	}
+	verdict, ok = a.Classify(example, round("203.0.113.1"))
+	if !ok || !verdict.Censored {
+		t.Errorf("answers outside the control ASNs should be censored, got %+v, %v", verdict, ok)
+	}
+	if _, ok = a.Classify(example, round("192.0.2.1")); ok {
+		t.Errorf("answers with no known ASN should have no opinion")
+	}
+	other := &DomainResolverResult{Domain: "other.test"}
+	if _, ok = a.Classify(other, round("203.0.113.1")); ok {
+		t.Errorf("domains without control answers should have no opinion")
+	}
+}
+
+// TestNewClassifiers checks classifiers missing their data, and unknown
+// classifiers, are rejected
+func TestNewClassifiers(t *testing.T) {
+	cs, err := NewClassifiers(nil, ClassifierOptions{})
+	if err != nil || len(cs) != 1 {
+		t.Fatalf("no names should give the TLS rule alone, got %v, %v", cs, err)
+	}
+	if _, ok := cs[0].(TLSValidity); !ok {
+		t.Errorf("no names should give the TLS rule, got %T", cs[0])
+	}
+	for _, names := range [][]string{
+		{RuleBlockpageIP},
+		{RuleASN},
+		{"nonsense"},
+	} {
+		if _, err := NewClassifiers(names, ClassifierOptions{}); err == nil {
+			t.Errorf("NewClassifiers(%v) should fail", names)
+		}
+	}
+	if _, err := ParseBlockpageIPs(strings.NewReader("not-an-ip\n")); err == nil {
+		t.Errorf("ParseBlockpageIPs should reject invalid addresses")
+	}
+}
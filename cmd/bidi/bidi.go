@@ -3,18 +3,25 @@ package main
 import (
 	"bufio"
 	"encoding/hex"
+	"encoding/json"
 	"flag"
+	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 
 	"github.com/miekg/dns"
+	"github.com/timartiny/v4vsv6/pkg/gen"
+	"github.com/timartiny/v4vsv6/pkg/pacer"
+	"github.com/timartiny/v4vsv6/pkg/prober"
+	"github.com/timartiny/v4vsv6/pkg/results"
+	"github.com/timartiny/v4vsv6/pkg/ttlsweep"
 )
 
 // If we want to add a response, do it here
@@ -24,83 +31,34 @@ type Result struct {
 	resp []byte
 }
 
-func getUdpPayload(packet gopacket.Packet) (out []byte) {
-	udpLayer := packet.Layer(layers.LayerTypeUDP)
-	if udpLayer != nil {
-		udp, _ := udpLayer.(*layers.UDP)
-		return udp.Payload
-	}
-	return
-}
-
-func handlePacket(packet gopacket.Packet) {
-
-	/*
-		decoded := []gopacket.LayerType{}
-		if err := parser.DecodeLayers(packet.Data(), &deocded); err != nil {
-
-			fmt.Fprintf(os.Stderr, "Could not decode layers: %v\n", err)
-			return
-		}*/
-	udpLayer := packet.Layer(layers.LayerTypeUDP)
-	if udpLayer == nil {
-		return
-	}
-	udp, _ := udpLayer.(*layers.UDP)
+// handlePcap captures the packets matching filter on iface, handing each to
+// handle, until stop is closed.
+func handlePcap(iface, filter string, handle func(gopacket.Packet), stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
 
-	dnsLayer := packet.Layer(layers.LayerTypeDNS)
-	if dnsLayer == nil {
-		return
+	h, err := pcap.OpenLive(iface, 1600, true, pcap.BlockForever)
+	if err != nil {
+		panic(err)
 	}
-	dns, _ := dnsLayer.(*layers.DNS)
-	questions := dns.Questions
-	answers := dns.Answers
-	if len(questions) < 1 {
-		return
+	if err := h.SetBPFFilter(filter); err != nil { // optional
+		panic(err)
 	}
-
-	var ipAddr net.IP
-	ipLayer := packet.Layer(layers.LayerTypeIPv4)
-	if ipLayer == nil {
-		ip6Layer := packet.Layer(layers.LayerTypeIPv6)
-		if ip6Layer == nil {
+	packets := gopacket.NewPacketSource(h, h.LinkType()).Packets()
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				return
+			}
+			handle(packet)
+		case <-stop:
 			return
 		}
-		ip6, _ := ip6Layer.(*layers.IPv6)
-		ipAddr = ip6.SrcIP
-	} else {
-		ip4, _ := ipLayer.(*layers.IPv4)
-		ipAddr = ip4.SrcIP
 	}
-	log.Printf("RESULT %s %s, %s %d answers: %s\n",
-		ipAddr, questions[0].Name, dns.ResponseCode, len(answers), hex.EncodeToString(udp.Payload))
 }
 
-func handlePcap(iface string) {
-
-	if handle, err := pcap.OpenLive(iface, 1600, true, pcap.BlockForever); err != nil {
-		panic(err)
-	} else if err := handle.SetBPFFilter("udp src port 53"); err != nil { // optional
-		panic(err)
-	} else {
-		/*
-			var eth layers.Ethernet
-			var ip4 layers.IPv4
-			var ip6 layers.IPv6
-			var udp layers.UDP
-			var dns layers.DNS
-			parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &eth, &ip4, &ip6, &udp, &dns)
-
-		*/
-		packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-		for packet := range packetSource.Packets() {
-			handlePacket(packet)
-		}
-	}
-
-}
-
-func sendDnsProbe(ip net.IP, name string, qType uint16, lAddr string, timeout time.Duration, verbose bool, shouldRead bool) (Result, error) {
+// newQuery returns a recursive query for name
+func newQuery(name string, qType uint16) *dns.Msg {
 	m := &dns.Msg{
 		MsgHdr: dns.MsgHdr{
 			Authoritative:     false,
@@ -118,89 +76,65 @@ func sendDnsProbe(ip net.IP, name string, qType uint16, lAddr string, timeout ti
 	}
 	m.Id = dns.Id()
 
-	out, err := m.Pack()
-	if err != nil {
-		if verbose {
-			log.Printf("%s - Error creating UDP packet: %v\n", ip.String(), err)
-		}
-		return Result{}, err
-	}
-	addr := ip.String() + ":53"
-	if ip.To16() != nil {
-		addr = "[" + ip.String() + "]:53"
-	}
-
-	//conn, err := net.Dial("udp", addr)
-	var d net.Dialer
-	if lAddr != "" {
-		d.LocalAddr, _ = net.ResolveUDPAddr("ip", lAddr)
-	}
-	conn, err := d.Dial("udp", addr)
-	if err != nil {
-		if verbose {
-			log.Printf("%s - Error creating UDP socket(?): %v\n", ip.String(), err)
-		}
-		return Result{}, err
-	}
-
-	defer conn.Close()
-
-	conn.Write(out)
-	if verbose {
-		log.Printf("Sent %s - %s\n", ip.String(), hex.EncodeToString(out))
-	}
+	return m
+}
 
-	if timeout == 0 {
-		return Result{ip: ip}, nil
-	}
+func sendDnsProbe(pr *prober.Prober, ip net.IP, name string, qType uint16, timeout time.Duration, verbose bool, shouldRead bool) (Result, error) {
+	m := newQuery(name, qType)
 
-	if shouldRead {
-		conn.SetReadDeadline(time.Now().Add(timeout))
-		resp := make([]byte, 1024)
-		n, err := conn.Read(resp)
+	if timeout == 0 || !shouldRead {
+		// responses are picked up by the pcap, so there's nothing to wait for
+		out, err := pr.Send(m, ip)
 		if err != nil {
 			if verbose {
-				log.Printf("%s - ReadErr: %v\n", ip.String(), err)
+				log.Printf("%s - Error sending: %v\n", ip.String(), err)
 			}
 			return Result{}, err
 		}
-
-		var r dns.Msg
-		err = r.Unpack(resp)
-		if err != nil {
-			if verbose {
-				log.Printf("%s - ParseErr: %v\n", ip.String(), err)
-			}
-			return Result{
-				ip:   ip,
-				err:  err,
-				resp: resp[:n],
-			}, err
-		}
 		if verbose {
-
-			ans := "??"
-			if res, ok := dns.RcodeToString[r.Rcode]; ok {
-				ans = res
-			}
-			if len(r.Answer) > 0 {
-				// Take first answer
-				ans += ": " + r.Answer[0].String()
-			}
-			log.Printf("%s - Response (%d bytes): %s - %s\n", ip.String(), n, hex.EncodeToString(resp[:n]), ans)
-			//fmt.Printf("%s\n", r.String())
+			log.Printf("Sent %s - %s\n", ip.String(), hex.EncodeToString(out))
 		}
+		return Result{ip: ip}, nil
+	}
 
+	resp, err := pr.Exchange(m, ip)
+	if verbose && !resp.Sent.IsZero() {
+		log.Printf("Sent %s - %s\n", ip.String(), hex.EncodeToString(resp.Query))
+	}
+	if err != nil {
+		if verbose {
+			log.Printf("%s - Error: %v\n", ip.String(), err)
+		}
+		if resp.Raw == nil {
+			return Result{}, err
+		}
 		return Result{
 			ip:   ip,
-			err:  nil,
-			resp: resp[:n]}, nil
-	} else {
-		return Result{ip: ip}, nil
+			err:  err,
+			resp: resp.Raw,
+		}, err
 	}
+	if verbose {
+		r := resp.Msg
+		ans := "??"
+		if res, ok := dns.RcodeToString[r.Rcode]; ok {
+			ans = res
+		}
+		if len(r.Answer) > 0 {
+			// Take first answer
+			ans += ": " + r.Answer[0].String()
+		}
+		log.Printf("%s - Response (%d bytes): %s - %s\n", ip.String(), len(resp.Raw), hex.EncodeToString(resp.Raw), ans)
+		//fmt.Printf("%s\n", r.String())
+	}
+
+	return Result{
+		ip:   ip,
+		err:  nil,
+		resp: resp.Raw}, nil
 }
 
-func dnsWorker(wait time.Duration, verbose bool, shouldRead bool, qType uint16, lAddr string, ips <-chan string, domains []string, wg *sync.WaitGroup) {
+func dnsWorker(pr *prober.Prober, wait time.Duration, verbose bool, shouldRead bool, qTypes []uint16, p *pacer.Pacer, pairs *pairing, ips <-chan string, domains []string, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for ip := range ips {
@@ -208,18 +142,29 @@ func dnsWorker(wait time.Duration, verbose bool, shouldRead bool, qType uint16,
 		if verbose {
 			log.Printf("Sending to %v...\n", addr)
 		}
+		// non-resolvers get the same queries at the same time as their
+		// target, so they see the same path
+		dests := []net.IP{addr}
+		if pairs != nil && addr != nil {
+			dests = append(dests, pairs.generate(addr)...)
+		}
 
 		for _, domain := range domains {
-			_, err := sendDnsProbe(addr, domain, qType, lAddr, wait, verbose, shouldRead)
-			if shouldRead {
-				// We expect a result (TODO)
-				if err != nil {
-					log.Printf("Result %s,%s - error: %v\n", ip, domain, err)
-				} else {
-					log.Printf("RESULT %s,%s\n", ip, domain)
+			for _, dest := range dests {
+				for _, qType := range qTypes {
+					p.Wait(dest)
+					_, err := sendDnsProbe(pr, dest, domain, qType, wait, verbose, shouldRead)
+					if shouldRead {
+						// We expect a result (TODO)
+						if err != nil {
+							log.Printf("Result %s,%s - error: %v\n", dest, domain, err)
+						} else {
+							log.Printf("RESULT %s,%s\n", dest, domain)
+						}
+					} else {
+						// No results in this thread; pcap gets results, we just send
+					}
 				}
-			} else {
-				// No results in this thread; pcap gets results, we just send
 			}
 			// Wait here???
 			if !shouldRead {
@@ -229,6 +174,24 @@ func dnsWorker(wait time.Duration, verbose bool, shouldRead bool, qType uint16,
 	}
 }
 
+// fromSource reports whether the target at the start of line can be sent to
+// from source, which is nil to send from the default address of each family.
+// Targets of the other family are logged and skipped, rather than sent from
+// an address that isn't the one asked for.
+func fromSource(source net.IP, line string) bool {
+	fields := strings.Fields(line)
+	if source == nil || len(fields) == 0 {
+		return true
+	}
+	target := net.ParseIP(fields[0])
+	if target != nil && (target.To4() == nil) != (source.To4() == nil) {
+		log.Printf("Skipping %s, it is not the same address family as -laddr %s\n", target, source)
+		return false
+	}
+
+	return true
+}
+
 func getDomains(fname string) ([]string, error) {
 
 	f, err := os.Open(fname)
@@ -254,10 +217,112 @@ func main() {
 	iface := flag.String("iface", "eth0", "Interface to listen on")
 	qTypeUint := flag.Uint("qtype", 1, "Type of Query to send (1 = A / 28 = AAAA)")
 	lAddr := flag.String("laddr", "", "Local address to send packets from - unset uses default interface.")
+	sockets := flag.Int("sockets", 4, "Number of UDP sockets per address family to send packets from")
+	pcapFiles := flag.String("pcap", "", "Comma separated PCAP or PCAPNG files captured during a run to replay instead of sending probes")
+	outFile := flag.String("output", "", "File to write a JSON object for each response to, in addition to the RESULT lines")
+	injectionsFile := flag.String("injections", "", "File to write a JSON object for each query with more than one response to, flagging those that look injected")
+	nonResolvers := flag.Int("non-resolvers", 0, "Number of addresses not running DNS to generate in each target's allocation and probe alongside it with both A and AAAA queries, 0 to only probe the targets")
+	geoliteDir := flag.String("geolite", "./GeoLite2/GeoLite2-Country/", "Directory with the GeoLite2-Country CSVs, for -non-resolvers")
+	filterLiveFile := flag.String("filter", "", "File containing list of addresses known to respond on UDP 53, never used as non-resolvers. Required with -non-resolvers")
+	excludeFiles := flag.String("exclude", "", "Comma separated ZMap blocklist files of subnets to never use as non-resolvers")
+	bogons := flag.Bool("bogons", true, "Never use addresses in IANA special-purpose (bogon) ranges as non-resolvers")
+	pairsFile := flag.String("pairs", "", "With -non-resolvers, file to write the \"non-resolver target CC\" pairs to. With -pcap, file of pairs from that run to report responses from non-resolvers with")
+	reportFile := flag.String("report", "", "File to write a JSON object to for each domain that got responses from non-resolvers, counted separately for v4 and v6 paths")
+	ttlSweep := flag.Bool("ttl-sweep", false, "Resend each domain to each target with every IP TTL (IPv6 hop limit) up to -max-ttl to locate the hop injection starts at. Input lines are \"target [domain]\"")
+	maxTTL := flag.Int("max-ttl", ttlsweep.DefaultMaxTTL, "Largest TTL to send with for -ttl-sweep")
+	tracesFile := flag.String("traces", "", "File to write a JSON object to for each -ttl-sweep trace")
+	var pacerConfig pacer.Config
+	pacerConfig.RegisterFlags(flag.CommandLine)
 
 	flag.Parse()
 
-	var qType = uint16(*qTypeUint)
+	var encoders [4]*json.Encoder
+	for i, path := range []string{*outFile, *injectionsFile, *reportFile, *tracesFile} {
+		if path == "" {
+			continue
+		}
+		w, err := results.Create(path)
+		if err != nil {
+			log.Printf("Error creating %s: %v\n", path, err)
+			return
+		}
+		defer w.Close()
+		encoders[i] = json.NewEncoder(w)
+	}
+	c := newCollector(encoders[0], encoders[1])
+	c.reportOut = encoders[2]
+
+	if *pcapFiles != "" {
+		if *pairsFile != "" {
+			pairs, err := loadPairs(*pairsFile)
+			if err != nil {
+				log.Printf("Error reading %s: %v\n", *pairsFile, err)
+				return
+			}
+			c.report = newNonResolverReport(pairs)
+		}
+		if err := replayPcaps(strings.Split(*pcapFiles, ","), c); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	p := pacer.New(pacerConfig)
+
+	var qTypes = []uint16{uint16(*qTypeUint)}
+
+	var pairs *pairing
+	if *nonResolvers > 0 {
+		// without the list, generated addresses could be resolvers, and
+		// their answers would be blamed on the path
+		if *filterLiveFile == "" {
+			log.Println("-filter is required with -non-resolvers")
+			return
+		}
+		ccMaps, err := gen.BuildCountryCodeMaps(strings.TrimSuffix(*geoliteDir, "/") + "/")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		responding, err := gen.ParseRespongindAddrs(*filterLiveFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		exclusions, err := gen.LoadExclusions(strings.Split(*excludeFiles, ","), *bogons)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		var pairsOut io.Writer
+		if *pairsFile != "" {
+			f, err := os.Create(*pairsFile)
+			if err != nil {
+				log.Printf("Error creating %s: %v\n", *pairsFile, err)
+				return
+			}
+			defer f.Close()
+			pairsOut = f
+		}
+		pairs = newPairing(ccMaps, responding, exclusions, *nonResolvers, pairsOut)
+		c.report = newNonResolverReport(pairs)
+		qTypes = []uint16{dns.TypeA, dns.TypeAAAA}
+	}
+
+	proberConfig := prober.Config{Sockets: *sockets, Timeout: *wait}
+	var source net.IP
+	if *lAddr != "" {
+		source = net.ParseIP(*lAddr)
+		if source == nil {
+			log.Printf("Invalid local address: %s\n", *lAddr)
+			return
+		}
+		if source.To4() != nil {
+			proberConfig.SourceV4 = source
+		} else {
+			proberConfig.SourceV6 = source
+		}
+	}
 
 	// Parse domains
 	domains, err := getDomains(*domainf)
@@ -270,25 +335,64 @@ func main() {
 	ips := make(chan string, *nWorkers*10)
 	var wg sync.WaitGroup
 
+	if *ttlSweep {
+		tracker := ttlsweep.NewTracker()
+		for w := uint(0); w < *nWorkers; w++ {
+			wg.Add(1)
+			go sweepWorker(proberConfig.SourceV4, proberConfig.SourceV6, *maxTTL, *wait, *verbose, qTypes, p, tracker, ips, domains, &wg)
+		}
+
+		stopCapture := make(chan struct{})
+		captureDone := make(chan struct{})
+		go handlePcap(*iface, sweepFilter, func(packet gopacket.Packet) {
+			tracker.Packet(packet)
+		}, stopCapture, captureDone)
+
+		err = pacer.ShuffleLines(os.Stdin, pacerConfig, func(line string) {
+			if fromSource(source, line) {
+				ips <- line
+			}
+		})
+		close(ips)
+		if err != nil {
+			log.Println(err)
+		}
+
+		wg.Wait()
+		close(stopCapture)
+		<-captureDone
+		writeTraces(tracker, encoders[3])
+		return
+	}
+
+	pr := prober.New(proberConfig)
+	defer pr.Close()
+
 	for w := uint(0); w < *nWorkers; w++ {
 		wg.Add(1)
-		go dnsWorker(*wait, *verbose, false, qType, *lAddr, ips, domains, &wg)
+		go dnsWorker(pr, *wait, *verbose, false, qTypes, p, pairs, ips, domains, &wg)
 	}
 
-	go handlePcap(*iface)
+	stopCapture := make(chan struct{})
+	captureDone := make(chan struct{})
+	go handlePcap(*iface, liveFilter, c.packet, stopCapture, captureDone)
 
 	nJobs := 0
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
+	err = pacer.ShuffleLines(os.Stdin, pacerConfig, func(line string) {
+		if !fromSource(source, line) {
+			return
+		}
 		ips <- line
 		nJobs += 1
-	}
+	})
 	close(ips)
 
-	if err := scanner.Err(); err != nil {
+	if err != nil {
 		log.Println(err)
 	}
 
 	wg.Wait()
+	close(stopCapture)
+	<-captureDone
+	c.finish()
 }
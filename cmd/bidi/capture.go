@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/timartiny/v4vsv6/pkg/dnspcap"
+)
+
+// liveFilter captures the queries sent as well as their responses, so they
+// can be matched as they are when replaying a capture
+const liveFilter = "udp port 53"
+
+// collector turns captured packets into results, for both the live capture
+// and replayed ones
+type collector struct {
+	matcher *dnspcap.Matcher
+	grouper *dnspcap.Grouper
+	// out gets every response, and injections every query with more than
+	// one response, if they're set
+	out        *json.Encoder
+	injections *json.Encoder
+	// report, if set, collects the responses from non-resolvers, written to
+	// reportOut at the end
+	report     *nonResolverReport
+	reportOut  *json.Encoder
+	duplicated int
+	injected   int
+}
+
+func newCollector(out, injections *json.Encoder) *collector {
+	return &collector{
+		matcher:    dnspcap.NewMatcher(dnspcap.DefaultWindow),
+		grouper:    dnspcap.NewGrouper(dnspcap.DefaultWindow),
+		out:        out,
+		injections: injections,
+	}
+}
+
+// packet handles the next captured packet
+func (c *collector) packet(packet gopacket.Packet) {
+	if r := c.matcher.Packet(packet); r != nil {
+		c.result(r)
+	}
+}
+
+// result handles the next response
+func (c *collector) result(r *dnspcap.Result) {
+	if r.Name != "" && r.Error == "" {
+		log.Printf("RESULT %s %s, %s %d answers: %s\n",
+			r.Resolver, r.Name, layers.DNSResponseCode(r.RCode), len(r.Answers), r.Response)
+	}
+	if c.out != nil {
+		if err := c.out.Encode(r); err != nil {
+			log.Printf("Error writing result: %v\n", err)
+		}
+	}
+	for _, group := range c.grouper.Add(r) {
+		c.group(group)
+	}
+}
+
+// group handles the responses to a query once no more are expected
+func (c *collector) group(g *dnspcap.Group) {
+	if c.report != nil {
+		// added once grouped, so they're marked if forged
+		for _, r := range g.Responses {
+			c.report.add(r)
+		}
+	}
+	if len(g.Responses) < 2 {
+		return
+	}
+	c.duplicated++
+	if g.Injected {
+		c.injected++
+		var answers []string
+		for _, r := range g.Responses {
+			answer := r.Status
+			if len(r.Answers) > 0 {
+				answer += " " + r.Answers[0].Answer
+			}
+			answers = append(answers, answer)
+		}
+		log.Printf("INJECTED %s %s, %d responses (%s): %s\n",
+			g.Resolver, g.Name, len(g.Responses), strings.Join(g.Reasons, ", "), strings.Join(answers, " / "))
+	}
+	if c.injections != nil {
+		if err := c.injections.Encode(g); err != nil {
+			log.Printf("Error writing injection: %v\n", err)
+		}
+	}
+}
+
+// finish handles the responses still waiting on more, and prints a summary
+func (c *collector) finish() {
+	c.matcher.Flush()
+	for _, group := range c.grouper.Flush() {
+		c.group(group)
+	}
+	stats := c.matcher.Stats()
+	log.Printf(
+		"Read %d packets: %d queries, %d responses (%d matched to a query), %d queries unanswered\n",
+		stats.Packets,
+		stats.Queries,
+		stats.Responses,
+		stats.Matched,
+		stats.Unanswered,
+	)
+	log.Printf(
+		"%d queries had more than one response, %d of them look injected\n",
+		c.duplicated,
+		c.injected,
+	)
+	if c.report != nil {
+		c.report.write(c.reportOut)
+	}
+}
+
+// replayPcaps reads captures taken during a run instead of sending probes,
+// pairing queries with their responses and emitting the same results as the
+// live capture
+func replayPcaps(paths []string, c *collector) error {
+	for _, path := range paths {
+		log.Printf("Replaying %s\n", path)
+		if err := c.matcher.ReadFile(path, c.result); err != nil {
+			return err
+		}
+	}
+	c.finish()
+
+	return nil
+}
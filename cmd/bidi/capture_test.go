@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6/pkg/dnspcap"
+)
+
+// udpFrame builds an Ethernet frame carrying payload from src:sport to
+// dst:dport
+func udpFrame(t *testing.T, src, dst net.IP, sport, dport uint16, payload []byte) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: layers.UDPPort(dport)}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(
+		buf,
+		gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		eth, ip, udp, gopacket.Payload(payload),
+	)
+	require.NoError(t, err)
+
+	return buf.Bytes()
+}
+
+func packMsg(t *testing.T, id uint16, name, answer string, response bool) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	if response {
+		m.SetReply(m.Copy())
+		if answer != "" {
+			rr, err := dns.NewRR(dns.Fqdn(name) + " 60 IN A " + answer)
+			require.NoError(t, err)
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	m.Id = id
+	bs, err := m.Pack()
+	require.NoError(t, err)
+
+	return bs
+}
+
+// collect runs the packets through a collector and returns what it wrote
+func collect(t *testing.T, feed func(*collector)) (string, string, dnspcap.Stats) {
+	var out, injections bytes.Buffer
+	c := newCollector(json.NewEncoder(&out), json.NewEncoder(&injections))
+	feed(c)
+
+	return out.String(), injections.String(), c.matcher.Stats()
+}
+
+func TestLiveMatchesReplay(t *testing.T) {
+	client := net.ParseIP("192.0.2.1").To4()
+	resolver := net.ParseIP("198.51.100.53").To4()
+	start := time.Date(2021, 5, 11, 12, 0, 0, 0, time.UTC)
+	packets := []struct {
+		at   time.Duration
+		data []byte
+	}{
+		{0, udpFrame(t, client, resolver, 40000, 53, packMsg(t, 1, "example.com", "", false))},
+		{20 * time.Millisecond, udpFrame(t, resolver, client, 53, 40000, packMsg(t, 1, "example.com", "93.184.216.34", true))},
+		{30 * time.Millisecond, udpFrame(t, client, resolver, 40001, 53, packMsg(t, 2, "blocked.test", "", false))},
+		{35 * time.Millisecond, udpFrame(t, resolver, client, 53, 40001, packMsg(t, 2, "blocked.test", "10.10.34.34", true))},
+		{60 * time.Millisecond, udpFrame(t, resolver, client, 53, 40001, packMsg(t, 2, "blocked.test", "203.0.113.7", true))},
+		{70 * time.Millisecond, udpFrame(t, client, resolver, 40002, 53, packMsg(t, 3, "quiet.test", "", false))},
+	}
+
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w := pcapgo.NewWriter(f)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	for _, p := range packets {
+		require.NoError(t, w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     start.Add(p.at),
+			CaptureLength: len(p.data),
+			Length:        len(p.data),
+		}, p.data))
+	}
+	require.NoError(t, f.Close())
+
+	// the live path hands packets that pass liveFilter to collector.packet
+	liveOut, liveInjections, liveStats := collect(t, func(c *collector) {
+		h, err := pcap.OpenOffline(path)
+		require.NoError(t, err)
+		defer h.Close()
+		require.NoError(t, h.SetBPFFilter(liveFilter))
+		for packet := range gopacket.NewPacketSource(h, h.LinkType()).Packets() {
+			c.packet(packet)
+		}
+		c.finish()
+	})
+	replayOut, replayInjections, replayStats := collect(t, func(c *collector) {
+		require.NoError(t, replayPcaps([]string{path}, c))
+	})
+
+	require.Equal(t, replayOut, liveOut)
+	require.Equal(t, replayInjections, liveInjections)
+	require.Equal(t, replayStats, liveStats)
+	require.Equal(t, dnspcap.Stats{
+		Packets:    len(packets),
+		Queries:    3,
+		Responses:  3,
+		Matched:    3,
+		Unanswered: 1,
+	}, liveStats)
+	require.NotContains(t, liveOut, `"matched":false`)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/timartiny/v4vsv6/pkg/dnspcap"
+	"github.com/timartiny/v4vsv6/pkg/gen"
+)
+
+// maxPairRetries is how many generated addresses may be rejected for a target
+// before giving up on finding it non-resolvers
+var maxPairRetries = 100
+
+// pairing generates addresses in the same allocation as each target that are
+// known not to run DNS. A response from one of them can't have come from the
+// endpoint, so it must have come from the path.
+type pairing struct {
+	ccMaps     *gen.CountryCodeMaps
+	responding map[string]struct{}
+	exclusions *gen.ExclusionSet
+	n          int
+
+	mu sync.Mutex
+	// targets maps each non-resolver to the target it was generated for
+	targets map[string]string
+	out     io.Writer
+}
+
+func newPairing(
+	ccMaps *gen.CountryCodeMaps,
+	responding map[string]struct{},
+	exclusions *gen.ExclusionSet,
+	n int,
+	out io.Writer,
+) *pairing {
+	return &pairing{
+		ccMaps:     ccMaps,
+		responding: responding,
+		exclusions: exclusions,
+		n:          n,
+		targets:    make(map[string]string),
+		out:        out,
+	}
+}
+
+// generate returns up to n non-resolvers in target's allocation, recording
+// them as paired with target
+func (p *pairing) generate(target net.IP) []net.IP {
+	cc, alloc, ok := p.ccMaps.Lookup(target)
+	if !ok {
+		log.Printf("No allocation found for %s, probing it without non-resolvers\n", target)
+		return nil
+	}
+	acceptance := func(addr *net.IP) bool {
+		if addr.Equal(target) || p.exclusions.Contains(*addr) {
+			return false
+		}
+		if _, ok := p.responding[addr.String()]; ok {
+			return false
+		}
+		// reserved as it's accepted, so workers pairing targets in the same
+		// allocation can't both draw it
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.targets[addr.String()]; ok {
+			return false
+		}
+		p.targets[addr.String()] = target.String()
+		return true
+	}
+	ccm := gen.CCMap{cc: {alloc}}
+	addrs, err := ccm.GetNAddrPerAlloc(rand.Reader, cc, p.n, maxPairRetries, acceptance)
+	if err != nil {
+		log.Printf("Error generating non-resolvers for %s: %v\n", target, err)
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ret []net.IP
+	for _, addr := range addrs {
+		if p.out != nil {
+			fmt.Fprintf(p.out, "%s %s %s\n", addr, target, cc)
+		}
+		ret = append(ret, *addr)
+	}
+
+	return ret
+}
+
+// target returns the target ip was generated for, or false if ip isn't a
+// non-resolver
+func (p *pairing) target(ip string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	target, ok := p.targets[ip]
+
+	return target, ok
+}
+
+// loadPairs reads the "non-resolver target CC" lines a previous run wrote
+func loadPairs(path string) (*pairing, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := &pairing{targets: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		ret.targets[fields[0]] = fields[1]
+	}
+
+	return ret, scanner.Err()
+}
+
+// pathReport counts the responses from non-resolvers over one address family
+type pathReport struct {
+	Responses int `json:"responses"`
+	// Records counts the responses by the record type asked for
+	Records      map[string]int `json:"records"`
+	Injected     int            `json:"injected"`
+	NonResolvers []string       `json:"non_resolvers"`
+	Targets      []string       `json:"targets"`
+
+	nonResolvers map[string]bool
+	targets      map[string]bool
+}
+
+func (pr *pathReport) add(r *dnspcap.Result, target string) {
+	pr.Responses++
+	pr.Records[r.Record]++
+	if r.Forged {
+		pr.Injected++
+	}
+	if !pr.nonResolvers[r.Resolver] {
+		pr.nonResolvers[r.Resolver] = true
+		pr.NonResolvers = append(pr.NonResolvers, r.Resolver)
+	}
+	if !pr.targets[target] {
+		pr.targets[target] = true
+		pr.Targets = append(pr.Targets, target)
+	}
+}
+
+// domainReport is every response from non-resolvers for a domain, separately
+// for v4 and v6 paths
+type domainReport struct {
+	Domain string      `json:"domain"`
+	V4     *pathReport `json:"v4,omitempty"`
+	V6     *pathReport `json:"v6,omitempty"`
+}
+
+// nonResolverReport collects the responses from non-resolvers by domain
+type nonResolverReport struct {
+	pairing *pairing
+	domains map[string]*domainReport
+}
+
+func newNonResolverReport(p *pairing) *nonResolverReport {
+	return &nonResolverReport{
+		pairing: p,
+		domains: make(map[string]*domainReport),
+	}
+}
+
+// add records r if it came from a non-resolver
+func (nr *nonResolverReport) add(r *dnspcap.Result) {
+	target, ok := nr.pairing.target(r.Resolver)
+	if !ok || r.Name == "" {
+		return
+	}
+	domain := strings.ToLower(r.Name)
+	dr, ok := nr.domains[domain]
+	if !ok {
+		dr = &domainReport{Domain: domain}
+		nr.domains[domain] = dr
+	}
+	path := &dr.V6
+	if net.ParseIP(r.Resolver).To4() != nil {
+		path = &dr.V4
+	}
+	if *path == nil {
+		*path = &pathReport{
+			Records:      make(map[string]int),
+			nonResolvers: make(map[string]bool),
+			targets:      make(map[string]bool),
+		}
+	}
+	(*path).add(r, target)
+}
+
+// write logs a NONRESOLVER line for each domain that got responses from
+// non-resolvers, and writes its report to out if there is one
+func (nr *nonResolverReport) write(out *json.Encoder) {
+	var domains []string
+	for domain := range nr.domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	count := func(pr *pathReport) (int, int) {
+		if pr == nil {
+			return 0, 0
+		}
+		return pr.Responses, len(pr.NonResolvers)
+	}
+	for _, domain := range domains {
+		dr := nr.domains[domain]
+		v4, v4Addrs := count(dr.V4)
+		v6, v6Addrs := count(dr.V6)
+		log.Printf("NONRESOLVER %s v4 %d responses from %d addresses, v6 %d responses from %d addresses\n",
+			domain, v4, v4Addrs, v6, v6Addrs)
+		if out != nil {
+			if err := out.Encode(dr); err != nil {
+				log.Printf("Error writing report: %v\n", err)
+			}
+		}
+	}
+	log.Printf("%d domains got responses from non-resolvers\n", len(domains))
+}
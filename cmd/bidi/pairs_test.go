@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6/pkg/dnspcap"
+	"github.com/timartiny/v4vsv6/pkg/gen"
+)
+
+func testCCMaps(t *testing.T) *gen.CountryCodeMaps {
+	_, v4, err := net.ParseCIDR("1.2.3.0/28")
+	require.NoError(t, err)
+	_, v6, err := net.ParseCIDR("2001:4860::/125")
+	require.NoError(t, err)
+
+	return &gen.CountryCodeMaps{
+		V4Map: gen.CCMap{"US": {v4}},
+		V6Map: gen.CCMap{"DE": {v6}},
+	}
+}
+
+func sortedStrings(ips []net.IP) []string {
+	var ret []string
+	for _, ip := range ips {
+		ret = append(ret, ip.String())
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+func TestPairing(t *testing.T) {
+	responding := map[string]struct{}{
+		"1.2.3.5": {},
+		"1.2.3.6": {},
+		"1.2.3.7": {},
+	}
+	exclusions := gen.NewExclusionSet()
+	_, excluded, err := net.ParseCIDR("1.2.3.8/29")
+	require.NoError(t, err)
+	exclusions.Add(excluded, "test")
+
+	var out bytes.Buffer
+	p := newPairing(testCCMaps(t), responding, exclusions, 3, &out)
+
+	// the target, the responding addresses and the excluded subnet leave
+	// only 1.2.3.1-3 of the /28's usable addresses
+	v4 := p.generate(net.ParseIP("1.2.3.4"))
+	require.Equal(t, []string{"1.2.3.1", "1.2.3.2", "1.2.3.3"}, sortedStrings(v4))
+
+	v6 := p.generate(net.ParseIP("2001:4860::1"))
+	require.Len(t, v6, 3)
+	for _, addr := range sortedStrings(v6) {
+		require.NotEqual(t, "2001:4860::1", addr)
+		target, ok := p.target(addr)
+		require.True(t, ok)
+		require.Equal(t, "2001:4860::1", target)
+	}
+
+	require.Nil(t, p.generate(net.ParseIP("9.9.9.9")))
+	_, ok := p.target("1.2.3.4")
+	require.False(t, ok)
+
+	path := filepath.Join(t.TempDir(), "pairs.txt")
+	require.NoError(t, ioutil.WriteFile(path, out.Bytes(), 0644))
+	loaded, err := loadPairs(path)
+	require.NoError(t, err)
+	require.Equal(t, p.targets, loaded.targets)
+	require.Len(t, loaded.targets, 6)
+}
+
+func TestPairingConcurrent(t *testing.T) {
+	p := newPairing(testCCMaps(t), nil, gen.NewExclusionSet(), 2, nil)
+
+	// targets in the same allocation, paired at once, never share a
+	// non-resolver
+	targets := []string{"1.2.3.1", "1.2.3.2", "1.2.3.3", "1.2.3.4", "1.2.3.5", "1.2.3.6"}
+	generated := make([][]net.IP, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			generated[i] = p.generate(net.ParseIP(target))
+		}(i, target)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, addrs := range generated {
+		require.Len(t, addrs, 2)
+		for _, addr := range addrs {
+			require.False(t, seen[addr.String()], addr.String())
+			seen[addr.String()] = true
+			target, ok := p.target(addr.String())
+			require.True(t, ok)
+			require.Equal(t, targets[i], target)
+		}
+	}
+}
+
+func TestNonResolverReport(t *testing.T) {
+	p := &pairing{targets: map[string]string{
+		"1.2.3.1":      "1.2.3.4",
+		"1.2.3.2":      "1.2.3.4",
+		"2001:4860::2": "2001:4860::1",
+	}}
+	nr := newNonResolverReport(p)
+	for _, r := range []*dnspcap.Result{
+		{Resolver: "1.2.3.1", Name: "Blocked.test", Record: "A", Forged: true},
+		{Resolver: "1.2.3.2", Name: "blocked.test", Record: "AAAA"},
+		{Resolver: "1.2.3.1", Name: "blocked.test", Record: "AAAA"},
+		{Resolver: "2001:4860::2", Name: "blocked.test", Record: "A", Forged: true},
+		// the target answering for itself isn't counted
+		{Resolver: "1.2.3.4", Name: "blocked.test", Record: "A"},
+		// nor are responses without a question
+		{Resolver: "1.2.3.1", Record: "A"},
+	} {
+		nr.add(r)
+	}
+
+	require.Len(t, nr.domains, 1)
+	dr := nr.domains["blocked.test"]
+	require.NotNil(t, dr.V4)
+	require.Equal(t, 3, dr.V4.Responses)
+	require.Equal(t, map[string]int{"A": 1, "AAAA": 2}, dr.V4.Records)
+	require.Equal(t, 1, dr.V4.Injected)
+	require.Equal(t, []string{"1.2.3.1", "1.2.3.2"}, dr.V4.NonResolvers)
+	require.Equal(t, []string{"1.2.3.4"}, dr.V4.Targets)
+
+	require.NotNil(t, dr.V6)
+	require.Equal(t, 1, dr.V6.Responses)
+	require.Equal(t, 1, dr.V6.Injected)
+	require.Equal(t, []string{"2001:4860::2"}, dr.V6.NonResolvers)
+	require.Equal(t, []string{"2001:4860::1"}, dr.V6.Targets)
+}
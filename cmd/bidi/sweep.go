@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timartiny/v4vsv6/pkg/pacer"
+	"github.com/timartiny/v4vsv6/pkg/ttlsweep"
+)
+
+// sweepFilter captures the ICMP errors routers send for expired probes along
+// with the responses
+const sweepFilter = "udp src port 53 or icmp or icmp6"
+
+// sweepWorker resends each domain to each target with every TTL up to maxTTL.
+// Lines are "target [domain]", sweeping all of domains if there's no domain.
+// The probe sockets for a domain stay open for wait after its sweep, so the
+// kernel can't hand a port to a later probe while answers could still arrive
+// on it, and are then closed so a worker only holds one domain's worth.
+func sweepWorker(
+	source4, source6 net.IP,
+	maxTTL int,
+	wait time.Duration,
+	verbose bool,
+	qTypes []uint16,
+	p *pacer.Pacer,
+	tracker *ttlsweep.Tracker,
+	lines <-chan string,
+	domains []string,
+	wg *sync.WaitGroup,
+) {
+	defer wg.Done()
+
+	for line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		target := net.ParseIP(fields[0])
+		if target == nil {
+			log.Printf("Invalid target: %s\n", fields[0])
+			continue
+		}
+		source := source6
+		if target.To4() != nil {
+			source = source4
+		}
+		sweepDomains := domains
+		if len(fields) > 1 {
+			sweepDomains = fields[1:2]
+		}
+
+		for _, domain := range sweepDomains {
+			sweepDomain(source, target, domain, maxTTL, wait, verbose, qTypes, p, tracker)
+		}
+	}
+}
+
+// sweepDomain sends domain to target with every TTL up to maxTTL for each of
+// qTypes, then waits for the responses before closing the probe sockets.
+// Responses are matched on their DNS ID too, so reusing a port for the next
+// domain after that is safe.
+func sweepDomain(
+	source, target net.IP,
+	domain string,
+	maxTTL int,
+	wait time.Duration,
+	verbose bool,
+	qTypes []uint16,
+	p *pacer.Pacer,
+	tracker *ttlsweep.Tracker,
+) {
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for _, qType := range qTypes {
+		for ttl := 1; ttl <= maxTTL; ttl++ {
+			p.Wait(target)
+			probe, conn, err := ttlsweep.Send(newQuery(domain, qType), source, target, 53, ttl)
+			if err != nil {
+				log.Printf("%s - Error sending with TTL %d: %v\n", target, ttl, err)
+				continue
+			}
+			tracker.Add(probe)
+			conns = append(conns, conn)
+		}
+		if verbose {
+			log.Printf("Swept %s %s with TTLs 1-%d\n", target, domain, maxTTL)
+		}
+	}
+	// the sockets stay open until the responses are in
+	time.Sleep(wait)
+}
+
+// writeTraces logs an INJECTOR line for each sweep that located an injector
+// and a SWEEP line per address family, and writes every trace to out if it's
+// set
+func writeTraces(tracker *ttlsweep.Tracker, out *json.Encoder) {
+	traces := tracker.Traces()
+	for _, trace := range traces {
+		if trace.Injected {
+			after := trace.InjectorAfter
+			if after == "" {
+				after = "us"
+			}
+			log.Printf("INJECTOR %s %s %s %s, from hop %d after %s\n",
+				trace.Family, trace.Target, trace.Name, trace.Record, trace.InjectionTTL, after)
+		}
+		if out != nil {
+			if err := out.Encode(trace); err != nil {
+				log.Printf("Error writing trace: %v\n", err)
+			}
+		}
+	}
+
+	summary := ttlsweep.Summarize(traces)
+	for _, family := range []string{ttlsweep.FamilyV4, ttlsweep.FamilyV6} {
+		s := summary[family]
+		var ttls []int
+		for ttl := range s.Hops {
+			ttls = append(ttls, ttl)
+		}
+		sort.Ints(ttls)
+		var hops []string
+		for _, ttl := range ttls {
+			hops = append(hops, fmt.Sprintf("%d (%d)", ttl, s.Hops[ttl]))
+		}
+		log.Printf("SWEEP %s %d traces, %d answered, %d injected, starting at hops %s\n",
+			family, s.Traces, s.Answered, s.Injected, strings.Join(hops, ", "))
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/timartiny/v4vsv6/pkg/resolvers"
+	"github.com/timartiny/v4vsv6/pkg/results"
+)
+
+var (
+	infoLogger  *log.Logger
+	errorLogger *log.Logger
+)
+
+type CartesianFlags struct {
+	DomainFile        string   `arg:"--domain-file,required" help:"(Required) Path to the file containing a JSON object for each domain, as written by querylist" json:"domain_file"`
+	ResolverFile      string   `arg:"--resolver-file,required" help:"(Required) Path to the file containing resolver pairs" json:"resolver_file"`
+	V4Output          string   `arg:"--v4-output,required" help:"(Required) Path to write the <domain>,<resolver> lines with v4 resolvers to" json:"v4_output"`
+	V6Output          string   `arg:"--v6-output,required" help:"(Required) Path to write the <domain>,<resolver> lines with v6 resolvers to" json:"v6_output"`
+	RequireV4TLS      bool     `arg:"--require-v4-tls" help:"Only include domains that have a v4 address supporting TLS" json:"require_v4_tls"`
+	RequireV6TLS      bool     `arg:"--require-v6-tls" help:"Only include domains that have a v6 address supporting TLS" json:"require_v6_tls"`
+	CitizenLabGlobal  bool     `arg:"--citizen-lab-global" help:"Include domains on the Citizen Lab global list, when given (or with --citizen-lab-country) only listed domains are included" json:"citizen_lab_global"`
+	CitizenLabCountry []string `arg:"--citizen-lab-country,separate" help:"Include domains on this country's Citizen Lab list, can be supplied multiple times" json:"citizen_lab_country"`
+	Shuffle           bool     `arg:"--shuffle" help:"Randomize the order of domains and resolvers" json:"shuffle"`
+	Seed              int64    `arg:"--seed" help:"Seed for --shuffle, defaults to the current time" json:"seed"`
+	Shards            int      `arg:"--shards" help:"Number of shards to split the output into, resolvers are split between shards" default:"1" json:"shards"`
+}
+
+// Domain is the part of querylist's output used to select domains.
+type Domain struct {
+	Domain                string   `json:"domain"`
+	HasV4TLS              bool     `json:"has_v4_tls"`
+	HasV6TLS              bool     `json:"has_v6_tls"`
+	CitizenLabGlobalList  bool     `json:"citizen_lab_global_list"`
+	CitizenLabCountryList []string `json:"citizen_lab_country_list"`
+}
+
+func setupArgs() CartesianFlags {
+	var ret CartesianFlags
+	p := arg.MustParse(&ret)
+	if ret.Shards < 1 {
+		p.Fail("--shards must be at least 1")
+	}
+
+	return ret
+}
+
+// include will check whether a domain passes every filter given on the command
+// line
+func include(domain Domain, args CartesianFlags) bool {
+	if args.RequireV4TLS && !domain.HasV4TLS {
+		return false
+	}
+	if args.RequireV6TLS && !domain.HasV6TLS {
+		return false
+	}
+	if !args.CitizenLabGlobal && len(args.CitizenLabCountry) == 0 {
+		return true
+	}
+	if args.CitizenLabGlobal && domain.CitizenLabGlobalList {
+		return true
+	}
+	for _, wanted := range args.CitizenLabCountry {
+		for _, cc := range domain.CitizenLabCountryList {
+			if strings.EqualFold(wanted, cc) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// readDomains will read the domains that pass the filters into memory. There
+// are far fewer domains than lines of output, so only the product itself is
+// streamed.
+func readDomains(args CartesianFlags) []string {
+	lr, err := results.OpenLineReader(args.DomainFile)
+	if err != nil {
+		errorLogger.Fatalf("Error opening %s: %v\n", args.DomainFile, err)
+	}
+	defer lr.Close()
+
+	var ret []string
+	seen := make(map[string]bool)
+	for lr.Next() {
+		var domain Domain
+		if err := json.Unmarshal(lr.Bytes(), &domain); err != nil {
+			errorLogger.Fatalf("Error reading domain: %v\n", lr.LineError(err))
+		}
+		if domain.Domain == "" || seen[domain.Domain] {
+			continue
+		}
+		seen[domain.Domain] = true
+		if include(domain, args) {
+			ret = append(ret, domain.Domain)
+		}
+	}
+	if err = lr.Err(); err != nil {
+		errorLogger.Fatalf("Error reading %s: %v\n", args.DomainFile, err)
+	}
+
+	return ret
+}
+
+// shardPath returns the path to write shard of count shards to. With a single
+// shard the path is unchanged, otherwise the shard number is added before any
+// compression extension, so "v4.gz" becomes "v4_shard0.gz".
+func shardPath(path string, shard, count int) string {
+	if count == 1 {
+		return path
+	}
+	for _, ext := range []string{".gz", ".zst"} {
+		if strings.HasSuffix(path, ext) {
+			return fmt.Sprintf("%s_shard%d%s", strings.TrimSuffix(path, ext), shard, ext)
+		}
+	}
+
+	return fmt.Sprintf("%s_shard%d", path, shard)
+}
+
+// writeProduct will write every domain-resolver pairing of a shard. Each row
+// uses every resolver once, so each resolver gets one query per row, and the
+// domains are rotated between rows so that neighbouring resolvers aren't all
+// asked about the same domain at once. Over len(domains) rows every resolver
+// is paired with every domain exactly once.
+func writeProduct(
+	domains []string,
+	pairs []resolvers.Pair,
+	v4Writer, v6Writer io.Writer,
+) error {
+	for row := range domains {
+		for i, pair := range pairs {
+			domain := domains[(row+i)%len(domains)]
+			if _, err := fmt.Fprintf(v4Writer, "%s,%s\n", domain, pair.V4); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(v6Writer, "%s,[%s]\n", domain, pair.V6); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dealShards will deal the resolver pairs out to count shards in turn, so each
+// resolver is only ever queried by a single scanner
+func dealShards(pairs []resolvers.Pair, count int) [][]resolvers.Pair {
+	ret := make([][]resolvers.Pair, count)
+	for i, pair := range pairs {
+		ret[i%count] = append(ret[i%count], pair)
+	}
+
+	return ret
+}
+
+// writeShard will create the output files for a shard and write its product
+func writeShard(
+	domains []string,
+	pairs []resolvers.Pair,
+	shard int,
+	args CartesianFlags,
+) {
+	v4Path := shardPath(args.V4Output, shard, args.Shards)
+	v6Path := shardPath(args.V6Output, shard, args.Shards)
+	infoLogger.Printf(
+		"Writing %d resolver pairs to %s and %s\n",
+		len(pairs),
+		v4Path,
+		v6Path,
+	)
+	v4Writer, err := results.Create(v4Path)
+	if err != nil {
+		errorLogger.Fatalf("Error creating %s: %v\n", v4Path, err)
+	}
+	v6Writer, err := results.Create(v6Path)
+	if err != nil {
+		errorLogger.Fatalf("Error creating %s: %v\n", v6Path, err)
+	}
+
+	if err = writeProduct(domains, pairs, v4Writer, v6Writer); err != nil {
+		errorLogger.Fatalf("Error writing shard %d: %v\n", shard, err)
+	}
+	if err = v4Writer.Close(); err != nil {
+		errorLogger.Fatalf("Error closing %s: %v\n", v4Path, err)
+	}
+	if err = v6Writer.Close(); err != nil {
+		errorLogger.Fatalf("Error closing %s: %v\n", v6Path, err)
+	}
+}
+
+func main() {
+	infoLogger = log.New(
+		os.Stderr,
+		"INFO: ",
+		log.Ldate|log.Ltime|log.Lshortfile,
+	)
+	errorLogger = log.New(
+		os.Stderr,
+		"ERROR: ",
+		log.Ldate|log.Ltime|log.Lshortfile,
+	)
+	args := setupArgs()
+
+	domains := readDomains(args)
+	pairs, err := resolvers.ReadPairsFile(args.ResolverFile, func(line int, err error) {
+		errorLogger.Printf("Skipping line %d of %s: %v\n", line, args.ResolverFile, err)
+	})
+	if err != nil {
+		errorLogger.Fatalf("Error reading resolver pairs: %v\n", err)
+	}
+	infoLogger.Printf(
+		"Pairing %d domains with %d resolver pairs\n",
+		len(domains),
+		len(pairs),
+	)
+
+	if args.Shuffle {
+		if args.Seed == 0 {
+			args.Seed = time.Now().UnixNano()
+		}
+		infoLogger.Printf("Shuffling with seed %d\n", args.Seed)
+		r := rand.New(rand.NewSource(args.Seed))
+		r.Shuffle(len(domains), func(i, j int) {
+			domains[i], domains[j] = domains[j], domains[i]
+		})
+		r.Shuffle(len(pairs), func(i, j int) {
+			pairs[i], pairs[j] = pairs[j], pairs[i]
+		})
+	}
+
+	for shard, sp := range dealShards(pairs, args.Shards) {
+		writeShard(domains, sp, shard, args)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6/pkg/resolvers"
+)
+
+func TestInclude(t *testing.T) {
+	domains := []Domain{
+		{Domain: "both.test", HasV4TLS: true, HasV6TLS: true},
+		{Domain: "v4only.test", HasV4TLS: true},
+		{Domain: "v6only.test", HasV6TLS: true},
+		{Domain: "global.test", HasV4TLS: true, HasV6TLS: true, CitizenLabGlobalList: true},
+		{Domain: "cn.test", HasV4TLS: true, CitizenLabCountryList: []string{"CN"}},
+		{Domain: "ir.test", CitizenLabCountryList: []string{"IR", "TR"}},
+	}
+
+	for _, tc := range []struct {
+		name string
+		args CartesianFlags
+		want []string
+	}{
+		{
+			"no filters",
+			CartesianFlags{},
+			[]string{"both.test", "v4only.test", "v6only.test", "global.test", "cn.test", "ir.test"},
+		},
+		{
+			"v4 tls",
+			CartesianFlags{RequireV4TLS: true},
+			[]string{"both.test", "v4only.test", "global.test", "cn.test"},
+		},
+		{
+			"v6 tls",
+			CartesianFlags{RequireV6TLS: true},
+			[]string{"both.test", "v6only.test", "global.test"},
+		},
+		{
+			"both tls",
+			CartesianFlags{RequireV4TLS: true, RequireV6TLS: true},
+			[]string{"both.test", "global.test"},
+		},
+		{
+			"global list",
+			CartesianFlags{CitizenLabGlobal: true},
+			[]string{"global.test"},
+		},
+		{
+			"country list ignores case",
+			CartesianFlags{CitizenLabCountry: []string{"cn", "tr"}},
+			[]string{"cn.test", "ir.test"},
+		},
+		{
+			"global or country list",
+			CartesianFlags{CitizenLabGlobal: true, CitizenLabCountry: []string{"IR"}},
+			[]string{"global.test", "ir.test"},
+		},
+		{
+			"country list and v4 tls",
+			CartesianFlags{RequireV4TLS: true, CitizenLabCountry: []string{"CN", "IR"}},
+			[]string{"cn.test"},
+		},
+	} {
+		var got []string
+		for _, domain := range domains {
+			if include(domain, tc.args) {
+				got = append(got, domain.Domain)
+			}
+		}
+		require.Equal(t, tc.want, got, tc.name)
+	}
+}
+
+func TestShardPath(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		shard int
+		count int
+		want  string
+	}{
+		{"v4.txt", 0, 1, "v4.txt"},
+		{"v4.gz", 0, 1, "v4.gz"},
+		{"v4.txt", 2, 3, "v4.txt_shard2"},
+		{"v4.gz", 0, 2, "v4_shard0.gz"},
+		{"out/v6.zst", 1, 2, "out/v6_shard1.zst"},
+	} {
+		require.Equal(t, tc.want, shardPath(tc.path, tc.shard, tc.count), tc.path)
+	}
+}
+
+// testPairs returns n resolver pairs with distinct addresses
+func testPairs(n int) []resolvers.Pair {
+	var ret []resolvers.Pair
+	for i := 0; i < n; i++ {
+		ret = append(ret, resolvers.Pair{
+			V4: net.IPv4(192, 0, 2, byte(i+1)),
+			V6: net.ParseIP(fmt.Sprintf("2001:db8::%x", i+1)),
+		})
+	}
+
+	return ret
+}
+
+// TestShardedProduct checks that whatever the number of shards, every
+// resolver is written to a single shard, and together the shards hold the
+// full product exactly once
+func TestShardedProduct(t *testing.T) {
+	domains := []string{"a.test", "b.test", "c.test"}
+	pairs := testPairs(7)
+
+	want := make(map[string]bool)
+	for _, domain := range domains {
+		for _, pair := range pairs {
+			want[fmt.Sprintf("%s,%s", domain, pair.V4)] = true
+			want[fmt.Sprintf("%s,[%s]", domain, pair.V6)] = true
+		}
+	}
+
+	for _, count := range []int{1, 2, 3, 7, 10} {
+		name := fmt.Sprintf("%d shards", count)
+		shards := dealShards(pairs, count)
+		require.Len(t, shards, count, name)
+		require.Equal(t, shards, dealShards(pairs, count), name)
+
+		got := make(map[string]bool)
+		shardOf := make(map[string]int)
+		for shard, sp := range shards {
+			var v4, v6 bytes.Buffer
+			require.NoError(t, writeProduct(domains, sp, &v4, &v6), name)
+			for _, line := range strings.Split(v4.String()+v6.String(), "\n") {
+				if line == "" {
+					continue
+				}
+				require.False(t, got[line], "%s: %s written twice", name, line)
+				got[line] = true
+
+				resolver := line[strings.Index(line, ",")+1:]
+				if prev, ok := shardOf[resolver]; ok {
+					require.Equal(t, prev, shard, "%s: %s in two shards", name, resolver)
+				}
+				shardOf[resolver] = shard
+			}
+		}
+		require.Equal(t, want, got, name)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/control"
+	"github.com/timartiny/v4vsv6/pkg/gen"
+	"github.com/timartiny/v4vsv6/pkg/results"
+	"github.com/timartiny/v4vsv6/pkg/zdns"
+)
+
+var (
+	infoLogger     *log.Logger
+	errorLogger    *log.Logger
+	controlDomains *control.Registry
+)
+
+type CheckASNsFlags struct {
+	ResultsFile    string   `arg:"--results-file,required" help:"(Required) Path to the file containing the DomainResolverResults" json:"results_file"`
+	OutputFile     string   `arg:"--output-file,required" help:"(Required) Path to write the DomainResolverResults to, with ASNs added" json:"output_file"`
+	ASNDB          string   `arg:"--asn-db,required" help:"(Required) Path to the folder containing GeoLite2-ASN-Blocks-IPv4.csv and GeoLite2-ASN-Blocks-IPv6.csv" json:"asn_db"`
+	ControlFiles   []string `arg:"--control-file,required,separate" help:"(Required) Path to ZDNS output of the control resolvers' lookups, such as satellite-A-<date>.json from 07.sh, can be supplied multiple times" json:"control_files"`
+	Classifiers    []string `arg:"--classifier,separate" help:"Re-decide which queries were censored with these classifiers (asn, tls, rcode or http-blockpage), can be supplied multiple times" json:"classifiers"`
+	ControlDomains string   `arg:"--control-domains" help:"Path to a JSON file of control domains and their expected answers, defaults to the v4vsv6.com control domains" json:"control_domains"`
+	Verbose        bool     `arg:"--verbose,-v" help:"Whether to add extra printing for debugging" json:"verbose"`
+}
+
+// Counts tallies what was found, for the summary printed at the end
+type Counts struct {
+	Answers       int
+	UnknownASN    int
+	NoControl     int
+	UnexpectedASN int
+	// Queries is the number of queries with an answer outside the control
+	// ASNs in their last round
+	Queries int
+}
+
+func setupArgs() CheckASNsFlags {
+	var ret CheckASNsFlags
+	arg.MustParse(&ret)
+
+	return ret
+}
+
+// readControlASNs will read the control resolvers' lookups and return the
+// ASNs of their answers for each domain
+func readControlASNs(
+	paths []string,
+	asnMaps *gen.AutonomousSystemNumberMaps,
+) map[string]map[uint]bool {
+	ret := make(map[string]map[uint]bool)
+	for _, path := range paths {
+		infoLogger.Printf("Reading control lookups from %s\n", path)
+		rdr, err := zdns.OpenReader(path)
+		if err != nil {
+			errorLogger.Fatalf("Error opening file: %s, %v\n", path, err)
+		}
+		for rdr.Next() {
+			zdnsLine := rdr.Result()
+			if !zdnsLine.OK() {
+				continue
+			}
+			domain := strings.TrimSuffix(strings.ToLower(zdnsLine.Name), ".")
+			ips, _ := zdnsLine.Addresses()
+			for _, ip := range ips {
+				asn, _, ok := asnMaps.Lookup(ip)
+				if !ok {
+					continue
+				}
+				if ret[domain] == nil {
+					ret[domain] = make(map[uint]bool)
+				}
+				ret[domain][asn] = true
+			}
+		}
+		if err = rdr.Err(); err != nil {
+			errorLogger.Fatalf("Error reading %s: %v\n", path, err)
+		}
+		rdr.Close()
+	}
+
+	return ret
+}
+
+// annotate will fill in the ASN and prefix of every answer of drr, flagging
+// those whose ASN none of the control answers were in. Returns whether any
+// answers of the last round were flagged.
+func annotate(
+	drr *v4vsv6.DomainResolverResult,
+	asnMaps *gen.AutonomousSystemNumberMaps,
+	controlASNs map[string]map[uint]bool,
+	counts *Counts,
+) bool {
+	controls, hasControls := controlASNs[drr.Domain]
+	var flagged bool
+	for _, rr := range drr.Rounds {
+		for _, ar := range rr.Results {
+			if ar == nil {
+				continue
+			}
+			counts.Answers++
+			ar.ASN, ar.Prefix, ar.UnexpectedASN = 0, "", false
+			asn, prefix, ok := asnMaps.Lookup(net.ParseIP(ar.IP))
+			if !ok {
+				counts.UnknownASN++
+				continue
+			}
+			ar.ASN = asn
+			ar.Prefix = prefix.String()
+			if !hasControls {
+				counts.NoControl++
+				continue
+			}
+			if !controls[asn] {
+				ar.UnexpectedASN = true
+				counts.UnexpectedASN++
+				if rr == drr.LastRound() {
+					flagged = true
+				}
+			}
+		}
+	}
+
+	return flagged
+}
+
+func main() {
+	infoLogger = log.New(
+		os.Stderr,
+		"INFO: ",
+		log.Ldate|log.Ltime|log.Lshortfile,
+	)
+	errorLogger = log.New(
+		os.Stderr,
+		"ERROR: ",
+		log.Ldate|log.Ltime|log.Lshortfile,
+	)
+
+	args := setupArgs()
+	var err error
+	controlDomains, err = control.Load(args.ControlDomains)
+	if err != nil {
+		errorLogger.Fatalf("Error loading control domains: %v\n", err)
+	}
+
+	infoLogger.Printf("Loading ASN data from %s\n", args.ASNDB)
+	asnMaps, err := gen.BuildASNMaps(strings.TrimSuffix(args.ASNDB, "/") + "/")
+	if err != nil {
+		errorLogger.Fatalf("Error loading ASN data: %v\n", err)
+	}
+	controlASNs := readControlASNs(args.ControlFiles, asnMaps)
+	infoLogger.Printf("Found control answers for %d domains\n", len(controlASNs))
+
+	var classifier v4vsv6.Classifier
+	if len(args.Classifiers) > 0 {
+		classifier, err = v4vsv6.NewClassifiers(
+			args.Classifiers,
+			v4vsv6.ClassifierOptions{
+				ASN: &v4vsv6.ASNConsistency{
+					Lookup: func(ip net.IP) (uint, bool) {
+						asn, _, ok := asnMaps.Lookup(ip)
+						return asn, ok
+					},
+					Control: controlASNs,
+				},
+			},
+		)
+		if err != nil {
+			errorLogger.Fatalln(err)
+		}
+	}
+
+	rdr, err := results.OpenDRRReader(args.ResultsFile)
+	if err != nil {
+		errorLogger.Fatalf("Error opening file: %s, %v\n", args.ResultsFile, err)
+	}
+	defer rdr.Close()
+	w, err := results.CreateDRRWriter(args.OutputFile)
+	if err != nil {
+		errorLogger.Fatalf("Error creating file: %s, %v\n", args.OutputFile, err)
+	}
+
+	infoLogger.Printf("Reading through %s\n", args.ResultsFile)
+	infoLogger.Printf("And writing to %s\n", args.OutputFile)
+	var counts Counts
+	nextVerboseTime := time.Now().Add(30 * time.Second)
+	for rdr.Next() {
+		if args.Verbose && time.Now().After(nextVerboseTime) {
+			infoLogger.Printf("Checked %d (and counting) answers\n", counts.Answers)
+			nextVerboseTime = time.Now().Add(30 * time.Second)
+		}
+		drr := rdr.DomainResolverResult()
+		if annotate(drr, asnMaps, controlASNs, &counts) {
+			counts.Queries++
+		}
+		if classifier != nil && !controlDomains.IsControlDomain(drr.Domain) {
+			for _, rr := range drr.Rounds {
+				drr.ClassifyRound(rr, classifier)
+			}
+			if last := drr.LastRound(); last != nil {
+				drr.CensoredQuery = last.Censored
+			}
+		}
+		if err = w.Write(drr); err != nil {
+			errorLogger.Fatalf("Error writing to file: %s, %v\n", args.OutputFile, err)
+		}
+	}
+	if err = rdr.Err(); err != nil {
+		errorLogger.Fatalf("Error reading drrs: %v\n", err)
+	}
+	if err = w.Close(); err != nil {
+		errorLogger.Fatalf("Error closing file: %s, %v\n", args.OutputFile, err)
+	}
+
+	infoLogger.Printf(
+		"Checked %d answers: %d with no known ASN, %d for domains without "+
+			"control answers, %d outside the control ASNs\n",
+		counts.Answers,
+		counts.UnknownASN,
+		counts.NoControl,
+		counts.UnexpectedASN,
+	)
+	infoLogger.Printf(
+		"%d queries had answers outside the control ASNs in their last round\n",
+		counts.Queries,
+	)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/gen"
+)
+
+// TestAnnotate maps answers to their ASNs and flags those outside the ASNs the
+// control resolvers' answers were in
+func TestAnnotate(t *testing.T) {
+	infoLogger = log.New(ioutil.Discard, "", 0)
+	errorLogger = log.New(ioutil.Discard, "", 0)
+
+	dir := t.TempDir()
+	header := "network,autonomous_system_number,autonomous_system_organization\n"
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "GeoLite2-ASN-Blocks-IPv4.csv"),
+		[]byte(header+
+			"192.0.2.0/24,64500,Example CDN\n"+
+			"198.51.100.0/24,64501,Example ISP\n"),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "GeoLite2-ASN-Blocks-IPv6.csv"),
+		[]byte(header+"2001:db8::/32,64500,Example CDN\n"),
+		0644,
+	))
+	controlFile := filepath.Join(dir, "satellite-A.json")
+	require.NoError(t, os.WriteFile(controlFile, []byte(
+		`{"name":"Example.test","status":"NOERROR","data":{"answers":[{"type":"A","answer":"192.0.2.10"}]}}`+"\n"+
+			`{"name":"broken.test","status":"SERVFAIL"}`+"\n",
+	), 0644))
+
+	asnMaps, err := gen.BuildASNMaps(dir + "/")
+	require.NoError(t, err)
+	controlASNs := readControlASNs([]string{controlFile}, asnMaps)
+	require.Equal(t, map[string]map[uint]bool{"example.test": {64500: true}}, controlASNs)
+
+	drr := &v4vsv6.DomainResolverResult{Domain: "example.test"}
+	drr.SetRoundResults(1, []*v4vsv6.AddressResult{
+		{IP: "192.0.2.20"},
+		{IP: "2001:db8::1"},
+	})
+	drr.SetRoundResults(2, []*v4vsv6.AddressResult{
+		{IP: "198.51.100.1"},
+		{IP: "203.0.113.1"},
+	})
+	var counts Counts
+	require.True(t, annotate(drr, asnMaps, controlASNs, &counts))
+	require.Equal(t, Counts{Answers: 4, UnknownASN: 1, UnexpectedASN: 1}, counts)
+
+	first := drr.Round(1).Results
+	require.Equal(t, uint(64500), first[0].ASN)
+	require.Equal(t, "192.0.2.0/24", first[0].Prefix)
+	require.False(t, first[0].UnexpectedASN)
+	require.Equal(t, "2001:db8::/32", first[1].Prefix)
+	require.False(t, first[1].UnexpectedASN)
+	second := drr.Round(2).Results
+	require.Equal(t, uint(64501), second[0].ASN)
+	require.True(t, second[0].UnexpectedASN)
+	require.Zero(t, second[1].ASN)
+	require.False(t, second[1].UnexpectedASN)
+
+	// the classifier agrees with the flags
+	verdict, ok := v4vsv6.ASNConsistency{
+		Lookup: func(ip net.IP) (uint, bool) {
+			asn, _, ok := asnMaps.Lookup(ip)
+			return asn, ok
+		},
+		Control: controlASNs,
+	}.Classify(drr, drr.Round(2))
+	require.True(t, ok)
+	require.True(t, verdict.Censored)
+	require.Equal(t, v4vsv6.RuleASN, verdict.Rule)
+
+	// domains without control answers are never flagged
+	other := &v4vsv6.DomainResolverResult{Domain: "other.test"}
+	other.SetRoundResults(1, []*v4vsv6.AddressResult{{IP: "198.51.100.1"}})
+	counts = Counts{}
+	require.False(t, annotate(other, asnMaps, controlASNs, &counts))
+	require.Equal(t, Counts{Answers: 1, NoControl: 1}, counts)
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -13,6 +12,7 @@ import (
 	"sync"
 
 	"github.com/alexflint/go-arg"
+	"github.com/timartiny/v4vsv6/pkg/results"
 )
 
 var (
@@ -69,7 +69,7 @@ func setupArgs() GraphFlags {
 	return ret
 }
 
-func updateNodeMaps(nodes *Nodes, ntbl map[string][]string, bs float64, lc <-chan string, wg *sync.WaitGroup) {
+func updateNodeMaps(nodes *Nodes, ntbl map[string][]string, bs float64, rsc <-chan ResolverStats, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	ignoreDomains := map[string]bool{
@@ -81,9 +81,7 @@ func updateNodeMaps(nodes *Nodes, ntbl map[string][]string, bs float64, lc <-cha
 		"www.hud.gov-AAAA":      true,
 	}
 
-	for line := range lc {
-		var rs ResolverStats
-		json.Unmarshal([]byte(line), &rs)
+	for rs := range rsc {
 		n := new(Node)
 		if net.ParseIP(rs.ResolverIP).To4() != nil {
 			n.Group = 1
@@ -189,19 +187,23 @@ func main() {
 	var nodes Nodes
 	var wg sync.WaitGroup
 	nodeToBlockedList := make(map[string][]string)
-	lineChan := make(chan string, 10)
+	statsChan := make(chan ResolverStats, 10)
 
 	wg.Add(1)
-	go updateNodeMaps(&nodes, nodeToBlockedList, args.BubbleScale, lineChan, &wg)
+	go updateNodeMaps(&nodes, nodeToBlockedList, args.BubbleScale, statsChan, &wg)
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineChan <- line
+	lr := results.NewLineReader(os.Stdin, "stdin")
+	for lr.Next() {
+		var rs ResolverStats
+		if err := json.Unmarshal(lr.Bytes(), &rs); err != nil {
+			errorLogger.Printf("Skipping %v\n", lr.LineError(err))
+			continue
+		}
+		statsChan <- rs
 	}
-	close(lineChan)
+	close(statsChan)
 
-	if err := scanner.Err(); err != nil {
+	if err := lr.Err(); err != nil {
 		errorLogger.Fatalf("Error reading from stdin: %v\n", err)
 	}
 	wg.Wait()
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/timartiny/v4vsv6/pkg/gen"
@@ -20,9 +21,9 @@ var pathASN = "GeoLite2-ASN/"
 var maxRetries = 100
 
 func main() {
-	var dbDir, outFilePath, filterLiveFile, idFile, csvFile string
+	var dbDir, outFilePath, filterLiveFile, idFile, csvFile, excludeFiles string
 	var seed, nAddrs int
-	var all, six bool
+	var all, six, bogons bool
 
 	// var ccList = []string{"AE", "AF", "BR", "CA", "CN", "CU", "FR", "IN", "IR", "HK", "MM", "PK", "RU", "SA", "TH", "TM", "UA", "VN", "US"}
 	var ccList = []string{"CN", "IR"}
@@ -34,6 +35,8 @@ func main() {
 	flag.StringVar(&csvFile, "csv-file", "", "Maxmind CSV Country database file  (e.g. \"<path_to>/GeoLite2-Country-Blocks-IPv4.csv\"). Overrides '-d' and '-6' options.")
 
 	flag.StringVar(&filterLiveFile, "filter", "", "File containing list of addresses known to respond on UDP 53")
+	flag.StringVar(&excludeFiles, "exclude", "", "Comma separated ZMap blocklist files of subnets to never generate addresses in")
+	flag.BoolVar(&bogons, "bogons", true, "Never generate addresses in IANA special-purpose (bogon) ranges")
 
 	flag.StringVar(&outFilePath, "o", "./generated_out", "Output file path")
 	flag.IntVar(&seed, "s", -1, "PRNG seed (default seeded with time in ns)")
@@ -80,6 +83,11 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	exclusions, err := gen.LoadExclusions(strings.Split(excludeFiles, ","), bogons)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	if all {
 		ccList = ccMap.GetCCList()
 	}
@@ -93,6 +101,10 @@ func main() {
 			log.Println("generated addr responds on UDP 53", addr)
 			return false
 		}
+		if network, source, ok := exclusions.Match(*addr); ok {
+			log.Println("generated addr excluded by", network, "from", source, addr)
+			return false
+		}
 		return true
 	}
 
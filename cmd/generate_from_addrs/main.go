@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/oschwald/geoip2-golang"
@@ -21,12 +22,15 @@ var pathCC = "GeoLite2-Country/"
 var maxGenerationRetries = 100
 
 func main() {
-	var dbDir, outfile, filterLiveFile string
+	var dbDir, outfile, filterLiveFile, excludeFiles string
+	var bogons bool
 	var seed, nAddrs int
 
 	flag.StringVar(&dbDir, "d", "./GeoLite2/", "Database directory path")
 	flag.StringVar(&outfile, "o", "./generated_out", "Output file path")
 	flag.StringVar(&filterLiveFile, "filter", "", "File containing list of addresses known to respond on UDP 53")
+	flag.StringVar(&excludeFiles, "exclude", "", "Comma separated ZMap blocklist files of subnets to never generate addresses in")
+	flag.BoolVar(&bogons, "bogons", true, "Never generate addresses in IANA special-purpose (bogon) ranges")
 	flag.IntVar(&seed, "s", -1, "PRNG seed (default seeded with time in ns)")
 	flag.IntVar(&nAddrs, "n", 2, "Number of addresses per IP-version per input address")
 
@@ -64,6 +68,11 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	exclusions, err := gen.LoadExclusions(strings.Split(excludeFiles, ","), bogons)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		// fmt.Println(scanner.Text())
@@ -109,6 +118,11 @@ func main() {
 				retries++
 				goto GenAddrs
 			}
+			if network, source, ok := exclusions.Match(*newAddr); ok {
+				log.Println("generated addr excluded by", network, "from", source, newAddr)
+				retries++
+				goto GenAddrs
+			}
 
 		}
 
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/timartiny/v4vsv6/pkg/gen"
@@ -15,23 +16,40 @@ import (
 var pathCC = "GeoLite2-Country/"
 var maxGenerationRetries = 100
 
+func sampleModes() string {
+	var names []string
+	for _, mode := range gen.SampleModes {
+		names = append(names, string(mode))
+	}
+
+	return strings.Join(names, ", ")
+}
+
 func main() {
-	var dbDir, outfile, filterLiveFile string
+	var dbDir, outfile, filterLiveFile, sample, excludeFiles string
 	var seed, nAddrs int
-	var all bool
+	var all, bogons bool
 
 	var ccList = []string{"AE", "AF", "BR", "CA", "CN", "CU", "FR", "IN", "IR", "HK", "MM", "PK", "RU", "SA", "TH", "TM", "UA", "VN", "US"}
 
 	flag.StringVar(&dbDir, "d", "./GeoLite2/", "Database directory path")
 	flag.StringVar(&filterLiveFile, "filter", "", "File containing list of addresses known to respond on UDP 53")
+	flag.StringVar(&excludeFiles, "exclude", "", "Comma separated ZMap blocklist files of subnets to never generate addresses in")
+	flag.BoolVar(&bogons, "bogons", true, "Never generate addresses in IANA special-purpose (bogon) ranges")
 	flag.StringVar(&outfile, "o", "./generated_out", "Output file path")
 	flag.IntVar(&seed, "s", -1, "PRNG seed (default seeded with time in ns)")
 	flag.IntVar(&nAddrs, "n", 100, "Number of addresses per IP-version per country")
 	flag.BoolVar(&all, "all", false, "Use all country codes instead of hard coded list")
+	flag.StringVar(&sample, "sample", string(gen.SampleSubnet), "How to pick the subnet for each address: "+sampleModes())
 
 	// parse flags from command line
 	flag.Parse()
 
+	sampleMode, err := gen.ParseSampleMode(sample)
+	if err != nil {
+		log.Fatalf("%v: %s, expected one of %s\n", err, sample, sampleModes())
+	}
+
 	var rdr io.Reader
 	if seed == -1 {
 		rdr = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -62,56 +80,63 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	exclusions, err := gen.LoadExclusions(strings.Split(excludeFiles, ","), bogons)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	if all {
 		ccList = ccMap.GetCCList()
 	}
 
+	// acceptance rejects addresses already written, ones known to respond on
+	// UDP 53 and excluded ones. Samplers redraw a rejected address on their
+	// own, so stratified sampling keeps its place.
+	acceptance := func(addr *net.IP) bool {
+		if _, ok := selectedAddrs[addr.String()]; ok {
+			log.Println("generated addr already selected", addr)
+			return false
+		}
+		if _, ok := respondingAddrs[addr.String()]; ok {
+			log.Println("generated addr responds on UDP 53", addr)
+			return false
+		}
+		if network, source, ok := exclusions.Match(*addr); ok {
+			log.Println("generated addr excluded by", network, "from", source, addr)
+			return false
+		}
+		return true
+	}
+
 	for _, cc := range ccList {
+		// one sampler per country for the whole run, so stratified sampling
+		// spreads across blocks
+		sampler4, err := ccMap.Sampler4(cc, sampleMode)
+		if err != nil && err != gen.ErrNoSubnets {
+			log.Fatal(err)
+		}
+		sampler6, err := ccMap.Sampler6(cc, sampleMode)
+		if err != nil && err != gen.ErrNoSubnets {
+			log.Fatal(err)
+		}
 		blockSize := 10
-		randAddrs4 := []*net.IP{}
-		randAddrs6 := []*net.IP{}
 		for countryCount := 0; countryCount < nAddrs; countryCount += blockSize {
-			retries := 0
-		GenAddrs:
-			if retries > maxGenerationRetries {
-				log.Println("too many retries generating for", cc, countryCount)
-				continue
-			}
-			randAddrs4, err = ccMap.GetNRandomAddr4(rdr, cc, blockSize, maxGenerationRetries)
-			if err != nil {
-				if err != gen.ErrNoSubnets {
-					log.Println(err)
+			var randAddrs4, randAddrs6 []*net.IP
+			if sampler4 != nil {
+				randAddrs4, err = sampler4.GetNRandomAddr(rdr, blockSize, maxGenerationRetries, acceptance)
+				if err != nil {
+					log.Println(err, cc, countryCount)
 					continue
 				}
 			}
-			randAddrs6, err = ccMap.GetNRandomAddr6(rdr, cc, blockSize, maxGenerationRetries)
-			if err != nil {
-				if err != gen.ErrNoSubnets {
-					log.Println(err)
+			if sampler6 != nil {
+				randAddrs6, err = sampler6.GetNRandomAddr(rdr, blockSize, maxGenerationRetries, acceptance)
+				if err != nil {
+					log.Println(err, cc, countryCount)
 					continue
 				}
 			}
 
-			for _, addr := range randAddrs4 {
-				if _, ok := selectedAddrs[addr.String()]; ok {
-					log.Println("generated addr already selected", addr, cc)
-					retries++
-					goto GenAddrs
-				}
-				if _, ok := respondingAddrs[addr.String()]; ok {
-					log.Println("generated addr responds on UDP 53", addr)
-					retries++
-					goto GenAddrs
-				}
-			}
-			for _, addr := range randAddrs6 {
-				if _, ok := selectedAddrs[addr.String()]; ok {
-					log.Println("generated addr already selected", addr, cc)
-					retries++
-					goto GenAddrs
-				}
-			}
-
 			for _, addr := range randAddrs4 {
 				selectedAddrs[addr.String()] = struct{}{}
 				file4.WriteString(addr.String() + " " + cc + "\n")
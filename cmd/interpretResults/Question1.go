@@ -300,7 +300,7 @@ func printCensoringResolverData(
 					// now everything is marked as seen, actually print data.
 					// we have a pair, so tally it.
 					q1s.NumResolversPairs += 1
-					if resolvers[v4.IP].ControlCount == len(controlDomains)*2 && resolvers[v6.IP].ControlCount == len(controlDomains)*2 {
+					if resolvers[v4.IP].ControlCount == controlDomains.ExpectedAnswers() && resolvers[v6.IP].ControlCount == controlDomains.ExpectedAnswers() {
 						q1s.NumCorrectControlResolverPairs += 1
 					} else if dataType == "passesControl" {
 						continue
@@ -374,6 +374,7 @@ func Question1(
 	readFileWG.Add(1)
 	go readDomainResolverResults(
 		args.ResultsFile,
+		args.Workers,
 		domainResolverResultChannel,
 		&readFileWG,
 	)
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/control"
+	"github.com/timartiny/v4vsv6/pkg/results"
+)
+
+// TestQuestion1 collects the censored domains of each resolver from the
+// results parseScans writes for a resolver pair censoring over one family.
+func TestQuestion1(t *testing.T) {
+	infoLogger = log.New(ioutil.Discard, "", 0)
+	errorLogger = log.New(ioutil.Discard, "", 0)
+	var err error
+	controlDomains, err = control.Load("")
+	require.NoError(t, err)
+
+	resultsFile := filepath.Join(t.TempDir(), "drrs.json")
+	w, err := results.CreateDRRWriter(resultsFile)
+	require.NoError(t, err)
+	for _, drr := range []struct {
+		domain, resolver, record string
+		censored                 bool
+	}{
+		{"honest.test", "127.0.0.1", "A", false},
+		{"honest.test", "::1", "AAAA", false},
+		{"v4nx.test", "127.0.0.1", "A", true},
+		{"v4nx.test", "127.0.0.1", "AAAA", true},
+		{"v4nx.test", "::1", "A", false},
+		{"aaaafilter.test", "::1", "AAAA", true},
+		{"v4vsv6.com", "::1", "A", true},
+	} {
+		require.NoError(t, w.Write(&v4vsv6.DomainResolverResult{
+			Domain:               drr.domain,
+			ResolverIP:           drr.resolver,
+			ResolverCountry:      "US",
+			RequestedAddressType: drr.record,
+			CensoredQuery:        drr.censored,
+		}))
+	}
+	require.NoError(t, w.Close())
+
+	ccrtsr := make(CountryCodeResolverToSimpleResult)
+	Question1(
+		InterpretResultsFlags{ResultsFile: resultsFile, Workers: 2},
+		ccrtsr,
+		nil,
+		nil,
+		false,
+	)
+
+	v4 := ccrtsr["US"]["127.0.0.1"]
+	require.Equal(t, "4", v4.AF)
+	require.Equal(t, map[string]struct{}{"v4nx.test": {}}, v4.ACensoredDomains)
+	require.Equal(t, map[string]struct{}{"v4nx.test": {}}, v4.AAAACensoredDomains)
+	// control domains aren't counted as censored
+	v6 := ccrtsr["US"]["::1"]
+	require.Equal(t, "6", v6.AF)
+	require.Empty(t, v6.ACensoredDomains)
+	require.Equal(t, map[string]struct{}{"aaaafilter.test": {}}, v6.AAAACensoredDomains)
+}
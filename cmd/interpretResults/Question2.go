@@ -115,7 +115,7 @@ func printCensoringRecordData(
 					// now everything is marked as seen, actually print data.
 					// we have a pair, so tally it.
 					q2s.NumResolversPairs += 1
-					if resolvers[v4.IP].ControlCount == len(controlDomains)*2 && resolvers[v6.IP].ControlCount == len(controlDomains)*2 {
+					if resolvers[v4.IP].ControlCount == controlDomains.ExpectedAnswers() && resolvers[v6.IP].ControlCount == controlDomains.ExpectedAnswers() {
 						q2s.NumCorrectControlResolverPairs += 1
 					} else if dataType == "passesControl" {
 						continue
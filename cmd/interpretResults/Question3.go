@@ -117,7 +117,7 @@ func updateCountryDomainMap(
 		}
 		// only update control map if this result came from a resolver that
 		// resolved all the control domains successfully
-		if sr.ControlCount == len(controlDomains)*2 {
+		if sr.ControlCount == controlDomains.ExpectedAnswers() {
 			counter := ccdtcControl[sr.CountryCode][sr.Domain]
 			if sr.Censored {
 				counter.Censored++
@@ -191,6 +191,7 @@ func Question3(args InterpretResultsFlags) {
 	readFileWG.Add(1)
 	go readDomainResolverResults(
 		args.ResultsFile,
+		args.Workers,
 		domainResolverResultChannel,
 		&readFileWG,
 	)
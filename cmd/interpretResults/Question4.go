@@ -71,12 +71,12 @@ func getQuestion4SimpleResults(
 				}
 				if drr.RequestedAddressType == "A" {
 					sr.CensoredARequests++
-					if resolvers[drr.ResolverIP].ControlCount == len(controlDomains)*2 {
+					if resolvers[drr.ResolverIP].ControlCount == controlDomains.ExpectedAnswers() {
 						sr.ControlCensoredARequests++
 					}
 				} else {
 					sr.CensoredAAAARequests++
-					if resolvers[drr.ResolverIP].ControlCount == len(controlDomains)*2 {
+					if resolvers[drr.ResolverIP].ControlCount == controlDomains.ExpectedAnswers() {
 						sr.ControlCensoredAAAARequests++
 					}
 				}
@@ -199,14 +199,14 @@ func printQuestion4Results(
 					q4o.Domain = domain
 					if dataType == "passesControl" {
 						for _, pair := range simpleResult.CensoringPairs {
-							if resolvers[pair.V4].ControlCount == len(controlDomains)*2 {
-								if resolvers[pair.V6].ControlCount == len(controlDomains)*2 {
+							if resolvers[pair.V4].ControlCount == controlDomains.ExpectedAnswers() {
+								if resolvers[pair.V6].ControlCount == controlDomains.ExpectedAnswers() {
 									q4o.CensoringPairs = append(q4o.CensoringPairs, pair)
 								} else {
 									q4o.CensoringV4Resolvers = append(q4o.CensoringV4Resolvers, pair.V4)
 								}
 							} else {
-								if resolvers[pair.V6].ControlCount == len(controlDomains)*2 {
+								if resolvers[pair.V6].ControlCount == controlDomains.ExpectedAnswers() {
 									q4o.CensoringV6Resolvers = append(q4o.CensoringV6Resolvers, pair.V6)
 								}
 							}
@@ -220,7 +220,7 @@ func printQuestion4Results(
 
 					tmpSlice := make([]string, 0, len(simpleResult.CensoringV4Resolvers))
 					for key := range simpleResult.CensoringV4Resolvers {
-						if dataType == "full" || resolvers[key].ControlCount == len(controlDomains)*2 {
+						if dataType == "full" || resolvers[key].ControlCount == controlDomains.ExpectedAnswers() {
 							tmpSlice = append(tmpSlice, key)
 						}
 					}
@@ -229,7 +229,7 @@ func printQuestion4Results(
 
 					tmpSlice = make([]string, 0, len(simpleResult.CensoringV4Resolvers))
 					for key := range simpleResult.CensoringV6Resolvers {
-						if dataType == "full" || resolvers[key].ControlCount == len(controlDomains)*2 {
+						if dataType == "full" || resolvers[key].ControlCount == controlDomains.ExpectedAnswers() {
 							tmpSlice = append(tmpSlice, key)
 						}
 					}
@@ -290,6 +290,7 @@ func Question4(
 	readFileWG.Add(1)
 	go readDomainResolverResults(
 		args.ResultsFile,
+		args.Workers,
 		domainResolverResultChannel,
 		&readFileWG,
 	)
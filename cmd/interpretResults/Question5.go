@@ -51,9 +51,7 @@ func getQuestion5SimpleResults(
 		sr.Domain = drr.Domain
 		sr.CountryCode = drr.ResolverCountry
 		sr.ControlCount = resolvers[drr.ResolverIP].ControlCount
-		allResults := drr.Day1Results
-		allResults = append(allResults, drr.Day2Results...)
-		allResults = append(allResults, drr.Day3Results...)
+		allResults := drr.AllResults()
 		for _, result := range allResults {
 			if result == nil {
 				continue
@@ -139,7 +137,7 @@ func updateCountryDomainQuestion5Map(
 				}
 			}
 		}
-		if sr.ControlCount == len(controlDomains)*2 {
+		if sr.ControlCount == controlDomains.ExpectedAnswers() {
 			// this result came from a resolver that passed the control stages,
 			// so do above again and put it in the control map
 			existingSR = controlccdtsr[sr.CountryCode][sr.Domain]
@@ -296,6 +294,7 @@ func Question5(
 	readFileWG.Add(1)
 	go readDomainResolverResults(
 		args.ResultsFile,
+		args.Workers,
 		domainResolverResultChannel,
 		&readFileWG,
 	)
@@ -9,7 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/results"
 )
 
 var (
@@ -86,21 +86,17 @@ func resolverStats(
 	v4ToV6, v6ToV4 map[string]string,
 	localResolvers map[string]ResolverStats,
 ) {
-	resultsFile, err := os.Open(resultsPath)
+	rdr, err := results.OpenDRRReader(resultsPath)
 	if err != nil {
 		errorLogger.Fatalf("Error opening results file, %v\n", err)
 	}
-	defer resultsFile.Close()
+	defer rdr.Close()
 
 	seenResolversToIDs := make(map[string]string)
 
-	scanner := bufio.NewScanner(resultsFile)
-
 	id := 1
-	for scanner.Scan() {
-		line := scanner.Text()
-		var drr v4vsv6.DomainResolverResult
-		json.Unmarshal([]byte(line), &drr)
+	for rdr.Next() {
+		drr := *rdr.DomainResolverResult()
 		var strID string
 		var AorB string
 		if net.ParseIP(drr.ResolverIP).To4() != nil {
@@ -156,6 +152,9 @@ func resolverStats(
 		}
 		localResolvers[strID] = rs
 	}
+	if err = rdr.Err(); err != nil {
+		errorLogger.Fatalf("Error reading results file, %v\n", err)
+	}
 	for k := range localResolvers {
 		resolvers[localResolvers[k].ResolverIP] = localResolvers[k]
 	}
@@ -232,10 +231,10 @@ func writeResolverStats(
 				continue
 			}
 			if dataType == "passesControl" {
-				if localResolvers[strIDA].ControlCount != len(controlDomains)*2 {
+				if localResolvers[strIDA].ControlCount != controlDomains.ExpectedAnswers() {
 					continue
 				}
-				if localResolvers[strIDB].ControlCount != len(controlDomains)*2 {
+				if localResolvers[strIDB].ControlCount != controlDomains.ExpectedAnswers() {
 					continue
 				}
 			}
@@ -350,10 +349,10 @@ func writePairStats(args InterpretResultsFlags, pairMap map[string]PairStats) {
 		defer pairFile.Close()
 		for _, pair := range pairMap {
 			if dataType == "passesControl" {
-				if pair.V4ControlCount != len(controlDomains)*2 {
+				if pair.V4ControlCount != controlDomains.ExpectedAnswers() {
 					continue
 				}
-				if pair.V6ControlCount != len(controlDomains)*2 {
+				if pair.V6ControlCount != controlDomains.ExpectedAnswers() {
 					continue
 				}
 			}
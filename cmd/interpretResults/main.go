@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"log"
 	"os"
 	"sort"
@@ -10,22 +8,30 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/control"
+	"github.com/timartiny/v4vsv6/pkg/results"
 )
 
 var (
 	infoLogger     *log.Logger
 	errorLogger    *log.Logger
-	controlDomains map[string]struct{}
+	controlDomains *control.Registry
+	// classifier re-decides which rounds were censored as results are read,
+	// nil to use the verdicts recorded by parseScans
+	classifier v4vsv6.Classifier
 )
 
 type InterpretResultsFlags struct {
-	DateString         string  `arg:"--date-string,required" help:"(Required) String that is appended/prepended to files with the date"`
-	DataFolder         string  `arg:"--data-folder,required" help:"(Required) Path to the folder to store answer to questions" json:"data_folder"`
-	ResultsFile        string  `arg:"--results-file,required" help:"(Required) Path to the file containing the DomainResolverResults" json:"results_file"`
-	Workers            int     `arg:"-w,--workers" help:"Number of workers to work simultaneously" default:"5" json:"wokers"`
-	CensorshipFraction float64 `arg:"-f,--fraction" help:"Fraction of queries that don't support TLS that should be considered censorship" default:"0.5" json:"censorship_fraction"`
-	ResolverFile       string  `arg:"-r,--resolver-file,required" help:"(Required) Path to the file containing the Resolver Pairings, needed to format output of Question 1" json:"resolver_file"`
-	Questions          []int   `arg:"-q,--questions,separate" help:"Which questions to answer, can be supplied multiple times" json:"questions"`
+	DateString         string   `arg:"--date-string,required" help:"(Required) String that is appended/prepended to files with the date"`
+	DataFolder         string   `arg:"--data-folder,required" help:"(Required) Path to the folder to store answer to questions" json:"data_folder"`
+	ResultsFile        string   `arg:"--results-file,required" help:"(Required) Path to the file containing the DomainResolverResults" json:"results_file"`
+	Workers            int      `arg:"-w,--workers" help:"Number of workers to work simultaneously" default:"5" json:"wokers"`
+	CensorshipFraction float64  `arg:"-f,--fraction" help:"Fraction of queries that don't support TLS that should be considered censorship" default:"0.5" json:"censorship_fraction"`
+	ResolverFile       string   `arg:"-r,--resolver-file,required" help:"(Required) Path to the file containing the Resolver Pairings, needed to format output of Question 1" json:"resolver_file"`
+	Questions          []int    `arg:"-q,--questions,separate" help:"Which questions to answer, can be supplied multiple times" json:"questions"`
+	ControlDomains     string   `arg:"--control-domains" help:"Path to a JSON file of control domains and their expected answers, defaults to the v4vsv6.com control domains" json:"control_domains"`
+	Classifiers        []string `arg:"--classifier,separate" help:"Re-decide which queries were censored with these classifiers (tls, rcode, blockpage-ip or http-blockpage) instead of using the recorded verdicts, can be supplied multiple times" json:"classifiers"`
+	BlockpageIPs       string   `arg:"--blockpage-ips" help:"Path to a file of known blockpage addresses or prefixes, one per line, for the blockpage-ip classifier" json:"blockpage_ips"`
 }
 
 type Counter struct {
@@ -40,37 +46,61 @@ func setupArgs() InterpretResultsFlags {
 	return ret
 }
 
-// readDomainResolverResults will read lines from the provided file. It will
-// pass them through a channel to workers to process the structs
+// readDomainResolverResults will read lines from the provided file, decoding
+// (and reclassifying) them with workers goroutines. It will pass them through a
+// channel to workers to process the structs
 func readDomainResolverResults(
 	path string,
+	workers int,
 	drrChan chan<- v4vsv6.DomainResolverResult,
 	wg *sync.WaitGroup,
 ) {
 	defer wg.Done()
-	resultsFile, err := os.Open(path)
+	rdr, err := results.OpenDRRReader(path)
 	if err != nil {
 		errorLogger.Fatalf("Error opening results file, %v\n", err)
 	}
-	defer resultsFile.Close()
+	defer rdr.Close()
 
-	scanner := bufio.NewScanner(resultsFile)
+	if workers < 1 {
+		workers = 1
+	}
+	decoded := make(chan *v4vsv6.DomainResolverResult)
+	var forwardWG sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		forwardWG.Add(1)
+		go func() {
+			defer forwardWG.Done()
+			for drr := range decoded {
+				if classifier != nil && !isControlDomain(*drr) {
+					reclassify(drr)
+				}
+				drrChan <- *drr
+			}
+		}()
+	}
+	err = rdr.FanOut(workers, decoded)
+	close(decoded)
+	forwardWG.Wait()
+	if err != nil {
+		errorLogger.Fatalf("Error reading results file, %v\n", err)
+	}
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		var drr v4vsv6.DomainResolverResult
-		json.Unmarshal([]byte(line), &drr)
-		drrChan <- drr
+// reclassify will decide again whether each round of drr was censored, and so
+// whether the query was
+func reclassify(drr *v4vsv6.DomainResolverResult) {
+	for _, rr := range drr.Rounds {
+		drr.ClassifyRound(rr, classifier)
+	}
+	if last := drr.LastRound(); last != nil {
+		drr.CensoredQuery = last.Censored
 	}
 }
 
 // isControlDomain will check if a provided drr is for a control domain.
 func isControlDomain(drr v4vsv6.DomainResolverResult) bool {
-	if _, ok := controlDomains[drr.Domain]; ok {
-		return true
-	}
-
-	return false
+	return controlDomains.IsControlDomain(drr.Domain)
 }
 
 // resolverStats will go throug the results file and for each resolver will
@@ -92,7 +122,25 @@ func main() {
 		"Each question will be answered one at a time, using %d workers\n",
 		args.Workers,
 	)
-	controlDomains = map[string]struct{}{"v4vsv6.com": {}, "test1.v4vsv6.com": {}, "test2.v4vsv6.com": {}}
+	var err error
+	controlDomains, err = control.Load(args.ControlDomains)
+	if err != nil {
+		errorLogger.Fatalf("Error loading control domains: %v\n", err)
+	}
+
+	if len(args.Classifiers) > 0 {
+		var classifierOpts v4vsv6.ClassifierOptions
+		if args.BlockpageIPs != "" {
+			classifierOpts.BlockpageIPs, err = v4vsv6.ReadBlockpageIPs(args.BlockpageIPs)
+			if err != nil {
+				errorLogger.Fatalf("Error reading blockpage addresses: %v\n", err)
+			}
+		}
+		classifier, err = v4vsv6.NewClassifiers(args.Classifiers, classifierOpts)
+		if err != nil {
+			errorLogger.Fatalln(err)
+		}
+	}
 
 	v4ToV6 := make(map[string]string)
 	v6ToV4 := make(map[string]string)
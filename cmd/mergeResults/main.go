@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -12,6 +10,7 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/results"
 )
 
 var (
@@ -22,6 +21,7 @@ var (
 type MergeResultsFlags struct {
 	DataFolder string `arg:"--data-folder,required" help:"(Required) The folder to read data from and write to" json:"data_folder"`
 	DateString string `arg:"--date-string,required" help:"(Required) The date string present in data files" json:"date_string"`
+	Rounds     int    `arg:"--rounds" help:"Number of rounds (days) of results to merge" default:"3" json:"rounds"`
 	Verbose    bool   `arg:"--verbose,-v" help:"Whether to add extra printing for debugging" json:"verbose"`
 }
 
@@ -34,8 +34,8 @@ func setupArgs() MergeResultsFlags {
 	return ret
 }
 
-//indexDRRDay will read the given day's domain-resolver-results JSON file and
-//read into memory the day2 or day3 drrs
+// indexDRRDay will read the given day's domain-resolver-results JSON file and
+// read into memory that round's drrs
 func indexDRRDay(
 	drrIndex DRRIndex,
 	args MergeResultsFlags,
@@ -48,72 +48,68 @@ func indexDRRDay(
 		fmt.Sprintf("%s-domain-resolver-results_day%d.json", args.DateString, day),
 	)
 	infoLogger.Printf("Indexing %s\n", drrFileName)
-	drrFile, err := os.Open(drrFileName)
+	rdr, err := results.OpenDRRReader(drrFileName)
 	if err != nil {
-		errorLogger.Printf("Error opening file: %s, %v\n", drrFileName, err)
+		errorLogger.Fatalf("Error opening file: %s, %v\n", drrFileName, err)
 	}
-	defer drrFile.Close()
-	scanner := bufio.NewScanner(drrFile)
+	defer rdr.Close()
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		drr := new(v4vsv6.DomainResolverResult)
-		err = json.Unmarshal([]byte(line), drr)
-		if err != nil {
-			errorLogger.Printf(
-				"Error unmarshaling drr bytes from %s: %v\n", drrFileName, err,
-			)
-			errorLogger.Fatalf(
-				"Bytes that failed to unmarshal (as string): %s\n", line,
-			)
-		}
+	for rdr.Next() {
+		drr := rdr.DomainResolverResult()
 		key := fmt.Sprintf(
 			"%s-%s-%s", drr.Domain, drr.ResolverIP, drr.RequestedAddressType,
 		)
 		drrIndex[key] = drr
 	}
+	if err = rdr.Err(); err != nil {
+		errorLogger.Fatalf("Error reading drrs: %v\n", err)
+	}
 	infoLogger.Printf("Done indexing day %d\n", day)
 }
 
 // consolidateResults will loop through day 1's results and look up the
-// corresponding day2 and day3 results (if applicable) then write the combined
-// results to the master file
+// corresponding results of every later round (if applicable) then write the
+// combined results to the master file. roundIndexes[i] holds the results of
+// round i+2.
 func consolidateResults(
-	drrDay2Index, drrDay3Index DRRIndex,
+	roundIndexes []DRRIndex,
 	args MergeResultsFlags,
 ) {
 	day1FileName := filepath.Join(
 		args.DataFolder,
 		fmt.Sprintf("%s-domain-resolver-results_day1.json", args.DateString),
 	)
-	day1File, err := os.Open(day1FileName)
+	day1Reader, err := results.OpenDRRReader(day1FileName)
 	if err != nil {
-		errorLogger.Printf("Error opening file: %s, %v\n", day1FileName, err)
+		errorLogger.Fatalf("Error opening file: %s, %v\n", day1FileName, err)
 	}
-	defer day1File.Close()
+	defer day1Reader.Close()
 
 	masterDRRFileName := filepath.Join(
 		args.DataFolder,
 		fmt.Sprintf("%s-domain-resolver-results.json", args.DateString),
 	)
-	masterDRRFile, err := os.Create(masterDRRFileName)
+	masterDRRWriter, err := results.CreateDRRWriter(masterDRRFileName)
 	if err != nil {
-		errorLogger.Printf(
+		errorLogger.Fatalf(
 			"Error creating file: %s, %v\n",
 			masterDRRFileName,
 			err,
 		)
 	}
-	defer masterDRRFile.Close()
-
-	day1Scanner := bufio.NewScanner(day1File)
+	defer func() {
+		if err := masterDRRWriter.Close(); err != nil {
+			errorLogger.Fatalf(
+				"Error closing file: %s, %v\n", masterDRRFileName, err,
+			)
+		}
+	}()
 
 	infoLogger.Printf("Reading through %s\n", day1FileName)
 	infoLogger.Printf("And writing to %s\n", masterDRRFileName)
 	var numLines int
 	nextVerboseTime := time.Now().Add(30 * time.Second)
-	for day1Scanner.Scan() {
-		line := day1Scanner.Text()
+	for day1Reader.Next() {
 		numLines++
 		if args.Verbose && time.Now().After(nextVerboseTime) {
 			infoLogger.Printf(
@@ -123,107 +119,74 @@ func consolidateResults(
 			)
 			nextVerboseTime = time.Now().Add(30 * time.Second)
 		}
-		var day1DRR v4vsv6.DomainResolverResult
-		err := json.Unmarshal([]byte(line), &day1DRR)
-		if err != nil {
-			errorLogger.Printf(
-				"Error unmarshaling following bytes (as string): %s\n", line,
-			)
-			errorLogger.Fatalf("Unmarshal Error: %v\n", err)
-		}
+		day1DRR := day1Reader.DomainResolverResult()
 		key := fmt.Sprintf(
 			"%s-%s-%s",
 			day1DRR.Domain,
 			day1DRR.ResolverIP,
 			day1DRR.RequestedAddressType,
 		)
-		if day2DRR, day2OK := drrDay2Index[key]; day2OK {
-			// day2 data is present, so we add it to the day1 drr
-			if day1DRR.Domain != day2DRR.Domain ||
-				day1DRR.ResolverIP != day2DRR.ResolverIP ||
-				day1DRR.RequestedAddressType != day2DRR.RequestedAddressType {
-				errorLogger.Fatalf(
-					"Got the incorrect day2 drr\nDay 1: %+v\nDay2: %+v\n",
-					day1DRR,
-					day2DRR,
-				)
+		missingRound := 0
+		for i, drrIndex := range roundIndexes {
+			round := i + 2
+			roundDRR, ok := drrIndex[key]
+			if !ok {
+				if missingRound == 0 {
+					missingRound = round
+				}
+				continue
 			}
-
-			// actually merge data finally
-			day1DRR.Day2Results = day2DRR.Day2Results
-
-			// this can be uncommented after feb-07 run
-
-			// if !day1DRR.CensoredQuery {
-			// 	errorLogger.Printf(
-			// 		"Day1DRR wasn't censored, why was it in day2?: %+v\n",
-			// 		day1DRR,
-			// 	)
-			// 	// this can be changed to Fatalf on runs after feb-07
-			// 	errorLogger.Printf("day2drr: %+v\n", day2DRR)
-			// }
-
-			// since day2 exists day 1 must have censored, so update the
-			// requests censorship to be day 2s
-			day1DRR.CensoredQuery = day2DRR.CensoredQuery
-		} else {
-			// here we didn't get anything in day2 so we confirm we get nothing
-			// in day 3
-			if _, day3OK := drrDay3Index[key]; day3OK {
+			if missingRound != 0 {
+				// a round is only run on queries censored in every earlier
+				// round, so there can't be a gap
 				errorLogger.Fatalf(
-					"Found %s in day 3 index but NOT in the day 2 index\n", key,
+					"Found %s in day %d index but NOT in the day %d index\n",
+					key,
+					round,
+					missingRound,
 				)
 			}
-		}
 
-		if day3DRR, day3OK := drrDay3Index[key]; day3OK {
-			// day3 data is present as well, so we add it to the day1 drr
-			if day1DRR.Domain != day3DRR.Domain ||
-				day1DRR.ResolverIP != day3DRR.ResolverIP ||
-				day1DRR.RequestedAddressType != day3DRR.RequestedAddressType {
+			// round data is present, so we add it to the day1 drr
+			if day1DRR.Domain != roundDRR.Domain ||
+				day1DRR.ResolverIP != roundDRR.ResolverIP ||
+				day1DRR.RequestedAddressType != roundDRR.RequestedAddressType {
 				errorLogger.Fatalf(
-					"Got the incorrect day3 drr\nDay 1: %+v\nDay3: %+v\n",
+					"Got the incorrect day%d drr\nDay 1: %+v\nDay%d: %+v\n",
+					round,
 					day1DRR,
-					day3DRR,
+					round,
+					roundDRR,
 				)
 			}
 
 			// actually merge data finally
-			day1DRR.Day3Results = day3DRR.Day3Results
-
-			// this can be uncommented on runs after feb-07
-
-			// if !day1DRR.CensoredQuery {
-			// 	errorLogger.Fatalf(
-			// 		"Day1DRR wasn't censored, why was it in day3?: %+v\n",
-			// 		day1DRR,
-			// 	)
-			// }
+			rr := roundDRR.Round(round)
+			if rr == nil {
+				errorLogger.Fatalf(
+					"Day %d drr has no results for day %d: %+v\n",
+					round,
+					round,
+					roundDRR,
+				)
+			}
+			day1DRR.AddRound(rr)
 
-			// since day3 exists day 1 and day2 must have censored, so update
-			// the requests censorship to be day 3s
-			day1DRR.CensoredQuery = day3DRR.CensoredQuery
+			// since this round exists all earlier rounds must have censored,
+			// so update the requests censorship to be this round's
+			day1DRR.CensoredQuery = roundDRR.CensoredQuery
 		}
 
 		// now we have all the data together, so write it!
-		bs, err := json.Marshal(day1DRR)
-		if err != nil {
-			errorLogger.Fatalf(
-				"Error marshaling day1DRR: %+v, %v\n", day1DRR, err,
-			)
-		}
-		_, err = masterDRRFile.Write(bs)
+		err = masterDRRWriter.Write(day1DRR)
 		if err != nil {
 			errorLogger.Fatalf(
 				"Error writing to file: %s, %v\n", masterDRRFileName, err,
 			)
 		}
-		_, err = masterDRRFile.WriteString("\n")
-		if err != nil {
-			errorLogger.Fatalf(
-				"Error writing newline to file: %v\n", err,
-			)
-		}
+	}
+	if err = day1Reader.Err(); err != nil {
+		errorLogger.Fatalf("Error reading day 1 drrs: %v\n", err)
 	}
 }
 
@@ -238,20 +201,23 @@ func main() {
 		"ERROR: ",
 		log.Ldate|log.Ltime|log.Lshortfile,
 	)
-	drrDay2Index := make(DRRIndex)
-	drrDay3Index := make(DRRIndex)
 	var indexWG sync.WaitGroup
 	args := setupArgs()
+	if args.Rounds < 1 {
+		errorLogger.Fatalf("Invalid number of rounds: %d\n", args.Rounds)
+	}
 
-	// index day2, day3
-	indexWG.Add(1)
-	go indexDRRDay(drrDay2Index, args, 2, &indexWG)
-	indexWG.Add(1)
-	go indexDRRDay(drrDay3Index, args, 3, &indexWG)
+	// index every round after the first
+	roundIndexes := make([]DRRIndex, args.Rounds-1)
+	for i := range roundIndexes {
+		roundIndexes[i] = make(DRRIndex)
+		indexWG.Add(1)
+		go indexDRRDay(roundIndexes[i], args, i+2, &indexWG)
+	}
 
-	infoLogger.Println("Waiting for day 2 and day 3 indexing")
+	infoLogger.Printf("Waiting for days 2 through %d indexing\n", args.Rounds)
 	indexWG.Wait()
 
 	// loop through day1 and write all results
-	consolidateResults(drrDay2Index, drrDay3Index, args)
+	consolidateResults(roundIndexes, args)
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -14,19 +16,45 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/miekg/dns"
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/control"
+	"github.com/timartiny/v4vsv6/pkg/prober"
+	"github.com/timartiny/v4vsv6/pkg/roots"
 )
 
 var (
-	infoLogger  *log.Logger
-	errorLogger *log.Logger
+	infoLogger     *log.Logger
+	errorLogger    *log.Logger
+	controlDomains *control.Registry
+	// tlsPort and rootCAs are where TLS connections are made to and the
+	// roots certificates are verified against, changed by tests to reach
+	// servers running on loopback
+	tlsPort = "443"
+	rootCAs *x509.CertPool
+	// dnsPort is where queries sent over TCP go, changed by tests like
+	// tlsPort
+	dnsPort = "53"
+	// rootsID names the root bundle rootCAs came from, recorded with each
+	// TLS checked result
+	rootsID = roots.System
+	// classifier decides whether the answers for a test domain were
+	// censored
+	classifier v4vsv6.Classifier = v4vsv6.TLSValidity{}
 )
 
 type NoRDBitFlags struct {
-	InputFile  string `arg:"--input,required" help:"(Required) File to read \"domain,ip\" inputs from"`
-	SourceIP   string `arg:"--source-ip" help:"Address to send queries from" default:"192.12.240.40"`
-	Threads    int    `arg:"--threads" help:"Number of goroutines to use for queries" default:"1000"`
-	Timeout    int    `arg:"--timeout" help:"Number of seconds to wait for DNS and TLS connections" default:"5"`
-	OutputFile string `arg:"--output,required" help:"(Required) Path to the file to save results to"`
+	InputFile      string   `arg:"--input,required" help:"(Required) File to read \"domain,ip\" inputs from"`
+	SourceIP       string   `arg:"--source-ip" help:"Address to send queries from" default:"192.12.240.40"`
+	Threads        int      `arg:"--threads" help:"Number of goroutines to use for queries" default:"1000"`
+	Timeout        int      `arg:"--timeout" help:"Number of seconds to wait for DNS and TLS connections" default:"5"`
+	Retries        int      `arg:"--retries" help:"Number of times to resend a query that isn't answered within --timeout" default:"0"`
+	Sockets        int      `arg:"--sockets" help:"Number of UDP sockets to send queries from" default:"4"`
+	OutputFile     string   `arg:"--output,required" help:"(Required) Path to the file to save results to"`
+	ControlDomains string   `arg:"--control-domains" help:"Path to a JSON file of control domains and their expected answers, defaults to the v4vsv6.com control domains"`
+	RootBundle     string   `arg:"--root-bundle" help:"Path to a PEM bundle of root certificates to verify TLS against, such as a Mozilla or CCADB snapshot, defaults to the system roots"`
+	Variations     string   `arg:"--variations" help:"Comma separated variations of the query to send for each input, or \"all\". See the README for the list" default:"no-rd"`
+	Classifiers    []string `arg:"--classifier,separate" help:"How to decide a query was censored, one of tls, rcode or blockpage-ip, can be supplied multiple times, censored if any say so [default: tls]"`
+	BlockpageIPs   string   `arg:"--blockpage-ips" help:"Path to a file of known blockpage addresses or prefixes, one per line, for the blockpage-ip classifier"`
 }
 
 type CensorshipCode uint
@@ -41,22 +69,60 @@ const (
 	ReturnedValidRecord
 )
 
+var censorshipCodeNames = []string{
+	"Unknown",
+	"ResolverResolveError",
+	"ResolverDialError",
+	"ResolverReadError",
+	"ReturnedAdditionals",
+	"ReturnedInvalidRecord",
+	"ReturnedValidRecord",
+}
+
+func (c CensorshipCode) String() string {
+	if int(c) < len(censorshipCodeNames) {
+		return censorshipCodeNames[c]
+	}
+
+	return fmt.Sprintf("CensorshipCode(%d)", uint(c))
+}
+
 type DNSResult struct {
-	Resolver string
-	Domain   string
-	Record   string
-	RCode    int
-	CCode    CensorshipCode
-	Answers  []net.IP
+	Resolver  string
+	Domain    string
+	Record    string
+	Variation string
+	RCode     int
+	CCode     CensorshipCode
+	Answers   []net.IP
+	TTLs      []uint32
+	CNAMEs    []string
+	// Censored, Rule and Reason are the verdict on the response, unset for
+	// responses that weren't classified
+	Censored bool
+	Rule     string
+	Reason   string
+	// Roots is the ID of the root bundle the answers were TLS checked
+	// against, empty if they weren't
+	Roots string
+	// CaseKept is whether the response's question name had the exact case
+	// sent, for variations that randomize it
+	CaseKept *bool
 }
 
 type Result struct {
 	Resolver    string         `json:"resolver"`
 	Domain      string         `json:"domain"`
 	Record      string         `json:"record"`
+	Variation   string         `json:"variation"`
 	RCode       int            `json:"r_code"`
 	CCode       CensorshipCode `json:"c_code"`
 	Explanation string         `json:"explanation"`
+	Censored    bool           `json:"censored"`
+	Rule        string         `json:"rule,omitempty"`
+	Reason      string         `json:"reason,omitempty"`
+	Roots       string         `json:"roots,omitempty"`
+	CaseKept    *bool          `json:"case_kept,omitempty"`
 }
 
 func setupArgs() NoRDBitFlags {
@@ -66,30 +132,63 @@ func setupArgs() NoRDBitFlags {
 	return ret
 }
 
+// exchangeTCP sends m to resolverIP over TCP from sourceIP, if it's set. It
+// returns the code to record when no response came back.
+func exchangeTCP(
+	m *dns.Msg,
+	sourceIP net.IP,
+	resolverIP net.IP,
+	timeout time.Duration,
+) (*dns.Msg, CensorshipCode) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if sourceIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: sourceIP}
+	}
+	client := &dns.Client{Net: "tcp", Dialer: dialer, Timeout: timeout}
+	conn, err := client.Dial(net.JoinHostPort(resolverIP.String(), dnsPort))
+	if err != nil {
+		return nil, ResolverDialError
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := conn.WriteMsg(m); err != nil {
+		return nil, ResolverReadError
+	}
+	r, err := conn.ReadMsg()
+	if err != nil {
+		return nil, ResolverReadError
+	}
+
+	return r, Unknown
+}
+
 func resolveDomain(
+	pr *prober.Prober,
+	sourceIP net.IP,
+	timeout time.Duration,
 	resolverIP net.IP,
-	dialer net.Dialer,
 	domain string,
 	record string,
-	timeout time.Duration,
+	variation Variation,
 ) DNSResult {
 	resolverAddr := resolverIP.String() + ":53"
 	if resolverIP.To4() == nil {
 		resolverAddr = "[" + resolverIP.String() + "]:53"
 	}
 	dnsResult := DNSResult{
-		Resolver: resolverIP.String(),
-		Domain:   domain,
-		Record:   record,
-		RCode:    -1,
+		Resolver:  resolverIP.String(),
+		Domain:    domain,
+		Record:    record,
+		Variation: variation.Name,
+		RCode:     -1,
 	}
 	m := &dns.Msg{
-		// no recursion desired
+		// what a stub resolver sends, for the variation to change
 		MsgHdr: dns.MsgHdr{
 			Authoritative:     false,
 			AuthenticatedData: false,
 			CheckingDisabled:  false,
-			RecursionDesired:  false,
+			RecursionDesired:  true,
 			Opcode:            dns.OpcodeQuery,
 		},
 		Question: make([]dns.Question, 1),
@@ -111,19 +210,34 @@ func resolveDomain(
 		Qclass: uint16(dns.ClassINET),
 	}
 	m.Id = dns.Id()
-	dnsQuery, err := m.Pack()
-	if err != nil {
-		errorLogger.Printf(
-			"Error creating UDP packet for domain: %s (resolver: %s)\n",
-			domain,
-			resolverAddr,
-		)
-		errorLogger.Println(err)
-		return dnsResult
+	if variation.Apply != nil {
+		variation.Apply(m)
 	}
 	func() {
-		conn, err := dialer.Dial("udp", resolverAddr)
-		if err != nil {
+		if variation.TCP {
+			r, ccode := exchangeTCP(m, sourceIP, resolverIP, timeout)
+			if r == nil {
+				dnsResult.CCode = ccode
+				return
+			}
+			if variation.CheckCase {
+				dnsResult.CaseKept = caseKept(m, r)
+			}
+			readResponse(&dnsResult, r)
+			return
+		}
+		resp, err := pr.Exchange(m, resolverIP)
+		var proberErr *prober.Error
+		if errors.As(err, &proberErr) && proberErr.Op == prober.OpPack {
+			errorLogger.Printf(
+				"Error creating UDP packet for domain: %s (resolver: %s)\n",
+				domain,
+				resolverAddr,
+			)
+			errorLogger.Println(err)
+			return
+		}
+		if errors.As(err, &proberErr) && proberErr.Op == prober.OpDial {
 			// errorLogger.Printf(
 			// 	"Error creating UDP socket(?): %s\n",
 			// 	resolverAddr,
@@ -132,14 +246,7 @@ func resolveDomain(
 			dnsResult.CCode = ResolverDialError
 			return
 		}
-
-		defer conn.Close()
-
-		conn.Write(dnsQuery)
-		conn.SetReadDeadline(time.Now().Add(timeout))
-		resp := make([]byte, 1024)
-		_, err = conn.Read(resp)
-		if err != nil {
+		if resp.Raw == nil {
 			// errorLogger.Printf(
 			// 	"Error reading from %s for %s\n", resolverAddr, domain,
 			// )
@@ -147,78 +254,231 @@ func resolveDomain(
 			dnsResult.CCode = ResolverReadError
 			return
 		}
-		var r dns.Msg
-		err = r.Unpack(resp)
 		if err != nil {
 			fmt.Printf(
 				"Error Parsing response for %s from %s\n", domain, resolverAddr,
 			)
 			return
 		}
-		dnsResult.RCode = r.Rcode
-		if dnsResult.RCode != 0 {
-			dnsResult.CCode = ResolverResolveError
-			return
-		}
-		if len(r.Answer) > 0 {
-			for _, answer := range r.Answer {
-				lastTab := strings.LastIndex(answer.String(), "\t")
-				strIP := answer.String()[lastTab+1:]
-				ip := net.ParseIP(strIP)
-				if ip != nil {
-					dnsResult.Answers = append(dnsResult.Answers, ip)
-				}
-			}
-			// Actually returned Records, so use that to determine censorship
-			return
-		}
-		// no Answers were given so see if they returned additionals or
-		// authorities
-		if len(r.Ns) > 0 || len(r.Extra) > 0 {
-			dnsResult.CCode = ReturnedAdditionals
+		if variation.CheckCase {
+			dnsResult.CaseKept = caseKept(m, resp.Msg)
 		}
+		readResponse(&dnsResult, resp.Msg)
 	}()
 
 	return dnsResult
 }
 
+// readResponse records the rcode and answers of r in dnsResult, setting the
+// censorship code if the answers don't need checking
+func readResponse(dnsResult *DNSResult, r *dns.Msg) {
+	dnsResult.RCode = r.Rcode
+	if dnsResult.RCode != 0 {
+		dnsResult.CCode = ResolverResolveError
+		return
+	}
+	if len(r.Answer) > 0 {
+		for _, answer := range r.Answer {
+			lastTab := strings.LastIndex(answer.String(), "\t")
+			strIP := answer.String()[lastTab+1:]
+			ip := net.ParseIP(strIP)
+			if ip != nil {
+				dnsResult.Answers = append(dnsResult.Answers, ip)
+				dnsResult.TTLs = append(dnsResult.TTLs, answer.Header().Ttl)
+			}
+			if cname, ok := answer.(*dns.CNAME); ok {
+				dnsResult.CNAMEs = append(dnsResult.CNAMEs, cname.Target)
+			}
+		}
+		// Actually returned Records, so use that to determine censorship
+		return
+	}
+	// no Answers were given so see if they returned additionals or
+	// authorities
+	if len(r.Ns) > 0 || len(r.Extra) > 0 {
+		dnsResult.CCode = ReturnedAdditionals
+	}
+}
+
+// tlsLookup checks whether each of ips presents a valid certificate for domain,
+// stopping at the first that does, and returns the outcome for each address
+// checked
 func tlsLookup(
 	domain string, ips []net.IP, timeout time.Duration,
-) CensorshipCode {
-	config := tls.Config{ServerName: domain}
+) []*v4vsv6.AddressResult {
+	config := tls.Config{ServerName: domain, RootCAs: rootCAs}
+	var ret []*v4vsv6.AddressResult
 	for _, ip := range ips {
-		ret := func() CensorshipCode {
+		ar := newAddressResult(domain, ip)
+		ar.TLS = func() *v4vsv6.TLSResult {
 			dialConn, err := net.DialTimeout(
-				"tcp", net.JoinHostPort(ip.String(), "443"), timeout,
+				"tcp", net.JoinHostPort(ip.String(), tlsPort), timeout,
 			)
 			if err != nil {
-				return ReturnedInvalidRecord
+				return &v4vsv6.TLSResult{
+					Status:  "connection-error",
+					Failure: v4vsv6.TLSFailureStatus,
+					Error:   err.Error(),
+					Roots:   rootsID,
+				}
 			}
 			tlsConn := tls.Client(dialConn, &config)
 			defer tlsConn.Close()
-			err = tlsConn.Handshake()
-			if err != nil {
-				return ReturnedInvalidRecord
+			tlsConn.SetDeadline(time.Now().Add(timeout))
+			// the handshake verifies the chain and the leaf's hostname
+			if err = tlsConn.Handshake(); err != nil {
+				return &v4vsv6.TLSResult{
+					Status:  "handshake-error",
+					Failure: tlsFailure(err),
+					Error:   err.Error(),
+					Roots:   rootsID,
+				}
 			}
-			// Leaf Cert
-			err = tlsConn.ConnectionState().PeerCertificates[0].VerifyHostname(domain)
-			if err != nil {
-				return ReturnedInvalidRecord
-			} else {
-				return ReturnedValidRecord
+			return &v4vsv6.TLSResult{
+				Status:        "success",
+				HostnameValid: true,
+				ChainValid:    true,
+				Roots:         rootsID,
 			}
 		}()
-		if ret == ReturnedValidRecord {
-			return ret
+		ar.SupportsTLS = ar.TLS.Valid()
+		ret = append(ret, ar)
+		if ar.SupportsTLS {
+			break
+		}
+	}
+
+	return ret
+}
+
+// tlsFailure returns which TLS failure a handshake error was
+func tlsFailure(err error) string {
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &hostnameErr):
+		return v4vsv6.TLSFailureHostname
+	case errors.As(err, &authorityErr):
+		return v4vsv6.TLSFailureChain
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return v4vsv6.TLSFailureExpired
+	default:
+		return v4vsv6.TLSFailureStatus
+	}
+}
+
+// newAddressResult returns the AddressResult for ip, an answer for domain
+func newAddressResult(domain string, ip net.IP) *v4vsv6.AddressResult {
+	ar := &v4vsv6.AddressResult{IP: ip.String(), Domain: domain, AddressType: "AAAA"}
+	if ip.To4() != nil {
+		ar.AddressType = "A"
+	}
+
+	return ar
+}
+
+// controlLookup checks the answers for a control domain against what our
+// authoritative servers return, rather than checking TLS.
+func controlLookup(dnsResult DNSResult) []*v4vsv6.AddressResult {
+	cd := controlDomains.Lookup(dnsResult.Domain)
+	validCNAME := cd.ValidCNAME(dnsResult.CNAMEs)
+	var ret []*v4vsv6.AddressResult
+	for i, ip := range dnsResult.Answers {
+		ar := newAddressResult(dnsResult.Domain, ip)
+		switch {
+		case !cd.ValidAddress(dnsResult.Record, ip):
+			ar.Error = "not an expected address"
+		case !cd.ValidTTL(dnsResult.TTLs[i]):
+			ar.Error = fmt.Sprintf("unexpected TTL %d", dnsResult.TTLs[i])
+		case !validCNAME:
+			ar.Error = "unexpected CNAMEs " + strings.Join(dnsResult.CNAMEs, ", ")
+		default:
+			ar.ValidControlIP = true
+		}
+		ret = append(ret, ar)
+	}
+
+	return ret
+}
+
+// controlAnswers says a round of a control domain is censored unless every
+// answer is one the control domain registry expects. It has no opinion on
+// other domains.
+type controlAnswers struct{}
+
+// Classify implements v4vsv6.Classifier.
+func (controlAnswers) Classify(
+	drr *v4vsv6.DomainResolverResult, rr *v4vsv6.RoundResult,
+) (v4vsv6.Verdict, bool) {
+	if !controlDomains.IsControlDomain(drr.Domain) {
+		return v4vsv6.Verdict{}, false
+	}
+	if len(rr.Results) == 0 {
+		return v4vsv6.Verdict{
+			Censored: true,
+			Rule:     v4vsv6.RuleControlDomain,
+			Reason:   "no addresses returned",
+		}, true
+	}
+	for _, ar := range rr.Results {
+		if ar != nil && !ar.ValidControlIP {
+			return v4vsv6.Verdict{
+				Censored: true,
+				Rule:     v4vsv6.RuleControlDomain,
+				Reason:   ar.IP + ": " + ar.Error,
+			}, true
 		}
 	}
 
-	return ReturnedInvalidRecord
+	return v4vsv6.Verdict{
+		Rule:   v4vsv6.RuleControlDomain,
+		Reason: "every answer is expected",
+	}, true
+}
+
+// classify records the verdict on the response in dnsResult, from the
+// control domain registry for control domains and classifier otherwise, and
+// the censorship code it comes to. Responses without answers that the
+// resolver didn't report an error for are cache misses, which get no verdict.
+func classify(dnsResult *DNSResult, timeout time.Duration) {
+	if dnsResult.RCode < 0 || (dnsResult.RCode == dns.RcodeSuccess && len(dnsResult.Answers) == 0) {
+		return
+	}
+	drr := &v4vsv6.DomainResolverResult{
+		Domain:               dnsResult.Domain,
+		ResolverIP:           dnsResult.Resolver,
+		RequestedAddressType: dnsResult.Record,
+	}
+	rr := &v4vsv6.RoundResult{Status: dns.RcodeToString[dnsResult.RCode]}
+	c := classifier
+	if controlDomains.IsControlDomain(dnsResult.Domain) {
+		c = controlAnswers{}
+		rr.Results = controlLookup(*dnsResult)
+	} else if len(dnsResult.Answers) > 0 {
+		rr.Results = tlsLookup(dnsResult.Domain, dnsResult.Answers, timeout)
+		dnsResult.Roots = rootsID
+	}
+	verdict := drr.ClassifyRound(rr, c)
+	dnsResult.Censored = verdict.Censored
+	dnsResult.Rule = verdict.Rule
+	dnsResult.Reason = verdict.Reason
+	if len(dnsResult.Answers) == 0 {
+		// the code stays ResolverResolveError
+		return
+	}
+	if verdict.Censored {
+		dnsResult.CCode = ReturnedInvalidRecord
+	} else {
+		dnsResult.CCode = ReturnedValidRecord
+	}
 }
 
 func inputWorker(
+	pr *prober.Prober,
 	sourceIP net.IP,
 	timeout time.Duration,
+	variations []Variation,
 	inputChan <-chan string,
 	resultChan chan<- DNSResult,
 	wg *sync.WaitGroup,
@@ -226,12 +486,6 @@ func inputWorker(
 	defer wg.Done()
 
 	for input := range inputChan {
-		udpAddr := &net.UDPAddr{
-			IP: sourceIP,
-		}
-		dialer := net.Dialer{
-			LocalAddr: udpAddr,
-		}
 		records := []string{"A", "AAAA"}
 		splitInput := strings.Split(input, ",")
 		domain := splitInput[0]
@@ -261,29 +515,20 @@ func inputWorker(
 			}
 		}
 
-		for _, record := range records {
-			dnsResult := resolveDomain(
-				resolverIP,
-				dialer,
-				domain,
-				record,
-				timeout,
-			)
-			if dnsResult.CCode == Unknown {
-				// still need to determine censorship
-				if len(dnsResult.Answers) <= 0 {
-					// didn't get any answers though, so there's nothing to do
-					// errorLogger.Printf(
-					// 	"Got CCode of Unknown with no Answers for %s "+
-					// 		"resolving %s\n",
-					// 	dnsResult.Resolver,
-					// 	dnsResult.Domain,
-					// )
-				} else {
-					dnsResult.CCode = tlsLookup(domain, dnsResult.Answers, timeout)
-				}
+		for _, variation := range variations {
+			for _, record := range records {
+				dnsResult := resolveDomain(
+					pr,
+					sourceIP,
+					timeout,
+					resolverIP,
+					domain,
+					record,
+					variation,
+				)
+				classify(&dnsResult, timeout)
+				resultChan <- dnsResult
 			}
-			resultChan <- dnsResult
 		}
 	}
 }
@@ -301,14 +546,23 @@ func saveResults(
 		errorLogger.Fatalln(err)
 	}
 	defer oFile.Close()
+	summary := newVariationSummary()
+	defer summary.log()
 
 	for dnsResult := range resultChan {
+		summary.add(dnsResult)
 		var result Result
 		result.Domain = dnsResult.Domain
 		result.Resolver = dnsResult.Resolver
 		result.RCode = dnsResult.RCode
 		result.CCode = dnsResult.CCode
 		result.Record = dnsResult.Record
+		result.Variation = dnsResult.Variation
+		result.Censored = dnsResult.Censored
+		result.Rule = dnsResult.Rule
+		result.Reason = dnsResult.Reason
+		result.Roots = dnsResult.Roots
+		result.CaseKept = dnsResult.CaseKept
 		switch result.CCode {
 		case Unknown:
 			result.Explanation = "Unusual Circumstance where c_code is never modified"
@@ -325,13 +579,22 @@ func saveResults(
 			result.Explanation = "Resolver returned Additionals and/or Authorities"
 		case ReturnedInvalidRecord:
 			result.Explanation = fmt.Sprintf(
-				"Resolver returned %s record, but it failed the TLS check",
+				"Resolver returned %s record, but it failed the %s check",
 				result.Record,
+				checkName(result.Rule),
 			)
 		case ReturnedValidRecord:
+			if result.Rule == "" {
+				result.Explanation = fmt.Sprintf(
+					"Resolver returned %s record, and no check had an opinion on it",
+					result.Record,
+				)
+				break
+			}
 			result.Explanation = fmt.Sprintf(
-				"Resolver returned %s record, and it passed the TLS check",
+				"Resolver returned %s record, and it passed the %s check",
 				result.Record,
+				checkName(result.Rule),
 			)
 		}
 		bBytes, err := json.Marshal(&result)
@@ -344,6 +607,19 @@ func saveResults(
 	}
 }
 
+// checkName returns how the classifier rule that decided a verdict is
+// described in explanations.
+func checkName(rule string) string {
+	switch rule {
+	case v4vsv6.RuleControlDomain:
+		return "control domain"
+	case v4vsv6.RuleTLS:
+		return "TLS"
+	}
+
+	return rule
+}
+
 func lineCounter(fileName string) int {
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -372,12 +648,51 @@ func main() {
 		log.Ldate|log.Ltime|log.Lshortfile,
 	)
 	args := setupArgs()
+	var err error
+	controlDomains, err = control.Load(args.ControlDomains)
+	if err != nil {
+		errorLogger.Fatalf("Error loading control domains: %v\n", err)
+	}
+	rootBundle, err := roots.Load(args.RootBundle)
+	if err != nil {
+		errorLogger.Fatalf("Error loading root bundle: %v\n", err)
+	}
+	rootCAs, rootsID = rootBundle.Pool, rootBundle.ID
+	variations, err := parseVariations(args.Variations)
+	if err != nil {
+		errorLogger.Fatalln(err)
+	}
+	infoLogger.Printf("Verifying TLS against the %s roots\n", rootsID)
+	var classifierOpts v4vsv6.ClassifierOptions
+	if args.BlockpageIPs != "" {
+		classifierOpts.BlockpageIPs, err = v4vsv6.ReadBlockpageIPs(args.BlockpageIPs)
+		if err != nil {
+			errorLogger.Fatalf("Error reading blockpage addresses: %v\n", err)
+		}
+	}
+	classifier, err = v4vsv6.NewClassifiers(args.Classifiers, classifierOpts)
+	if err != nil {
+		errorLogger.Fatalln(err)
+	}
 	connTimeout := time.Second * time.Duration(args.Timeout)
 	sourceIP := net.ParseIP(args.SourceIP)
 	if sourceIP == nil {
 		errorLogger.Fatalf("Invalid Source IP: %s\n", args.SourceIP)
 	}
 
+	proberConfig := prober.Config{
+		Sockets: args.Sockets,
+		Timeout: connTimeout,
+		Retries: args.Retries,
+	}
+	if sourceIP.To4() != nil {
+		proberConfig.SourceV4 = sourceIP
+	} else {
+		proberConfig.SourceV6 = sourceIP
+	}
+	pr := prober.New(proberConfig)
+	defer pr.Close()
+
 	var workersWG sync.WaitGroup
 	var saveResultsWG sync.WaitGroup
 	inputChan := make(chan string)
@@ -389,8 +704,10 @@ func main() {
 	for w := uint(0); w < uint(args.Threads); w++ {
 		workersWG.Add(1)
 		go inputWorker(
+			pr,
 			sourceIP,
 			connTimeout,
+			variations,
 			inputChan,
 			resultChan,
 			&workersWG,
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/control"
+	"github.com/timartiny/v4vsv6/pkg/fakenet"
+	"github.com/timartiny/v4vsv6/pkg/prober"
+)
+
+// TestResolveDomain checks the censorship codes given for queries without the
+// RD bit to a resolver on loopback, which only answers from its cache.
+func TestResolveDomain(t *testing.T) {
+	infoLogger = log.New(ioutil.Discard, "", 0)
+	errorLogger = log.New(ioutil.Discard, "", 0)
+	var err error
+	controlDomains, err = control.Load("")
+	require.NoError(t, err)
+
+	auth, err := fakenet.NewAuthoritative(fakenet.Zone{
+		"cached.test":   fakenet.Host(),
+		"uncached.test": fakenet.Host(),
+		"v4nx.test":     fakenet.Host(),
+		"dropped.test":  fakenet.Host(),
+		"bogus.test":    fakenet.Host(),
+	})
+	require.NoError(t, err)
+	defer auth.Close()
+	resolver, err := fakenet.NewResolver(fakenet.ResolverConfig{
+		Upstream: auth,
+		Rules: []fakenet.Rule{
+			{Domain: "v4nx.test", Family: 4, Action: fakenet.NXDomain},
+			{Domain: "dropped.test", Action: fakenet.Drop},
+			{
+				Domain: "bogus.test",
+				Action: fakenet.Bogus,
+				Bogus:  []net.IP{net.IPv4(127, 0, 0, 2)},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer resolver.Close()
+	ca, err := fakenet.NewCA()
+	require.NoError(t, err)
+	server, err := fakenet.NewTLSServer(ca, "cached.test")
+	require.NoError(t, err)
+	defer server.Close()
+	tlsPort = fmt.Sprint(server.Port)
+	rootCAs = ca.Pool()
+	defer func() {
+		tlsPort, rootCAs = "443", nil
+	}()
+
+	// someone else looked up cached.test, so it's in the resolver's cache
+	m := new(dns.Msg)
+	m.SetQuestion("cached.test.", dns.TypeA)
+	_, _, err = new(dns.Client).Exchange(m, resolver.Addr(fakenet.V4))
+	require.NoError(t, err)
+
+	pr := prober.New(prober.Config{
+		Port:    resolver.Port,
+		Timeout: 500 * time.Millisecond,
+	})
+	defer pr.Close()
+
+	noRD, err := parseVariations("no-rd")
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		resolver net.IP
+		domain   string
+		rcode    int
+		ccode    CensorshipCode
+		censored bool
+		rule     string
+	}{
+		{fakenet.V4, "cached.test", dns.RcodeSuccess, ReturnedValidRecord, false, v4vsv6.RuleTLS},
+		// cache misses and lost queries get no verdict
+		{fakenet.V4, "uncached.test", dns.RcodeSuccess, Unknown, false, ""},
+		{fakenet.V4, "v4nx.test", dns.RcodeNameError, ResolverResolveError, true, v4vsv6.RuleTLS},
+		{fakenet.V6, "v4nx.test", dns.RcodeSuccess, Unknown, false, ""},
+		{fakenet.V6, "dropped.test", -1, ResolverReadError, false, ""},
+		{fakenet.V6, "bogus.test", dns.RcodeSuccess, ReturnedInvalidRecord, true, v4vsv6.RuleTLS},
+	} {
+		dnsResult := resolveDomain(pr, nil, time.Second, tc.resolver, tc.domain, "A", noRD[0])
+		classify(&dnsResult, time.Second)
+		require.Equal(t, tc.rcode, dnsResult.RCode, "%s at %s", tc.domain, tc.resolver)
+		require.Equal(t, tc.ccode, dnsResult.CCode, "%s at %s", tc.domain, tc.resolver)
+		require.Equal(t, tc.censored, dnsResult.Censored, "%s at %s", tc.domain, tc.resolver)
+		require.Equal(t, tc.rule, dnsResult.Rule, "%s at %s", tc.domain, tc.resolver)
+	}
+
+	// the rcode classifier has no opinion on answers, so they pass
+	classifier = v4vsv6.RCodes{"NXDOMAIN": true}
+	defer func() { classifier = v4vsv6.TLSValidity{} }()
+	dnsResult := resolveDomain(pr, nil, time.Second, fakenet.V6, "bogus.test", "A", noRD[0])
+	classify(&dnsResult, time.Second)
+	require.Equal(t, ReturnedValidRecord, dnsResult.CCode)
+	require.False(t, dnsResult.Censored)
+	require.Empty(t, dnsResult.Rule)
+	dnsResult = resolveDomain(pr, nil, time.Second, fakenet.V4, "v4nx.test", "A", noRD[0])
+	classify(&dnsResult, time.Second)
+	require.Equal(t, ResolverResolveError, dnsResult.CCode)
+	require.True(t, dnsResult.Censored)
+	require.Equal(t, v4vsv6.RuleRCode, dnsResult.Rule)
+}
+
+// TestClassifyControl checks control domain answers are judged against the
+// registry rather than TLS
+func TestClassifyControl(t *testing.T) {
+	var err error
+	controlDomains, err = control.Load("")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		answer   string
+		ttl      uint32
+		censored bool
+	}{
+		{"192.12.240.40", 300, false},
+		{"10.10.34.34", 300, true},
+	} {
+		dnsResult := DNSResult{
+			Resolver: "192.0.2.53",
+			Domain:   "v4vsv6.com",
+			Record:   "A",
+			Answers:  []net.IP{net.ParseIP(tc.answer)},
+			TTLs:     []uint32{tc.ttl},
+		}
+		classify(&dnsResult, time.Second)
+		require.Equal(t, tc.censored, dnsResult.Censored, tc.answer)
+		require.Equal(t, v4vsv6.RuleControlDomain, dnsResult.Rule, tc.answer)
+		require.Empty(t, dnsResult.Roots, tc.answer)
+	}
+}
+
+// TestVariations checks each variation's query still gets answered by a
+// resolver on loopback, over TCP too, and that results are tagged with it.
+func TestVariations(t *testing.T) {
+	infoLogger = log.New(ioutil.Discard, "", 0)
+	errorLogger = log.New(ioutil.Discard, "", 0)
+
+	auth, err := fakenet.NewAuthoritative(fakenet.Zone{
+		"example.test": fakenet.Host(),
+	})
+	require.NoError(t, err)
+	defer auth.Close()
+	resolver, err := fakenet.NewResolver(fakenet.ResolverConfig{
+		Upstream: auth,
+		Rules: []fakenet.Rule{
+			{Domain: "blocked.test", Family: 6, Action: fakenet.NXDomain},
+		},
+	})
+	require.NoError(t, err)
+	defer resolver.Close()
+
+	// fakenet only serves UDP, so the resolver is served over TCP here
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	started := make(chan struct{})
+	tcpServer := &dns.Server{
+		Listener:          l,
+		Handler:           resolver,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go tcpServer.ActivateAndServe()
+	<-started
+	defer tcpServer.Shutdown()
+	dnsPort = fmt.Sprint(l.Addr().(*net.TCPAddr).Port)
+	defer func() { dnsPort = "53" }()
+
+	pr := prober.New(prober.Config{
+		Port:    resolver.Port,
+		Timeout: 500 * time.Millisecond,
+	})
+	defer pr.Close()
+
+	variations, err := parseVariations("rd,edns0,edns0-do,cd,ad,0x20,tcp")
+	require.NoError(t, err)
+	for _, v := range variations {
+		dnsResult := resolveDomain(pr, nil, time.Second, fakenet.V4, "example.test", "A", v)
+		require.Equal(t, v.Name, dnsResult.Variation)
+		require.Equal(t, dns.RcodeSuccess, dnsResult.RCode, v.Name)
+		require.Equal(t, Unknown, dnsResult.CCode, v.Name)
+		require.Len(t, dnsResult.Answers, 1, v.Name)
+		if v.CheckCase {
+			require.NotNil(t, dnsResult.CaseKept, v.Name)
+			require.True(t, *dnsResult.CaseKept, v.Name)
+		} else {
+			require.Nil(t, dnsResult.CaseKept, v.Name)
+		}
+
+		if v.TCP {
+			continue
+		}
+		dnsResult = resolveDomain(pr, nil, time.Second, fakenet.V6, "blocked.test", "A", v)
+		require.Equal(t, ResolverResolveError, dnsResult.CCode, v.Name)
+	}
+
+	// nothing is listening on TCP over v6
+	tcp, err := parseVariations("tcp")
+	require.NoError(t, err)
+	dnsResult := resolveDomain(pr, nil, time.Second, fakenet.V6, "example.test", "A", tcp[0])
+	require.Equal(t, ResolverDialError, dnsResult.CCode)
+
+	all, err := parseVariations("all")
+	require.NoError(t, err)
+	require.Equal(t, len(Variations), len(all))
+	_, err = parseVariations("rd,nope")
+	require.Error(t, err)
+}
+
+func TestRandomizeCase(t *testing.T) {
+	name := "www.example-domain.test."
+	changed := false
+	for i := 0; i < 10; i++ {
+		got := randomizeCase(name)
+		require.True(t, strings.EqualFold(name, got))
+		changed = changed || got != name
+	}
+	require.True(t, changed)
+}
+
+func TestCaseKept(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("wWw.ExAmple.TEST.", dns.TypeA)
+	r := new(dns.Msg)
+	r.SetReply(m)
+	require.True(t, *caseKept(m, r))
+
+	// injected responses often lower the name
+	r.Question[0].Name = strings.ToLower(r.Question[0].Name)
+	require.False(t, *caseKept(m, r))
+
+	r.Question = nil
+	require.Nil(t, caseKept(m, r))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"unicode"
+
+	"github.com/miekg/dns"
+)
+
+// Variation is a change to the header or question of the query sent for each
+// input. Every variation starts from the query a stub resolver would send, a
+// recursive IN query over UDP, so its results can be compared to those of
+// "rd" to see whether the change gets around (or into) censorship.
+type Variation struct {
+	Name        string
+	Description string
+	// TCP sends the query over TCP instead of UDP
+	TCP bool
+	// CheckCase records whether the response kept the case of the query
+	// name, which Apply randomizes
+	CheckCase bool
+	Apply     func(m *dns.Msg)
+}
+
+// Variations is every variation that can be run, in the order results are
+// written
+var Variations = []Variation{
+	{
+		Name:        "rd",
+		Description: "RD bit set, the plain query to compare the others to",
+	},
+	{
+		Name:        "no-rd",
+		Description: "RD bit unset, so resolvers only answer from their cache",
+		Apply:       func(m *dns.Msg) { m.RecursionDesired = false },
+	},
+	{
+		Name:        "edns0",
+		Description: "EDNS0 OPT record with a 4096 byte UDP size, without DO",
+		Apply:       func(m *dns.Msg) { m.SetEdns0(4096, false) },
+	},
+	{
+		Name:        "edns0-do",
+		Description: "EDNS0 OPT record with the DO bit asking for DNSSEC records",
+		Apply:       func(m *dns.Msg) { m.SetEdns0(4096, true) },
+	},
+	{
+		Name:        "cd",
+		Description: "CD bit set, asking the resolver not to validate DNSSEC",
+		Apply:       func(m *dns.Msg) { m.CheckingDisabled = true },
+	},
+	{
+		Name:        "ad",
+		Description: "AD bit set, asking whether the answer was validated",
+		Apply:       func(m *dns.Msg) { m.AuthenticatedData = true },
+	},
+	{
+		Name:        "0x20",
+		Description: "Random case in the query name (DNS 0x20)",
+		CheckCase:   true,
+		Apply: func(m *dns.Msg) {
+			for i := range m.Question {
+				m.Question[i].Name = randomizeCase(m.Question[i].Name)
+			}
+		},
+	},
+	{
+		Name:        "tcp",
+		Description: "Sent over TCP instead of UDP",
+		TCP:         true,
+	},
+	{
+		Name:        "qclass-chaos",
+		Description: "CHAOS qclass instead of IN",
+		Apply: func(m *dns.Msg) {
+			for i := range m.Question {
+				m.Question[i].Qclass = dns.ClassCHAOS
+			}
+		},
+	},
+	{
+		Name:        "qclass-any",
+		Description: "ANY qclass instead of IN",
+		Apply: func(m *dns.Msg) {
+			for i := range m.Question {
+				m.Question[i].Qclass = dns.ClassANY
+			}
+		},
+	},
+}
+
+// parseVariations returns the variations named in a comma separated list, or
+// all of them for "all"
+func parseVariations(names string) ([]Variation, error) {
+	if names == "all" {
+		return Variations, nil
+	}
+	var ret []Variation
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, v := range Variations {
+			if v.Name == name {
+				ret = append(ret, v)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown variation: %q", name)
+		}
+	}
+
+	return ret, nil
+}
+
+// randomizeCase flips the case of each letter in name with probability 1/2
+func randomizeCase(name string) string {
+	ret := []rune(name)
+	for i, r := range ret {
+		if rand.Intn(2) == 0 {
+			continue
+		}
+		if unicode.IsUpper(r) {
+			ret[i] = unicode.ToLower(r)
+		} else {
+			ret[i] = unicode.ToUpper(r)
+		}
+	}
+
+	return string(ret)
+}
+
+// caseKept reports whether r asked the question of m with exactly the same
+// case, so forged responses that lower the name stand out. Responses without a
+// question, like many refusals, give nil since there's nothing to compare.
+func caseKept(m, r *dns.Msg) *bool {
+	if len(r.Question) == 0 || len(m.Question) == 0 {
+		return nil
+	}
+	kept := r.Question[0].Name == m.Question[0].Name
+
+	return &kept
+}
+
+// variationSummary counts the censorship codes each variation got, separately
+// for v4 and v6 resolvers, so the variations that change censorship stand out
+type variationSummary struct {
+	// order holds the variations in the order their first result came in
+	order  []string
+	counts map[string]map[string]map[CensorshipCode]int
+}
+
+func newVariationSummary() *variationSummary {
+	return &variationSummary{
+		counts: make(map[string]map[string]map[CensorshipCode]int),
+	}
+}
+
+func (vs *variationSummary) add(dnsResult DNSResult) {
+	families, ok := vs.counts[dnsResult.Variation]
+	if !ok {
+		families = make(map[string]map[CensorshipCode]int)
+		vs.counts[dnsResult.Variation] = families
+		vs.order = append(vs.order, dnsResult.Variation)
+	}
+	family := "v6"
+	if ip := net.ParseIP(dnsResult.Resolver); ip != nil && ip.To4() != nil {
+		family = "v4"
+	}
+	if families[family] == nil {
+		families[family] = make(map[CensorshipCode]int)
+	}
+	families[family][dnsResult.CCode]++
+}
+
+// log writes a line per variation and family with the count of each
+// censorship code
+func (vs *variationSummary) log() {
+	for _, variation := range vs.order {
+		for _, family := range []string{"v4", "v6"} {
+			codes, ok := vs.counts[variation][family]
+			if !ok {
+				continue
+			}
+			var total int
+			var counts []string
+			for code := Unknown; code <= ReturnedValidRecord; code++ {
+				if codes[code] == 0 {
+					continue
+				}
+				total += codes[code]
+				counts = append(counts, fmt.Sprintf("%s %d", code, codes[code]))
+			}
+			infoLogger.Printf(
+				"%s %s: %d results, %s\n",
+				variation,
+				family,
+				total,
+				strings.Join(counts, ", "),
+			)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/google/gopacket/layers"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/timartiny/v4vsv6/pkg/dnspcap"
+	"github.com/timartiny/v4vsv6/pkg/qname"
+	"github.com/timartiny/v4vsv6/pkg/resolvers"
+)
+
+var (
+	infoLogger  *log.Logger
+	errorLogger *log.Logger
+)
+
+type PairResolversFlags struct {
+	Pcaps      []string `arg:"--pcap,required,separate" help:"(Required) Path to a PCAP (or PCAPNG) file captured at our name server while running probe --prefix, can be supplied multiple times" json:"pcaps"`
+	Domains    []string `arg:"--domain,separate" help:"Only use queries under this domain, can be supplied multiple times, defaults to every query that encodes an address" json:"domains"`
+	CountryDB  string   `arg:"--country-db,required" help:"(Required) Path to the GeoLite2-Country.mmdb file" json:"country_db"`
+	OutputFile string   `arg:"--output,required" help:"(Required) Path to write the \"v6 v4 CC\" resolver pairs to" json:"output_file"`
+	Verbose    bool     `arg:"--verbose,-v" help:"Whether to add extra printing for debugging" json:"verbose"`
+}
+
+func setupArgs() PairResolversFlags {
+	var ret PairResolversFlags
+	arg.MustParse(&ret)
+
+	return ret
+}
+
+// decodeQuery returns the address encoded in a query name, trying each of the
+// allowed domains
+func decodeQuery(name string, domains []string) (net.IP, bool) {
+	if len(domains) == 0 {
+		ip, _, err := qname.Decode(name, "")
+		return ip, err == nil
+	}
+	for _, domain := range domains {
+		if ip, _, err := qname.Decode(name, domain); err == nil {
+			return ip, true
+		}
+	}
+
+	return nil, false
+}
+
+// readPcap will record, in the Matches of the source address's family, every
+// DNS query in the file that encodes an address
+func readPcap(
+	path string,
+	v6Sources, v4Sources *resolvers.Matches,
+	args PairResolversFlags,
+) {
+	packetSource, closer, err := dnspcap.Open(path)
+	if err != nil {
+		errorLogger.Fatalf("Error opening %s: %v\n", path, err)
+	}
+	defer closer.Close()
+	packetSource.Lazy = true
+
+	var numPackets, numQueries int
+	for packet := range packetSource.Packets() {
+		numPackets++
+		var source net.IP
+		switch network := packet.NetworkLayer().(type) {
+		case *layers.IPv4:
+			source = network.SrcIP
+		case *layers.IPv6:
+			source = network.SrcIP
+		default:
+			continue
+		}
+		dnsLayer, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+		if !ok || dnsLayer.QR || len(dnsLayer.Questions) == 0 {
+			continue
+		}
+		name := string(dnsLayer.Questions[0].Name)
+		encoded, ok := decodeQuery(name, args.Domains)
+		if !ok {
+			if args.Verbose {
+				infoLogger.Printf("Query from %s doesn't encode an address: %s\n", source, name)
+			}
+			continue
+		}
+		numQueries++
+		if source.To4() == nil {
+			v6Sources.Add(source, encoded)
+		} else {
+			v4Sources.Add(source.To4(), encoded)
+		}
+	}
+	infoLogger.Printf(
+		"Read %d packets from %s, %d were queries for encoded addresses\n",
+		numPackets,
+		path,
+		numQueries,
+	)
+}
+
+func main() {
+	infoLogger = log.New(
+		os.Stderr,
+		"INFO: ",
+		log.Ldate|log.Ltime|log.Lshortfile,
+	)
+	errorLogger = log.New(
+		os.Stderr,
+		"ERROR: ",
+		log.Ldate|log.Ltime|log.Lshortfile,
+	)
+	args := setupArgs()
+
+	countryDB, err := geoip2.Open(args.CountryDB)
+	if err != nil {
+		errorLogger.Fatalf("Error opening %s: %v\n", args.CountryDB, err)
+	}
+	defer countryDB.Close()
+	country := func(ip net.IP) string {
+		record, err := countryDB.Country(ip)
+		if err != nil {
+			return ""
+		}
+		return record.Country.IsoCode
+	}
+
+	// v6 sources are resolvers probed at their v4 address that forwarded to
+	// our v6 only name server, and v4 sources the reverse
+	v6Sources := resolvers.NewMatches()
+	v4Sources := resolvers.NewMatches()
+	for _, path := range args.Pcaps {
+		readPcap(path, v6Sources, v4Sources, args)
+	}
+
+	var pairs []resolvers.Pair
+	v6ToV4 := v6Sources.Pairs(country)
+	v4ToV6 := v4Sources.Pairs(country)
+	infoLogger.Printf(
+		"Found %d pairs from %d v6 sources, and %d pairs from %d v4 sources\n",
+		len(v6ToV4),
+		v6Sources.Len(),
+		len(v4ToV6),
+		v4Sources.Len(),
+	)
+	switch {
+	case v6Sources.Len() > 0 && v4Sources.Len() > 0:
+		// both directions were probed, so only keep the pairs confirmed by
+		// both
+		pairs = resolvers.Intersect(v6ToV4, v4ToV6)
+	case v6Sources.Len() > 0:
+		pairs = v6ToV4
+	default:
+		pairs = v4ToV6
+	}
+
+	outFile, err := os.Create(args.OutputFile)
+	if err != nil {
+		errorLogger.Fatalf("Error creating %s: %v\n", args.OutputFile, err)
+	}
+	defer outFile.Close()
+	if err = resolvers.WritePairs(outFile, pairs); err != nil {
+		errorLogger.Fatalf("Error writing %s: %v\n", args.OutputFile, err)
+	}
+	infoLogger.Printf("Wrote %d resolver pairs to %s\n", len(pairs), args.OutputFile)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/blockpage"
+)
+
+var (
+	// httpPort is the port blockpages are fetched from, changed by tests to
+	// reach servers running on loopback
+	httpPort = "80"
+	// blockpages are the fingerprints HTTP responses are matched against, nil
+	// when --http-fetch isn't given
+	blockpages *blockpage.DB
+)
+
+// blockpageLookup will fetch the HTTP page ip serves for domainName and return
+// the blockpage it matches, or nil if it doesn't match one (or there is no
+// page)
+func blockpageLookup(
+	dialer *net.Dialer,
+	domainName string,
+	ip net.IP,
+) *v4vsv6.Blockpage {
+	resp, err := blockpage.Fetch(dialer, ip, httpPort, domainName)
+	if err != nil {
+		return nil
+	}
+	fp := blockpages.Match(resp)
+	if fp == nil {
+		return nil
+	}
+
+	return &v4vsv6.Blockpage{
+		Fingerprint: fp.Name,
+		Vendor:      fp.Vendor,
+		Country:     fp.Country,
+		StatusCode:  resp.StatusCode,
+	}
+}
+
+// fetchBlockpages will look for blockpages on every address in ditarm that
+// didn't support TLS, for the Zgrab2 results which only cover TLS
+func fetchBlockpages(ditarm DomainIPToAddressResultMap, args ParseScansFlags) {
+	s := &scanner{
+		sourceV4: parseSourceIP(args.SourceV4, true),
+		sourceV6: parseSourceIP(args.SourceV6, false),
+		timeout:  time.Duration(args.Timeout) * time.Second,
+	}
+	arChan := make(chan *v4vsv6.AddressResult)
+	var wg sync.WaitGroup
+	for w := 0; w < args.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ar := range arChan {
+				ip := net.ParseIP(ar.IP)
+				ar.Blockpage = blockpageLookup(s.dialer(ip, "tcp"), ar.Domain, ip)
+			}
+		}()
+	}
+
+	var numFetched int
+	for _, ar := range ditarm {
+		if ar.SupportsTLS || isControlDomain(ar.Domain) || net.ParseIP(ar.IP) == nil {
+			continue
+		}
+		numFetched++
+		arChan <- ar
+	}
+	close(arChan)
+	wg.Wait()
+	infoLogger.Printf("Fetched HTTP pages from %d addresses\n", numFetched)
+}
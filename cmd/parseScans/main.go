@@ -1,9 +1,10 @@
 package main
 
 import (
-	"bufio"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -16,51 +17,55 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/blockpage"
+	"github.com/timartiny/v4vsv6/pkg/control"
+	"github.com/timartiny/v4vsv6/pkg/resolvers"
+	"github.com/timartiny/v4vsv6/pkg/results"
+	"github.com/timartiny/v4vsv6/pkg/roots"
+	"github.com/timartiny/v4vsv6/pkg/zdns"
 	"github.com/zmap/zgrab2"
 )
 
 var (
-	infoLogger          *log.Logger
-	errorLogger         *log.Logger
-	v4ControlDomToIPMap map[string]net.IP
-	v6ControlDomToIPMap map[string]net.IP
+	infoLogger     *log.Logger
+	errorLogger    *log.Logger
+	controlDomains *control.Registry
+	// rootCAs are the roots certificates are verified against, nil for the
+	// system roots
+	rootCAs *x509.CertPool
+	// rootsID names the root bundle rootCAs came from, recorded with each TLS
+	// result
+	rootsID = roots.System
+	// classifier decides which rounds are censored
+	classifier v4vsv6.Classifier = v4vsv6.TLSValidity{}
 )
 
 type ParseScansFlags struct {
-	Day        int    `arg:"--day,required" help:"(Required) The day of the experiment, will be used to determine which files to read and which fields to update" json:"day"`
-	DataFolder string `arg:"--data-folder,required" help:"(Required) The folder to read data from and write to" json:"data_folder"`
-	Repeats    bool   `arg:"--repeats" help:"Whether to look for repeat TLS connections or not" json:"repeats"`
-	DateString string `arg:"--date-string,required" help:"(Required) The date string present in data files" json:"date_string"`
-	Verbose    bool   `arg:"--verbose,-v" help:"Whether to add extra printing for debugging" json:"verbose"`
+	Day            int      `arg:"--day,required" help:"(Required) The round of the experiment (starting at 1), will be used to determine which files to read and which round to record" json:"day"`
+	DataFolder     string   `arg:"--data-folder,required" help:"(Required) The folder to read data from and write to" json:"data_folder"`
+	Repeats        bool     `arg:"--repeats" help:"Whether to look for repeat TLS connections or not" json:"repeats"`
+	DateString     string   `arg:"--date-string,required" help:"(Required) The date string present in data files" json:"date_string"`
+	ControlDomains string   `arg:"--control-domains" help:"Path to a JSON file of control domains and their expected answers, defaults to the v4vsv6.com control domains" json:"control_domains"`
+	VantagePoint   string   `arg:"--vantage-point" help:"Name of the machine the round was measured from, recorded with the round" json:"vantage_point"`
+	Verbose        bool     `arg:"--verbose,-v" help:"Whether to add extra printing for debugging" json:"verbose"`
+	Scan           bool     `arg:"--scan" help:"Query the resolvers and check TLS directly, rather than reading ZDNS and Zgrab2 results" json:"scan"`
+	DomainFile     string   `arg:"--domain-file" help:"(Required with --scan) Path to the file of domains to look up, one per line or as JSON objects with a \"domain\" field" json:"domain_file"`
+	ResolverFile   string   `arg:"--resolver-file" help:"Path to the resolver pair file, defaults to <date-string>-single-resolvers-country-correct-sorted in the data folder" json:"resolver_file"`
+	SourceV4       string   `arg:"--source-v4" help:"With --scan or --http-fetch, the address to send IPv4 queries and connections from" json:"source_v4"`
+	SourceV6       string   `arg:"--source-v6" help:"With --scan or --http-fetch, the address to send IPv6 queries and connections from" json:"source_v6"`
+	Rate           int      `arg:"--rate" help:"With --scan, the maximum number of DNS queries to send per second, 0 for no limit" default:"1000" json:"rate"`
+	Workers        int      `arg:"-w,--workers" help:"With --scan, the number of domain-resolver pairs to scan simultaneously, with --http-fetch the number of addresses to fetch from simultaneously" default:"1000" json:"workers"`
+	Timeout        int      `arg:"--timeout" help:"With --scan or --http-fetch, the number of seconds to wait for DNS, TLS and HTTP responses" default:"5" json:"timeout"`
+	Classifiers    []string `arg:"--classifier,separate" help:"How to decide a query was censored, one of tls, rcode, blockpage-ip or http-blockpage, can be supplied multiple times, censored if any say so [default: tls]" json:"classifiers"`
+	BlockpageIPs   string   `arg:"--blockpage-ips" help:"Path to a file of known blockpage addresses or prefixes, one per line, for the blockpage-ip classifier" json:"blockpage_ips"`
+	HTTPFetch      bool     `arg:"--http-fetch" help:"Fetch the HTTP page of each address that fails TLS and match it against the blockpage fingerprints" json:"http_fetch"`
+	Blockpages     string   `arg:"--blockpages" help:"With --http-fetch, path to a JSON file of blockpage fingerprints, defaults to a few well known blockpages" json:"blockpages"`
+	RootBundle     string   `arg:"--root-bundle" help:"Path to a PEM bundle of root certificates to verify TLS against, such as a Mozilla or CCADB snapshot from the scan date, defaults to the system roots" json:"root_bundle"`
 }
 
 // type DomainResolverResultMap map[string]*v4vsv6.DomainResolverResult
 type DomainIPToAddressResultMap map[string]*v4vsv6.AddressResult
 
-type ZDNSResult struct {
-	AlteredName string        `json:"altered_name,omitempty" groups:"short,normal,long,trace"`
-	Name        string        `json:"name,omitempty" groups:"short,normal,long,trace"`
-	Nameserver  string        `json:"nameserver,omitempty" groups:"normal,long,trace"`
-	Class       string        `json:"class,omitempty" groups:"long,trace"`
-	AlexaRank   int           `json:"alexa_rank,omitempty" groups:"short,normal,long,trace"`
-	Metadata    string        `json:"metadata,omitempty" groups:"short,normal,long,trace"`
-	Status      string        `json:"status,omitempty" groups:"short,normal,long,trace"`
-	Error       string        `json:"error,omitempty" groups:"short,normal,long,trace"`
-	Timestamp   string        `json:"timestamp,omitempty" groups:"short,normal,long,trace"`
-	Data        interface{}   `json:"data,omitempty" groups:"short,normal,long,trace"`
-	Trace       []interface{} `json:"trace,omitempty" groups:"trace"`
-}
-
-type ZDNSAnswer struct {
-	Ttl     uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
-	Type    string `json:"type,omitempty" groups:"short,normal,long,trace"`
-	RrType  uint16 `json:"-"`
-	Class   string `json:"class,omitempty" groups:"short,normal,long,trace"`
-	RrClass uint16 `json:"-"`
-	Name    string `json:"name,omitempty" groups:"short,normal,long,trace"`
-	Answer  string `json:"answer,omitempty" groups:"short,normal,long,trace"`
-}
-
 type AddressResults []*v4vsv6.AddressResult
 
 func setupArgs() ParseScansFlags {
@@ -74,50 +79,32 @@ func setupArgs() ParseScansFlags {
 // create a mapping for each resolver IP to which country code is listed.
 func getResolverCountryCodeMap(rccm map[string]string, path string, wg *sync.WaitGroup) {
 	defer wg.Done()
-	resolverFile, err := os.Open(path)
+	pairs, err := resolvers.ReadPairsFile(path, func(line int, err error) {
+		errorLogger.Printf("Skipping line %d of %s: %v\n", line, path, err)
+	})
 	if err != nil {
-		errorLogger.Fatalf("error opening %s: %v\n", path, err)
+		errorLogger.Fatalf("error reading %s: %v\n", path, err)
 	}
-	defer resolverFile.Close()
-
-	scanner := bufio.NewScanner(resolverFile)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "!!") {
-			// We aren't using resolvers where the country code for v6 and v4
-			// differ
-			continue
-		}
-		splitLine := strings.Split(line, " ")
-		var countryCode, ipv6Addr, ipv4Addr string
-		ipv6Addr = strings.TrimSpace(splitLine[0])
-		if splitLine[1] == " " {
-			// this means there are double spaces between everything
-			ipv4Addr = strings.TrimSpace(splitLine[2])
-			countryCode = strings.TrimSpace(splitLine[4])
-		} else {
-			ipv4Addr = strings.TrimSpace(splitLine[1])
-			countryCode = strings.TrimSpace(splitLine[2])
-		}
-		rccm[ipv6Addr] = countryCode
-		rccm[ipv4Addr] = countryCode
+	for ip, countryCode := range resolvers.CountryCodes(pairs) {
+		rccm[ip] = countryCode
 	}
 }
 
-// getAddressResultFromZDNS will take a ZDNSResult, collect all the answers
-// given and return all of the AddressResult entries in the mapping. If no
-// address is provided it will return an AddressResult with the error part
-// filled out
+// addressResultLookup returns the AddressResult for an address a resolver
+// returned for domainName
+type addressResultLookup func(domainName string, ip net.IP) *v4vsv6.AddressResult
+
+// getAddressResultFromZDNS will take a ZDNS result, collect all the answers
+// given and return the AddressResult of each one from lookup. If no address is
+// provided it will return an AddressResult with the error part filled out
 func getAddressResultFromZDNS(
-	zdnsLine ZDNSResult,
-	ditarm DomainIPToAddressResultMap,
+	zdnsLine *zdns.Result,
+	resolverStr string,
+	lookup addressResultLookup,
 ) AddressResults {
 	ret := make(AddressResults, 0)
 	domainName := zdnsLine.Name
-	dataMap := zdnsLine.Data.(map[string]interface{})
-	resolverStr := dataMap["resolver"].(string)
-	if zdnsLine.Status != "NOERROR" {
+	if !zdnsLine.OK() {
 		// had a DNS error, so we should put that here
 		singleAnswer := new(v4vsv6.AddressResult)
 		singleAnswer.Domain = domainName
@@ -126,38 +113,28 @@ func getAddressResultFromZDNS(
 		return ret
 	}
 
-	interfaceAnswers, ok := zdnsLine.Data.(map[string]interface{})["answers"]
-	if !ok {
+	if len(zdnsLine.Data.Answers) == 0 {
 		singleAnswer := new(v4vsv6.AddressResult)
 		singleAnswer.Domain = domainName
 		singleAnswer.Error = "No DNS Answers"
 		ret = append(ret, singleAnswer)
 		return ret
 	}
-	zdnsAnswers := interfaceAnswers.([]interface{})
-	for _, interfaceAnswer := range zdnsAnswers {
-		tmpJSONString, _ := json.Marshal(interfaceAnswer)
-		var zdnsAnswer ZDNSAnswer
-		json.Unmarshal(tmpJSONString, &zdnsAnswer)
-		if zdnsAnswer.Type != "A" && zdnsAnswer.Type != "AAAA" {
-			continue
-		}
-		tmpIP := net.ParseIP(zdnsAnswer.Answer)
-		if tmpIP == nil {
-			errorLogger.Printf(
-				"Got an Invalid IP from ZDNS: %s\n",
-				zdnsAnswer.Answer,
-			)
-			errorLogger.Printf(
-				"Came from resolver: %s, for domain: %s\n",
-				resolverStr,
-				domainName,
-			)
-			continue
-		}
-		ar, ok := ditarm[domainName+"-"+tmpIP.String()]
-		if !ok {
-			errorLogger.Printf("Got a ZDNS result that wasn't sent to Zgrab2!!\n")
+	ips, invalid := zdnsLine.Addresses()
+	for _, zdnsAnswer := range invalid {
+		errorLogger.Printf(
+			"Got an Invalid IP from ZDNS: %s\n",
+			zdnsAnswer.Answer,
+		)
+		errorLogger.Printf(
+			"Came from resolver: %s, for domain: %s\n",
+			resolverStr,
+			domainName,
+		)
+	}
+	for _, tmpIP := range ips {
+		ar := lookup(domainName, tmpIP)
+		if ar == nil {
 			errorLogger.Printf(
 				"Domain: %s, resolver: %s, answer: %s\n",
 				domainName,
@@ -179,6 +156,19 @@ func getAddressResultFromZDNS(
 	return ret
 }
 
+// lookup finds the AddressResult for the Zgrab2 scan of ip for domainName
+func (ditarm DomainIPToAddressResultMap) lookup(
+	domainName string,
+	ip net.IP,
+) *v4vsv6.AddressResult {
+	ar, ok := ditarm[domainName+"-"+ip.String()]
+	if !ok {
+		errorLogger.Printf("Got a ZDNS result that wasn't sent to Zgrab2!!\n")
+	}
+
+	return ar
+}
+
 // writeDomainResolverResults will write a particular DomainResolverResult to
 // the provided file, first turning it into JSON after it receives it from the
 // channel
@@ -188,54 +178,21 @@ func writeDomainResolverResults(
 	wg *sync.WaitGroup,
 ) {
 	defer wg.Done()
-	outFile, err := os.Create(path)
+	drrWriter, err := results.CreateDRRWriter(path)
 	if err != nil {
 		errorLogger.Fatalf("Error creating output file: %s, %v\n", path, err)
 	}
-	defer outFile.Close()
-
-	for drr := range drrChan {
-		bs, err := json.Marshal(drr)
-		if err != nil {
-			errorLogger.Printf("Error marshaling a drr: %v\n", err)
-		}
-		_, err = outFile.Write(bs)
-		if err != nil {
-			errorLogger.Printf("Error writing bytes of drr to file: %v\n", err)
+	defer func() {
+		if err := drrWriter.Close(); err != nil {
+			errorLogger.Printf("Error closing output file: %s, %v\n", path, err)
 		}
-		_, err = outFile.WriteString("\n")
-		if err != nil {
-			errorLogger.Printf("Error writing newline to file: %v\n", err)
-		}
-	}
-}
+	}()
 
-// isDayCensorship will read through a slice of AddressResults and return true if
-// all of the Answers returned fail to support TLS, if any one does then no
-// censorship.
-func isDayCensorship(drr v4vsv6.DomainResolverResult, day int) bool {
-	var results []*v4vsv6.AddressResult
-	switch day {
-	case 1:
-		results = drr.Day1Results
-	case 2:
-		results = drr.Day2Results
-	case 3:
-		results = drr.Day3Results
-	default:
-		errorLogger.Fatalf("Invalid Day provided: %d, must be 1-3\n", day)
-	}
-	if len(results) == 0 || results[0] == nil {
-		return true
-	}
-
-	for _, ar := range results {
-		if ar.SupportsTLS {
-			return false
+	for drr := range drrChan {
+		if err := drrWriter.Write(drr); err != nil {
+			errorLogger.Printf("Error writing drr to file: %v\n", err)
 		}
 	}
-
-	return true
 }
 
 // createThenWriteDomainResolverResults will read in ZDNS scan results and will
@@ -250,125 +207,177 @@ func createThenWriteDomainResolverResults(
 	wg *sync.WaitGroup,
 ) {
 	defer wg.Done()
-	zdnsFile, err := os.Open(zdnsPath)
+	zdnsReader, err := zdns.OpenReader(zdnsPath)
 	if err != nil {
 		errorLogger.Fatalf("error opening %s: %v\n", zdnsPath, err)
 	}
-	defer zdnsFile.Close()
+	defer zdnsReader.Close()
 
-	scanner := bufio.NewScanner(zdnsFile)
 	var numLines int
 	nextVerboseTime := time.Now().Add(30 * time.Second)
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	for zdnsReader.Next() {
 		numLines++
 		if args.Verbose && time.Now().After(nextVerboseTime) {
 			infoLogger.Printf("Read in %d lines of %s\n", numLines, zdnsPath)
 			nextVerboseTime = time.Now().Add(30 * time.Second)
 		}
-		var zdnsLine ZDNSResult
-		json.Unmarshal([]byte(line), &zdnsLine)
-
-		results := getAddressResultFromZDNS(zdnsLine, ditarm)
-
-		domainName := zdnsLine.Name
-		dataMap := zdnsLine.Data.(map[string]interface{})
-		resolverFullStr := dataMap["resolver"].(string)
-		var resolverStr string
-		if strings.Count(resolverFullStr, ":") == 1 {
-			resolverStr = strings.Split(resolverFullStr, ":")[0]
-		} else {
-			resolverStr = strings.Split(
-				strings.Split(resolverFullStr, "]")[0],
-				"[",
-			)[1]
-		}
-		drr := new(v4vsv6.DomainResolverResult)
-		drr.Domain = domainName
-		drr.ResolverIP = resolverStr
-		if _, ok := rccm[resolverStr]; !ok {
+		zdnsLine := zdnsReader.Result()
+		resolverIP, err := zdnsLine.ResolverIP()
+		if err != nil {
 			errorLogger.Printf(
-				"resolver %s is not in resolver country code map!\n",
-				resolverStr,
+				"Skipping line %d of %s: %v\n", zdnsReader.Line(), zdnsPath, err,
 			)
+			continue
 		}
-		drr.ResolverCountry = rccm[resolverStr]
-		drr.RequestedAddressType = resultType
-
-		switch args.Day {
-		case 1:
-			drr.Day1Results = results
-		case 2:
-			drr.Day2Results = results
-		case 3:
-			drr.Day3Results = results
-		default:
-			errorLogger.Fatalf("Incorrect day passed: %d, must be 1-3\n", args.Day)
-		}
-		if isControlDomain(drr.Domain) {
-			for _, result := range results {
-				if !result.ValidControlIP {
-					drr.CorrectControlResolution = false
-					break
-				}
-				drr.CorrectControlResolution = true
+		resolverStr := resolverIP.String()
+
+		results := getAddressResultFromZDNS(
+			zdnsLine,
+			resolverStr,
+			ditarm.lookup,
+		)
+		drr := newDomainResolverResult(
+			zdnsLine,
+			resolverStr,
+			resultType,
+			results,
+			rccm,
+			args,
+		)
+		drrChan <- drr
+	}
+	if err = zdnsReader.Err(); err != nil {
+		errorLogger.Fatalf("Error reading %s: %v\n", zdnsPath, err)
+	}
+}
+
+// newDomainResolverResult will record results, the answers resolverStr gave
+// for a resultType query in zdnsLine, as this round of a new
+// DomainResolverResult.
+func newDomainResolverResult(
+	zdnsLine *zdns.Result,
+	resolverStr, resultType string,
+	results AddressResults,
+	rccm map[string]string,
+	args ParseScansFlags,
+) *v4vsv6.DomainResolverResult {
+	drr := new(v4vsv6.DomainResolverResult)
+	drr.Domain = zdnsLine.Name
+	drr.ResolverIP = resolverStr
+	if _, ok := rccm[resolverStr]; !ok {
+		errorLogger.Printf(
+			"resolver %s is not in resolver country code map!\n",
+			resolverStr,
+		)
+	}
+	drr.ResolverCountry = rccm[resolverStr]
+	drr.RequestedAddressType = resultType
+
+	round := drr.SetRoundResults(args.Day, results)
+	round.Timestamp = zdnsLine.Timestamp
+	round.VantagePoint = args.VantagePoint
+	if isControlDomain(drr.Domain) {
+		for _, result := range results {
+			if result == nil || !result.ValidControlIP {
+				drr.CorrectControlResolution = false
+				break
 			}
+			drr.CorrectControlResolution = true
 		}
-
-		if isDayCensorship(*drr, args.Day) || isControlDomain(drr.Domain) {
-			drr.CensoredQuery = true
-		} else {
-			drr.CensoredQuery = false
+		if drr.CorrectControlResolution {
+			drr.CorrectControlResolution = verifyControlAnswers(zdnsLine)
 		}
-		drrChan <- drr
 	}
+
+	round.Status = zdnsLine.Status
+	if isControlDomain(drr.Domain) {
+		round.Censored = true
+		round.Rule = v4vsv6.RuleControlDomain
+	} else {
+		drr.ClassifyRound(round, classifier)
+	}
+	drr.CensoredQuery = round.Censored
+
+	return drr
 }
 
 // verifyTLS will take a tls scan response and determine whether the information
-// provided is a valid TLS cert for the given domainName at the time of the scan
-func verifyTLS(tlsScanResponse zgrab2.ScanResponse, domainName string) bool {
-	if tlsScanResponse.Status != "success" {
+// provided is a valid TLS cert for the given domainName at the time of the
+// scan, recording why not if it isn't
+func verifyTLS(
+	tlsScanResponse zgrab2.ScanResponse,
+	domainName string,
+) *v4vsv6.TLSResult {
+	ret := &v4vsv6.TLSResult{Status: string(tlsScanResponse.Status)}
+	if tlsScanResponse.Status != zgrab2.SCAN_SUCCESS {
 		// infoLogger.Printf("This results is a non-successful tls result: %s, %s\n", domainName, tlsScanResults.Status)
-		return false
+		ret.Failure = v4vsv6.TLSFailureStatus
+		if tlsScanResponse.Error != nil {
+			ret.Error = *tlsScanResponse.Error
+		}
+		return ret
 	}
 
 	timestampString := tlsScanResponse.Timestamp
 	timestamp, err := time.Parse(time.RFC3339, timestampString)
 	if err != nil {
 		errorLogger.Printf("Error parsing timestamp: %s\n", timestampString)
-		return false
+		ret.Failure = v4vsv6.TLSFailureParse
+		ret.Error = fmt.Sprintf("invalid timestamp: %s", timestampString)
+		return ret
+	}
+	rawLeafCertificate, err := zgrabString(
+		tlsScanResponse.Result,
+		"handshake_log", "server_certificates", "certificate", "raw",
+	)
+	if err != nil {
+		errorLogger.Printf("Malformed TLS result for %s: %v\n", domainName, err)
+		ret.Failure = v4vsv6.TLSFailureParse
+		ret.Error = err.Error()
+		return ret
 	}
-	serverHandshakeInterface := tlsScanResponse.Result.(map[string]interface{})["handshake_log"].(map[string]interface{})
-	serverCertificatesInterface := serverHandshakeInterface["server_certificates"].(map[string]interface{})
-	leafCertificateInterface := serverCertificatesInterface["certificate"].(map[string]interface{})
-	rawLeafCertificate := leafCertificateInterface["raw"].(string)
 
 	decoded, err := base64.StdEncoding.DecodeString(string(rawLeafCertificate))
 	if err != nil {
 		errorLogger.Printf("base64.Decode of cert err: %v\n", err)
-		return false
+		ret.Failure = v4vsv6.TLSFailureParse
+		ret.Error = err.Error()
+		return ret
 	}
 	x509Cert, err := x509.ParseCertificate(decoded)
 	if err != nil {
 		errorLogger.Printf("x509.ParseCertificate of cert err: %v\n", err)
 		errorLogger.Printf("decoded certificate: %v\n", decoded)
-		return false
-	}
-	err = x509Cert.VerifyHostname(domainName)
-	if err != nil {
-		return false
+		ret.Failure = v4vsv6.TLSFailureParse
+		ret.Error = err.Error()
+		return ret
 	}
-
-	certPool := x509.NewCertPool()
 	var chain []interface{}
-	if serverCertificatesInterface["chain"] != nil {
-		chain = serverCertificatesInterface["chain"].([]interface{})
+	if chainInterface, err := zgrabField(
+		tlsScanResponse.Result,
+		"handshake_log", "server_certificates", "chain",
+	); err == nil {
+		var ok bool
+		if chain, ok = chainInterface.([]interface{}); !ok {
+			errorLogger.Printf("Malformed TLS result for %s: chain is not a list\n", domainName)
+			ret.Failure = v4vsv6.TLSFailureParse
+			ret.Error = "handshake_log.server_certificates.chain is not a list"
+			return ret
+		}
 	}
 
+	var intermediates []*x509.Certificate
 	for ind, mInterface := range chain {
-		raw := mInterface.(map[string]interface{})["raw"]
-		chainDecoded, err := base64.StdEncoding.DecodeString(raw.(string))
+		raw, err := zgrabString(mInterface, "raw")
+		if err != nil {
+			err = fmt.Errorf("handshake_log.server_certificates.chain[%d]: %v", ind, err)
+			errorLogger.Printf("Malformed TLS result for %s: %v\n", domainName, err)
+			ret.Failure = v4vsv6.TLSFailureParse
+			ret.Error = err.Error()
+			return ret
+		}
+		chainDecoded, err := base64.StdEncoding.DecodeString(raw)
 		if err != nil {
 			errorLogger.Printf("base64.Decode of chain ind %d err: %v\n", ind, err)
 			continue
@@ -379,16 +388,98 @@ func verifyTLS(tlsScanResponse zgrab2.ScanResponse, domainName string) bool {
 			errorLogger.Printf("x509.ParseCertificate for chain ind %d err: %v\n", ind, err)
 			continue
 		}
-		certPool.AddCert(chainCert)
+		intermediates = append(intermediates, chainCert)
+	}
+
+	verifyCertificate(ret, x509Cert, intermediates, domainName, timestamp)
+
+	return ret
+}
+
+// zgrabField walks the objects of a decoded ZGrab2 result down path, returning
+// an error naming the first field that is missing or isn't an object
+func zgrabField(result interface{}, path ...string) (interface{}, error) {
+	value := result
+	for i, name := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			if i == 0 {
+				return nil, fmt.Errorf("result is not an object")
+			}
+			return nil, fmt.Errorf("%s is not an object", strings.Join(path[:i], "."))
+		}
+		if value, ok = object[name]; !ok || value == nil {
+			return nil, fmt.Errorf("missing %s", strings.Join(path[:i+1], "."))
+		}
 	}
 
+	return value, nil
+}
+
+// zgrabString is zgrabField for a field holding a string
+func zgrabString(result interface{}, path ...string) (string, error) {
+	value, err := zgrabField(result, path...)
+	if err != nil {
+		return "", err
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s is not a string", strings.Join(path, "."))
+	}
+
+	return str, nil
+}
+
+// verifyCertificate will check that leaf is valid for domainName at timestamp,
+// using intermediates to build a chain to a trusted root, and record the
+// outcome along with the details of leaf in ret
+func verifyCertificate(
+	ret *v4vsv6.TLSResult,
+	leaf *x509.Certificate,
+	intermediates []*x509.Certificate,
+	domainName string,
+	timestamp time.Time,
+) {
+	ret.Roots = rootsID
+	fingerprint := sha256.Sum256(leaf.Raw)
+	ret.Fingerprint = hex.EncodeToString(fingerprint[:])
+	ret.Subject = leaf.Subject.String()
+	ret.Issuer = leaf.Issuer.String()
+	ret.SANs = leaf.DNSNames
+	for _, ip := range leaf.IPAddresses {
+		ret.SANs = append(ret.SANs, ip.String())
+	}
+	ret.NotBefore = leaf.NotBefore.Format(time.RFC3339)
+	ret.NotAfter = leaf.NotAfter.Format(time.RFC3339)
+	ret.Expired = timestamp.Before(leaf.NotBefore) || timestamp.After(leaf.NotAfter)
+
+	ret.HostnameValid = leaf.VerifyHostname(domainName) == nil
+
+	certPool := x509.NewCertPool()
+	for _, cert := range intermediates {
+		certPool.AddCert(cert)
+	}
+
+	// the hostname was checked above, so the chain is checked on its own
 	verifyOptions := x509.VerifyOptions{
-		DNSName:       domainName,
 		CurrentTime:   timestamp,
 		Intermediates: certPool,
+		Roots:         rootCAs,
+	}
+	_, err := leaf.Verify(verifyOptions)
+	if err != nil {
+		ret.ChainError = err.Error()
+	}
+	ret.ChainValid = err == nil
+
+	switch {
+	case !ret.HostnameValid:
+		ret.Failure = v4vsv6.TLSFailureHostname
+	case ret.Expired:
+		ret.Failure = v4vsv6.TLSFailureExpired
+	case !ret.ChainValid:
+		ret.Failure = v4vsv6.TLSFailureChain
 	}
-	_, err = x509Cert.Verify(verifyOptions)
-	return err == nil
 }
 
 // updateAddressResults will accept AddressResults from a channel then add them
@@ -419,37 +510,49 @@ func updateAddressResults(
 	}
 }
 
-// isControlDomain will check if dom is in a list of control domains or not.
+// isControlDomain will check if dom is in the registry of control domains or
+// not.
 func isControlDomain(dom string) bool {
-	controls := []string{"v4vsv6.com", "test1.v4vsv6.com", "test2.v4vsv6.com"}
+	return controlDomains.IsControlDomain(dom)
+}
 
-	for _, cDom := range controls {
-		if dom == cDom {
-			return true
-		}
+// verifyControlDomain will check whether the IP is one of those expected for
+// the listed domain in the control domain registry, this function assumes the
+// domain has already been tested as a control domain
+func verifyControlDomain(ar v4vsv6.AddressResult) bool {
+	if ar.AddressType != "A" && ar.AddressType != "AAAA" {
+		errorLogger.Printf("Invalid Address Type given: %v\n", ar.AddressType)
+		return false
 	}
 
-	return false
+	return controlDomains.ValidAddress(
+		ar.Domain,
+		ar.AddressType,
+		net.ParseIP(ar.IP),
+	)
 }
 
-// verifyControlDomain will check whether the IP corresponds to the listed
-// domain, this only works for hardcoded control domains, this function assumes
-// the domain has already been tested as a control domain
-func verifyControlDomain(ar v4vsv6.AddressResult) bool {
+// verifyControlAnswers will check the TTLs and CNAMEs a resolver returned for
+// a control domain against those expected in the control domain registry.
+func verifyControlAnswers(zdnsLine *zdns.Result) bool {
+	cd := controlDomains.Lookup(zdnsLine.Name)
+	if cd == nil {
+		return false
+	}
 
-	if ar.AddressType == "A" {
-		if v4ControlDomToIPMap[ar.Domain].String() == net.ParseIP(ar.IP).String() {
-			return true
-		}
-	} else if ar.AddressType == "AAAA" {
-		if v6ControlDomToIPMap[ar.Domain].String() == net.ParseIP(ar.IP).String() {
-			return true
+	var cnames []string
+	for _, zdnsAnswer := range zdnsLine.Data.Answers {
+		switch zdnsAnswer.Type {
+		case "CNAME":
+			cnames = append(cnames, zdnsAnswer.Answer)
+		case "A", "AAAA":
+			if !cd.ValidTTL(zdnsAnswer.TTL) {
+				return false
+			}
 		}
-	} else {
-		errorLogger.Printf("Invalid Address Type given: %v\n", ar.AddressType)
 	}
 
-	return false
+	return cd.ValidCNAME(cnames)
 }
 
 // createAddressResults will read into memory the results of a Zgrab2 scan and
@@ -462,13 +565,14 @@ func createAddressResults(
 	wg *sync.WaitGroup,
 ) {
 	defer wg.Done()
-	tlsResultsFile, err := os.Open(path)
+	// certificate chains make for long lines, so read them with a
+	// LineReader rather than a bufio.Scanner
+	lr, err := results.OpenLineReader(path)
 	if err != nil {
 		errorLogger.Fatalf("error opening %s: %v\n", path, err)
 	}
 	var numLines int
-	defer tlsResultsFile.Close()
-	scanner := bufio.NewScanner(tlsResultsFile)
+	defer lr.Close()
 
 	// future scans can have duplicated attempts for the same TLS IP, and domain
 	// to check for timeouts. To avoid parsing unnecessary lines (like if we
@@ -479,17 +583,16 @@ func createAddressResults(
 	// only used with verbose, so minor slow down
 	nextVerboseTime := time.Now().Add(30 * time.Second)
 
-	for scanner.Scan() {
+	for lr.Next() {
 		var zgrabResult zgrab2.Grab
-		l := scanner.Text()
 		numLines++
 		if verbose && time.Now().After(nextVerboseTime) {
 			infoLogger.Printf("Read in %d lines of %s\n", numLines, path)
 			nextVerboseTime = time.Now().Add(30 * time.Second)
 		}
-		err = json.Unmarshal([]byte(l), &zgrabResult)
+		err = json.Unmarshal(lr.Bytes(), &zgrabResult)
 		if err != nil {
-			errorLogger.Printf("error unmarshaling line: %s, err: %v\n", l, err)
+			errorLogger.Printf("error unmarshaling line: %v\n", lr.LineError(err))
 			continue
 		}
 		if nonDuplicationMap[zgrabResult.Domain+"-"+zgrabResult.IP] {
@@ -523,11 +626,15 @@ func createAddressResults(
 
 			nonDuplicationMap[ar.Domain+"-"+ar.IP] = ar.ValidControlIP
 		} else {
-			ar.SupportsTLS = verifyTLS(tlsScanResponse, zgrabResult.Domain)
+			ar.TLS = verifyTLS(tlsScanResponse, zgrabResult.Domain)
+			ar.SupportsTLS = ar.TLS.Valid()
 			nonDuplicationMap[ar.Domain+"-"+ar.IP] = ar.SupportsTLS
 		}
 		arChan <- ar
 	}
+	if err = lr.Err(); err != nil {
+		errorLogger.Fatalf("error reading TLS results: %v\n", err)
+	}
 
 	infoLogger.Printf("Read %d lines from %s\n", numLines, path)
 }
@@ -543,16 +650,43 @@ func main() {
 		"ERROR: ",
 		log.Ldate|log.Ltime|log.Lshortfile,
 	)
-	v4ControlDomToIPMap = make(map[string]net.IP)
-	v6ControlDomToIPMap = make(map[string]net.IP)
-	v4ControlDomToIPMap["v4vsv6.com"] = net.ParseIP("192.12.240.40")
-	v4ControlDomToIPMap["test1.v4vsv6.com"] = net.ParseIP("1.1.1.1")
-	v4ControlDomToIPMap["test2.v4vsv6.com"] = net.ParseIP("2.2.2.2")
-	v6ControlDomToIPMap["v4vsv6.com"] = net.ParseIP("2620:18f:30:4100::2")
-	v6ControlDomToIPMap["test1.v4vsv6.com"] = net.ParseIP("1111:1111:1111:1111:1111:1111:1111:1111")
-	v6ControlDomToIPMap["test2.v4vsv6.com"] = net.ParseIP("2222:2222:2222:2222:2222:2222:2222:2222")
 
 	args := setupArgs()
+	if args.Day < 1 {
+		errorLogger.Fatalf("Invalid day passed: %d, must be at least 1\n", args.Day)
+	}
+	var err error
+	controlDomains, err = control.Load(args.ControlDomains)
+	if err != nil {
+		errorLogger.Fatalf("Error loading control domains: %v\n", err)
+	}
+	rootBundle, err := roots.Load(args.RootBundle)
+	if err != nil {
+		errorLogger.Fatalf("Error loading root bundle: %v\n", err)
+	}
+	rootCAs, rootsID = rootBundle.Pool, rootBundle.ID
+	infoLogger.Printf("Verifying TLS against the %s roots\n", rootsID)
+	var classifierOpts v4vsv6.ClassifierOptions
+	if args.BlockpageIPs != "" {
+		classifierOpts.BlockpageIPs, err = v4vsv6.ReadBlockpageIPs(args.BlockpageIPs)
+		if err != nil {
+			errorLogger.Fatalf("Error reading blockpage addresses: %v\n", err)
+		}
+	}
+	classifier, err = v4vsv6.NewClassifiers(args.Classifiers, classifierOpts)
+	if err != nil {
+		errorLogger.Fatalln(err)
+	}
+	if args.HTTPFetch {
+		blockpages, err = blockpage.Load(args.Blockpages)
+		if err != nil {
+			errorLogger.Fatalf("Error loading blockpage fingerprints: %v\n", err)
+		}
+	}
+	if args.Scan {
+		runScan(args)
+		return
+	}
 
 	domainIPToAddressResultsMap := make(DomainIPToAddressResultMap)
 	addressResultsChan := make(chan *v4vsv6.AddressResult, 100)
@@ -675,6 +809,10 @@ func main() {
 		"domainIPToAddressResultMap has %d entries\n",
 		len(domainIPToAddressResultsMap),
 	)
+	if blockpages != nil {
+		infoLogger.Println("Fetching HTTP pages from addresses that failed TLS")
+		fetchBlockpages(domainIPToAddressResultsMap, args)
+	}
 	infoLogger.Println("Waiting resolver country codes to be filled in")
 	resolverCountryCodeMapWG.Wait()
 
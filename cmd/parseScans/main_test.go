@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/control"
+	"github.com/timartiny/v4vsv6/pkg/fakenet"
+	"github.com/timartiny/v4vsv6/pkg/roots"
+	"github.com/zmap/zgrab2"
+)
+
+// TestVerifyCertificate checks each way a certificate can fail is recorded
+// separately
+func TestVerifyCertificate(t *testing.T) {
+	ca, err := fakenet.NewCA()
+	require.NoError(t, err)
+	cert, err := ca.Issue("good.test", "www.good.test")
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	rootsID = "pinned.pem@sha256:00"
+	defer func() {
+		rootCAs, rootsID = nil, roots.System
+	}()
+
+	for _, tc := range []struct {
+		name      string
+		domain    string
+		timestamp time.Time
+		roots     *x509.CertPool
+		hostname  bool
+		chain     bool
+		expired   bool
+		failure   string
+	}{
+		{"valid", "good.test", time.Now(), ca.Pool(), true, true, false, ""},
+		{"wrong name", "other.test", time.Now(), ca.Pool(), false, true, false, v4vsv6.TLSFailureHostname},
+		{"expired", "good.test", time.Now().Add(48 * time.Hour), ca.Pool(), true, false, true, v4vsv6.TLSFailureExpired},
+		{"untrusted", "good.test", time.Now(), x509.NewCertPool(), true, false, false, v4vsv6.TLSFailureChain},
+	} {
+		rootCAs = tc.roots
+		result := &v4vsv6.TLSResult{Status: string(zgrab2.SCAN_SUCCESS)}
+		verifyCertificate(result, leaf, nil, tc.domain, tc.timestamp)
+		require.Equal(t, tc.hostname, result.HostnameValid, tc.name)
+		require.Equal(t, tc.chain, result.ChainValid, tc.name)
+		require.Equal(t, tc.expired, result.Expired, tc.name)
+		require.Equal(t, tc.failure, result.Failure, tc.name)
+		require.Equal(t, tc.failure == "", result.Valid(), tc.name)
+		require.Equal(t, tc.chain, result.ChainError == "", tc.name)
+		require.Equal(t, []string{"good.test", "www.good.test"}, result.SANs, tc.name)
+		require.Equal(t, "CN=good.test", result.Subject, tc.name)
+		require.Len(t, result.Fingerprint, 64, tc.name)
+		require.Equal(t, "pinned.pem@sha256:00", result.Roots, tc.name)
+	}
+}
+
+// TestVerifyTLSStatus checks unsuccessful Zgrab2 scans keep their status
+func TestVerifyTLSStatus(t *testing.T) {
+	errorLogger = log.New(ioutil.Discard, "", 0)
+	msg := "dial tcp 192.0.2.1:443: i/o timeout"
+	result := verifyTLS(zgrab2.ScanResponse{
+		Status: zgrab2.SCAN_CONNECTION_TIMEOUT,
+		Error:  &msg,
+	}, "good.test")
+	require.False(t, result.Valid())
+	require.Equal(t, "connection-timeout", result.Status)
+	require.Equal(t, v4vsv6.TLSFailureStatus, result.Failure)
+	require.Equal(t, msg, result.Error)
+
+	result = verifyTLS(zgrab2.ScanResponse{
+		Status:    zgrab2.SCAN_SUCCESS,
+		Timestamp: "yesterday",
+	}, "good.test")
+	require.Equal(t, v4vsv6.TLSFailureParse, result.Failure)
+}
+
+// TestVerifyTLSMalformed checks successful scans missing parts of the
+// handshake are recorded as parse failures instead of panicking
+func TestVerifyTLSMalformed(t *testing.T) {
+	errorLogger = log.New(ioutil.Discard, "", 0)
+	ca, err := fakenet.NewCA()
+	require.NoError(t, err)
+	cert, err := ca.Issue("good.test")
+	require.NoError(t, err)
+	raw := base64.StdEncoding.EncodeToString(cert.Certificate[0])
+	handshake := func(certificates map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"handshake_log": map[string]interface{}{
+			"server_certificates": certificates,
+		}}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		result interface{}
+		err    string
+	}{
+		{"no result", nil, "result is not an object"},
+		{"no handshake", map[string]interface{}{}, "missing handshake_log"},
+		{
+			"no leaf",
+			handshake(map[string]interface{}{}),
+			"missing handshake_log.server_certificates.certificate",
+		},
+		{
+			"raw not a string",
+			handshake(map[string]interface{}{
+				"certificate": map[string]interface{}{"raw": 7.0},
+			}),
+			"handshake_log.server_certificates.certificate.raw is not a string",
+		},
+		{
+			"chain element without raw",
+			handshake(map[string]interface{}{
+				"certificate": map[string]interface{}{"raw": raw},
+				"chain":       []interface{}{map[string]interface{}{}},
+			}),
+			"handshake_log.server_certificates.chain[0]: missing raw",
+		},
+	} {
+		result := verifyTLS(zgrab2.ScanResponse{
+			Status:    zgrab2.SCAN_SUCCESS,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Result:    tc.result,
+		}, "good.test")
+		require.Equal(t, v4vsv6.TLSFailureParse, result.Failure, tc.name)
+		require.Equal(t, tc.err, result.Error, tc.name)
+	}
+}
+
+// TestCreateAddressResultsLongLine checks ZGrab2 lines longer than a
+// bufio.Scanner allows don't end the read early
+func TestCreateAddressResultsLongLine(t *testing.T) {
+	infoLogger = log.New(ioutil.Discard, "", 0)
+	errorLogger = log.New(ioutil.Discard, "", 0)
+	var err error
+	controlDomains, err = control.Load("")
+	require.NoError(t, err)
+
+	line := func(ip, msg string) string {
+		return fmt.Sprintf(
+			`{"ip":%q,"domain":"good.test","data":{"tls":{"status":"connection-timeout","timestamp":"2021-05-11T12:00:00Z","error":%q}}}`,
+			ip, msg,
+		)
+	}
+	path := filepath.Join(t.TempDir(), "tls.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(
+		line("192.0.2.1", strings.Repeat("x", 200*1024))+"\n"+line("2001:db8::1", "timeout")+"\n",
+	), 0644))
+
+	arChan := make(chan *v4vsv6.AddressResult, 2)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	createAddressResults(path, arChan, false, &wg)
+	close(arChan)
+
+	var got []*v4vsv6.AddressResult
+	for ar := range arChan {
+		got = append(got, ar)
+	}
+	require.Len(t, got, 2)
+	require.Equal(t, "A", got[0].AddressType)
+	require.Equal(t, v4vsv6.TLSFailureStatus, got[0].TLS.Failure)
+	require.Equal(t, "AAAA", got[1].AddressType)
+	require.Equal(t, "timeout", got[1].TLS.Error)
+}
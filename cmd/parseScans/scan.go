@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/pacer"
+	"github.com/timartiny/v4vsv6/pkg/resolvers"
+	"github.com/timartiny/v4vsv6/pkg/zdns"
+	"github.com/zmap/zgrab2"
+)
+
+// the ports queries and TLS connections are sent to, changed by tests to reach
+// servers running on loopback
+var (
+	dnsPort = "53"
+	tlsPort = "443"
+)
+
+// scanInput is a domain to look up at every address of a resolver pair
+type scanInput struct {
+	Domain string
+	Pair   resolvers.Pair
+}
+
+// tlsResult is the (possibly still running) TLS check of a domain-ip
+type tlsResult struct {
+	done chan struct{}
+	ar   *v4vsv6.AddressResult
+}
+
+// scanner queries resolvers and TLS verifies the answers, taking the place of
+// ZDNS and Zgrab2.
+type scanner struct {
+	sourceV4 net.IP
+	sourceV6 net.IP
+	timeout  time.Duration
+	pacer    *pacer.Pacer
+
+	// every resolver is asked about the same domains, so each domain-ip
+	// is only TLS checked once
+	tlsMutex   sync.Mutex
+	tlsResults map[string]*tlsResult
+}
+
+// dialer returns a net.Dialer sending from the source address of the same
+// family as dest, letting the OS pick when none was given.
+func (s *scanner) dialer(dest net.IP, network string) *net.Dialer {
+	source := s.sourceV4
+	if dest.To4() == nil {
+		source = s.sourceV6
+	}
+	dialer := &net.Dialer{Timeout: s.timeout}
+	if source == nil {
+		return dialer
+	}
+	switch network {
+	case "tcp":
+		dialer.LocalAddr = &net.TCPAddr{IP: source}
+	default:
+		dialer.LocalAddr = &net.UDPAddr{IP: source}
+	}
+
+	return dialer
+}
+
+// query will ask resolverIP for the record of domain, retrying over TCP if the
+// response was truncated, and return the response in the same form ZDNS would
+func (s *scanner) query(
+	resolverIP net.IP,
+	domain, record string,
+) *zdns.Result {
+	resolverAddr := net.JoinHostPort(resolverIP.String(), dnsPort)
+	result := &zdns.Result{
+		Name:      strings.TrimSuffix(strings.ToLower(domain), "."),
+		Class:     "IN",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Data:      zdns.Data{Protocol: "udp", Resolver: resolverAddr},
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.StringToType[record])
+
+	s.pacer.Wait(resolverIP)
+	client := &dns.Client{
+		Net:     "udp",
+		Timeout: s.timeout,
+		Dialer:  s.dialer(resolverIP, "udp"),
+	}
+	resp, _, err := client.Exchange(m, resolverAddr)
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		client.Dialer = s.dialer(resolverIP, "tcp")
+		result.Data.Protocol = "tcp"
+		resp, _, err = client.Exchange(m, resolverAddr)
+	}
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			result.Status = "TIMEOUT"
+		} else {
+			result.Status = "ERROR"
+		}
+		result.Error = err.Error()
+		return result
+	}
+
+	result.FromMsg(resp)
+
+	return result
+}
+
+// addressResult is an addressResultLookup that TLS checks ip for domainName,
+// or verifies it against the control domain registry, the first time it is
+// asked about the pair.
+func (s *scanner) addressResult(
+	domainName string,
+	ip net.IP,
+) *v4vsv6.AddressResult {
+	key := domainName + "-" + ip.String()
+	s.tlsMutex.Lock()
+	tr, ok := s.tlsResults[key]
+	if ok {
+		s.tlsMutex.Unlock()
+		<-tr.done
+		return tr.ar
+	}
+	tr = &tlsResult{done: make(chan struct{})}
+	s.tlsResults[key] = tr
+	s.tlsMutex.Unlock()
+
+	ar := new(v4vsv6.AddressResult)
+	ar.Domain = domainName
+	ar.IP = ip.String()
+	if ip.To4() == nil {
+		ar.AddressType = "AAAA"
+	} else {
+		ar.AddressType = "A"
+	}
+	ar.Timestamp = time.Now().Format(time.RFC3339)
+	if isControlDomain(ar.Domain) {
+		ar.ValidControlIP = verifyControlDomain(*ar)
+	} else {
+		ar.TLS = s.tlsLookup(domainName, ip)
+		ar.SupportsTLS = ar.TLS.Valid()
+		ar.Error = ar.TLS.Error
+		if !ar.SupportsTLS && blockpages != nil {
+			ar.Blockpage = blockpageLookup(s.dialer(ip, "tcp"), domainName, ip)
+		}
+	}
+
+	tr.ar = ar
+	close(tr.done)
+
+	return ar
+}
+
+// tlsLookup will make a TLS connection to ip for domainName and verify the
+// certificate the same way Zgrab2 results are verified in verifyTLS
+func (s *scanner) tlsLookup(domainName string, ip net.IP) *v4vsv6.TLSResult {
+	dialer := s.dialer(ip, "tcp")
+	conn, err := tls.DialWithDialer(
+		dialer,
+		"tcp",
+		net.JoinHostPort(ip.String(), tlsPort),
+		&tls.Config{
+			ServerName: domainName,
+			// the certificate is checked by verifyCertificate
+			InsecureSkipVerify: true,
+		},
+	)
+	if err != nil {
+		return &v4vsv6.TLSResult{
+			Status:  string(tlsStatus(err)),
+			Failure: v4vsv6.TLSFailureStatus,
+			Error:   err.Error(),
+		}
+	}
+	defer conn.Close()
+
+	ret := &v4vsv6.TLSResult{Status: string(zgrab2.SCAN_SUCCESS)}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		ret.Failure = v4vsv6.TLSFailureParse
+		ret.Error = "no certificates presented"
+		return ret
+	}
+	verifyCertificate(ret, certs[0], certs[1:], domainName, time.Now())
+
+	return ret
+}
+
+// tlsStatus returns the Zgrab2 scan status for a failed TLS connection.
+// Zgrab2 reports every failed dial as a timeout, here refused connections are
+// kept apart since they mean nothing is listening.
+func tlsStatus(err error) zgrab2.ScanStatus {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return zgrab2.SCAN_CONNECTION_REFUSED
+	case errors.Is(err, io.EOF), errors.Is(err, syscall.ECONNRESET):
+		return zgrab2.SCAN_CONNECTION_CLOSED
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return zgrab2.SCAN_CONNECTION_TIMEOUT
+	case errors.As(err, &opErr):
+		return zgrab2.SCAN_IO_TIMEOUT
+	default:
+		// anything that isn't a network error went wrong in the handshake
+		return zgrab2.SCAN_PROTOCOL_ERROR
+	}
+}
+
+// scanWorker will look up each input's domain at both resolvers of the pair,
+// for both A and AAAA records, and send the DomainResolverResults to drrChan
+func scanWorker(
+	s *scanner,
+	rccm map[string]string,
+	inputChan <-chan scanInput,
+	drrChan chan<- *v4vsv6.DomainResolverResult,
+	args ParseScansFlags,
+	wg *sync.WaitGroup,
+) {
+	defer wg.Done()
+	for input := range inputChan {
+		for _, resolverIP := range []net.IP{input.Pair.V4, input.Pair.V6} {
+			if resolverIP == nil {
+				continue
+			}
+			resolverStr := resolverIP.String()
+			for _, record := range []string{"A", "AAAA"} {
+				zdnsLine := s.query(resolverIP, input.Domain, record)
+				results := getAddressResultFromZDNS(
+					zdnsLine,
+					resolverStr,
+					s.addressResult,
+				)
+				drrChan <- newDomainResolverResult(
+					zdnsLine,
+					resolverStr,
+					record,
+					results,
+					rccm,
+					args,
+				)
+			}
+		}
+	}
+}
+
+// readDomains will read the domain file, which either has a JSON object with a
+// "domain" field or just a domain on each line
+func readDomains(path string) []string {
+	domainFile, err := os.Open(path)
+	if err != nil {
+		errorLogger.Fatalf("error opening %s: %v\n", path, err)
+	}
+	defer domainFile.Close()
+
+	var ret []string
+	scanner := bufio.NewScanner(domainFile)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "{") {
+			ret = append(ret, line)
+			continue
+		}
+		var domainObj struct {
+			Domain string `json:"domain"`
+		}
+		if err = json.Unmarshal([]byte(line), &domainObj); err != nil {
+			errorLogger.Printf("error unmarshaling line: %s, err: %v\n", line, err)
+			continue
+		}
+		ret = append(ret, domainObj.Domain)
+	}
+	if err = scanner.Err(); err != nil {
+		errorLogger.Fatalf("error reading %s: %v\n", path, err)
+	}
+
+	return ret
+}
+
+// parseSourceIP parses a --source-v4/--source-v6 flag, which must be empty or
+// an address of the given family
+func parseSourceIP(flag string, v4 bool) net.IP {
+	if flag == "" {
+		return nil
+	}
+	ip := net.ParseIP(flag)
+	if ip == nil || (ip.To4() != nil) != v4 {
+		errorLogger.Fatalf("Invalid source address: %s\n", flag)
+	}
+
+	return ip
+}
+
+// runScan will do the measurement itself rather than reading ZDNS and Zgrab2
+// results, writing the same domain-resolver-results file.
+func runScan(args ParseScansFlags) {
+	if args.DomainFile == "" {
+		errorLogger.Fatalln("--domain-file is required with --scan")
+	}
+	s := &scanner{
+		sourceV4:   parseSourceIP(args.SourceV4, true),
+		sourceV6:   parseSourceIP(args.SourceV6, false),
+		timeout:    time.Duration(args.Timeout) * time.Second,
+		pacer:      pacer.New(pacer.Config{Rate: float64(args.Rate)}),
+		tlsResults: make(map[string]*tlsResult),
+	}
+
+	resolverFile := args.ResolverFile
+	if resolverFile == "" {
+		resolverFile = filepath.Join(
+			args.DataFolder,
+			fmt.Sprintf("%s-single-resolvers-country-correct-sorted", args.DateString),
+		)
+	}
+	infoLogger.Printf("Reading resolver pairs from %s\n", resolverFile)
+	pairs, err := resolvers.ReadPairsFile(resolverFile, func(line int, err error) {
+		errorLogger.Printf("Skipping line %d of %s: %v\n", line, resolverFile, err)
+	})
+	if err != nil {
+		errorLogger.Fatalf("error reading %s: %v\n", resolverFile, err)
+	}
+	resolverCountryCodeMap := resolvers.CountryCodes(pairs)
+	infoLogger.Printf("Reading domains from %s\n", args.DomainFile)
+	domains := readDomains(args.DomainFile)
+	infoLogger.Printf(
+		"Scanning %d domains with %d resolver pairs\n",
+		len(domains),
+		len(pairs),
+	)
+
+	outputFile := filepath.Join(
+		args.DataFolder,
+		fmt.Sprintf("%s-domain-resolver-results_day%d.json", args.DateString, args.Day),
+	)
+	infoLogger.Printf("Writing DomainResolverResults to %s\n", outputFile)
+	domainResolverResultChan := make(chan *v4vsv6.DomainResolverResult, 100)
+	var drrWriteWG sync.WaitGroup
+	drrWriteWG.Add(1)
+	go writeDomainResolverResults(domainResolverResultChan, outputFile, &drrWriteWG)
+
+	inputChan := make(chan scanInput)
+	var workersWG sync.WaitGroup
+	for w := 0; w < args.Workers; w++ {
+		workersWG.Add(1)
+		go scanWorker(
+			s,
+			resolverCountryCodeMap,
+			inputChan,
+			domainResolverResultChan,
+			args,
+			&workersWG,
+		)
+	}
+
+	nextVerboseTime := time.Now().Add(30 * time.Second)
+	for i, domain := range domains {
+		if args.Verbose && time.Now().After(nextVerboseTime) {
+			infoLogger.Printf("Scanning domain %d of %d\n", i+1, len(domains))
+			nextVerboseTime = time.Now().Add(30 * time.Second)
+		}
+		for _, pair := range pairs {
+			inputChan <- scanInput{Domain: domain, Pair: pair}
+		}
+	}
+	close(inputChan)
+
+	infoLogger.Println("Waiting for scan workers to finish")
+	workersWG.Wait()
+	close(domainResolverResultChan)
+	drrWriteWG.Wait()
+}
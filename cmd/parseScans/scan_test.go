@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6"
+	"github.com/timartiny/v4vsv6/pkg/blockpage"
+	"github.com/timartiny/v4vsv6/pkg/control"
+	"github.com/timartiny/v4vsv6/pkg/fakenet"
+	"github.com/timartiny/v4vsv6/pkg/results"
+)
+
+// TestRunScan scans a resolver pair on loopback that censors some domains
+// over only one address family, and checks which queries are marked censored.
+func TestRunScan(t *testing.T) {
+	infoLogger = log.New(ioutil.Discard, "", 0)
+	errorLogger = log.New(ioutil.Discard, "", 0)
+	var err error
+	controlDomains, err = control.Load("")
+	require.NoError(t, err)
+
+	domains := []string{
+		"honest.test",
+		"v4nx.test",
+		"v6bogus.test",
+		"aaaafilter.test",
+		"dropped.test",
+		"blockpage.test",
+	}
+	zone := make(fakenet.Zone)
+	for _, domain := range domains {
+		zone[domain] = fakenet.Host()
+	}
+	auth, err := fakenet.NewAuthoritative(zone)
+	require.NoError(t, err)
+	defer auth.Close()
+	resolver, err := fakenet.NewResolver(fakenet.ResolverConfig{
+		Upstream: auth,
+		Rules: []fakenet.Rule{
+			{Domain: "v4nx.test", Family: 4, Action: fakenet.NXDomain},
+			{
+				Domain: "v6bogus.test",
+				Family: 6,
+				Action: fakenet.Bogus,
+				// nothing listens here, so TLS fails
+				Bogus: []net.IP{net.IPv4(127, 0, 0, 3)},
+			},
+			{Domain: "aaaafilter.test", Family: 6, Action: fakenet.FilterAAAA},
+			{Domain: "dropped.test", Family: 4, Action: fakenet.Drop},
+			{
+				Domain: "blockpage.test",
+				Family: 4,
+				Action: fakenet.Bogus,
+				Bogus:  []net.IP{net.IPv4(127, 0, 0, 2)},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer resolver.Close()
+	ca, err := fakenet.NewCA()
+	require.NoError(t, err)
+	server, err := fakenet.NewTLSServer(ca, domains...)
+	require.NoError(t, err)
+	defer server.Close()
+	// the blockpage is only served where the bogus answer points
+	httpListener, err := net.Listen("tcp", "127.0.0.2:0")
+	require.NoError(t, err)
+	httpServer := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<iframe src="http://10.10.34.34?type=Invalid Site&host=%s">`, r.Host)
+		},
+	))
+	httpServer.Listener.Close()
+	httpServer.Listener = httpListener
+	httpServer.Start()
+	defer httpServer.Close()
+	_, httpPort, err = net.SplitHostPort(httpListener.Addr().String())
+	require.NoError(t, err)
+	blockpages = blockpage.Default()
+
+	dnsPort = fmt.Sprint(resolver.Port)
+	tlsPort = fmt.Sprint(server.Port)
+	rootCAs = ca.Pool()
+	classifier, err = v4vsv6.NewClassifiers(
+		[]string{v4vsv6.RuleRCode, v4vsv6.RuleHTTPBlockpage, v4vsv6.RuleTLS},
+		v4vsv6.ClassifierOptions{},
+	)
+	require.NoError(t, err)
+	defer func() {
+		dnsPort, tlsPort, rootCAs = "53", "443", nil
+		classifier = v4vsv6.TLSValidity{}
+		httpPort, blockpages = "80", nil
+	}()
+
+	dir := t.TempDir()
+	domainFile := filepath.Join(dir, "domains")
+	require.NoError(t, ioutil.WriteFile(
+		domainFile, []byte(strings.Join(domains, "\n")), 0644,
+	))
+	resolverFile := filepath.Join(dir, "resolvers")
+	require.NoError(t, ioutil.WriteFile(
+		resolverFile, []byte("::1 127.0.0.1 US\n"), 0644,
+	))
+	runScan(ParseScansFlags{
+		Day:          1,
+		DataFolder:   dir,
+		DateString:   "test",
+		Scan:         true,
+		DomainFile:   domainFile,
+		ResolverFile: resolverFile,
+		Workers:      10,
+		Timeout:      1,
+	})
+
+	censored := make(map[string]bool)
+	rules := make(map[string]string)
+	var found []*v4vsv6.Blockpage
+	tlsResults := make(map[string]*v4vsv6.TLSResult)
+	rdr, err := results.OpenDRRReader(
+		filepath.Join(dir, "test-domain-resolver-results_day1.json"),
+	)
+	require.NoError(t, err)
+	defer rdr.Close()
+	for rdr.Next() {
+		drr := rdr.DomainResolverResult()
+		require.Equal(t, "US", drr.ResolverCountry)
+		key := fmt.Sprintf("%s %s %s", drr.Domain, drr.ResolverIP, drr.RequestedAddressType)
+		censored[key] = drr.CensoredQuery
+		rules[key] = drr.LastRound().Rule
+		for _, ar := range drr.LastRound().Results {
+			if ar != nil && ar.Blockpage != nil {
+				found = append(found, ar.Blockpage)
+			}
+			if ar != nil {
+				tlsResults[drr.Domain+" "+ar.IP] = ar.TLS
+			}
+		}
+	}
+	require.NoError(t, rdr.Err())
+
+	expected := make(map[string]bool)
+	for _, domain := range domains {
+		for _, resolverIP := range []string{"127.0.0.1", "::1"} {
+			for _, record := range []string{"A", "AAAA"} {
+				expected[fmt.Sprintf("%s %s %s", domain, resolverIP, record)] = false
+			}
+		}
+	}
+	for _, key := range []string{
+		"v4nx.test 127.0.0.1 A",
+		"v4nx.test 127.0.0.1 AAAA",
+		"v6bogus.test ::1 A",
+		"v6bogus.test ::1 AAAA",
+		"aaaafilter.test ::1 AAAA",
+		"dropped.test 127.0.0.1 A",
+		"dropped.test 127.0.0.1 AAAA",
+		"blockpage.test 127.0.0.1 A",
+		"blockpage.test 127.0.0.1 AAAA",
+	} {
+		expected[key] = true
+	}
+	require.Equal(t, expected, censored)
+
+	// NXDOMAIN is caught by the rcode rule before the TLS rule
+	require.Equal(t, v4vsv6.RuleRCode, rules["v4nx.test 127.0.0.1 A"])
+	require.Equal(t, v4vsv6.RuleTLS, rules["v6bogus.test ::1 A"])
+	require.Equal(t, v4vsv6.RuleTLS, rules["honest.test ::1 A"])
+	require.Equal(t, v4vsv6.RuleHTTPBlockpage, rules["blockpage.test 127.0.0.1 A"])
+	require.NotEmpty(t, found)
+	honest := tlsResults["honest.test 127.0.0.1"]
+	require.True(t, honest.Valid())
+	require.Equal(t, "success", honest.Status)
+	require.Equal(t, []string{"honest.test"}, honest.SANs)
+	bogus := tlsResults["v6bogus.test 127.0.0.3"]
+	require.Equal(t, "connection-refused", bogus.Status)
+	require.Equal(t, v4vsv6.TLSFailureStatus, bogus.Failure)
+	for _, bp := range found {
+		require.Equal(t, "iran-peyvandha", bp.Fingerprint)
+		require.Equal(t, "IR", bp.Country)
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"bufio"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -13,6 +16,11 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/miekg/dns"
+	"github.com/timartiny/v4vsv6/pkg/pacer"
+	"github.com/timartiny/v4vsv6/pkg/prober"
+	"github.com/timartiny/v4vsv6/pkg/qname"
+	"github.com/timartiny/v4vsv6/pkg/results"
+	"github.com/timartiny/v4vsv6/pkg/zdns"
 )
 
 type ProbeFlags struct {
@@ -22,19 +30,60 @@ type ProbeFlags struct {
 	Prefix                 bool   `arg:"--prefix" help:"If we should encode the resolver IP in our query" default:"false"`
 	Workers                uint   `arg:"--workers" help:"Number of worker threads" default:"1000"`
 	Timeout                int    `arg:"--timeout" help:"Duration to wait for DNS response" default:"5"`
+	Retries                int    `arg:"--retries" help:"Number of times to resend a query that isn't answered within --timeout" default:"0"`
+	Sockets                int    `arg:"--sockets" help:"Number of UDP sockets to send queries from" default:"4"`
 	Verbose                bool   `arg:"--verbose" help:"Print sent/received DNS packets/info" default:"true"`
-	V6Addresses            bool   `arg:"--v6-addresses" help:"Whether to prefix v6 addresses with dashes instead of colons" default:"false"`
+	V6Addresses            bool   `arg:"--v6-addresses" help:"No longer needed, v4 and v6 addresses are both prefixed with dashes" default:"false"`
 	NumberOfIndexedQueries int    `arg:"--num-indexed-queries" help:"Number of queries to index and issue to each resolver, will index each query with a new lowercase letter" default:"0"`
+	OutputFormat           string `arg:"--output-format" help:"How to report probes, \"text\" prints the --verbose lines, \"jsonl\" writes a JSON object for every probe" default:"text"`
+	OutputFile             string `arg:"--output" help:"With --output-format jsonl, the file to write to instead of stdout"`
+	pacer.Config
 }
 
-// If we want to add a response, do it here
+// Failure reasons recorded in a Result when no usable response was received
+const (
+	FailurePack    = "pack_error"
+	FailureDial    = "dial_error"
+	FailureWrite   = "write_error"
+	FailureTimeout = "timeout"
+	FailureRead    = "read_error"
+	FailureParse   = "parse_error"
+	// FailureNoWait is recorded when --timeout is 0, so no response was
+	// waited for
+	FailureNoWait = "no_wait"
+)
+
+// Result records a single probe and the response to it, if any.
 type Result struct {
-	ip   net.IP
-	err  error
-	resp []byte
+	Resolver    string        `json:"resolver"`
+	Name        string        `json:"name"`
+	Record      string        `json:"record"`
+	SourceIP    string        `json:"source_ip,omitempty"`
+	ID          uint16        `json:"id"`
+	Sent        string        `json:"sent,omitempty"`
+	Received    string        `json:"received,omitempty"`
+	RTTMillis   float64       `json:"rtt_ms,omitempty"`
+	RCode       int           `json:"rcode"`
+	Status      string        `json:"status,omitempty"`
+	Flags       *zdns.Flags   `json:"flags,omitempty"`
+	Answers     []zdns.Answer `json:"answers,omitempty"`
+	Authorities []zdns.Answer `json:"authorities,omitempty"`
+	Additionals []zdns.Answer `json:"additionals,omitempty"`
+	Query       string        `json:"query,omitempty"`
+	Response    string        `json:"response,omitempty"`
+	Failure     string        `json:"failure,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// fail records why a probe didn't get a usable response
+func (r *Result) fail(reason string, err error) {
+	r.Failure = reason
+	if err != nil {
+		r.Error = err.Error()
+	}
 }
 
-func sendDnsProbe(ip net.IP, domain string, timeout time.Duration, verbose bool, queryType uint16, sourceIP string) (Result, error) {
+func sendDnsProbe(pr *prober.Prober, ip net.IP, domain string, wait bool, verbose bool, queryType uint16, sourceIP string) Result {
 	m := &dns.Msg{
 		MsgHdr: dns.MsgHdr{
 			Authoritative:     false,
@@ -51,66 +100,63 @@ func sendDnsProbe(ip net.IP, domain string, timeout time.Duration, verbose bool,
 		Qclass: uint16(dns.ClassINET),
 	}
 	m.Id = dns.Id()
-
-	out, err := m.Pack()
-	if err != nil {
-		if verbose {
-			fmt.Printf("%s - Error creating UDP packet: %v\n", ip.String(), err)
-		}
-		return Result{}, err
-	}
-	addr := ip.String() + ":53"
-	if ip.To16() != nil {
-		addr = "[" + ip.String() + "]:53"
-	}
-	udpAddr := &net.UDPAddr{
-		IP: net.ParseIP(sourceIP),
-	}
-	dialer := net.Dialer{
-		LocalAddr: udpAddr,
+	result := Result{
+		Resolver: ip.String(),
+		Name:     domain,
+		Record:   dns.TypeToString[queryType],
+		SourceIP: sourceIP,
+		ID:       m.Id,
+		RCode:    -1,
 	}
 
-	conn, err := dialer.Dial("udp", addr)
-	if err != nil {
+	if !wait {
+		sent := time.Now()
+		out, err := pr.Send(m, ip)
+		result.Query = hex.EncodeToString(out)
+		if err != nil {
+			if verbose {
+				fmt.Printf("%s - Error sending: %v\n", ip.String(), err)
+			}
+			result.fail(failureFromError(err), err)
+			return result
+		}
+		result.Sent = sent.Format(time.RFC3339Nano)
 		if verbose {
-			fmt.Printf("%s - Error creating UDP socket(?): %v\n", ip.String(), err)
+			fmt.Printf("Sent %s - %s - %s\n", ip.String(), domain, result.Query)
 		}
-		return Result{}, err
+		result.fail(FailureNoWait, nil)
+		return result
 	}
 
-	defer conn.Close()
-
-	conn.Write(out)
-	if verbose {
-		fmt.Printf("Sent %s - %s - %s\n", ip.String(), domain, hex.EncodeToString(out))
-	}
-
-	if timeout == 0 {
-		return Result{ip: ip}, nil
-	}
-
-	conn.SetReadDeadline(time.Now().Add(timeout))
-	resp := make([]byte, 1024)
-	n, err := conn.Read(resp)
-	if err != nil {
+	resp, err := pr.Exchange(m, ip)
+	result.Query = hex.EncodeToString(resp.Query)
+	if !resp.Sent.IsZero() {
+		result.Sent = resp.Sent.Format(time.RFC3339Nano)
+		// the prober picks a new ID if this one is already in use
+		result.ID = uint16(resp.Query[0])<<8 | uint16(resp.Query[1])
 		if verbose {
-			fmt.Printf("%s - ReadErr: %v\n", ip.String(), err)
+			fmt.Printf("Sent %s - %s - %s\n", ip.String(), domain, result.Query)
 		}
-		return Result{}, err
 	}
-
-	var r dns.Msg
-	err = r.Unpack(resp)
+	if resp.Raw != nil {
+		result.Received = resp.Received.Format(time.RFC3339Nano)
+		result.RTTMillis = float64(resp.RTT()) / float64(time.Millisecond)
+		result.Response = hex.EncodeToString(resp.Raw)
+	}
 	if err != nil {
 		if verbose {
-			fmt.Printf("%s - ParseErr: %v\n", ip.String(), err)
+			fmt.Printf("%s - Error: %v\n", ip.String(), err)
 		}
-		return Result{
-			ip:   ip,
-			err:  err,
-			resp: resp[:n],
-		}, err
+		result.fail(failureFromError(err), err)
+		return result
 	}
+	r := resp.Msg
+	result.RCode = r.Rcode
+	result.Status = dns.RcodeToString[r.Rcode]
+	result.Flags = zdns.FlagsFromMsg(r)
+	result.Answers = zdns.AnswersFromRRs(r.Answer)
+	result.Authorities = zdns.AnswersFromRRs(r.Ns)
+	result.Additionals = zdns.AnswersFromRRs(r.Extra)
 	if verbose {
 
 		ans := "??"
@@ -121,26 +167,81 @@ func sendDnsProbe(ip net.IP, domain string, timeout time.Duration, verbose bool,
 			// Take first answer
 			ans += ": " + r.Answer[0].String()
 		}
-		fmt.Printf("%s - Response (%d bytes): %s - %s\n", ip.String(), n, hex.EncodeToString(resp[:n]), ans)
+		fmt.Printf("%s - Response (%d bytes): %s - %s\n", ip.String(), len(resp.Raw), result.Response, ans)
 		//fmt.Printf("%s\n", r.String())
 	}
 
-	return Result{
-		ip:   ip,
-		err:  nil,
-		resp: resp[:n]}, nil
+	return result
+}
+
+// failureFromError returns the Failure reason for an error from the prober
+func failureFromError(err error) string {
+	if errors.Is(err, prober.ErrTimeout) {
+		return FailureTimeout
+	}
+	var proberErr *prober.Error
+	if !errors.As(err, &proberErr) {
+		return FailureRead
+	}
+	switch proberErr.Op {
+	case prober.OpPack:
+		return FailurePack
+	case prober.OpDial:
+		return FailureDial
+	case prober.OpWrite:
+		return FailureWrite
+	case prober.OpParse:
+		return FailureParse
+	default:
+		return FailureRead
+	}
+}
+
+// writeResults will write each Result as a line of JSON to path, or stdout if
+// path is empty
+func writeResults(
+	resultChan <-chan Result,
+	path string,
+	wg *sync.WaitGroup,
+) {
+	defer wg.Done()
+	var w io.WriteCloser = os.Stdout
+	if path != "" {
+		var err error
+		w, err = results.Create(path)
+		if err != nil {
+			log.Fatalf("Error creating %s: %v\n", path, err)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	for result := range resultChan {
+		if err := encoder.Encode(result); err != nil {
+			log.Printf("Error writing result: %v\n", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatalf("Error writing results: %v\n", err)
+	}
+	if path != "" {
+		if err := w.Close(); err != nil {
+			log.Fatalf("Error closing %s: %v\n", path, err)
+		}
+	}
 }
 
 func dnsWorker(
+	pr *prober.Prober,
 	baseDomain string,
 	prefixIP bool,
-	timeout time.Duration,
+	wait bool,
 	queryType uint16,
 	sourceIP string,
-	verbose,
-	v6Addresses bool,
+	verbose bool,
 	indexedQueries int,
+	p *pacer.Pacer,
 	ips <-chan net.IP,
+	resultChan chan<- Result,
 	wg *sync.WaitGroup,
 ) {
 	defer wg.Done()
@@ -154,24 +255,37 @@ func dnsWorker(
 		domain := baseDomain
 		for i := 0; i < numLoops; i++ {
 			if prefixIP {
-				if v6Addresses {
-					domain = strings.ReplaceAll(ip.String(), ":", "-")
-				} else {
-					domain = strings.Replace(ip.String(), ".", "-", 3)
-				}
-
+				index := -1
 				if indexedQueries > 0 {
 					// index query with alpha characters a-z
-					domain += "_" + string(i+97) // convert our iteration to a-z
+					index = i
 				}
-
-				domain += "." + baseDomain
+				domain = qname.Encode(ip, index, baseDomain)
+			}
+			p.Wait(ip)
+			result := sendDnsProbe(pr, ip, domain, wait, verbose, queryType, sourceIP)
+			if resultChan != nil {
+				resultChan <- result
 			}
-			sendDnsProbe(ip, domain, timeout, verbose, queryType, sourceIP)
 		}
 	}
 }
 
+// parseTarget parses a line of input. Addresses of the other family than
+// source are an error, as they would be sent from whatever address the kernel
+// picked rather than the source recorded with their results.
+func parseTarget(line string, source net.IP) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(line))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address: %q", line)
+	}
+	if (ip.To4() == nil) != (source.To4() == nil) {
+		return nil, fmt.Errorf("%s is not the same address family as --source-ip %s", ip, source)
+	}
+
+	return ip, nil
+}
+
 func setupArgs() ProbeFlags {
 	var ret ProbeFlags
 	arg.MustParse(&ret)
@@ -188,43 +302,83 @@ func main() {
 		)
 	}
 
+	// in jsonl mode results are written as they come in, and the verbose
+	// text lines would be mixed in with them
+	var resultChan chan Result
+	var writeWG sync.WaitGroup
+	switch args.OutputFormat {
+	case "text":
+	case "jsonl":
+		args.Verbose = false
+		resultChan = make(chan Result, args.Workers)
+		writeWG.Add(1)
+		go writeResults(resultChan, args.OutputFile, &writeWG)
+	default:
+		log.Fatalf("Unknown output format: %s\n", args.OutputFormat)
+	}
+
+	sourceIP := net.ParseIP(args.SourceIP)
+	if sourceIP == nil {
+		log.Fatalf("Invalid source IP: %s\n", args.SourceIP)
+	}
+	proberConfig := prober.Config{
+		Sockets: args.Sockets,
+		Timeout: timeout,
+		Retries: args.Retries,
+	}
+	if sourceIP.To4() != nil {
+		proberConfig.SourceV4 = sourceIP
+	} else {
+		proberConfig.SourceV6 = sourceIP
+	}
+	pr := prober.New(proberConfig)
+	defer pr.Close()
+
+	p := pacer.New(args.Config)
 	jobs := make(chan net.IP, args.Workers*10)
 	var wg sync.WaitGroup
-	var dnsType uint16
-	if args.RecordType == "A" {
-		dnsType = dns.TypeA
-	} else if args.RecordType == "AAAA" {
-		dnsType = dns.TypeAAAA
+	dnsType, ok := dns.StringToType[args.RecordType]
+	if !ok {
+		log.Fatalf("Unknown record type: %s\n", args.RecordType)
 	}
 
 	for w := uint(0); w < args.Workers; w++ {
 		wg.Add(1)
 		go dnsWorker(
+			pr,
 			args.BaseDomain,
 			args.Prefix,
-			timeout,
+			args.Timeout > 0,
 			dnsType,
 			args.SourceIP,
 			args.Verbose,
-			args.V6Addresses,
 			args.NumberOfIndexedQueries,
+			p,
 			jobs,
+			resultChan,
 			&wg,
 		)
 	}
 
 	nJobs := 0
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
-		jobs <- net.ParseIP(line)
+	err := pacer.ShuffleLines(os.Stdin, args.Config, func(line string) {
+		ip, err := parseTarget(line, sourceIP)
+		if err != nil {
+			log.Printf("Skipping %v\n", err)
+			return
+		}
+		jobs <- ip
 		nJobs += 1
-	}
+	})
 	close(jobs)
 
-	if err := scanner.Err(); err != nil {
+	if err != nil {
 		log.Println(err)
 	}
 
 	wg.Wait()
+	if resultChan != nil {
+		close(resultChan)
+		writeWG.Wait()
+	}
 }
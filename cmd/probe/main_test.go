@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6/pkg/fakenet"
+	"github.com/timartiny/v4vsv6/pkg/prober"
+	"github.com/timartiny/v4vsv6/pkg/qname"
+)
+
+// TestSendDnsProbe probes the v4 address of a resolver on loopback that
+// forwards over IPv6, and checks our name server sees the query for the
+// encoded address come from the resolver's v6 address.
+func TestSendDnsProbe(t *testing.T) {
+	auth, err := fakenet.NewAuthoritative(fakenet.Zone{
+		"*.probe.test": fakenet.Host(),
+	})
+	require.NoError(t, err)
+	defer auth.Close()
+	resolver, err := fakenet.NewResolver(fakenet.ResolverConfig{
+		Upstream:      auth,
+		ForwardFamily: 6,
+		Rules: []fakenet.Rule{
+			{Domain: "dropped.probe.test", Action: fakenet.Drop},
+		},
+	})
+	require.NoError(t, err)
+	defer resolver.Close()
+	pr := prober.New(prober.Config{
+		Port:    resolver.Port,
+		Timeout: 200 * time.Millisecond,
+	})
+	defer pr.Close()
+
+	domain := qname.Encode(fakenet.V4, -1, "probe.test")
+	result := sendDnsProbe(pr, fakenet.V4, domain, true, false, dns.TypeA, "")
+	require.Empty(t, result.Failure)
+	require.Equal(t, "NOERROR", result.Status)
+	require.Len(t, result.Answers, 1)
+	require.Equal(t, "127.0.0.1", result.Answers[0].Answer)
+
+	queries := auth.Queries()
+	require.Len(t, queries, 1)
+	require.Equal(t, "::1", queries[0].Source.String())
+	encoded, _, err := qname.Decode(queries[0].Name, "probe.test")
+	require.NoError(t, err)
+	require.True(t, encoded.Equal(net.IPv4(127, 0, 0, 1)))
+
+	result = sendDnsProbe(pr, fakenet.V4, "dropped.probe.test", true, false, dns.TypeA, "")
+	require.Equal(t, FailureTimeout, result.Failure)
+	require.Equal(t, -1, result.RCode)
+}
+
+func TestParseTarget(t *testing.T) {
+	v4, v6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+
+	ip, err := parseTarget("198.51.100.7", v4)
+	require.NoError(t, err)
+	require.True(t, ip.Equal(net.ParseIP("198.51.100.7")))
+	ip, err = parseTarget(" 2001:db8::53\r", v6)
+	require.NoError(t, err)
+	require.True(t, ip.Equal(net.ParseIP("2001:db8::53")))
+
+	_, err = parseTarget("2001:db8::53", v4)
+	require.Error(t, err)
+	_, err = parseTarget("198.51.100.7", v6)
+	require.Error(t, err)
+	_, err = parseTarget("not an address", v4)
+	require.Error(t, err)
+}
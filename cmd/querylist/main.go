@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/timartiny/v4vsv6/pkg/zdns"
 	flags "github.com/zmap/zflags"
 	"github.com/zmap/zgrab2"
 )
@@ -20,30 +21,6 @@ import (
 var infoLogger *log.Logger
 var errorLogger *log.Logger
 
-type ZDNSResult struct {
-	AlteredName string        `json:"altered_name,omitempty" groups:"short,normal,long,trace"`
-	Name        string        `json:"name,omitempty" groups:"short,normal,long,trace"`
-	Nameserver  string        `json:"nameserver,omitempty" groups:"normal,long,trace"`
-	Class       string        `json:"class,omitempty" groups:"long,trace"`
-	AlexaRank   int           `json:"alexa_rank,omitempty" groups:"short,normal,long,trace"`
-	Metadata    string        `json:"metadata,omitempty" groups:"short,normal,long,trace"`
-	Status      string        `json:"status,omitempty" groups:"short,normal,long,trace"`
-	Error       string        `json:"error,omitempty" groups:"short,normal,long,trace"`
-	Timestamp   string        `json:"timestamp,omitempty" groups:"short,normal,long,trace"`
-	Data        interface{}   `json:"data,omitempty" groups:"short,normal,long,trace"`
-	Trace       []interface{} `json:"trace,omitempty" groups:"trace"`
-}
-
-type ZDNSAnswer struct {
-	Ttl     uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
-	Type    string `json:"type,omitempty" groups:"short,normal,long,trace"`
-	RrType  uint16 `json:"-"`
-	Class   string `json:"class,omitempty" groups:"short,normal,long,trace"`
-	RrClass uint16 `json:"-"`
-	Name    string `json:"name,omitempty" groups:"short,normal,long,trace"`
-	Answer  string `json:"answer,omitempty" groups:"short,normal,long,trace"`
-}
-
 type IPSupportsTLS map[string]bool
 
 type TLSResults struct {
@@ -177,22 +154,37 @@ func setupArgs(args []string) QuerylistFlags {
 	return ret
 }
 
+// openZDNS opens a ZDNS output file, exiting if it can't
+func openZDNS(path string) *zdns.Reader {
+	zdnsReader, err := zdns.OpenReader(path)
+	if err != nil {
+		errorLogger.Printf("Error opening %s\n", path)
+		errorLogger.Fatalln(err)
+	}
+
+	return zdnsReader
+}
+
+// closeZDNS checks a ZDNS output file was read without error and closes it
+func closeZDNS(zdnsReader *zdns.Reader) {
+	if err := zdnsReader.Err(); err != nil {
+		errorLogger.Fatalln(err)
+	}
+	zdnsReader.Close()
+}
+
 // addDNSResults will take a path to the DNS results (in ZDNS form) and update
 // drm.
 func addDNSResults(drm DomainResultsMap, path string) {
-	file, err := os.Open(path)
+	zdnsReader, err := zdns.OpenReader(path)
 	if err != nil {
-		errorLogger.Printf("os.Open err: %v\n", err)
+		errorLogger.Printf("zdns.OpenReader err: %v\n", err)
 		errorLogger.Fatalln("Please provide a valid file using the --v{4,6}_dns flag")
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
+	defer closeZDNS(zdnsReader)
 
-	for scanner.Scan() {
-		var zdnsResult ZDNSResult
-		l := scanner.Text()
-		json.Unmarshal([]byte(l), &zdnsResult)
+	for zdnsReader.Next() {
+		zdnsResult := zdnsReader.Result()
 		domainName := zdnsResult.Name
 		// infoLogger.Printf("the domain name is: %s\n", domainName)
 		if _, ok := drm[domainName]; !ok {
@@ -202,27 +194,18 @@ func addDNSResults(drm DomainResultsMap, path string) {
 			tmp.Rank = zdnsResult.AlexaRank
 			drm[domainName] = tmp
 		}
-		interfaceAnswers, ok := zdnsResult.Data.(map[string]interface{})["answers"]
-		if !ok {
-			// infoLogger.Printf("This results has no answers, domain: %s\n", domainName)
-			continue
+		for _, answer := range zdnsResult.AnswersOfType("A") {
+			ip, err := answer.IP()
+			if err == nil && ip.To4() != nil {
+				drm[domainName].HasV4 = true
+				break
+			}
 		}
-		zdnsAnswers := interfaceAnswers.([]interface{})
-		for _, interfaceAnswer := range zdnsAnswers {
-			tmpJSONString, _ := json.Marshal(interfaceAnswer)
-			var answer ZDNSAnswer
-			json.Unmarshal(tmpJSONString, &answer)
-			if answer.Type == "A" {
-				ip := net.ParseIP(answer.Answer)
-				if ip != nil && ip.To4() != nil {
-					drm[domainName].HasV4 = true
-					break
-				}
-			} else if answer.Type == "AAAA" {
-				ip := net.ParseIP(answer.Answer)
-				if ip != nil && ip.To4() == nil {
-					drm[domainName].HasV6 = true
-				}
+		for _, answer := range zdnsResult.AnswersOfType("AAAA") {
+			ip, err := answer.IP()
+			if err == nil && ip.To4() == nil {
+				drm[domainName].HasV6 = true
+				break
 			}
 		}
 	}
@@ -234,49 +217,28 @@ func addDNSResults(drm DomainResultsMap, path string) {
 // addresses
 func domainNSMapper(drm DomainResultsMap, NSPath, NSAPath, NSAAAAPath string) {
 	infoLogger.Printf("Looking at A records for NSs from %s\n", NSAPath)
-	file, err := os.Open(NSAPath)
-	if err != nil {
-		errorLogger.Printf("Error opening %s\n", NSAPath)
-		errorLogger.Fatalln(err)
-	}
-	defer file.Close()
+	zdnsReader := openZDNS(NSAPath)
 
 	nsStatusMap := make(DomainNSStatusMap)
 
-	scanner := bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		var zdnsResult ZDNSResult
-		l := scanner.Text()
-		json.Unmarshal([]byte(l), &zdnsResult)
-
+	for zdnsReader.Next() {
+		zdnsResult := zdnsReader.Result()
 		ns := zdnsResult.Name
-		if zdnsResult.Status != "NOERROR" {
+		if !zdnsResult.OK() {
 			errorLogger.Printf(
 				"Error doing NS A lookup for %s, skipping\n", ns,
 			)
 			continue
 		}
-		interfaceAnswers, ok := zdnsResult.Data.(map[string]interface{})["answers"]
-		if !ok {
-			// infoLogger.Printf("This results has no answers, domain: %s\n", domainName)
-			continue
-		}
-		zdnsAnswers := interfaceAnswers.([]interface{})
-		for _, interfaceAnswer := range zdnsAnswers {
-			tmpJSONString, _ := json.Marshal(interfaceAnswer)
-			var answer ZDNSAnswer
-			json.Unmarshal(tmpJSONString, &answer)
-			if answer.Type == "A" {
-				ip := net.ParseIP(answer.Answer)
-				if ip != nil && ip.To4() != nil {
-					nsStatusMap[ns] = DomainNSStatus{V4NS: true}
-					break
-				}
+		for _, answer := range zdnsResult.AnswersOfType("A") {
+			ip, err := answer.IP()
+			if err == nil && ip.To4() != nil {
+				nsStatusMap[ns] = DomainNSStatus{V4NS: true}
+				break
 			}
 		}
 	}
-	file.Close()
+	closeZDNS(zdnsReader)
 
 	// finished looking up A records for NSes
 	infoLogger.Printf(
@@ -284,51 +246,30 @@ func domainNSMapper(drm DomainResultsMap, NSPath, NSAPath, NSAAAAPath string) {
 		NSAAAAPath,
 	)
 
-	file, err = os.Open(NSAAAAPath)
-	if err != nil {
-		errorLogger.Printf("Error opening %s\n", NSAAAAPath)
-		errorLogger.Fatalln(err)
-	}
-	defer file.Close()
-	scanner = bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		var zdnsResult ZDNSResult
-		l := scanner.Text()
-		json.Unmarshal([]byte(l), &zdnsResult)
-
+	zdnsReader = openZDNS(NSAAAAPath)
+	for zdnsReader.Next() {
+		zdnsResult := zdnsReader.Result()
 		ns := zdnsResult.Name
-		if zdnsResult.Status != "NOERROR" {
+		if !zdnsResult.OK() {
 			errorLogger.Printf(
 				"Error doing NS AAAA lookup for %s, skipping\n", ns,
 			)
 			continue
 		}
-		interfaceAnswers, ok := zdnsResult.Data.(map[string]interface{})["answers"]
-		if !ok {
-			// infoLogger.Printf("This results has no answers, domain: %s\n", domainName)
-			continue
-		}
-		zdnsAnswers := interfaceAnswers.([]interface{})
-		for _, interfaceAnswer := range zdnsAnswers {
-			tmpJSONString, _ := json.Marshal(interfaceAnswer)
-			var answer ZDNSAnswer
-			json.Unmarshal(tmpJSONString, &answer)
-			if answer.Type == "AAAA" {
-				ip := net.ParseIP(answer.Answer)
-				if ip != nil && ip.To4() != nil {
-					if status, ok := nsStatusMap[ns]; ok {
-						status.V6NS = true
-						nsStatusMap[ns] = status
-					} else {
-						nsStatusMap[ns] = DomainNSStatus{V6NS: true}
-					}
-					break
+		for _, answer := range zdnsResult.AnswersOfType("AAAA") {
+			ip, err := answer.IP()
+			if err == nil && ip.To4() == nil {
+				if status, ok := nsStatusMap[ns]; ok {
+					status.V6NS = true
+					nsStatusMap[ns] = status
+				} else {
+					nsStatusMap[ns] = DomainNSStatus{V6NS: true}
 				}
+				break
 			}
 		}
 	}
-	file.Close()
+	closeZDNS(zdnsReader)
 
 	// finished looking up AAAA records for NSes
 	infoLogger.Printf(
@@ -336,50 +277,29 @@ func domainNSMapper(drm DomainResultsMap, NSPath, NSAPath, NSAAAAPath string) {
 			"whether they have NSs with A and AAAA records from: %s\n",
 		NSPath,
 	)
-	file, err = os.Open(NSPath)
-	if err != nil {
-		errorLogger.Printf("Error opening %s\n", NSPath)
-		errorLogger.Fatalln(err)
-	}
-	defer file.Close()
-	scanner = bufio.NewScanner(file)
-	for scanner.Scan() {
-		var zdnsResult ZDNSResult
-		l := scanner.Text()
-		json.Unmarshal([]byte(l), &zdnsResult)
-
+	zdnsReader = openZDNS(NSPath)
+	defer closeZDNS(zdnsReader)
+	for zdnsReader.Next() {
+		zdnsResult := zdnsReader.Result()
 		domain := zdnsResult.Name
-		// if _, dnmOK := dnm[domain]; !dnmOK {
-		// 	// we've not seen this domain before. This should always be the case
-		// 	dnm[domain] = DomainNSStatus{}
-		// }
-		if zdnsResult.Status != "NOERROR" {
+		if !zdnsResult.OK() {
 			errorLogger.Printf(
 				"Error doing NS lookup for %s, skipping\n", domain,
 			)
 			continue
 		}
-		interfaceAnswers, ok := zdnsResult.Data.(map[string]interface{})["answers"]
+		domainResults, ok := drm[domain]
 		if !ok {
-			// infoLogger.Printf("This results has no answers, domain: %s\n", domainName)
 			continue
 		}
-		zdnsAnswers := interfaceAnswers.([]interface{})
-		for _, interfaceAnswer := range zdnsAnswers {
-			domainResults := drm[domain]
-			tmpJSONString, _ := json.Marshal(interfaceAnswer)
-			var answer ZDNSAnswer
-			json.Unmarshal(tmpJSONString, &answer)
-			if answer.Type == "NS" {
-				if status, ok := nsStatusMap[answer.Answer]; ok {
-					// got an NS we've seen before
-					if status.V4NS {
-						domainResults.HasV4NS = true
-					}
-					if status.V6NS {
-						domainResults.HasV6NS = true
-					}
-					drm[domain] = domainResults
+		for _, answer := range zdnsResult.AnswersOfType("NS") {
+			if status, ok := nsStatusMap[answer.Answer]; ok {
+				// got an NS we've seen before
+				if status.V4NS {
+					domainResults.HasV4NS = true
+				}
+				if status.V6NS {
+					domainResults.HasV6NS = true
 				}
 			}
 		}
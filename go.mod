@@ -6,9 +6,11 @@ require (
 	github.com/alexflint/go-arg v1.4.2
 	github.com/google/gopacket v1.1.19
 	github.com/keltia/ripe-atlas v0.0.0-20211113111020-53d678dab043
+	github.com/klauspost/compress v1.15.15
 	github.com/miekg/dns v1.1.45
 	github.com/oschwald/geoip2-golang v1.7.0
 	github.com/oschwald/maxminddb-golang v1.9.0
-	github.com/zmap/zflags v1.4.0-beta.1 // indirect
+	github.com/stretchr/testify v1.7.1
+	github.com/zmap/zflags v1.4.0-beta.1
 	github.com/zmap/zgrab2 v0.1.7
 )
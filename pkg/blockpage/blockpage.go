@@ -0,0 +1,200 @@
+// Package blockpage identifies the pages censors serve in place of a blocked
+// site. Addresses returned by a resolver that fail TLS are fetched over HTTP
+// with the test domain as the Host, and the response is matched against a
+// database of fingerprints, each labelled with the vendor of the filtering
+// product and the country it is known to be deployed in.
+package blockpage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNoPattern is returned when a fingerprint has nothing to match on.
+	ErrNoPattern = errors.New("fingerprint has no body, header or sha256 to match")
+
+	// ErrNoName is returned when a fingerprint has no name.
+	ErrNoName = errors.New("fingerprint has no name")
+)
+
+// Fingerprint describes a single blockpage. A response matches if it matches
+// every pattern given.
+type Fingerprint struct {
+	Name    string `json:"name"`
+	Vendor  string `json:"vendor,omitempty"`
+	Country string `json:"country,omitempty"`
+	// Body is a regular expression matched against the response body.
+	Body string `json:"body,omitempty"`
+	// Header is a regular expression matched against each response header,
+	// formatted as "Name: value".
+	Header string `json:"header,omitempty"`
+	// SHA256 is the hex encoded SHA-256 of the response body as Fetch reads
+	// it, the first MaxBody bytes, so for larger pages it is the hash of
+	// their start rather than the whole body.
+	SHA256 string `json:"sha256,omitempty"`
+
+	body   *regexp.Regexp
+	header *regexp.Regexp
+}
+
+// DB is a set of blockpage fingerprints, tried in order.
+type DB struct {
+	fingerprints []*Fingerprint
+}
+
+// defaultFingerprints are well known blockpages, enough to get started with.
+var defaultFingerprints = []*Fingerprint{
+	{
+		Name:    "iran-peyvandha",
+		Vendor:  "government",
+		Country: "IR",
+		Body:    `(?i)peyvandha\.ir|src="https?://10\.10\.34\.3[4-6]`,
+	},
+	{
+		Name:   "opendns-block",
+		Vendor: "Cisco Umbrella",
+		Header: `(?i)^Location: https?://[^/]*block\.opendns\.com`,
+	},
+	{
+		Name:   "fortiguard",
+		Vendor: "Fortinet",
+		Body:   `(?i)FortiGuard (Web Filtering|Intrusion Prevention)`,
+	},
+	{
+		Name:   "netsweeper",
+		Vendor: "Netsweeper",
+		Body:   `(?i)netsweeper|/webadmin/deny/`,
+	},
+}
+
+// compile checks fp and compiles its patterns.
+func (fp *Fingerprint) compile() error {
+	if fp.Name == "" {
+		return ErrNoName
+	}
+	if fp.Body == "" && fp.Header == "" && fp.SHA256 == "" {
+		return fmt.Errorf("%s: %w", fp.Name, ErrNoPattern)
+	}
+	var err error
+	if fp.Body != "" {
+		if fp.body, err = regexp.Compile(fp.Body); err != nil {
+			return fmt.Errorf("%s body: %w", fp.Name, err)
+		}
+	}
+	if fp.Header != "" {
+		// headers are matched one line at a time
+		if fp.header, err = regexp.Compile("(?m)" + fp.Header); err != nil {
+			return fmt.Errorf("%s header: %w", fp.Name, err)
+		}
+	}
+	fp.SHA256 = strings.ToLower(fp.SHA256)
+
+	return nil
+}
+
+// NewDB builds a DB from a list of fingerprints, checking every pattern
+// compiles.
+func NewDB(fingerprints []*Fingerprint) (*DB, error) {
+	for _, fp := range fingerprints {
+		if err := fp.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DB{fingerprints: fingerprints}, nil
+}
+
+// Default returns a DB of a few well known blockpages.
+func Default() *DB {
+	fingerprints := make([]*Fingerprint, len(defaultFingerprints))
+	for i, fp := range defaultFingerprints {
+		copied := *fp
+		fingerprints[i] = &copied
+	}
+	db, err := NewDB(fingerprints)
+	if err != nil {
+		// the defaults are hardcoded, so this can only be a programming error
+		panic(err)
+	}
+
+	return db
+}
+
+// Load reads a JSON fingerprint database, a list of objects like:
+//
+//	[{"name": "iran-peyvandha", "vendor": "government", "country": "IR", "body": "peyvandha\\.ir"}]
+//
+// If path is empty the Default database is returned.
+func Load(path string) (*DB, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fingerprints []*Fingerprint
+	if err = json.Unmarshal(bs, &fingerprints); err != nil {
+		return nil, fmt.Errorf("error parsing blockpage fingerprints %s: %w", path, err)
+	}
+
+	return NewDB(fingerprints)
+}
+
+// Len returns the number of fingerprints.
+func (db *DB) Len() int {
+	return len(db.fingerprints)
+}
+
+// Match returns the first fingerprint resp matches, or nil if it matches none.
+func (db *DB) Match(resp *Response) *Fingerprint {
+	if resp == nil {
+		return nil
+	}
+	var headers, sum string
+	for _, fp := range db.fingerprints {
+		if fp.header != nil && headers == "" {
+			headers = formatHeaders(resp)
+		}
+		if fp.SHA256 != "" && sum == "" {
+			digest := sha256.Sum256(resp.Body)
+			sum = hex.EncodeToString(digest[:])
+		}
+		if fp.body != nil && !fp.body.Match(resp.Body) {
+			continue
+		}
+		if fp.header != nil && !fp.header.MatchString(headers) {
+			continue
+		}
+		if fp.SHA256 != "" && fp.SHA256 != sum {
+			continue
+		}
+
+		return fp
+	}
+
+	return nil
+}
+
+// formatHeaders writes the headers of resp one per line as "Name: value", in
+// a stable order.
+func formatHeaders(resp *Response) string {
+	var lines []string
+	for name, values := range resp.Header {
+		for _, value := range values {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,113 @@
+package blockpage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefault(t *testing.T) {
+	db := Default()
+	require.Equal(t, len(defaultFingerprints), db.Len())
+
+	fp := db.Match(&Response{
+		StatusCode: http.StatusForbidden,
+		Body:       []byte(`<html><iframe src="http://10.10.34.34?type=Invalid Site"></iframe></html>`),
+	})
+	require.NotNil(t, fp)
+	require.Equal(t, "iran-peyvandha", fp.Name)
+	require.Equal(t, "IR", fp.Country)
+
+	fp = db.Match(&Response{
+		StatusCode: http.StatusFound,
+		Header:     http.Header{"Location": {"https://block.opendns.com/?url=example.com"}},
+	})
+	require.NotNil(t, fp)
+	require.Equal(t, "opendns-block", fp.Name)
+
+	require.Nil(t, db.Match(&Response{
+		StatusCode: http.StatusOK,
+		Body:       []byte("<html>Welcome to example.com</html>"),
+	}))
+	require.Nil(t, db.Match(nil))
+}
+
+func TestLoad(t *testing.T) {
+	body := []byte("<html>This site has been blocked</html>")
+	digest := sha256.Sum256(body)
+	path := filepath.Join(t.TempDir(), "blockpages.json")
+	config := fmt.Sprintf(`[
+		{"name": "exact", "vendor": "ExampleWall", "country": "ZZ", "sha256": "%s"},
+		{"name": "both", "body": "blocked", "header": "^Server: ExampleWall"}
+	]`, hex.EncodeToString(digest[:]))
+	require.Nil(t, os.WriteFile(path, []byte(config), 0644))
+
+	db, err := Load(path)
+	require.Nil(t, err)
+	require.Equal(t, 2, db.Len())
+
+	fp := db.Match(&Response{Body: body})
+	require.NotNil(t, fp)
+	require.Equal(t, "exact", fp.Name)
+	require.Equal(t, "ExampleWall", fp.Vendor)
+
+	// every pattern given has to match
+	other := []byte("<html>You have been blocked</html>")
+	require.Nil(t, db.Match(&Response{Body: other}))
+	fp = db.Match(&Response{
+		Header: http.Header{"Server": {"ExampleWall/1.0"}},
+		Body:   other,
+	})
+	require.NotNil(t, fp)
+	require.Equal(t, "both", fp.Name)
+
+	db, err = Load("")
+	require.Nil(t, err)
+	require.Equal(t, len(defaultFingerprints), db.Len())
+}
+
+func TestNewDBErrors(t *testing.T) {
+	_, err := NewDB([]*Fingerprint{{Vendor: "nameless", Body: "x"}})
+	require.True(t, errors.Is(err, ErrNoName))
+	_, err = NewDB([]*Fingerprint{{Name: "empty"}})
+	require.True(t, errors.Is(err, ErrNoPattern))
+	_, err = NewDB([]*Fingerprint{{Name: "broken", Body: "("}})
+	require.NotNil(t, err)
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "blocked.test" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "https://block.opendns.com/", http.StatusFound)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.Nil(t, err)
+
+	dialer := &net.Dialer{Timeout: time.Second}
+	resp, err := Fetch(dialer, net.ParseIP(host), port, "blocked.test")
+	require.Nil(t, err)
+	// the redirect isn't followed
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	fp := Default().Match(resp)
+	require.NotNil(t, fp)
+	require.Equal(t, "opendns-block", fp.Name)
+
+	resp, err = Fetch(dialer, net.ParseIP(host), port, "other.test")
+	require.Nil(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Nil(t, Default().Match(resp))
+}
@@ -0,0 +1,66 @@
+package blockpage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// MaxBody is the most of a response body read, blockpages are small.
+const MaxBody = 64 * 1024
+
+// Response is what an address served for the test domain over HTTP.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	// Body is the start of the response body, up to MaxBody bytes.
+	Body []byte
+}
+
+// Fetch requests / from ip on port with domain as the Host, connecting with
+// dialer. Redirects are not followed, since where a blockpage redirects to is
+// often what identifies it.
+func Fetch(dialer *net.Dialer, ip net.IP, port, domain string) (*Response, error) {
+	addr := net.JoinHostPort(ip.String(), port)
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				// always connect to the address being tested, whatever the
+				// Host is
+				return dialer.DialContext(ctx, network, addr)
+			},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: dialer.Timeout,
+	}
+	if client.Timeout == 0 {
+		client.Timeout = 10 * time.Second
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+domain+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxBody))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
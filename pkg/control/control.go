@@ -0,0 +1,240 @@
+// Package control keeps track of the control domains used to check that a
+// resolver answers honestly. Control domains are served by our own
+// authoritative servers, so the addresses (and optionally TTL and CNAME) a
+// resolver should return for them are known ahead of time.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidAddress is returned when a control domain config lists an
+	// address that can't be parsed, or lists it under the wrong record type.
+	ErrInvalidAddress = errors.New("invalid control domain address")
+
+	// ErrNoAddresses is returned when a control domain config lists no
+	// expected addresses at all for a domain.
+	ErrNoAddresses = errors.New("control domain has no expected addresses")
+)
+
+// Domain holds what our authoritative servers answer for a single control
+// domain.
+type Domain struct {
+	Name string   `json:"domain"`
+	A    []string `json:"a,omitempty"`
+	AAAA []string `json:"aaaa,omitempty"`
+	// TTL, if set, is the TTL handed out by the authoritative server. Resolvers
+	// count cached TTLs down, so answers may have a lower TTL but never higher.
+	TTL uint32 `json:"ttl,omitempty"`
+	// CNAME, if set, is the name the control domain is an alias for, and must
+	// appear in a resolver's answer.
+	CNAME string `json:"cname,omitempty"`
+
+	aSet    map[string]struct{}
+	aaaaSet map[string]struct{}
+}
+
+// Registry is the set of control domains, indexed by name.
+type Registry struct {
+	domains map[string]*Domain
+}
+
+// defaultDomains are the control domains served for the original v4vsv6
+// measurements.
+var defaultDomains = []*Domain{
+	{
+		Name: "v4vsv6.com",
+		A:    []string{"192.12.240.40"},
+		AAAA: []string{"2620:18f:30:4100::2"},
+	},
+	{
+		Name: "test1.v4vsv6.com",
+		A:    []string{"1.1.1.1"},
+		AAAA: []string{"1111:1111:1111:1111:1111:1111:1111:1111"},
+	},
+	{
+		Name: "test2.v4vsv6.com",
+		A:    []string{"2.2.2.2"},
+		AAAA: []string{"2222:2222:2222:2222:2222:2222:2222:2222"},
+	},
+}
+
+// normalizeName lower cases a domain and removes any trailing dot so names from
+// configs, ZDNS output and DNS messages all compare equal.
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// NewRegistry builds a Registry from a list of control domains, checking that
+// every listed address is valid for its record type.
+func NewRegistry(domains []*Domain) (*Registry, error) {
+	r := &Registry{domains: make(map[string]*Domain)}
+	for _, d := range domains {
+		d.Name = normalizeName(d.Name)
+		d.CNAME = normalizeName(d.CNAME)
+		if len(d.A) == 0 && len(d.AAAA) == 0 {
+			return nil, fmt.Errorf("%s: %w", d.Name, ErrNoAddresses)
+		}
+		d.aSet = make(map[string]struct{})
+		for _, a := range d.A {
+			ip := net.ParseIP(a)
+			if ip == nil || ip.To4() == nil {
+				return nil, fmt.Errorf("%s A %s: %w", d.Name, a, ErrInvalidAddress)
+			}
+			d.aSet[ip.String()] = struct{}{}
+		}
+		d.aaaaSet = make(map[string]struct{})
+		for _, aaaa := range d.AAAA {
+			ip := net.ParseIP(aaaa)
+			if ip == nil || ip.To4() != nil {
+				return nil, fmt.Errorf("%s AAAA %s: %w", d.Name, aaaa, ErrInvalidAddress)
+			}
+			d.aaaaSet[ip.String()] = struct{}{}
+		}
+		r.domains[d.Name] = d
+	}
+
+	return r, nil
+}
+
+// Default returns a Registry of the v4vsv6.com, test1.v4vsv6.com and
+// test2.v4vsv6.com control domains.
+func Default() *Registry {
+	domains := make([]*Domain, len(defaultDomains))
+	for i, d := range defaultDomains {
+		copied := *d
+		domains[i] = &copied
+	}
+	r, err := NewRegistry(domains)
+	if err != nil {
+		// the defaults are hardcoded, so this can only be a programming error
+		panic(err)
+	}
+
+	return r
+}
+
+// Load reads a JSON control domain config, a list of objects like:
+//
+//	[{"domain": "v4vsv6.com", "a": ["192.12.240.40"], "aaaa": ["2620:18f:30:4100::2"], "ttl": 300}]
+//
+// If path is empty the Default registry is returned.
+func Load(path string) (*Registry, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var domains []*Domain
+	if err = json.Unmarshal(bs, &domains); err != nil {
+		return nil, fmt.Errorf("error parsing control domain config %s: %w", path, err)
+	}
+
+	return NewRegistry(domains)
+}
+
+// Lookup returns the control Domain for name, or nil if name isn't a control
+// domain.
+func (r *Registry) Lookup(name string) *Domain {
+	return r.domains[normalizeName(name)]
+}
+
+// IsControlDomain returns true if name is a control domain.
+func (r *Registry) IsControlDomain(name string) bool {
+	return r.Lookup(name) != nil
+}
+
+// Len returns the number of control domains.
+func (r *Registry) Len() int {
+	return len(r.domains)
+}
+
+// ExpectedAnswers returns how many correct control answers a resolver that
+// answers honestly gives: one for each record type, A or AAAA, that a control
+// domain lists addresses for.
+func (r *Registry) ExpectedAnswers() int {
+	n := 0
+	for _, d := range r.domains {
+		if len(d.A) > 0 {
+			n++
+		}
+		if len(d.AAAA) > 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Names returns the sorted names of every control domain.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.domains))
+	for name := range r.domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ValidAddress returns true if ip is one of the addresses expected for name
+// when requesting addressType ("A" or "AAAA") records. Returns false if name is
+// not a control domain.
+func (r *Registry) ValidAddress(name, addressType string, ip net.IP) bool {
+	d := r.Lookup(name)
+	if d == nil {
+		return false
+	}
+
+	return d.ValidAddress(addressType, ip)
+}
+
+// ValidAddress returns true if ip is one of the addresses expected when
+// requesting addressType ("A" or "AAAA") records.
+func (d *Domain) ValidAddress(addressType string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	var set map[string]struct{}
+	switch addressType {
+	case "A":
+		set = d.aSet
+	case "AAAA":
+		set = d.aaaaSet
+	default:
+		return false
+	}
+	_, ok := set[ip.String()]
+
+	return ok
+}
+
+// ValidTTL returns true if no TTL is expected or ttl doesn't exceed it.
+func (d *Domain) ValidTTL(ttl uint32) bool {
+	return d.TTL == 0 || ttl <= d.TTL
+}
+
+// ValidCNAME returns true if no CNAME is expected, or one of cnames is the
+// expected one.
+func (d *Domain) ValidCNAME(cnames []string) bool {
+	if d.CNAME == "" {
+		return true
+	}
+	for _, cname := range cnames {
+		if normalizeName(cname) == d.CNAME {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+package control
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefault(t *testing.T) {
+	r := Default()
+	require.Equal(t, 3, r.Len())
+	require.Equal(t, 6, r.ExpectedAnswers())
+	require.True(t, r.IsControlDomain("V4vsV6.com."))
+	require.False(t, r.IsControlDomain("example.com"))
+
+	require.True(t, r.ValidAddress("test1.v4vsv6.com", "A", net.ParseIP("1.1.1.1")))
+	require.False(t, r.ValidAddress("test1.v4vsv6.com", "AAAA", net.ParseIP("1.1.1.1")))
+	require.False(t, r.ValidAddress("test1.v4vsv6.com", "A", net.ParseIP("2.2.2.2")))
+	require.True(t, r.ValidAddress("test2.v4vsv6.com", "AAAA", net.ParseIP("2222:2222:2222:2222:2222:2222:2222:2222")))
+	require.False(t, r.ValidAddress("example.com", "A", net.ParseIP("1.1.1.1")))
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.json")
+	config := `[{"domain": "control.example.org", "a": ["192.0.2.1", "192.0.2.2"], "ttl": 300, "cname": "cdn.example.org."}]`
+	require.Nil(t, os.WriteFile(path, []byte(config), 0644))
+
+	r, err := Load(path)
+	require.Nil(t, err)
+	require.Equal(t, []string{"control.example.org"}, r.Names())
+	require.Equal(t, 1, r.ExpectedAnswers())
+	d := r.Lookup("control.example.org")
+	require.True(t, d.ValidAddress("A", net.ParseIP("192.0.2.2")))
+	require.True(t, d.ValidTTL(120))
+	require.False(t, d.ValidTTL(3600))
+	require.True(t, d.ValidCNAME([]string{"CDN.example.org"}))
+	require.False(t, d.ValidCNAME(nil))
+
+	config = `[{"domain": "control.example.org", "aaaa": ["192.0.2.1"]}]`
+	require.Nil(t, os.WriteFile(path, []byte(config), 0644))
+	_, err = Load(path)
+	require.True(t, errors.Is(err, ErrInvalidAddress))
+}
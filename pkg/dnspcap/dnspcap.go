@@ -0,0 +1,49 @@
+// Package dnspcap reads DNS traffic out of packet captures, pairing each
+// response with the query it answers, so captures taken during a run can be
+// analysed offline the same way as live traffic.
+package dnspcap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/timartiny/v4vsv6/pkg/results"
+)
+
+// pcapNGMagic is the first four bytes of a PCAPNG file, the section header
+// block type
+var pcapNGMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// Open opens a (possibly compressed) PCAP or PCAPNG file. The returned closer
+// closes the file once the packets have been read.
+func Open(path string) (*gopacket.PacketSource, io.Closer, error) {
+	f, err := results.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(4)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	if bytes.Equal(magic, pcapNGMagic) {
+		ngReader, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return gopacket.NewPacketSource(ngReader, ngReader.LinkType()), f, nil
+	}
+	reader, err := pcapgo.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return gopacket.NewPacketSource(reader, reader.LinkType()), f, nil
+}
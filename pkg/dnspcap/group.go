@@ -0,0 +1,167 @@
+package dnspcap
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons a Group of responses is flagged as injected
+const (
+	// ReasonAnswers is when responses to the query have different answers or
+	// rcodes
+	ReasonAnswers = "answers-differ"
+	// ReasonTTL is when responses arrived with different IP TTLs, so they
+	// came different distances
+	ReasonTTL = "ttl-differs"
+	// ReasonFlags is when responses have different DNS header flags
+	ReasonFlags = "flags-differ"
+)
+
+// Group is every response seen to one query, in the order they arrived.
+type Group struct {
+	Resolver   string    `json:"resolver"`
+	Name       string    `json:"name"`
+	Record     string    `json:"record"`
+	SourceIP   string    `json:"source_ip,omitempty"`
+	SourcePort uint16    `json:"source_port,omitempty"`
+	ID         uint16    `json:"id"`
+	Sent       string    `json:"sent,omitempty"`
+	Responses  []*Result `json:"responses"`
+	// Injected is set when the responses disagree in a way a resolver
+	// answering twice wouldn't, the signature of an on-path injector racing
+	// the real response
+	Injected bool     `json:"injected"`
+	Reasons  []string `json:"reasons,omitempty"`
+}
+
+// answerSet returns a comparable summary of a response's rcode and answers,
+// ignoring their order and TTLs.
+func answerSet(r *Result) string {
+	var answers []string
+	for _, a := range r.Answers {
+		answers = append(answers, a.Type+" "+strings.ToLower(a.Name)+" "+a.Answer)
+	}
+	sort.Strings(answers)
+
+	return r.Status + ": " + strings.Join(answers, ", ")
+}
+
+// detect decides whether the group's responses look injected. The last
+// response is taken to be the resolver's, since injectors sit closer to us
+// and race it; earlier responses that disagree with it are marked Forged.
+func (g *Group) detect() {
+	g.Injected, g.Reasons = false, nil
+	if len(g.Responses) < 2 {
+		return
+	}
+	last := g.Responses[len(g.Responses)-1]
+	reasons := make(map[string]bool)
+	for _, r := range g.Responses[:len(g.Responses)-1] {
+		r.Forged = false
+		if answerSet(r) != answerSet(last) || r.Error != last.Error {
+			reasons[ReasonAnswers] = true
+			r.Forged = true
+		}
+		if r.TTL != last.TTL {
+			reasons[ReasonTTL] = true
+			r.Forged = true
+		}
+		if (r.Flags == nil) != (last.Flags == nil) ||
+			(r.Flags != nil && *r.Flags != *last.Flags) {
+			reasons[ReasonFlags] = true
+			r.Forged = true
+		}
+	}
+	for _, reason := range []string{ReasonAnswers, ReasonTTL, ReasonFlags} {
+		if reasons[reason] {
+			g.Reasons = append(g.Reasons, reason)
+		}
+	}
+	g.Injected = len(g.Reasons) > 0
+}
+
+type groupKey struct {
+	key   flowKey
+	first time.Time
+}
+
+// Grouper collects the Results from a Matcher into a Group per query. A group
+// is complete Window after its first response, as no resolver takes that
+// long to answer twice. A Grouper is not safe for concurrent use.
+type Grouper struct {
+	Window time.Duration
+	groups map[flowKey]*Group
+	// queue holds group keys in the order their first response arrived
+	queue []groupKey
+}
+
+// NewGrouper returns a Grouper that completes groups window after their first
+// response, or only on Flush if window is 0.
+func NewGrouper(window time.Duration) *Grouper {
+	return &Grouper{
+		Window: window,
+		groups: make(map[flowKey]*Group),
+	}
+}
+
+// Add adds r to the group of its query, and returns the groups completed
+// since the last call.
+func (g *Grouper) Add(r *Result) []*Group {
+	done := g.expire(r.at)
+	group, ok := g.groups[r.key]
+	if !ok {
+		group = &Group{
+			Resolver:   r.Resolver,
+			SourceIP:   r.SourceIP,
+			SourcePort: r.SourcePort,
+			ID:         r.ID,
+		}
+		g.groups[r.key] = group
+		g.queue = append(g.queue, groupKey{r.key, r.at})
+	}
+	if group.Name == "" {
+		group.Name, group.Record = r.Name, r.Record
+	}
+	if group.Sent == "" {
+		group.Sent = r.Sent
+	}
+	group.Responses = append(group.Responses, r)
+
+	return done
+}
+
+// expire completes the groups whose first response was more than Window
+// before now.
+func (g *Grouper) expire(now time.Time) []*Group {
+	if g.Window == 0 {
+		return nil
+	}
+	var done []*Group
+	var i int
+	for ; i < len(g.queue) && now.Sub(g.queue[i].first) > g.Window; i++ {
+		if group, ok := g.groups[g.queue[i].key]; ok {
+			group.detect()
+			done = append(done, group)
+			delete(g.groups, g.queue[i].key)
+		}
+	}
+	g.queue = g.queue[i:]
+
+	return done
+}
+
+// Flush completes every group, in the order their first response arrived.
+func (g *Grouper) Flush() []*Group {
+	var done []*Group
+	for _, q := range g.queue {
+		if group, ok := g.groups[q.key]; ok {
+			group.detect()
+			done = append(done, group)
+			delete(g.groups, q.key)
+		}
+	}
+	g.queue = nil
+
+	return done
+}
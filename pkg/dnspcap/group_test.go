@@ -0,0 +1,74 @@
+package dnspcap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGrouper feeds a capture with an injected response, a resolver that
+// answers twice and a single response through a Matcher and Grouper
+func TestGrouper(t *testing.T) {
+	forged := response(t, 2, "blocked.test", "10.10.34.34")
+	// injectors tend to set flags a resolver wouldn't
+	forged[2] |= 0x04 // AA
+	packets := []struct {
+		p   testPacket
+		ttl uint8
+	}{
+		{testPacket{0, client4, resolver, 40000, 53, query(t, 1, "example.com")}, 64},
+		{testPacket{10 * time.Millisecond, resolver, client4, 53, 40000, response(t, 1, "example.com", "93.184.216.34")}, 52},
+		{testPacket{20 * time.Millisecond, client4, resolver, 40001, 53, query(t, 2, "blocked.test")}, 64},
+		// the forged response is fast and comes from closer
+		{testPacket{22 * time.Millisecond, resolver, client4, 53, 40001, forged}, 61},
+		{testPacket{90 * time.Millisecond, resolver, client4, 53, 40001, response(t, 2, "blocked.test", "203.0.113.7")}, 52},
+		// a resolver retransmitting its answer isn't injection
+		{testPacket{100 * time.Millisecond, client4, resolver, 40002, 53, query(t, 3, "twice.test")}, 64},
+		{testPacket{110 * time.Millisecond, resolver, client4, 53, 40002, response(t, 3, "twice.test", "192.0.2.7")}, 52},
+		{testPacket{120 * time.Millisecond, resolver, client4, 53, 40002, response(t, 3, "twice.test", "192.0.2.7")}, 52},
+		// completes the groups above
+		{testPacket{time.Minute, resolver, client4, 53, 40003, response(t, 4, "late.test", "")}, 52},
+	}
+
+	m := NewMatcher(DefaultWindow)
+	g := NewGrouper(DefaultWindow)
+	var groups []*Group
+	for _, tp := range packets {
+		packet := gopacket.NewPacket(serializeTTL(t, tp.p, tp.ttl), layers.LinkTypeEthernet, gopacket.Default)
+		packet.Metadata().Timestamp = start.Add(tp.p.at)
+		if r := m.Packet(packet); r != nil {
+			groups = append(groups, g.Add(r)...)
+		}
+	}
+	require.Len(t, groups, 3)
+	groups = append(groups, g.Flush()...)
+	require.Len(t, groups, 4)
+
+	require.Equal(t, "example.com", groups[0].Name)
+	require.Len(t, groups[0].Responses, 1)
+	require.False(t, groups[0].Injected)
+
+	injected := groups[1]
+	require.Equal(t, "blocked.test", injected.Name)
+	require.Equal(t, "198.51.100.53", injected.Resolver)
+	require.Equal(t, uint16(40001), injected.SourcePort)
+	require.NotEmpty(t, injected.Sent)
+	require.True(t, injected.Injected)
+	require.Equal(t, []string{ReasonAnswers, ReasonTTL, ReasonFlags}, injected.Reasons)
+	require.Len(t, injected.Responses, 2)
+	require.True(t, injected.Responses[0].Forged)
+	require.Equal(t, uint8(61), injected.Responses[0].TTL)
+	require.InDelta(t, 2.0, injected.Responses[0].RTTMillis, 0.001)
+	require.False(t, injected.Responses[1].Forged)
+	require.Equal(t, 2, injected.Responses[1].Responses)
+
+	require.Len(t, groups[2].Responses, 2)
+	require.False(t, groups[2].Injected)
+	require.Empty(t, groups[2].Reasons)
+
+	require.Equal(t, "late.test", groups[3].Name)
+	require.False(t, groups[3].Responses[0].Matched)
+}
@@ -0,0 +1,254 @@
+package dnspcap
+
+import (
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"github.com/timartiny/v4vsv6/pkg/zdns"
+)
+
+// DefaultWindow is how long a Matcher waits for the response to a query by
+// default, in capture time.
+const DefaultWindow = 30 * time.Second
+
+// Result records a DNS response seen in a capture, and the query it answers if
+// that was captured too. The fields follow probe's JSONL results.
+type Result struct {
+	Resolver    string        `json:"resolver"`
+	Name        string        `json:"name"`
+	Record      string        `json:"record"`
+	SourceIP    string        `json:"source_ip,omitempty"`
+	SourcePort  uint16        `json:"source_port,omitempty"`
+	ID          uint16        `json:"id"`
+	Sent        string        `json:"sent,omitempty"`
+	Received    string        `json:"received,omitempty"`
+	RTTMillis   float64       `json:"rtt_ms,omitempty"`
+	RCode       int           `json:"rcode"`
+	Status      string        `json:"status,omitempty"`
+	Flags       *zdns.Flags   `json:"flags,omitempty"`
+	Answers     []zdns.Answer `json:"answers,omitempty"`
+	Authorities []zdns.Answer `json:"authorities,omitempty"`
+	Additionals []zdns.Answer `json:"additionals,omitempty"`
+	Query       string        `json:"query,omitempty"`
+	Response    string        `json:"response,omitempty"`
+	// Matched is whether the query this answers was captured
+	Matched bool `json:"matched"`
+	// Responses counts the responses seen to the same query so far,
+	// including this one. More than one usually means a response was
+	// injected.
+	Responses int `json:"responses,omitempty"`
+	// TTL is the IP TTL (or IPv6 hop limit) the response arrived with, and
+	// IPID its IPv4 ID. Injectors rarely get these to match the resolver's.
+	TTL  uint8  `json:"ttl"`
+	IPID uint16 `json:"ip_id,omitempty"`
+	// Forged is set by a Group on responses that look injected
+	Forged bool `json:"forged,omitempty"`
+	// Error is set when the response couldn't be parsed
+	Error string `json:"error,omitempty"`
+
+	key flowKey
+	at  time.Time
+}
+
+// Stats counts what a Matcher has seen.
+type Stats struct {
+	Packets    int
+	Queries    int
+	Responses  int
+	Matched    int
+	Unanswered int
+}
+
+// flowKey identifies a query by its 5-tuple and DNS ID. The protocol is always
+// UDP.
+type flowKey struct {
+	client string
+	server string
+	id     uint16
+}
+
+type pendingQuery struct {
+	sent      time.Time
+	query     []byte
+	msg       *dns.Msg
+	responses int
+}
+
+type queuedKey struct {
+	key  flowKey
+	sent time.Time
+}
+
+// Matcher pairs DNS responses with the queries they answer as packets are
+// fed to it in capture order. A query is forgotten Window after it was sent,
+// so memory stays bounded on long captures; a response after that is
+// reported unmatched. A Matcher is not safe for concurrent use.
+type Matcher struct {
+	Window  time.Duration
+	pending map[flowKey]*pendingQuery
+	// queue holds pending keys in the order they were sent, for expiry
+	queue []queuedKey
+	stats Stats
+}
+
+// NewMatcher returns a Matcher that waits window for each response, or
+// forever if window is 0.
+func NewMatcher(window time.Duration) *Matcher {
+	return &Matcher{
+		Window:  window,
+		pending: make(map[flowKey]*pendingQuery),
+	}
+}
+
+// Stats returns the counts so far.
+func (m *Matcher) Stats() Stats {
+	return m.stats
+}
+
+// expire forgets the queries sent more than Window before now.
+func (m *Matcher) expire(now time.Time) {
+	if m.Window == 0 {
+		return
+	}
+	var i int
+	for ; i < len(m.queue) && now.Sub(m.queue[i].sent) > m.Window; i++ {
+		q := m.queue[i]
+		// a retransmission with the same key replaces the query
+		if pq, ok := m.pending[q.key]; ok && pq.sent.Equal(q.sent) {
+			if pq.responses == 0 {
+				m.stats.Unanswered++
+			}
+			delete(m.pending, q.key)
+		}
+	}
+	m.queue = m.queue[i:]
+}
+
+// Flush forgets every pending query, counting those never answered.
+func (m *Matcher) Flush() {
+	for key, pq := range m.pending {
+		if pq.responses == 0 {
+			m.stats.Unanswered++
+		}
+		delete(m.pending, key)
+	}
+	m.queue = nil
+}
+
+func hostPort(ip net.IP, port layers.UDPPort) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// Packet processes the next packet of a capture. It returns a Result for DNS
+// responses, and nil for everything else.
+func (m *Matcher) Packet(packet gopacket.Packet) *Result {
+	m.stats.Packets++
+	var src, dst net.IP
+	var ttl uint8
+	var ipID uint16
+	switch network := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		src, dst = network.SrcIP, network.DstIP
+		ttl, ipID = network.TTL, network.Id
+	case *layers.IPv6:
+		src, dst = network.SrcIP, network.DstIP
+		ttl = network.HopLimit
+	default:
+		return nil
+	}
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok {
+		return nil
+	}
+	now := packet.Metadata().Timestamp
+	m.expire(now)
+
+	msg := new(dns.Msg)
+	err := msg.Unpack(udp.Payload)
+	switch {
+	case udp.DstPort == 53 && err == nil && !msg.Response:
+		m.stats.Queries++
+		key := flowKey{hostPort(src, udp.SrcPort), hostPort(dst, udp.DstPort), msg.Id}
+		m.pending[key] = &pendingQuery{
+			sent:  now,
+			query: append([]byte(nil), udp.Payload...),
+			msg:   msg,
+		}
+		m.queue = append(m.queue, queuedKey{key, now})
+		return nil
+	case udp.SrcPort != 53 || (err == nil && !msg.Response):
+		return nil
+	}
+
+	m.stats.Responses++
+	ret := &Result{
+		Resolver:   src.String(),
+		SourceIP:   dst.String(),
+		SourcePort: uint16(udp.DstPort),
+		RCode:      -1,
+		Response:   hex.EncodeToString(udp.Payload),
+		TTL:        ttl,
+		IPID:       ipID,
+		at:         now,
+	}
+	if !now.IsZero() {
+		ret.Received = now.Format(time.RFC3339Nano)
+	}
+	if len(udp.Payload) >= 2 {
+		ret.ID = uint16(udp.Payload[0])<<8 | uint16(udp.Payload[1])
+	}
+	question := msg.Question
+	ret.key = flowKey{hostPort(dst, udp.DstPort), hostPort(src, udp.SrcPort), ret.ID}
+	if pq, ok := m.pending[ret.key]; ok {
+		m.stats.Matched++
+		pq.responses++
+		ret.Matched = true
+		ret.Responses = pq.responses
+		ret.Query = hex.EncodeToString(pq.query)
+		ret.Sent = pq.sent.Format(time.RFC3339Nano)
+		ret.RTTMillis = float64(now.Sub(pq.sent)) / float64(time.Millisecond)
+		// the question asked, in case the response's was mangled
+		question = pq.msg.Question
+	}
+	if len(question) > 0 {
+		ret.Name = strings.TrimSuffix(question[0].Name, ".")
+		ret.Record = dns.TypeToString[question[0].Qtype]
+	}
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.RCode = msg.Rcode
+	ret.Status = dns.RcodeToString[msg.Rcode]
+	ret.Flags = zdns.FlagsFromMsg(msg)
+	ret.Answers = zdns.AnswersFromRRs(msg.Answer)
+	ret.Authorities = zdns.AnswersFromRRs(msg.Ns)
+	ret.Additionals = zdns.AnswersFromRRs(msg.Extra)
+
+	return ret
+}
+
+// ReadFile feeds every packet of the capture at path to m, calling emit with
+// each Result.
+func (m *Matcher) ReadFile(path string, emit func(*Result)) error {
+	packetSource, closer, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	packetSource.Lazy = true
+
+	for packet := range packetSource.Packets() {
+		if result := m.Packet(packet); result != nil {
+			emit(result)
+		}
+	}
+
+	return nil
+}
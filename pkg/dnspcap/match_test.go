@@ -0,0 +1,204 @@
+package dnspcap
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	client4  = net.ParseIP("192.0.2.1").To4()
+	resolver = net.ParseIP("198.51.100.53").To4()
+	client6  = net.ParseIP("2001:db8::1")
+	server6  = net.ParseIP("2001:db8::53")
+	start    = time.Date(2021, 5, 11, 12, 0, 0, 0, time.UTC)
+)
+
+type testPacket struct {
+	at       time.Duration
+	src, dst net.IP
+	sport    uint16
+	dport    uint16
+	payload  []byte
+}
+
+func query(t *testing.T, id uint16, name string) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	m.Id = id
+	bs, err := m.Pack()
+	require.NoError(t, err)
+
+	return bs
+}
+
+func response(t *testing.T, id uint16, name, answer string) []byte {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	m.Id = id
+	if answer != "" {
+		rr, err := dns.NewRR(dns.Fqdn(name) + " 60 IN A " + answer)
+		require.NoError(t, err)
+		m.Answer = append(m.Answer, rr)
+	}
+	bs, err := m.Pack()
+	require.NoError(t, err)
+
+	return bs
+}
+
+func serialize(t *testing.T, p testPacket) []byte {
+	return serializeTTL(t, p, 64)
+}
+
+// serializeTTL builds an Ethernet frame for p, sent with the IP TTL ttl
+func serializeTTL(t *testing.T, p testPacket, ttl uint8) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2},
+	}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(p.sport), DstPort: layers.UDPPort(p.dport)}
+	var network gopacket.SerializableLayer
+	if p.src.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip := &layers.IPv4{Version: 4, TTL: ttl, Protocol: layers.IPProtocolUDP, SrcIP: p.src, DstIP: p.dst}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+		network = ip
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip := &layers.IPv6{Version: 6, HopLimit: ttl, NextHeader: layers.IPProtocolUDP, SrcIP: p.src, DstIP: p.dst}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+		network = ip
+	}
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(
+		buf,
+		gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		eth, network, udp, gopacket.Payload(p.payload),
+	)
+	require.NoError(t, err)
+
+	return buf.Bytes()
+}
+
+// writePcap writes packets to a PCAP file, or a PCAPNG one if ng is set
+func writePcap(t *testing.T, path string, ng bool, packets []testPacket) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var write func(gopacket.CaptureInfo, []byte) error
+	if ng {
+		w, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, w.Flush()) }()
+		write = w.WritePacket
+	} else {
+		w := pcapgo.NewWriter(f)
+		require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeEthernet))
+		write = w.WritePacket
+	}
+	for _, p := range packets {
+		data := serialize(t, p)
+		require.NoError(t, write(gopacket.CaptureInfo{
+			Timestamp:     start.Add(p.at),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}, data))
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	packets := []testPacket{
+		// answered once
+		{0, client4, resolver, 40000, 53, query(t, 1, "example.com")},
+		{20 * time.Millisecond, resolver, client4, 53, 40000, response(t, 1, "example.com", "93.184.216.34")},
+		// answered twice, as when a response is injected
+		{30 * time.Millisecond, client4, resolver, 40001, 53, query(t, 2, "blocked.test")},
+		{35 * time.Millisecond, resolver, client4, 53, 40001, response(t, 2, "blocked.test", "10.10.34.34")},
+		{60 * time.Millisecond, resolver, client4, 53, 40001, response(t, 2, "blocked.test", "203.0.113.7")},
+		// same ID on another port doesn't match
+		{70 * time.Millisecond, client4, resolver, 40002, 53, query(t, 3, "other.test")},
+		{80 * time.Millisecond, resolver, client4, 53, 40003, response(t, 3, "other.test", "")},
+		// answered after the window
+		{time.Second, client6, server6, 40004, 53, query(t, 4, "late.test")},
+		{time.Minute, server6, client6, 53, 40004, response(t, 4, "late.test", "192.0.2.99")},
+		// not DNS
+		{time.Minute, client4, resolver, 40005, 54, []byte("hello")},
+		// garbage from port 53
+		{time.Minute, resolver, client4, 53, 40006, []byte{0, 7, 0x81}},
+		// never answered
+		{time.Minute, client6, server6, 40007, 53, query(t, 8, "quiet.test")},
+	}
+
+	for _, ng := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "capture.pcap")
+		writePcap(t, path, ng, packets)
+
+		m := NewMatcher(DefaultWindow)
+		var got []*Result
+		require.NoError(t, m.ReadFile(path, func(r *Result) {
+			got = append(got, r)
+		}))
+		m.Flush()
+		require.Len(t, got, 6)
+
+		require.True(t, got[0].Matched)
+		require.Equal(t, 1, got[0].Responses)
+		require.Equal(t, "198.51.100.53", got[0].Resolver)
+		require.Equal(t, "192.0.2.1", got[0].SourceIP)
+		require.Equal(t, uint16(40000), got[0].SourcePort)
+		require.Equal(t, "example.com", got[0].Name)
+		require.Equal(t, "A", got[0].Record)
+		require.Equal(t, "NOERROR", got[0].Status)
+		require.Equal(t, "93.184.216.34", got[0].Answers[0].Answer)
+		require.InDelta(t, 20.0, got[0].RTTMillis, 0.001)
+		require.NotEmpty(t, got[0].Query)
+
+		require.True(t, got[1].Matched)
+		require.Equal(t, 1, got[1].Responses)
+		require.True(t, got[2].Matched)
+		require.Equal(t, 2, got[2].Responses)
+		require.Equal(t, "203.0.113.7", got[2].Answers[0].Answer)
+
+		require.False(t, got[3].Matched)
+		require.Equal(t, "other.test", got[3].Name)
+		require.Empty(t, got[3].Query)
+
+		require.False(t, got[4].Matched)
+		require.Equal(t, "2001:db8::53", got[4].Resolver)
+
+		require.False(t, got[5].Matched)
+		require.NotEmpty(t, got[5].Error)
+		require.Equal(t, -1, got[5].RCode)
+		require.Equal(t, uint16(7), got[5].ID)
+
+		require.Equal(t, Stats{
+			Packets:    len(packets),
+			Queries:    5,
+			Responses:  6,
+			Matched:    3,
+			Unanswered: 3,
+		}, m.Stats())
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	_, _, err := Open(filepath.Join(t.TempDir(), "missing.pcap"))
+	require.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "bogus.pcap")
+	require.NoError(t, os.WriteFile(path, []byte("not a capture"), 0644))
+	_, _, err = Open(path)
+	require.Error(t, err)
+}
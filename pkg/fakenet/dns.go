@@ -0,0 +1,267 @@
+package fakenet
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Query is a query received by an Authoritative server.
+type Query struct {
+	Source net.IP
+	Name   string
+	Type   uint16
+}
+
+// Authoritative is a name server answering from a Zone and recording every
+// query it receives, playing the part of our name servers.
+type Authoritative struct {
+	*dnsServer
+	zone    Zone
+	mutex   sync.Mutex
+	queries []Query
+}
+
+// NewAuthoritative starts a name server answering from zone.
+func NewAuthoritative(zone Zone) (*Authoritative, error) {
+	a := &Authoritative{zone: zone}
+	var err error
+	a.dnsServer, err = startDNS(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Queries returns every query received so far.
+func (a *Authoritative) Queries() []Query {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return append([]Query(nil), a.queries...)
+}
+
+// ServeDNS implements dns.Handler.
+func (a *Authoritative) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
+	if len(query.Question) != 1 {
+		return
+	}
+	q := query.Question[0]
+	source, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+	a.mutex.Lock()
+	a.queries = append(a.queries, Query{
+		Source: net.ParseIP(source),
+		Name:   q.Name,
+		Type:   q.Qtype,
+	})
+	a.mutex.Unlock()
+
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.Authoritative = true
+	records, ok := a.zone.lookup(q.Name)
+	if !ok {
+		resp.Rcode = dns.RcodeNameError
+	}
+	resp.Answer = answers(q, records)
+	w.WriteMsg(resp)
+}
+
+// answers returns the records of type q.Qtype as answers to q.
+func answers(q dns.Question, records Records) []dns.RR {
+	var ret []dns.RR
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 300}
+	switch q.Qtype {
+	case dns.TypeA:
+		for _, ip := range records.A {
+			ret = append(ret, &dns.A{Hdr: hdr, A: ip.To4()})
+		}
+	case dns.TypeAAAA:
+		for _, ip := range records.AAAA {
+			ret = append(ret, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	return ret
+}
+
+// Action is what a censor does to a query.
+type Action int
+
+const (
+	// Pass answers the query honestly.
+	Pass Action = iota
+	// NXDomain answers that the domain doesn't exist.
+	NXDomain
+	// Bogus answers A and AAAA queries with the Rule's Bogus addresses.
+	Bogus
+	// FilterAAAA answers AAAA queries with no records, leaving A queries
+	// alone.
+	FilterAAAA
+	// Drop never answers.
+	Drop
+)
+
+// Rule censors queries for a domain, and its subdomains, arriving over one or
+// both address families.
+type Rule struct {
+	Domain string
+	// Family is 4 or 6 to only censor queries sent to the resolver's IPv4 or
+	// IPv6 address, 0 for both.
+	Family int
+	Action Action
+	// Bogus are the addresses given by the Bogus action, of either family.
+	Bogus []net.IP
+	// Inject sends the honest answer after the censored one, the way an on
+	// path injector racing the real resolver would.
+	Inject bool
+}
+
+func (r Rule) matches(name string, fam int) bool {
+	if r.Family != 0 && r.Family != fam {
+		return false
+	}
+	domain := normalize(r.Domain)
+	name = normalize(name)
+
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// ResolverConfig describes a Resolver.
+type ResolverConfig struct {
+	// Upstream is the name server recursive queries are forwarded to.
+	Upstream *Authoritative
+	// ForwardFamily is 4 or 6 to always forward queries to Upstream over that
+	// address family, 0 to forward over the family the query arrived on.
+	ForwardFamily int
+	// Rules are the censors between the client and the resolver, the first
+	// rule matching a query applies.
+	Rules []Rule
+}
+
+// Resolver is a recursive resolver forwarding to an Authoritative server,
+// subject to censorship Rules. Queries without the RD bit set are answered
+// only from the resolver's cache.
+type Resolver struct {
+	*dnsServer
+	cfg   ResolverConfig
+	mutex sync.Mutex
+	cache map[dns.Question]*dns.Msg
+}
+
+// NewResolver starts a resolver.
+func NewResolver(cfg ResolverConfig) (*Resolver, error) {
+	r := &Resolver{cfg: cfg, cache: make(map[dns.Question]*dns.Msg)}
+	var err error
+	r.dnsServer, err = startDNS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// ServeDNS implements dns.Handler.
+func (r *Resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
+	if len(query.Question) != 1 {
+		return
+	}
+	q := query.Question[0]
+	fam := family(w.LocalAddr())
+	for _, rule := range r.cfg.Rules {
+		if !rule.matches(q.Name, fam) {
+			continue
+		}
+		if rule.Action == Drop {
+			return
+		}
+		if rule.Action == FilterAAAA && q.Qtype != dns.TypeAAAA {
+			break
+		}
+		w.WriteMsg(censored(query, rule))
+		if !rule.Inject {
+			return
+		}
+		break
+	}
+
+	resp := r.resolve(query, fam)
+	if resp == nil {
+		return
+	}
+	w.WriteMsg(resp)
+}
+
+// censored returns the response rule gives to query.
+func censored(query *dns.Msg, rule Rule) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.RecursionAvailable = true
+	switch rule.Action {
+	case NXDomain:
+		resp.Rcode = dns.RcodeNameError
+	case Bogus:
+		var records Records
+		for _, ip := range rule.Bogus {
+			if ip.To4() != nil {
+				records.A = append(records.A, ip)
+			} else {
+				records.AAAA = append(records.AAAA, ip)
+			}
+		}
+		resp.Answer = answers(query.Question[0], records)
+	}
+
+	return resp
+}
+
+// resolve answers query from the cache, or from Upstream for recursive
+// queries, returning nil if Upstream didn't answer.
+func (r *Resolver) resolve(query *dns.Msg, fam int) *dns.Msg {
+	q := query.Question[0]
+	key := dns.Question{Name: normalize(q.Name), Qtype: q.Qtype, Qclass: q.Qclass}
+	r.mutex.Lock()
+	cached := r.cache[key]
+	r.mutex.Unlock()
+
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.RecursionAvailable = true
+	switch {
+	case cached != nil:
+		resp.Rcode = cached.Rcode
+		resp.Answer = cached.Answer
+	case query.RecursionDesired && r.cfg.Upstream != nil:
+		if r.cfg.ForwardFamily != 0 {
+			fam = r.cfg.ForwardFamily
+		}
+		source := V4
+		if fam == 6 {
+			source = V6
+		}
+		client := &dns.Client{
+			Timeout: 2 * time.Second,
+			Dialer: &net.Dialer{
+				LocalAddr: &net.UDPAddr{IP: source},
+			},
+		}
+		upstream, _, err := client.Exchange(
+			query.Copy(),
+			r.cfg.Upstream.Addr(source),
+		)
+		if err != nil {
+			return nil
+		}
+		r.mutex.Lock()
+		r.cache[key] = upstream
+		r.mutex.Unlock()
+		resp.Rcode = upstream.Rcode
+		resp.Answer = upstream.Answer
+	}
+
+	return resp
+}
@@ -0,0 +1,168 @@
+// Package fakenet runs fake DNS resolvers, authoritative name servers and TLS
+// servers on the loopback addresses, so the measurement pipeline can be tested
+// end to end without touching the network.
+//
+// Every server listens on both 127.0.0.1 and ::1 on the same port, so a single
+// server looks like a resolver (or web server) reachable over both IPv4 and
+// IPv6, and can be told to censor queries arriving over just one of them.
+package fakenet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// V4 and V6 are the addresses every server listens on.
+	V4 = net.IPv4(127, 0, 0, 1).To4()
+	V6 = net.IPv6loopback
+
+	// ErrNoPort is returned when no port free on both loopback addresses
+	// could be found.
+	ErrNoPort = errors.New("no port free on both 127.0.0.1 and ::1")
+)
+
+// Records are the addresses a domain resolves to.
+type Records struct {
+	A    []net.IP
+	AAAA []net.IP
+}
+
+// Zone maps domain names to their records. A name starting with "*." also
+// matches every subdomain that isn't itself in the zone.
+type Zone map[string]Records
+
+// lookup returns the records of name, and whether it exists at all.
+func (z Zone) lookup(name string) (Records, bool) {
+	name = normalize(name)
+	for key, records := range z {
+		if normalize(key) == name {
+			return records, true
+		}
+	}
+	for key, records := range z {
+		if wildcard := normalize(key); strings.HasPrefix(wildcard, "*.") &&
+			strings.HasSuffix(name, wildcard[1:]) {
+			return records, true
+		}
+	}
+
+	return Records{}, false
+}
+
+// normalize returns name lower cased and fully qualified.
+func normalize(name string) string {
+	return dns.Fqdn(strings.ToLower(name))
+}
+
+// Host returns the records of a host reachable at the loopback addresses,
+// such as a TLSServer.
+func Host() Records {
+	return Records{A: []net.IP{V4}, AAAA: []net.IP{V6}}
+}
+
+// family returns 4 or 6 for the address family of addr.
+func family(addr net.Addr) int {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	}
+	if ip.To4() != nil {
+		return 4
+	}
+
+	return 6
+}
+
+// listenUDP opens UDP sockets on the same port of both loopback addresses.
+func listenUDP() (v4, v6 net.PacketConn, err error) {
+	for try := 0; try < 20; try++ {
+		v4, err = net.ListenPacket("udp4", net.JoinHostPort(V4.String(), "0"))
+		if err != nil {
+			return nil, nil, err
+		}
+		port := v4.LocalAddr().(*net.UDPAddr).Port
+		v6, err = net.ListenPacket("udp6", net.JoinHostPort(V6.String(), fmt.Sprint(port)))
+		if err == nil {
+			return v4, v6, nil
+		}
+		v4.Close()
+	}
+
+	return nil, nil, ErrNoPort
+}
+
+// listenTCP opens TCP listeners on the same port of both loopback addresses.
+func listenTCP() (v4, v6 net.Listener, err error) {
+	for try := 0; try < 20; try++ {
+		v4, err = net.Listen("tcp4", net.JoinHostPort(V4.String(), "0"))
+		if err != nil {
+			return nil, nil, err
+		}
+		port := v4.Addr().(*net.TCPAddr).Port
+		v6, err = net.Listen("tcp6", net.JoinHostPort(V6.String(), fmt.Sprint(port)))
+		if err == nil {
+			return v4, v6, nil
+		}
+		v4.Close()
+	}
+
+	return nil, nil, ErrNoPort
+}
+
+// dnsServer serves handler on UDP on both loopback addresses.
+type dnsServer struct {
+	Port    int
+	servers []*dns.Server
+}
+
+func startDNS(handler dns.Handler) (*dnsServer, error) {
+	v4, v6, err := listenUDP()
+	if err != nil {
+		return nil, err
+	}
+	s := &dnsServer{Port: v4.LocalAddr().(*net.UDPAddr).Port}
+	for _, pc := range []net.PacketConn{v4, v6} {
+		started := make(chan struct{})
+		server := &dns.Server{
+			PacketConn:        pc,
+			Handler:           handler,
+			NotifyStartedFunc: func() { close(started) },
+		}
+		go server.ActivateAndServe()
+		<-started
+		s.servers = append(s.servers, server)
+	}
+
+	return s, nil
+}
+
+// Close stops the server.
+func (s *dnsServer) Close() {
+	for _, server := range s.servers {
+		server.Shutdown()
+	}
+}
+
+// Addr returns the address of the server on the loopback address of the same
+// family as ip, e.g. to pass to dns.Client.Exchange.
+func (s *dnsServer) Addr(ip net.IP) string {
+	return loopbackAddr(ip, s.Port)
+}
+
+// loopbackAddr returns port on the loopback address of the same family as
+// ip.
+func loopbackAddr(ip net.IP, port int) string {
+	if ip != nil && ip.To4() == nil {
+		return net.JoinHostPort(V6.String(), fmt.Sprint(port))
+	}
+
+	return net.JoinHostPort(V4.String(), fmt.Sprint(port))
+}
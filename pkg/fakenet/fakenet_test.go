@@ -0,0 +1,168 @@
+package fakenet
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func query(r *Resolver, ip net.IP, name string, qtype uint16, rd bool) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = rd
+	client := &dns.Client{Timeout: 200 * time.Millisecond}
+	resp, _, err := client.Exchange(m, r.Addr(ip))
+
+	return resp, err
+}
+
+// exchange returns the resolver's response, failing the test if there isn't one
+func exchange(t *testing.T, r *Resolver, ip net.IP, name string, qtype uint16, rd bool) *dns.Msg {
+	resp, err := query(r, ip, name, qtype, rd)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	return resp
+}
+
+// requireDropped fails the test unless the query times out without a response
+func requireDropped(t *testing.T, r *Resolver, ip net.IP, name string, qtype uint16) {
+	resp, err := query(r, ip, name, qtype, true)
+	require.Nil(t, resp)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr), "expected a timeout, got %v", err)
+	require.True(t, netErr.Timeout(), "expected a timeout, got %v", err)
+}
+
+func addresses(resp *dns.Msg) []string {
+	var ret []string
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			ret = append(ret, rr.A.String())
+		case *dns.AAAA:
+			ret = append(ret, rr.AAAA.String())
+		}
+	}
+
+	return ret
+}
+
+func TestResolver(t *testing.T) {
+	auth, err := NewAuthoritative(Zone{
+		"example.com":        Host(),
+		"*.wild.example.com": {A: []net.IP{net.IPv4(10, 0, 0, 1)}},
+	})
+	require.NoError(t, err)
+	defer auth.Close()
+	r, err := NewResolver(ResolverConfig{
+		Upstream:      auth,
+		ForwardFamily: 6,
+		Rules: []Rule{
+			{Domain: "nx.example.com", Family: 4, Action: NXDomain},
+			{Domain: "bogus.example.com", Action: Bogus, Bogus: []net.IP{net.IPv4(10, 0, 0, 2)}},
+			{Domain: "example.com", Family: 6, Action: FilterAAAA},
+			{Domain: "dropped.wild.example.com", Action: Drop},
+		},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	resp := exchange(t, r, V4, "example.com", dns.TypeAAAA, true)
+	require.Equal(t, []string{"::1"}, addresses(resp))
+	resp = exchange(t, r, V6, "example.com", dns.TypeAAAA, true)
+	require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Empty(t, resp.Answer)
+	resp = exchange(t, r, V6, "example.com", dns.TypeA, true)
+	require.Equal(t, []string{"127.0.0.1"}, addresses(resp))
+
+	resp = exchange(t, r, V4, "nx.example.com", dns.TypeA, true)
+	require.Equal(t, dns.RcodeNameError, resp.Rcode)
+	resp = exchange(t, r, V4, "bogus.example.com", dns.TypeA, true)
+	require.Equal(t, []string{"10.0.0.2"}, addresses(resp))
+	resp = exchange(t, r, V4, "a.wild.example.com", dns.TypeA, true)
+	require.Equal(t, []string{"10.0.0.1"}, addresses(resp))
+	requireDropped(t, r, V4, "dropped.wild.example.com", dns.TypeA)
+
+	// the authoritative server sees queries forwarded over IPv6
+	queries := auth.Queries()
+	require.NotEmpty(t, queries)
+	for _, q := range queries {
+		require.Equal(t, "::1", q.Source.String())
+	}
+
+	// without the RD bit only cached answers are given
+	resp = exchange(t, r, V4, "example.com", dns.TypeA, false)
+	require.Equal(t, []string{"127.0.0.1"}, addresses(resp))
+	resp = exchange(t, r, V4, "b.wild.example.com", dns.TypeA, false)
+	require.Empty(t, resp.Answer)
+}
+
+func TestResolverInject(t *testing.T) {
+	auth, err := NewAuthoritative(Zone{"example.com": Host()})
+	require.NoError(t, err)
+	defer auth.Close()
+	r, err := NewResolver(ResolverConfig{
+		Upstream: auth,
+		Rules: []Rule{{
+			Domain: "example.com",
+			Action: Bogus,
+			Bogus:  []net.IP{net.IPv4(10, 0, 0, 2)},
+			Inject: true,
+		}},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	conn, err := net.Dial("udp", r.Addr(V4))
+	require.NoError(t, err)
+	defer conn.Close()
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	out, err := m.Pack()
+	require.NoError(t, err)
+	_, err = conn.Write(out)
+	require.NoError(t, err)
+
+	// the injected answer comes first, then the real one
+	var got []string
+	buf := make([]byte, dns.MaxMsgSize)
+	for i := 0; i < 2; i++ {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		resp := new(dns.Msg)
+		require.NoError(t, resp.Unpack(buf[:n]))
+		got = append(got, addresses(resp)...)
+	}
+	require.Equal(t, []string{"10.0.0.2", "127.0.0.1"}, got)
+}
+
+func TestTLSServer(t *testing.T) {
+	ca, err := NewCA()
+	require.NoError(t, err)
+	s, err := NewTLSServer(ca, "example.com")
+	require.NoError(t, err)
+	defer s.Close()
+
+	for _, ip := range []net.IP{V4, V6} {
+		addr := s.Addr(ip)
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			ServerName: "example.com",
+			RootCAs:    ca.Pool(),
+		})
+		require.NoError(t, err)
+		conn.Close()
+
+		_, err = tls.Dial("tcp", addr, &tls.Config{
+			ServerName: "other.com",
+			RootCAs:    ca.Pool(),
+		})
+		require.Error(t, err)
+	}
+}
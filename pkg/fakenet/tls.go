@@ -0,0 +1,176 @@
+package fakenet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CA is a certificate authority for test certificates.
+type CA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	mutex  sync.Mutex
+	serial int64
+}
+
+// NewCA creates a CA with a new self-signed root certificate.
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fakenet test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{cert: cert, key: key, serial: 1}, nil
+}
+
+// Certificate returns the CA's root certificate.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// Pool returns a certificate pool trusting only the CA.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	return pool
+}
+
+// Issue returns a certificate for names, which may be domain names or
+// addresses, signed by the CA.
+func (ca *CA) Issue(names ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	ca.mutex.Lock()
+	ca.serial++
+	serial := ca.serial
+	ca.mutex.Unlock()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// TLSServer completes TLS handshakes with a certificate for the requested
+// server name, if it has one, then closes the connection.
+type TLSServer struct {
+	Port      int
+	listeners []net.Listener
+	certs     map[string]*tls.Certificate
+	fallback  *tls.Certificate
+	wg        sync.WaitGroup
+}
+
+// NewTLSServer starts a TLS server with certificates from ca for names.
+// Clients asking for any other name get a certificate for "invalid.test".
+func NewTLSServer(ca *CA, names ...string) (*TLSServer, error) {
+	s := &TLSServer{certs: make(map[string]*tls.Certificate)}
+	for _, name := range names {
+		cert, err := ca.Issue(name)
+		if err != nil {
+			return nil, err
+		}
+		s.certs[strings.ToLower(name)] = &cert
+	}
+	fallback, err := ca.Issue("invalid.test")
+	if err != nil {
+		return nil, err
+	}
+	s.fallback = &fallback
+
+	v4, v6, err := listenTCP()
+	if err != nil {
+		return nil, err
+	}
+	s.Port = v4.Addr().(*net.TCPAddr).Port
+	config := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, ok := s.certs[strings.ToLower(hello.ServerName)]; ok {
+				return cert, nil
+			}
+			return s.fallback, nil
+		},
+	}
+	for _, l := range []net.Listener{v4, v6} {
+		l = tls.NewListener(l, config)
+		s.listeners = append(s.listeners, l)
+		s.wg.Add(1)
+		go s.serve(l)
+	}
+
+	return s, nil
+}
+
+func (s *TLSServer) serve(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.(*tls.Conn).Handshake()
+		}()
+	}
+}
+
+// Addr returns the address of the server on the loopback address of the same
+// family as ip.
+func (s *TLSServer) Addr(ip net.IP) string {
+	return loopbackAddr(ip, s.Port)
+}
+
+// Close stops the server.
+func (s *TLSServer) Close() {
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.wg.Wait()
+}
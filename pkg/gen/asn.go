@@ -11,6 +11,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"sync"
 )
 
 // ASNMap maps a string to a list of subnets. Here this maps ASN to to the list
@@ -56,6 +57,9 @@ func (asnm *ASNMap) contains(asn uint) error {
 type AutonomousSystemNumberMaps struct {
 	V4Map ASNMap
 	V6Map ASNMap
+
+	trieOnce sync.Once
+	trie     *prefixTrie
 }
 
 // BuildASNMaps build the reverse map of country code to associated
@@ -218,3 +222,25 @@ func (asnm *AutonomousSystemNumberMaps) GetASNList() []uint {
 	sort.Slice(asns, func(i, j int) bool { return asns[i] < asns[j] })
 	return asns
 }
+
+// Lookup returns the ASN ip is allocated to and the subnet it is in, or false
+// if ip isn't in any subnet. The lookup trie is built from V4Map and V6Map on
+// the first call, so they shouldn't be changed after.
+func (asnm *AutonomousSystemNumberMaps) Lookup(ip net.IP) (uint, *net.IPNet, bool) {
+	asnm.trieOnce.Do(func() {
+		asnm.trie = new(prefixTrie)
+		for _, m := range []ASNMap{asnm.V4Map, asnm.V6Map} {
+			for asn, networks := range m {
+				for _, network := range networks {
+					asnm.trie.insert(network, asn)
+				}
+			}
+		}
+	})
+	network, value, ok := asnm.trie.lookup(ip)
+	if !ok {
+		return 0, nil, false
+	}
+
+	return value.(uint), network, true
+}
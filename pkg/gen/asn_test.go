@@ -0,0 +1,53 @@
+package gen
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestASNLookup(t *testing.T) {
+	dir := t.TempDir()
+	header := "network,autonomous_system_number,autonomous_system_organization\n"
+	require.Nil(t, os.WriteFile(
+		filepath.Join(dir, "GeoLite2-ASN-Blocks-IPv4.csv"),
+		[]byte(header+
+			"192.0.2.0/24,64500,Example One\n"+
+			"198.51.100.0/25,64501,Example Two\n"+
+			"198.51.100.128/25,64500,Example One\n"),
+		0644,
+	))
+	require.Nil(t, os.WriteFile(
+		filepath.Join(dir, "GeoLite2-ASN-Blocks-IPv6.csv"),
+		[]byte(header+"2001:db8::/32,64502,Example Three\n"),
+		0644,
+	))
+	asnMaps, err := BuildASNMaps(dir + "/")
+	require.Nil(t, err)
+
+	for _, tc := range []struct {
+		ip     string
+		asn    uint
+		prefix string
+		found  bool
+	}{
+		{"192.0.2.1", 64500, "192.0.2.0/24", true},
+		{"198.51.100.5", 64501, "198.51.100.0/25", true},
+		{"198.51.100.200", 64500, "198.51.100.128/25", true},
+		{"2001:db8::1", 64502, "2001:db8::/32", true},
+		{"203.0.113.1", 0, "", false},
+		{"10.0.0.1", 0, "", false},
+		{"2001:db9::1", 0, "", false},
+		{"::ffff:192.0.2.9", 64500, "192.0.2.0/24", true},
+	} {
+		asn, prefix, ok := asnMaps.Lookup(net.ParseIP(tc.ip))
+		require.Equal(t, tc.found, ok, tc.ip)
+		require.Equal(t, tc.asn, asn, tc.ip)
+		if tc.found {
+			require.Equal(t, tc.prefix, prefix.String(), tc.ip)
+		}
+	}
+}
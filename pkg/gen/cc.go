@@ -7,11 +7,12 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math"
+	"math/big"
 	"net"
 	"os"
 	"sort"
 	"strconv"
+	"sync"
 )
 
 // CCMap maps a string to a list of subnets. Here this maps Country codes to
@@ -98,8 +99,11 @@ func (ccm *CCMap) GetNRandomAddr(r io.Reader, cc string, n int, maxRetries int)
 }
 
 // GetNAddrPerAlloc selects N addresses per allocation for a specific country
-// code. This function allows the caller to specify an acceptance test function
-// and a max number of retries should a subnet fail too often.
+// code. Allocations with N or fewer usable addresses have all of them added,
+// otherwise N are drawn without replacement. The network and broadcast
+// addresses of IPv4 allocations are never selected. This function allows the
+// caller to specify an acceptance test function and a max number of
+// rejections before an allocation is given up on.
 func (ccm *CCMap) GetNAddrPerAlloc(r io.Reader, cc string, n int, maxRetries int, acceptance func(*net.IP) bool) ([]*net.IP, error) {
 	if err := ccm.contains(cc); err != nil {
 		return nil, err
@@ -112,46 +116,46 @@ func (ccm *CCMap) GetNAddrPerAlloc(r io.Reader, cc string, n int, maxRetries int
 		return addrs, nil
 	}
 
+	accept := func(addr *net.IP) bool {
+		if acceptance != nil && !acceptance(addr) {
+			return false
+		} else if _, ok := selectedAddrs[addr.String()]; ok {
+			// allocations can overlap
+			return false
+		}
+		addrs = append(addrs, addr)
+		selectedAddrs[addr.String()] = struct{}{}
+		return true
+	}
+
 ByAllocLoop:
 	for _, alloc := range (*ccm)[cc] {
-		retries := 0
-
-		ones, bits := alloc.Mask.Size()
-		if bits-ones <= int(math.Floor(math.Log(float64(n)))) {
-			// for allocs less than double the number of addresses we need add
-			// the mask IP and continue so we don't just fail retrying to
-			// select. This also handle /32 and /128 which have a single IP.
-			addr := &alloc.IP
-			if _, ok := selectedAddrs[addr.String()]; ok {
-				continue
+		first, count := hostRange(alloc)
+		if count.Cmp(big.NewInt(int64(n))) <= 0 {
+			// enumerate every host of allocations too small to sample from
+			for i := int64(0); i < count.Int64(); i++ {
+				accept(addrAt(alloc, new(big.Int).Add(first, big.NewInt(i))))
 			}
-
-			addrs = append(addrs, addr)
-			selectedAddrs[addr.String()] = struct{}{}
 			continue
 		}
 
+		perm := newHostPermutation(alloc)
+		retries := 0
 		for i := 0; i < n; {
-
 			if retries >= maxRetries {
 				log.Printf("too many retries generating for %s in %s\n", alloc, cc)
 				continue ByAllocLoop
 			}
 
-			addr := RandomAddr(r, alloc)
+			addr := perm.next(r)
 			if addr == nil {
-				retries++
-				continue
-			} else if acceptance != nil && !acceptance(addr) {
-				retries++
-				continue
-			} else if _, ok := selectedAddrs[addr.String()]; ok {
+				// exhausted by rejections, or r failed
+				continue ByAllocLoop
+			}
+			if !accept(addr) {
 				retries++
 				continue
 			}
-
-			addrs = append(addrs, addr)
-			selectedAddrs[addr.String()] = struct{}{}
 			i++
 		}
 	}
@@ -274,6 +278,9 @@ type CountryCodeMaps struct {
 	idMap map[int]string
 	V4Map CCMap
 	V6Map CCMap
+
+	trieOnce sync.Once
+	trie     *prefixTrie
 }
 
 // BuildCountryCodeMaps build the reverse map of country code to associated
@@ -349,6 +356,18 @@ func (ccm *CountryCodeMaps) GetNAddrPerAlloc6(r io.Reader, cc string, n int, max
 	return ccm.V6Map.GetNAddrPerAlloc(r, cc, n, maxRetries, acceptance)
 }
 
+// Sampler4 returns a Sampler picking by mode from the IPv4 subnets associated
+// with a specific country code.
+func (ccm *CountryCodeMaps) Sampler4(cc string, mode SampleMode) (*Sampler, error) {
+	return ccm.V4Map.Sampler(cc, mode)
+}
+
+// Sampler6 returns a Sampler picking by mode from the IPv6 subnets associated
+// with a specific country code.
+func (ccm *CountryCodeMaps) Sampler6(cc string, mode SampleMode) (*Sampler, error) {
+	return ccm.V6Map.Sampler(cc, mode)
+}
+
 // GetCCList returns a list of all country codes.
 func (ccm *CountryCodeMaps) GetCCList() []string {
 	j := 0
@@ -361,3 +380,25 @@ func (ccm *CountryCodeMaps) GetCCList() []string {
 
 	return countryCodes
 }
+
+// Lookup returns the country code ip is allocated to and the subnet it is in,
+// or false if ip isn't in any subnet. The lookup trie is built from V4Map and
+// V6Map on the first call, so they shouldn't be changed after.
+func (ccm *CountryCodeMaps) Lookup(ip net.IP) (string, *net.IPNet, bool) {
+	ccm.trieOnce.Do(func() {
+		ccm.trie = new(prefixTrie)
+		for _, m := range []CCMap{ccm.V4Map, ccm.V6Map} {
+			for cc, networks := range m {
+				for _, network := range networks {
+					ccm.trie.insert(network, cc)
+				}
+			}
+		}
+	})
+	network, value, ok := ccm.trie.lookup(ip)
+	if !ok {
+		return "", nil, false
+	}
+
+	return value.(string), network, true
+}
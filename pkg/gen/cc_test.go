@@ -1,6 +1,8 @@
 package gen
 
 import (
+	"crypto/rand"
+	"math/big"
 	"net"
 	"testing"
 
@@ -11,12 +13,84 @@ func TestRandAddr(t *testing.T) {
 	_, network, err := net.ParseCIDR("10.0.0.1/16")
 	require.Nil(t, err)
 
-	addr := randomAddr(network)
+	addr := RandomAddr(rand.Reader, network)
+	require.True(t, network.Contains(*addr))
 	t.Log(addr)
 
 	_, network, err = net.ParseCIDR("2001::1/64")
 	require.Nil(t, err)
 
-	addr = randomAddr(network)
+	addr = RandomAddr(rand.Reader, network)
+	require.True(t, network.Contains(*addr))
 	t.Log(addr)
 }
+
+func TestGetNAddrPerAlloc(t *testing.T) {
+	ccm := CCMap{"XX": parseSubnets(t,
+		"192.0.2.0/24",
+		"198.51.100.0/29",
+		"198.51.100.8/31",
+		"198.51.100.16/32",
+		"2001:db8::/64",
+	)}
+	addrs, err := ccm.GetNAddrPerAlloc(rand.Reader, "XX", 6, 10, nil)
+	require.Nil(t, err)
+
+	got := make(map[string]bool)
+	for _, addr := range addrs {
+		require.False(t, got[addr.String()], addr.String())
+		got[addr.String()] = true
+	}
+	perAlloc := make(map[string]int)
+	for _, alloc := range ccm["XX"] {
+		for addr := range got {
+			if alloc.Contains(net.ParseIP(addr)) {
+				perAlloc[alloc.String()]++
+			}
+		}
+	}
+	// the /29 has exactly 6 hosts, so every one of them is used
+	require.Equal(t, map[string]int{
+		"192.0.2.0/24":     6,
+		"198.51.100.0/29":  6,
+		"198.51.100.8/31":  2,
+		"198.51.100.16/32": 1,
+		"2001:db8::/64":    6,
+	}, perAlloc)
+	for _, addr := range []string{"192.0.2.0", "192.0.2.255", "198.51.100.0", "198.51.100.7"} {
+		require.False(t, got[addr], addr)
+	}
+	for _, addr := range []string{"198.51.100.1", "198.51.100.6", "198.51.100.8", "198.51.100.9", "198.51.100.16"} {
+		require.True(t, got[addr], addr)
+	}
+
+	// rejected allocations are given up on
+	addrs, err = ccm.GetNAddrPerAlloc(rand.Reader, "XX", 2, 5, func(addr *net.IP) bool {
+		return addr.To4() == nil
+	})
+	require.Nil(t, err)
+	require.Len(t, addrs, 2)
+	require.Nil(t, addrs[0].To4())
+}
+
+// TestHostPermutation checks every host of a subnet is drawn exactly once
+func TestHostPermutation(t *testing.T) {
+	for _, cidr := range []string{"192.0.2.0/28", "2001:db8::/124"} {
+		_, network, err := net.ParseCIDR(cidr)
+		require.Nil(t, err)
+		_, count := hostRange(network)
+		perm := newHostPermutation(network)
+		seen := make(map[string]bool)
+		for addr := perm.next(rand.Reader); addr != nil; addr = perm.next(rand.Reader) {
+			require.True(t, network.Contains(*addr), addr.String())
+			require.False(t, seen[addr.String()], addr.String())
+			seen[addr.String()] = true
+		}
+		require.Equal(t, count.Int64(), int64(len(seen)), cidr)
+	}
+
+	_, network, err := net.ParseCIDR("2001:db8::/32")
+	require.Nil(t, err)
+	_, count := hostRange(network)
+	require.Equal(t, 0, count.Cmp(new(big.Int).Lsh(big.NewInt(1), 96)))
+}
@@ -0,0 +1,174 @@
+package gen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Bogons4 are the IPv4 ranges from the IANA special-purpose registry that are
+// never routed on the public internet, along with multicast and reserved space.
+var Bogons4 = []string{
+	"0.0.0.0/8",          // "this" network
+	"10.0.0.0/8",         // private use
+	"100.64.0.0/10",      // shared address space
+	"127.0.0.0/8",        // loopback
+	"169.254.0.0/16",     // link local
+	"172.16.0.0/12",      // private use
+	"192.0.0.0/24",       // IETF protocol assignments
+	"192.0.2.0/24",       // documentation (TEST-NET-1)
+	"192.168.0.0/16",     // private use
+	"198.18.0.0/15",      // benchmarking
+	"198.51.100.0/24",    // documentation (TEST-NET-2)
+	"203.0.113.0/24",     // documentation (TEST-NET-3)
+	"224.0.0.0/4",        // multicast
+	"240.0.0.0/4",        // reserved
+	"255.255.255.255/32", // limited broadcast
+}
+
+// Bogons6 are the IPv6 ranges from the IANA special-purpose registry that are
+// never routed on the public internet, along with multicast and deprecated
+// space.
+var Bogons6 = []string{
+	"::/96",          // unspecified, loopback and IPv4-compatible
+	"64:ff9b:1::/48", // local use IPv4/IPv6 translation
+	"100::/64",       // discard only
+	"2001:2::/48",    // benchmarking
+	"2001:10::/28",   // deprecated ORCHID
+	"2001:20::/28",   // ORCHIDv2
+	"2001:db8::/32",  // documentation
+	"2002::/16",      // 6to4
+	"3fff::/20",      // documentation
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link local
+	"fec0::/10",      // deprecated site local
+	"ff00::/8",       // multicast
+}
+
+// ExclusionSet holds subnets that generated addresses must not be in, such as
+// opt-out requests, bogons and our own networks. It covers IPv4 and IPv6, with
+// IPv4-mapped IPv6 addresses checked against the IPv4 subnets.
+type ExclusionSet struct {
+	trie *prefixTrie
+	len  int
+}
+
+// NewExclusionSet returns an empty ExclusionSet.
+func NewExclusionSet() *ExclusionSet {
+	return &ExclusionSet{trie: new(prefixTrie)}
+}
+
+// Add excludes network, recording source as the reason.
+func (e *ExclusionSet) Add(network *net.IPNet, source string) {
+	e.trie.insert(network, source)
+	e.len++
+}
+
+// AddBogons excludes Bogons4 and Bogons6.
+func (e *ExclusionSet) AddBogons() {
+	for _, cidr := range append(append([]string{}, Bogons4...), Bogons6...) {
+		_, network, _ := net.ParseCIDR(cidr)
+		e.Add(network, "bogon")
+	}
+}
+
+// parseExclusion parses one subnet or address, with an address excluding only
+// itself.
+func parseExclusion(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address: %s", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Read adds the subnets in r, which is in ZMap blocklist format: one subnet or
+// address per line, with blank lines and anything after a '#' ignored. name
+// is used in errors and as the source of the subnets.
+func (e *ExclusionSet) Read(r io.Reader, name string) error {
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		network, err := parseExclusion(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNo, err)
+		}
+		e.Add(network, fmt.Sprintf("%s:%d", name, lineNo))
+	}
+
+	return scanner.Err()
+}
+
+// Load adds the subnets in the ZMap blocklist file at path.
+func (e *ExclusionSet) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return e.Read(f, path)
+}
+
+// LoadExclusions returns an ExclusionSet of the blocklist files at paths, and
+// the bogons if bogons is set. Empty paths are skipped.
+func LoadExclusions(paths []string, bogons bool) (*ExclusionSet, error) {
+	ret := NewExclusionSet()
+	if bogons {
+		ret.AddBogons()
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := ret.Load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// Len returns how many subnets have been added.
+func (e *ExclusionSet) Len() int {
+	return e.len
+}
+
+// Match returns the excluded subnet ip is in and its source, or false if ip
+// isn't excluded.
+func (e *ExclusionSet) Match(ip net.IP) (*net.IPNet, string, bool) {
+	if e == nil {
+		return nil, "", false
+	}
+	network, value, ok := e.trie.lookup(ip)
+	if !ok {
+		return nil, "", false
+	}
+
+	return network, value.(string), true
+}
+
+// Contains returns whether ip is excluded. A nil ExclusionSet excludes
+// nothing.
+func (e *ExclusionSet) Contains(ip net.IP) bool {
+	_, _, ok := e.Match(ip)
+	return ok
+}
@@ -0,0 +1,69 @@
+package gen
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExclusionSet(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocklist.conf")
+	require.Nil(t, os.WriteFile(path, []byte(
+		"# opt-out requests\n"+
+			"\n"+
+			"93.184.216.0/24 # example\n"+
+			"  1.1.1.1\n"+
+			"2606:4700::/32\n",
+	), 0644))
+
+	e, err := LoadExclusions([]string{path, ""}, true)
+	require.Nil(t, err)
+	require.Equal(t, len(Bogons4)+len(Bogons6)+3, e.Len())
+
+	for _, tc := range []struct {
+		ip     string
+		source string
+	}{
+		{"93.184.216.34", path + ":3"},
+		{"1.1.1.1", path + ":4"},
+		{"::ffff:1.1.1.1", path + ":4"},
+		{"2606:4700::6810:84e5", path + ":5"},
+		{"10.1.2.3", "bogon"},
+		{"192.0.2.1", "bogon"},
+		{"255.255.255.255", "bogon"},
+		{"::1", "bogon"},
+		{"fe80::1", "bogon"},
+		{"2001:db8::53", "bogon"},
+		{"ff02::1", "bogon"},
+	} {
+		_, source, ok := e.Match(net.ParseIP(tc.ip))
+		require.True(t, ok, tc.ip)
+		require.Equal(t, tc.source, source, tc.ip)
+	}
+	for _, ip := range []string{"1.1.1.2", "8.8.8.8", "2001:4860:4860::8888"} {
+		require.False(t, e.Contains(net.ParseIP(ip)), ip)
+	}
+
+	var nilSet *ExclusionSet
+	require.False(t, nilSet.Contains(net.ParseIP("10.0.0.1")))
+}
+
+func TestExclusionSetErrors(t *testing.T) {
+	e := NewExclusionSet()
+	err := e.Read(strings.NewReader("10.0.0.0/8\nnot-an-address\n"), "bad.conf")
+	require.EqualError(t, err, "bad.conf:2: invalid address: not-an-address")
+	err = e.Read(strings.NewReader("10.0.0.0/33\n"), "bad.conf")
+	require.Error(t, err)
+	_, err = LoadExclusions([]string{filepath.Join(t.TempDir(), "missing")}, false)
+	require.Error(t, err)
+
+	for _, cidr := range append(append([]string{}, Bogons4...), Bogons6...) {
+		_, _, err := net.ParseCIDR(cidr)
+		require.Nil(t, err, cidr)
+	}
+}
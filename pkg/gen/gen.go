@@ -2,8 +2,10 @@ package gen
 
 import (
 	"bufio"
+	"crypto/rand"
 	"errors"
 	"io"
+	"math/big"
 	"net"
 	"os"
 )
@@ -37,6 +39,82 @@ func RandomAddr(rdr io.Reader, subnet *net.IPNet) *net.IP {
 	return &addr
 }
 
+// hostRange returns the offset of the first usable address of subnet and how
+// many usable addresses it has. For IPv4 subnets larger than a /31 the network
+// and broadcast addresses aren't usable.
+func hostRange(subnet *net.IPNet) (*big.Int, *big.Int) {
+	ones, bits := subnet.Mask.Size()
+	first := big.NewInt(0)
+	count := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	if bits == 8*net.IPv4len && bits-ones >= 2 {
+		first.SetInt64(1)
+		count.Sub(count, big.NewInt(2))
+	}
+
+	return first, count
+}
+
+// addrAt returns the address offset past the start of subnet.
+func addrAt(subnet *net.IPNet, offset *big.Int) *net.IP {
+	base := subnet.IP.Mask(subnet.Mask)
+	sum := new(big.Int).Add(new(big.Int).SetBytes(base), offset)
+	addr := make(net.IP, len(base))
+	sum.FillBytes(addr)
+
+	return &addr
+}
+
+// hostPermutation draws the usable addresses of a subnet in a random order,
+// each exactly once. It runs a Fisher-Yates shuffle lazily over the offsets,
+// only remembering the swapped ones, so it works for subnets of any size.
+type hostPermutation struct {
+	subnet  *net.IPNet
+	first   *big.Int
+	count   *big.Int
+	i       *big.Int
+	swapped map[string]*big.Int
+}
+
+func newHostPermutation(subnet *net.IPNet) *hostPermutation {
+	first, count := hostRange(subnet)
+	return &hostPermutation{
+		subnet:  subnet,
+		first:   first,
+		count:   count,
+		i:       big.NewInt(0),
+		swapped: make(map[string]*big.Int),
+	}
+}
+
+// at returns the offset at position i of the shuffle.
+func (p *hostPermutation) at(i *big.Int) *big.Int {
+	if v, ok := p.swapped[i.String()]; ok {
+		return v
+	}
+
+	return i
+}
+
+// next returns the next address of the permutation, or nil once every address
+// has been drawn or r fails.
+func (p *hostPermutation) next(r io.Reader) *net.IP {
+	left := new(big.Int).Sub(p.count, p.i)
+	if left.Sign() <= 0 {
+		return nil
+	}
+	j, err := rand.Int(r, left)
+	if err != nil {
+		return nil
+	}
+	j.Add(j, p.i)
+	picked := p.at(j)
+	p.swapped[j.String()] = p.at(p.i)
+	delete(p.swapped, p.i.String())
+	p.i = new(big.Int).Add(p.i, big.NewInt(1))
+
+	return addrAt(p.subnet, new(big.Int).Add(p.first, picked))
+}
+
 // ParseRespongindAddrs parses a file with one address per line of addresses and
 // returns a "hash set" so that packages can check for inclusion. If no file
 // path is provided an empty hash set is returned. However, if a file path is
@@ -65,3 +143,29 @@ func ParseRespongindAddrs(fPath string) (map[string]struct{}, error) {
 
 	return respondingAddrs, nil
 }
+
+// IPInfo is what the GeoLite2 databases say about an address.
+type IPInfo struct {
+	Country       string
+	CountryPrefix *net.IPNet
+	ASN           uint
+	ASNPrefix     *net.IPNet
+}
+
+// LookupIP returns the country and ASN ip is allocated to, and the subnet each
+// came from. Either map may be nil, and fields not found are left empty.
+func LookupIP(
+	ccm *CountryCodeMaps,
+	asnm *AutonomousSystemNumberMaps,
+	ip net.IP,
+) IPInfo {
+	var ret IPInfo
+	if ccm != nil {
+		ret.Country, ret.CountryPrefix, _ = ccm.Lookup(ip)
+	}
+	if asnm != nil {
+		ret.ASN, ret.ASNPrefix, _ = asnm.Lookup(ip)
+	}
+
+	return ret
+}
@@ -0,0 +1,268 @@
+package gen
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sort"
+)
+
+// SampleMode says how a Sampler picks the subnet each address is drawn from.
+// The address is then drawn uniformly from that subnet, so the mode decides how
+// addresses spread across a country's allocations:
+//
+//   - SampleSubnet picks every subnet with equal probability, whatever its
+//     size. An address in a /24 is 2^16 times as likely to be drawn as one in a
+//     /8, so small allocations are heavily over represented. This is what
+//     CCMap.GetRandomSubnet does.
+//   - SampleWeighted picks a subnet with probability proportional to the number
+//     of addresses in it, so every address in the country is equally likely and
+//     the sample is uniform over the country's address space. In IPv6, where
+//     sizes span 2^64 or more, the few largest allocations get nearly every
+//     draw.
+//   - SampleLogWeighted picks a subnet with probability proportional to its
+//     number of host bits plus one, so an IPv6 /32 (weight 97) is under 1.5
+//     times as likely as a /64 (weight 65) rather than 2^32 times, and an IPv4
+//     /8 (25) about 2.8 times as likely as a /24 (9). Sizes barely matter, so
+//     this is close to SampleSubnet, but it is meant for IPv6, where weighted
+//     sampling would only ever pick the largest allocations.
+//   - SampleStratified visits the subnets in a random order, a whole pass at a
+//     time, so after n draws from k subnets each has been drawn from
+//     floor(n/k) or ceil(n/k) times. Every allocation is represented, with none
+//     of the variance of SampleSubnet. GetNRandomAddr redraws a rejected
+//     address from the same subnet, so rejections don't move the pass on; the
+//     counts only drift when a subnet has no acceptable address left, like a
+//     /32 already drawn, and is skipped for the rest of its pass.
+type SampleMode string
+
+const (
+	SampleSubnet      SampleMode = "subnet"
+	SampleWeighted    SampleMode = "weighted"
+	SampleLogWeighted SampleMode = "log-weighted"
+	SampleStratified  SampleMode = "stratified"
+)
+
+// SampleModes lists every SampleMode, for help text
+var SampleModes = []SampleMode{
+	SampleSubnet,
+	SampleWeighted,
+	SampleLogWeighted,
+	SampleStratified,
+}
+
+// ErrUnknownSampleMode is returned by ParseSampleMode for names that aren't a
+// SampleMode.
+var ErrUnknownSampleMode = errors.New("unknown sample mode")
+
+// ParseSampleMode returns the SampleMode named s, with "" meaning SampleSubnet.
+func ParseSampleMode(s string) (SampleMode, error) {
+	if s == "" {
+		return SampleSubnet, nil
+	}
+	for _, mode := range SampleModes {
+		if SampleMode(s) == mode {
+			return mode, nil
+		}
+	}
+
+	return "", ErrUnknownSampleMode
+}
+
+// Sampler picks subnets from a list according to a SampleMode. A Sampler in
+// SampleStratified mode remembers where it is in its pass, so it should be
+// kept for all the draws from one list and not shared between goroutines.
+type Sampler struct {
+	mode    SampleMode
+	subnets []*net.IPNet
+	// cumulative holds the running total of the subnet weights, for the
+	// weighted modes
+	cumulative []float64
+	// order and next are the stratified mode's current pass
+	order []int
+	next  int
+}
+
+// NewSampler returns a Sampler picking from subnets by mode. Returns
+// ErrNoSubnets if subnets is empty.
+func NewSampler(subnets []*net.IPNet, mode SampleMode) (*Sampler, error) {
+	if len(subnets) == 0 {
+		return nil, ErrNoSubnets
+	}
+	if _, err := ParseSampleMode(string(mode)); err != nil {
+		return nil, err
+	}
+	s := &Sampler{mode: mode, subnets: subnets}
+	if mode == SampleWeighted || mode == SampleLogWeighted {
+		s.cumulative = make([]float64, len(subnets))
+		var total float64
+		for i, subnet := range subnets {
+			total += subnetWeight(subnet, mode)
+			s.cumulative[i] = total
+		}
+	}
+
+	return s, nil
+}
+
+// subnetWeight returns how likely subnet is to be picked relative to others
+// by the weighted modes. Sizes are floats since an IPv6 subnet can have more
+// addresses than fit in 64 bits.
+func subnetWeight(subnet *net.IPNet, mode SampleMode) float64 {
+	ones, bits := subnet.Mask.Size()
+	if mode == SampleLogWeighted {
+		return float64(bits-ones) + 1
+	}
+
+	return math.Ldexp(1, bits-ones)
+}
+
+// randFloat reads a float in [0, 1) from r.
+func randFloat(r io.Reader) (float64, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+
+	return float64(binary.BigEndian.Uint64(buf)>>11) / (1 << 53), nil
+}
+
+// randIntn reads an int in [0, n) from r.
+func randIntn(r io.Reader, n int) (int, error) {
+	f, err := randFloat(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(f * float64(n)), nil
+}
+
+// Subnet uses the provided random reader to pick a subnet. Returns nil if r
+// fails.
+func (s *Sampler) Subnet(r io.Reader) *net.IPNet {
+	switch s.mode {
+	case SampleWeighted, SampleLogWeighted:
+		f, err := randFloat(r)
+		if err != nil {
+			return nil
+		}
+		target := f * s.cumulative[len(s.cumulative)-1]
+		i := sort.Search(len(s.cumulative), func(i int) bool {
+			return s.cumulative[i] > target
+		})
+		if i == len(s.cumulative) {
+			i--
+		}
+		return s.subnets[i]
+	case SampleStratified:
+		if s.next == 0 {
+			// shuffle for a new pass
+			if s.order == nil {
+				s.order = make([]int, len(s.subnets))
+				for i := range s.order {
+					s.order[i] = i
+				}
+			}
+			for i := len(s.order) - 1; i > 0; i-- {
+				j, err := randIntn(r, i+1)
+				if err != nil {
+					return nil
+				}
+				s.order[i], s.order[j] = s.order[j], s.order[i]
+			}
+		}
+		subnet := s.subnets[s.order[s.next]]
+		s.next = (s.next + 1) % len(s.subnets)
+		return subnet
+	default:
+		// the same draw as CCMap.GetRandomSubnet, so seeded runs pick the
+		// same addresses either way
+		buf := make([]byte, 4)
+		if _, err := r.Read(buf); err != nil {
+			return nil
+		}
+		return s.subnets[binary.BigEndian.Uint32(buf)%uint32(len(s.subnets))]
+	}
+}
+
+// GetRandomAddr uses the provided random reader to select a random address
+// from a subnet picked by the Sampler. Returns nil if r fails.
+func (s *Sampler) GetRandomAddr(r io.Reader) *net.IP {
+	subnet := s.Subnet(r)
+	if subnet == nil {
+		return nil
+	}
+
+	return RandomAddr(r, subnet)
+}
+
+// stratifiedRetries is how many addresses GetNRandomAddr draws from a subnet
+// in SampleStratified mode before giving up on it for the pass, so a used up
+// allocation costs one retry rather than the whole budget
+var stratifiedRetries = 10
+
+// GetNRandomAddr selects N addresses with no repeats from subnets picked by the
+// Sampler. This function allows the caller to specify an acceptance test
+// function, which may be nil, and a max number of rejections before giving up.
+// In SampleStratified mode a rejected address is redrawn from the same subnet,
+// so the pass isn't moved on by rejections, until stratifiedRetries draws from
+// it have been rejected; that counts as a single rejection.
+func (s *Sampler) GetNRandomAddr(r io.Reader, n int, maxRetries int, acceptance func(*net.IP) bool) ([]*net.IP, error) {
+	selectedAddrs := make(map[string]struct{})
+	addrs := make([]*net.IP, 0)
+	retries := 0
+
+	var subnet *net.IPNet
+	subnetRetries := 0
+	for len(addrs) < n {
+		if retries >= maxRetries {
+			return nil, ErrTooManyRetries
+		}
+
+		if subnet == nil || s.mode != SampleStratified {
+			subnet = s.Subnet(r)
+			subnetRetries = 0
+			if subnet == nil {
+				retries++
+				continue
+			}
+		}
+		addr := RandomAddr(r, subnet)
+		rejected := addr == nil
+		if !rejected {
+			_, rejected = selectedAddrs[addr.String()]
+		}
+		if !rejected && acceptance != nil {
+			rejected = !acceptance(addr)
+		}
+		if rejected {
+			if s.mode == SampleStratified {
+				subnetRetries++
+				if subnetRetries < stratifiedRetries {
+					continue
+				}
+				// move the pass on
+				subnet = nil
+			}
+			retries++
+			continue
+		}
+
+		addrs = append(addrs, addr)
+		selectedAddrs[addr.String()] = struct{}{}
+		subnet = nil
+	}
+
+	return addrs, nil
+}
+
+// Sampler returns a Sampler picking by mode from the subnets associated with
+// the provided country code.
+func (ccm *CCMap) Sampler(cc string, mode SampleMode) (*Sampler, error) {
+	if err := ccm.contains(cc); err != nil {
+		return nil, err
+	}
+
+	return NewSampler((*ccm)[cc], mode)
+}
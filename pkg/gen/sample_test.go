@@ -0,0 +1,175 @@
+package gen
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parseSubnets(t *testing.T, cidrs ...string) []*net.IPNet {
+	var ret []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		require.Nil(t, err)
+		ret = append(ret, network)
+	}
+
+	return ret
+}
+
+// TestSamplerModes draws from a /16 and a /24 and checks each mode picks the
+// /24 about as often as it should
+func TestSamplerModes(t *testing.T) {
+	subnets := parseSubnets(t, "10.0.0.0/16", "192.0.2.0/24")
+	draws := 20000
+	for _, tc := range []struct {
+		mode SampleMode
+		want float64
+	}{
+		{SampleSubnet, 0.5},
+		{SampleWeighted, 256.0 / (65536 + 256)},
+		{SampleLogWeighted, 9.0 / (17 + 9)},
+		{SampleStratified, 0.5},
+	} {
+		r := rand.New(rand.NewSource(1))
+		s, err := NewSampler(subnets, tc.mode)
+		require.Nil(t, err)
+		var small int
+		for i := 0; i < draws; i++ {
+			addr := s.GetRandomAddr(r)
+			require.NotNil(t, addr)
+			if subnets[1].Contains(*addr) {
+				small++
+			} else {
+				require.True(t, subnets[0].Contains(*addr), tc.mode)
+			}
+		}
+		require.InDelta(t, tc.want, float64(small)/float64(draws), 0.01, tc.mode)
+	}
+}
+
+// TestSamplerStratified checks every subnet is drawn from evenly
+func TestSamplerStratified(t *testing.T) {
+	subnets := parseSubnets(t, "10.0.0.0/8", "192.0.2.0/24", "198.51.100.0/24")
+	s, err := NewSampler(subnets, SampleStratified)
+	require.Nil(t, err)
+	r := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		counts[s.Subnet(r).String()]++
+	}
+	require.Len(t, counts, 3)
+	for subnet, count := range counts {
+		require.True(t, count == 3 || count == 4, subnet)
+	}
+
+	addrs, err := s.GetNRandomAddr(r, 6, 10, nil)
+	require.Nil(t, err)
+	require.Len(t, addrs, 6)
+}
+
+// TestSamplerStratifiedRejections checks rejected addresses are redrawn from
+// the same subnet, so every subnet is still drawn from evenly
+func TestSamplerStratifiedRejections(t *testing.T) {
+	subnets := parseSubnets(t, "10.0.0.0/8", "192.0.2.0/24", "198.51.100.0/24")
+	s, err := NewSampler(subnets, SampleStratified)
+	require.Nil(t, err)
+	r := rand.New(rand.NewSource(1))
+	var rejected int
+	addrs, err := s.GetNRandomAddr(r, 30, 1000, func(addr *net.IP) bool {
+		// reject about half of the addresses
+		if (*addr)[len(*addr)-1]%2 == 0 {
+			rejected++
+			return false
+		}
+		return true
+	})
+	require.Nil(t, err)
+	require.Len(t, addrs, 30)
+	require.NotZero(t, rejected)
+
+	counts := make(map[int]int)
+	for _, addr := range addrs {
+		for i, subnet := range subnets {
+			if subnet.Contains(*addr) {
+				counts[i]++
+			}
+		}
+	}
+	require.Equal(t, map[int]int{0: 10, 1: 10, 2: 10}, counts)
+}
+
+// TestSamplerStratifiedUsedUp checks a subnet with no addresses left is moved
+// past instead of using up every retry
+func TestSamplerStratifiedUsedUp(t *testing.T) {
+	subnets := parseSubnets(t, "10.0.0.0/8", "192.0.2.7/32")
+	s, err := NewSampler(subnets, SampleStratified)
+	require.Nil(t, err)
+	r := rand.New(rand.NewSource(1))
+	selected := make(map[string]bool)
+	acceptance := func(addr *net.IP) bool {
+		return !selected[addr.String()]
+	}
+	for block := 0; block < 5; block++ {
+		addrs, err := s.GetNRandomAddr(r, 10, 100, acceptance)
+		require.Nil(t, err, block)
+		require.Len(t, addrs, 10, block)
+		for _, addr := range addrs {
+			selected[addr.String()] = true
+		}
+	}
+	require.Len(t, selected, 50)
+	require.True(t, selected["192.0.2.7"])
+}
+
+// TestSamplerIPv6 checks the weights don't overflow for large IPv6 subnets
+func TestSamplerIPv6(t *testing.T) {
+	subnets := parseSubnets(t, "2001:db8::/32", "2001:db9::/64")
+	s, err := NewSampler(subnets, SampleWeighted)
+	require.Nil(t, err)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		require.Equal(t, subnets[0], s.Subnet(r))
+	}
+
+	s, err = NewSampler(subnets, SampleLogWeighted)
+	require.Nil(t, err)
+	var small int
+	for i := 0; i < 10000; i++ {
+		if s.Subnet(r) == subnets[1] {
+			small++
+		}
+	}
+	require.InDelta(t, 65.0/(97+65), float64(small)/10000, 0.02)
+}
+
+func TestParseSampleMode(t *testing.T) {
+	mode, err := ParseSampleMode("")
+	require.Nil(t, err)
+	require.Equal(t, SampleSubnet, mode)
+	mode, err = ParseSampleMode("log-weighted")
+	require.Nil(t, err)
+	require.Equal(t, SampleLogWeighted, mode)
+	_, err = ParseSampleMode("bogus")
+	require.Equal(t, ErrUnknownSampleMode, err)
+
+	_, err = NewSampler(nil, SampleWeighted)
+	require.Equal(t, ErrNoSubnets, err)
+}
+
+// TestSamplerSubnetMatchesCCMap checks SampleSubnet draws the same addresses
+// as CCMap.GetRandomAddr from the same seed
+func TestSamplerSubnetMatchesCCMap(t *testing.T) {
+	ccm := CCMap{"XX": parseSubnets(t, "10.0.0.0/16", "192.0.2.0/24", "198.51.100.0/24")}
+	s, err := ccm.Sampler("XX", SampleSubnet)
+	require.Nil(t, err)
+	r1 := rand.New(rand.NewSource(7))
+	r2 := rand.New(rand.NewSource(7))
+	for i := 0; i < 50; i++ {
+		require.Equal(t, ccm.GetRandomAddr(r1, "XX"), s.GetRandomAddr(r2))
+	}
+	_, err = ccm.Sampler("YY", SampleSubnet)
+	require.Equal(t, ErrNoSubnets, err)
+}
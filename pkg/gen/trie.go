@@ -0,0 +1,147 @@
+package gen
+
+import (
+	"math/bits"
+	"net"
+)
+
+// trieNode is a node of a path compressed binary trie over address bits. A
+// node holds a value only if a subnet was inserted with exactly its prefix,
+// other nodes just join two branches.
+type trieNode struct {
+	// key is the node's prefix, with every bit past plen zeroed
+	key      []byte
+	plen     int
+	network  *net.IPNet
+	value    interface{}
+	children [2]*trieNode
+}
+
+// prefixTrie finds the longest inserted subnet holding an address, with one
+// trie for each address family. A lookup visits at most one node per bit of
+// the address, and usually far fewer.
+type prefixTrie struct {
+	v4 *trieNode
+	v6 *trieNode
+}
+
+// bit returns bit i of key, counting from the most significant.
+func bit(key []byte, i int) int {
+	return int(key[i/8]>>(7-uint(i%8))) & 1
+}
+
+// commonPrefixLen returns how many leading bits a and b share, up to max.
+func commonPrefixLen(a, b []byte, max int) int {
+	var n int
+	for i := 0; i < len(a) && n < max; i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			n += bits.LeadingZeros8(x)
+			break
+		}
+		n += 8
+	}
+	if n > max {
+		return max
+	}
+
+	return n
+}
+
+// maskKey returns a copy of key with every bit past plen zeroed.
+func maskKey(key []byte, plen int) []byte {
+	ret := make([]byte, len(key))
+	copy(ret, key)
+	for i := plen; i < len(ret)*8; i++ {
+		ret[i/8] &^= 1 << (7 - uint(i%8))
+	}
+
+	return ret
+}
+
+// insert adds network to the trie with value, replacing the value of an
+// identical subnet.
+func (t *prefixTrie) insert(network *net.IPNet, value interface{}) {
+	plen, _ := network.Mask.Size()
+	// the mask says which family a network is, since a v4 network may be
+	// written with a 16 byte address
+	node, key := &t.v6, network.IP.To16()
+	if len(network.Mask) == net.IPv4len {
+		node, key = &t.v4, network.IP.To4()
+	}
+	if key == nil {
+		return
+	}
+	leaf := &trieNode{
+		key:     maskKey(key, plen),
+		plen:    plen,
+		network: network,
+		value:   value,
+	}
+
+	for {
+		n := *node
+		if n == nil {
+			*node = leaf
+			return
+		}
+		common := commonPrefixLen(n.key, leaf.key, minInt(n.plen, plen))
+		switch {
+		case common == n.plen && common == plen:
+			n.network, n.value = network, value
+			return
+		case common == n.plen:
+			// n holds the new subnet, carry on down
+			node = &n.children[bit(leaf.key, n.plen)]
+		case common == plen:
+			// the new subnet holds n
+			leaf.children[bit(n.key, plen)] = n
+			*node = leaf
+			return
+		default:
+			// they diverge, so join them under their common prefix
+			join := &trieNode{key: maskKey(leaf.key, common), plen: common}
+			join.children[bit(leaf.key, common)] = leaf
+			join.children[bit(n.key, common)] = n
+			*node = join
+			return
+		}
+	}
+}
+
+// lookup returns the longest subnet holding ip, and its value, or false if no
+// subnet does.
+func (t *prefixTrie) lookup(ip net.IP) (*net.IPNet, interface{}, bool) {
+	root, key := t.v6, ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		root, key = t.v4, ip4
+	}
+	if key == nil {
+		return nil, nil, false
+	}
+	var best *trieNode
+	for n := root; n != nil; {
+		if commonPrefixLen(n.key, key, n.plen) < n.plen {
+			break
+		}
+		if n.network != nil {
+			best = n
+		}
+		if n.plen == len(key)*8 {
+			break
+		}
+		n = n.children[bit(key, n.plen)]
+	}
+	if best == nil {
+		return nil, nil, false
+	}
+
+	return best.network, best.value, true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
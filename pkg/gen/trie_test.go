@@ -0,0 +1,146 @@
+package gen
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixTrie(t *testing.T) {
+	trie := new(prefixTrie)
+	for _, tc := range []struct {
+		cidr  string
+		value string
+	}{
+		// inserted out of order so nodes are split and joined
+		{"10.1.2.0/24", "c"},
+		{"10.0.0.0/8", "a"},
+		{"10.1.2.3/32", "d"},
+		{"10.1.0.0/16", "b"},
+		{"0.0.0.0/0", "default"},
+		{"2001:db8:1::/48", "v6-long"},
+		{"2001:db8::/32", "v6"},
+		{"10.1.0.0/16", "b2"},
+	} {
+		_, network, err := net.ParseCIDR(tc.cidr)
+		require.Nil(t, err)
+		trie.insert(network, tc.value)
+	}
+
+	for _, tc := range []struct {
+		ip     string
+		prefix string
+		value  string
+	}{
+		{"10.1.2.3", "10.1.2.3/32", "d"},
+		{"10.1.2.4", "10.1.2.0/24", "c"},
+		{"10.1.3.1", "10.1.0.0/16", "b2"},
+		{"10.200.0.1", "10.0.0.0/8", "a"},
+		{"192.0.2.1", "0.0.0.0/0", "default"},
+		{"::ffff:10.1.2.3", "10.1.2.3/32", "d"},
+		{"2001:db8:1::1", "2001:db8:1::/48", "v6-long"},
+		{"2001:db8:2::1", "2001:db8::/32", "v6"},
+	} {
+		network, value, ok := trie.lookup(net.ParseIP(tc.ip))
+		require.True(t, ok, tc.ip)
+		require.Equal(t, tc.prefix, network.String(), tc.ip)
+		require.Equal(t, tc.value, value, tc.ip)
+	}
+	_, _, ok := trie.lookup(net.ParseIP("2001:db9::1"))
+	require.False(t, ok)
+	_, _, ok = trie.lookup(nil)
+	require.False(t, ok)
+}
+
+// TestPrefixTrieRandom checks the trie against a linear search over random
+// subnets
+func TestPrefixTrieRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	trie := new(prefixTrie)
+	var networks []*net.IPNet
+	for i := 0; i < 2000; i++ {
+		ip := make(net.IP, net.IPv4len)
+		r.Read(ip)
+		// short prefixes so they overlap
+		plen := 4 + r.Intn(20)
+		network := &net.IPNet{
+			IP:   ip.Mask(net.CIDRMask(plen, 32)),
+			Mask: net.CIDRMask(plen, 32),
+		}
+		networks = append(networks, network)
+		trie.insert(network, i)
+	}
+
+	for i := 0; i < 2000; i++ {
+		ip := make(net.IP, net.IPv4len)
+		r.Read(ip)
+		var want *net.IPNet
+		wantValue := -1
+		for j, network := range networks {
+			if !network.Contains(ip) {
+				continue
+			}
+			ones, _ := network.Mask.Size()
+			if want != nil {
+				wantOnes, _ := want.Mask.Size()
+				if ones < wantOnes {
+					continue
+				}
+			}
+			// later inserts of the same subnet replace earlier ones
+			want, wantValue = network, j
+		}
+		network, value, ok := trie.lookup(ip)
+		require.Equal(t, want != nil, ok, ip.String())
+		if ok {
+			require.Equal(t, want.String(), network.String(), ip.String())
+			require.Equal(t, wantValue, value, ip.String())
+		}
+	}
+}
+
+func TestCountryCodeLookup(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(
+		filepath.Join(dir, "GeoLite2-Country-Locations-en.csv"),
+		[]byte("geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,is_in_european_union\n"+
+			"6252001,en,NA,\"North America\",US,\"United States\",0\n"+
+			"130758,en,AS,Asia,IR,Iran,0\n"),
+		0644,
+	))
+	header := "network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider\n"
+	require.Nil(t, os.WriteFile(
+		filepath.Join(dir, "GeoLite2-Country-Blocks-IPv4.csv"),
+		[]byte(header+
+			"192.0.2.0/24,6252001,6252001,,0,0\n"+
+			"198.51.100.0/24,130758,130758,,0,0\n"),
+		0644,
+	))
+	require.Nil(t, os.WriteFile(
+		filepath.Join(dir, "GeoLite2-Country-Blocks-IPv6.csv"),
+		[]byte(header+"2001:db8::/32,130758,130758,,0,0\n"),
+		0644,
+	))
+	ccMaps, err := BuildCountryCodeMaps(dir + "/")
+	require.Nil(t, err)
+
+	cc, prefix, ok := ccMaps.Lookup(net.ParseIP("198.51.100.7"))
+	require.True(t, ok)
+	require.Equal(t, "IR", cc)
+	require.Equal(t, "198.51.100.0/24", prefix.String())
+	cc, _, ok = ccMaps.Lookup(net.ParseIP("2001:db8::53"))
+	require.True(t, ok)
+	require.Equal(t, "IR", cc)
+	_, _, ok = ccMaps.Lookup(net.ParseIP("203.0.113.1"))
+	require.False(t, ok)
+
+	info := LookupIP(ccMaps, nil, net.ParseIP("192.0.2.1"))
+	require.Equal(t, "US", info.Country)
+	require.Equal(t, "192.0.2.0/24", info.CountryPrefix.String())
+	require.Zero(t, info.ASN)
+	require.Nil(t, info.ASNPrefix)
+}
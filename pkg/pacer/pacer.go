@@ -0,0 +1,201 @@
+// Package pacer limits how fast the probing commands send packets. A Pacer
+// enforces a global packets per second limit, a separate limit for each
+// destination prefix (so a single network is never flooded even when the
+// global rate is high) and an optional random jitter before every send.
+// ShuffleLines randomizes the order inputs are probed in, so consecutive
+// probes are spread across networks.
+package pacer
+
+import (
+	"flag"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config describes the limits a Pacer enforces. It can be embedded in a go-arg
+// flag struct, or registered with the flag package with RegisterFlags.
+type Config struct {
+	Rate        float64       `arg:"--rate" help:"Maximum packets to send per second across all destinations, 0 for no limit" default:"0" json:"rate"`
+	PrefixRate  float64       `arg:"--prefix-rate" help:"Maximum packets to send per second to any single destination prefix, 0 for no limit" default:"0" json:"prefix_rate"`
+	V4PrefixLen int           `arg:"--v4-prefix-len" help:"Length of the IPv4 prefixes --prefix-rate applies to" default:"24" json:"v4_prefix_len"`
+	V6PrefixLen int           `arg:"--v6-prefix-len" help:"Length of the IPv6 prefixes --prefix-rate applies to" default:"48" json:"v6_prefix_len"`
+	Jitter      time.Duration `arg:"--jitter" help:"Wait a random duration up to this long before each packet, e.g. 10ms" default:"0s" json:"jitter"`
+	Shuffle     bool          `arg:"--shuffle" help:"Randomize the order destinations are probed in" json:"shuffle"`
+	// ShuffleWindow is the number of inputs read ahead to shuffle between, 0
+	// reads in the whole input first.
+	ShuffleWindow int `arg:"--shuffle-window" help:"With --shuffle, the number of inputs to shuffle between, 0 to read the whole input first" default:"100000" json:"shuffle_window"`
+}
+
+// RegisterFlags adds flags for every field of c to fs, using the current
+// values as defaults.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	if c.V4PrefixLen == 0 {
+		c.V4PrefixLen = 24
+	}
+	if c.V6PrefixLen == 0 {
+		c.V6PrefixLen = 48
+	}
+	if c.ShuffleWindow == 0 {
+		c.ShuffleWindow = 100000
+	}
+	fs.Float64Var(&c.Rate, "rate", c.Rate, "Maximum packets to send per second across all destinations, 0 for no limit")
+	fs.Float64Var(&c.PrefixRate, "prefix-rate", c.PrefixRate, "Maximum packets to send per second to any single destination prefix, 0 for no limit")
+	fs.IntVar(&c.V4PrefixLen, "v4-prefix-len", c.V4PrefixLen, "Length of the IPv4 prefixes -prefix-rate applies to")
+	fs.IntVar(&c.V6PrefixLen, "v6-prefix-len", c.V6PrefixLen, "Length of the IPv6 prefixes -prefix-rate applies to")
+	fs.DurationVar(&c.Jitter, "jitter", c.Jitter, "Wait a random duration up to this long before each packet, e.g. 10ms")
+	fs.BoolVar(&c.Shuffle, "shuffle", c.Shuffle, "Randomize the order destinations are probed in")
+	fs.IntVar(&c.ShuffleWindow, "shuffle-window", c.ShuffleWindow, "With -shuffle, the number of inputs to shuffle between, 0 to read the whole input first")
+}
+
+// bucket is a token bucket refilled at rate tokens per second, holding at most
+// burst tokens. Tokens can be borrowed, leaving the bucket negative, which is
+// how waiting callers reserve their place in line.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, now time.Time) *bucket {
+	burst := rate / 10
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// full reports whether the bucket has been idle long enough to refill, so it
+// can be forgotten without changing the limits.
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// pruneEvery is how many prefixes are tracked before idle ones are removed.
+const pruneEvery = 100000
+
+// Pacer blocks callers until they may send their next packet. A nil Pacer
+// never blocks. A Pacer is safe for use by multiple goroutines.
+type Pacer struct {
+	cfg      Config
+	v4Mask   net.IPMask
+	v6Mask   net.IPMask
+	mutex    sync.Mutex
+	global   *bucket
+	prefixes map[string]*bucket
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+// New returns a Pacer enforcing cfg, or nil if cfg sets no limits at all.
+func New(cfg Config) *Pacer {
+	if cfg.Rate <= 0 && cfg.PrefixRate <= 0 && cfg.Jitter <= 0 {
+		return nil
+	}
+	if cfg.V4PrefixLen <= 0 || cfg.V4PrefixLen > 32 {
+		cfg.V4PrefixLen = 24
+	}
+	if cfg.V6PrefixLen <= 0 || cfg.V6PrefixLen > 128 {
+		cfg.V6PrefixLen = 48
+	}
+	p := &Pacer{
+		cfg:      cfg,
+		v4Mask:   net.CIDRMask(cfg.V4PrefixLen, 32),
+		v6Mask:   net.CIDRMask(cfg.V6PrefixLen, 128),
+		prefixes: make(map[string]*bucket),
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+	if cfg.Rate > 0 {
+		p.global = newBucket(cfg.Rate, p.now())
+	}
+
+	return p
+}
+
+// prefix returns the destination prefix dest is limited as part of.
+func (p *Pacer) prefix(dest net.IP) string {
+	if v4 := dest.To4(); v4 != nil {
+		return v4.Mask(p.v4Mask).String()
+	}
+
+	return dest.Mask(p.v6Mask).String()
+}
+
+// reservePrefix takes a token from dest's prefix bucket.
+func (p *Pacer) reservePrefix(dest net.IP) time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := p.now()
+	key := p.prefix(dest)
+	b, ok := p.prefixes[key]
+	if !ok {
+		if len(p.prefixes) >= pruneEvery {
+			for k, idle := range p.prefixes {
+				if idle.full(now) {
+					delete(p.prefixes, k)
+				}
+			}
+		}
+		b = newBucket(p.cfg.PrefixRate, now)
+		p.prefixes[key] = b
+	}
+
+	return b.reserve(now)
+}
+
+// reserveGlobal takes a token from the global bucket.
+func (p *Pacer) reserveGlobal() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.global.reserve(p.now())
+}
+
+// Wait blocks until a packet may be sent to dest. dest may be nil when the
+// destination isn't known, in which case only the global limit applies.
+func (p *Pacer) Wait(dest net.IP) {
+	if p == nil {
+		return
+	}
+	if p.cfg.Jitter > 0 {
+		p.sleep(time.Duration(rand.Int63n(int64(p.cfg.Jitter))))
+	}
+	// the destination's limit is waited on first so that a busy prefix
+	// doesn't hold on to a global token other destinations could use
+	if p.cfg.PrefixRate > 0 && dest != nil {
+		if d := p.reservePrefix(dest); d > 0 {
+			p.sleep(d)
+		}
+	}
+	if p.global != nil {
+		if d := p.reserveGlobal(); d > 0 {
+			p.sleep(d)
+		}
+	}
+}
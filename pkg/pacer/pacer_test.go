@@ -0,0 +1,101 @@
+package pacer
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock lets tests run the pacer without actually sleeping
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept += d
+	c.now = c.now.Add(d)
+}
+
+func newTestPacer(cfg Config) (*Pacer, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	p := New(cfg)
+	p.now = clock.Now
+	p.sleep = clock.Sleep
+	if p.global != nil {
+		p.global.last = clock.now
+	}
+	return p, clock
+}
+
+func TestNilPacer(t *testing.T) {
+	p := New(Config{})
+	require.Nil(t, p)
+	// must not block or panic
+	p.Wait(net.ParseIP("192.0.2.1"))
+}
+
+func TestGlobalRate(t *testing.T) {
+	p, clock := newTestPacer(Config{Rate: 100})
+	for i := 0; i < 1000; i++ {
+		p.Wait(net.ParseIP("192.0.2.1"))
+	}
+	// 1000 packets at 100 per second, less the initial burst of 10
+	require.InDelta(t, 9.9, clock.slept.Seconds(), 0.01)
+}
+
+func TestPrefixRate(t *testing.T) {
+	p, clock := newTestPacer(Config{PrefixRate: 1})
+	// every address of a /24 shares a limit
+	for i := 1; i <= 5; i++ {
+		p.Wait(net.ParseIP("192.0.2." + strconv.Itoa(i)))
+	}
+	require.InDelta(t, 4, clock.slept.Seconds(), 0.01)
+
+	// other prefixes aren't held up
+	clock.slept = 0
+	p.Wait(net.ParseIP("198.51.100.1"))
+	p.Wait(net.ParseIP("2001:db8:1::1"))
+	p.Wait(net.ParseIP("2001:db8:2::1"))
+	require.Equal(t, time.Duration(0), clock.slept)
+	p.Wait(net.ParseIP("2001:db8:2:ffff::1"))
+	require.InDelta(t, 1, clock.slept.Seconds(), 0.01)
+}
+
+func TestShuffleLines(t *testing.T) {
+	var input []string
+	for i := 0; i < 1000; i++ {
+		input = append(input, strconv.Itoa(i))
+	}
+	for _, cfg := range []Config{
+		{},
+		{Shuffle: true},
+		{Shuffle: true, ShuffleWindow: 10},
+	} {
+		var out []string
+		err := ShuffleLines(
+			strings.NewReader(strings.Join(input, "\n")),
+			cfg,
+			func(line string) { out = append(out, line) },
+		)
+		require.Nil(t, err)
+		if !cfg.Shuffle {
+			require.Equal(t, input, out)
+			continue
+		}
+		require.NotEqual(t, input, out)
+		sort.Slice(out, func(i, j int) bool {
+			a, _ := strconv.Atoi(out[i])
+			b, _ := strconv.Atoi(out[j])
+			return a < b
+		})
+		require.Equal(t, input, out)
+	}
+}
@@ -0,0 +1,43 @@
+package pacer
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// ShuffleLines reads lines from r and passes each to emit. If cfg.Shuffle is
+// set the lines are emitted in a random order: up to cfg.ShuffleWindow lines
+// are held at once and a random one is emitted each time another is read, so
+// input of any size can be shuffled in bounded memory. A window of 0 reads the
+// whole input before emitting anything.
+func ShuffleLines(r io.Reader, cfg Config, emit func(string)) error {
+	scanner := bufio.NewScanner(r)
+	if !cfg.Shuffle {
+		for scanner.Scan() {
+			emit(scanner.Text())
+		}
+		return scanner.Err()
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var window []string
+	for scanner.Scan() {
+		window = append(window, scanner.Text())
+		if cfg.ShuffleWindow > 0 && len(window) > cfg.ShuffleWindow {
+			i := rng.Intn(len(window))
+			emit(window[i])
+			window[i] = window[len(window)-1]
+			window = window[:len(window)-1]
+		}
+	}
+	rng.Shuffle(len(window), func(i, j int) {
+		window[i], window[j] = window[j], window[i]
+	})
+	for _, line := range window {
+		emit(line)
+	}
+
+	return scanner.Err()
+}
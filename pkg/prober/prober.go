@@ -0,0 +1,474 @@
+// Package prober sends DNS queries over a few long-lived UDP sockets per
+// address family instead of dialing a socket for every query. Responses are
+// matched back to their query by transaction ID and 5-tuple, queries that go
+// unanswered are retransmitted and eventually timed out by a timing wheel, so
+// a single Prober can be shared by thousands of goroutines without running out
+// of ephemeral ports or file descriptors.
+package prober
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// ErrTimeout is returned when no response arrives before the last
+	// attempt times out.
+	ErrTimeout = errors.New("timed out waiting for response")
+
+	// ErrClosed is returned for queries made after, or still waiting when,
+	// the Prober is closed.
+	ErrClosed = errors.New("prober closed")
+)
+
+// Steps of an exchange an Error can come from
+const (
+	OpPack  = "pack"
+	OpDial  = "dial"
+	OpWrite = "write"
+	OpRead  = "read"
+	OpParse = "parse"
+)
+
+// Error records which step of an exchange failed.
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Config controls how a Prober sends queries. The zero value sends from any
+// local address with 4 sockets per address family, waits 5 seconds for a
+// response and never retransmits.
+type Config struct {
+	// SourceV4 and SourceV6 are the local addresses to send from, nil for
+	// any.
+	SourceV4 net.IP
+	SourceV6 net.IP
+	// Port is the port queries are sent to, 53 if 0.
+	Port int
+	// Sockets is the number of sockets opened for each address family.
+	Sockets int
+	// Timeout is how long to wait for a response to each attempt.
+	Timeout time.Duration
+	// Retries is the number of times a query is retransmitted before giving
+	// up on it.
+	Retries int
+	// Tick is the resolution timeouts are checked at, 10ms if 0.
+	Tick time.Duration
+	// Unmatched, if set, is called with every response that doesn't match
+	// a waiting query, including second responses to the same query.
+	Unmatched func(from net.IP, raw []byte)
+}
+
+// Response is a reply to a query, along with when it was sent and received.
+type Response struct {
+	// Query is the packed query that was sent.
+	Query []byte
+	// Raw is the response as received, and Msg the parsed response, nil if
+	// it couldn't be parsed.
+	Raw []byte
+	Msg *dns.Msg
+	// Sent is when the query was first sent, Received when the response
+	// arrived.
+	Sent     time.Time
+	Received time.Time
+	// Attempts is the number of times the query was sent.
+	Attempts int
+}
+
+// RTT returns how long after the query was first sent the response arrived.
+func (r *Response) RTT() time.Duration {
+	return r.Received.Sub(r.Sent)
+}
+
+// Stats counts what a Prober has done.
+type Stats struct {
+	Sent          uint64
+	Retransmitted uint64
+	Received      uint64
+	Timeouts      uint64
+	Unmatched     uint64
+}
+
+// key identifies a waiting query on one socket, so together with the socket
+// it is the full 5-tuple and transaction ID.
+type key struct {
+	addr [16]byte
+	port int
+	id   uint16
+}
+
+func makeKey(ip net.IP, port int, id uint16) key {
+	k := key{port: port, id: id}
+	copy(k.addr[:], ip.To16())
+
+	return k
+}
+
+// pending is a query waiting for its response
+type pending struct {
+	key      key
+	sock     *socket
+	dest     *net.UDPAddr
+	question dns.Question
+	resp     *Response
+	deadline time.Time
+	done     chan error
+}
+
+type socket struct {
+	conn    *net.UDPConn
+	mutex   sync.Mutex
+	pending map[key]*pending
+}
+
+// family is the sockets of one address family, opened on first use
+type family struct {
+	network string
+	source  net.IP
+	once    sync.Once
+	sockets []*socket
+	err     error
+	next    uint32
+}
+
+// Prober sends queries and waits for their responses. It is safe for use by
+// multiple goroutines.
+type Prober struct {
+	cfg    Config
+	v4     *family
+	v6     *family
+	wheel  *wheel
+	stats  Stats
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New returns a Prober using cfg. Sockets are opened the first time a query
+// is sent to an address of their family.
+func New(cfg Config) *Prober {
+	if cfg.Port == 0 {
+		cfg.Port = 53
+	}
+	if cfg.Sockets <= 0 {
+		cfg.Sockets = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Tick <= 0 {
+		cfg.Tick = 10 * time.Millisecond
+	}
+	p := &Prober{
+		cfg:    cfg,
+		v4:     &family{network: "udp4", source: cfg.SourceV4},
+		v6:     &family{network: "udp6", source: cfg.SourceV6},
+		closed: make(chan struct{}),
+	}
+	p.wheel = newWheel(cfg.Tick, cfg.Timeout, time.Now())
+	p.wg.Add(1)
+	go p.runWheel()
+
+	return p
+}
+
+// Stats returns counts of what the Prober has done so far.
+func (p *Prober) Stats() Stats {
+	return Stats{
+		Sent:          atomic.LoadUint64(&p.stats.Sent),
+		Retransmitted: atomic.LoadUint64(&p.stats.Retransmitted),
+		Received:      atomic.LoadUint64(&p.stats.Received),
+		Timeouts:      atomic.LoadUint64(&p.stats.Timeouts),
+		Unmatched:     atomic.LoadUint64(&p.stats.Unmatched),
+	}
+}
+
+// socketFor returns the next socket to send to dest from, opening the
+// sockets of its family if this is the first use.
+func (p *Prober) socketFor(dest net.IP) (*socket, error) {
+	fam := p.v6
+	if dest.To4() != nil {
+		fam = p.v4
+	}
+	fam.once.Do(func() {
+		for i := 0; i < p.cfg.Sockets; i++ {
+			conn, err := net.ListenUDP(fam.network, &net.UDPAddr{IP: fam.source})
+			if err != nil {
+				fam.err = err
+				return
+			}
+			sock := &socket{conn: conn, pending: make(map[key]*pending)}
+			fam.sockets = append(fam.sockets, sock)
+			p.wg.Add(1)
+			go p.readLoop(sock)
+		}
+	})
+	if fam.err != nil {
+		return nil, fam.err
+	}
+	select {
+	case <-p.closed:
+		return nil, ErrClosed
+	default:
+	}
+	i := atomic.AddUint32(&fam.next, 1)
+
+	return fam.sockets[int(i)%len(fam.sockets)], nil
+}
+
+// Send sends m to dest without waiting for a response, returning the packed
+// query.
+func (p *Prober) Send(m *dns.Msg, dest net.IP) ([]byte, error) {
+	query, err := m.Pack()
+	if err != nil {
+		return nil, &Error{OpPack, err}
+	}
+	sock, err := p.socketFor(dest)
+	if err != nil {
+		return query, &Error{OpDial, err}
+	}
+	_, err = sock.conn.WriteToUDP(query, &net.UDPAddr{IP: dest, Port: p.cfg.Port})
+	if err != nil {
+		return query, &Error{OpWrite, err}
+	}
+	atomic.AddUint64(&p.stats.Sent, 1)
+
+	return query, nil
+}
+
+// Exchange sends m to dest and waits for the response, retransmitting it if
+// configured to. If another query with the same ID is already waiting on a
+// response from dest, m is sent with a new random ID. The returned Response is
+// never nil, and holds whatever got done before any error.
+func (p *Prober) Exchange(m *dns.Msg, dest net.IP) (*Response, error) {
+	resp := &Response{}
+	if len(m.Question) != 1 {
+		return resp, &Error{OpPack, errors.New("queries must have exactly one question")}
+	}
+	sock, err := p.socketFor(dest)
+	if err != nil {
+		return resp, &Error{OpDial, err}
+	}
+	pend := &pending{
+		sock:     sock,
+		dest:     &net.UDPAddr{IP: dest, Port: p.cfg.Port},
+		question: m.Question[0],
+		resp:     resp,
+		done:     make(chan error, 1),
+	}
+
+	sock.mutex.Lock()
+	pend.key = makeKey(dest, p.cfg.Port, m.Id)
+	for sock.pending[pend.key] != nil {
+		pend.key.id = uint16(rand.Intn(1 << 16))
+	}
+	if pend.key.id != m.Id {
+		m = m.Copy()
+		m.Id = pend.key.id
+	}
+	resp.Query, err = m.Pack()
+	if err != nil {
+		sock.mutex.Unlock()
+		return resp, &Error{OpPack, err}
+	}
+	sock.pending[pend.key] = pend
+	resp.Sent = time.Now()
+	resp.Attempts = 1
+	pend.deadline = resp.Sent.Add(p.cfg.Timeout)
+	sock.mutex.Unlock()
+
+	if _, err = sock.conn.WriteToUDP(resp.Query, pend.dest); err != nil {
+		p.finish(pend, &Error{OpWrite, err})
+	} else {
+		atomic.AddUint64(&p.stats.Sent, 1)
+		p.wheel.add(pend)
+	}
+
+	return resp, <-pend.done
+}
+
+// finish removes a waiting query and completes its Exchange with err, unless
+// it already completed.
+func (p *Prober) finish(pend *pending, err error) {
+	sock := pend.sock
+	sock.mutex.Lock()
+	if sock.pending[pend.key] != pend {
+		sock.mutex.Unlock()
+		return
+	}
+	delete(sock.pending, pend.key)
+	sock.mutex.Unlock()
+	pend.done <- err
+}
+
+// expire is called by the wheel once a query's deadline passes, and either
+// retransmits it or times it out.
+func (p *Prober) expire(pend *pending, now time.Time) {
+	sock := pend.sock
+	sock.mutex.Lock()
+	if sock.pending[pend.key] != pend {
+		sock.mutex.Unlock()
+		return
+	}
+	if pend.resp.Attempts > p.cfg.Retries {
+		sock.mutex.Unlock()
+		atomic.AddUint64(&p.stats.Timeouts, 1)
+		p.finish(pend, &Error{OpRead, ErrTimeout})
+		return
+	}
+	pend.resp.Attempts++
+	pend.deadline = now.Add(p.cfg.Timeout)
+	sock.mutex.Unlock()
+
+	if _, err := sock.conn.WriteToUDP(pend.resp.Query, pend.dest); err != nil {
+		p.finish(pend, &Error{OpWrite, err})
+		return
+	}
+	atomic.AddUint64(&p.stats.Sent, 1)
+	atomic.AddUint64(&p.stats.Retransmitted, 1)
+	p.wheel.add(pend)
+}
+
+// readLoop matches every packet received on sock to the query waiting for
+// it.
+func (p *Prober) readLoop(sock *socket) {
+	defer p.wg.Done()
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, from, err := sock.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-p.closed:
+				return
+			default:
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return
+		}
+		received := time.Now()
+		raw := make([]byte, n)
+		copy(raw, buf[:n])
+		if !p.match(sock, from, raw, received) {
+			atomic.AddUint64(&p.stats.Unmatched, 1)
+			if p.cfg.Unmatched != nil {
+				p.cfg.Unmatched(from.IP, raw)
+			}
+		}
+	}
+}
+
+// match completes the query raw answers, and reports whether there was one.
+func (p *Prober) match(sock *socket, from *net.UDPAddr, raw []byte, received time.Time) bool {
+	if len(raw) < 2 {
+		return false
+	}
+	k := makeKey(from.IP, from.Port, uint16(raw[0])<<8|uint16(raw[1]))
+	sock.mutex.Lock()
+	pend := sock.pending[k]
+	sock.mutex.Unlock()
+	if pend == nil {
+		return false
+	}
+
+	msg := new(dns.Msg)
+	parseErr := msg.Unpack(raw)
+	if parseErr == nil {
+		// a response to a different question is not the answer to this
+		// query, even if the ID happens to match. Error responses such as
+		// REFUSED or FORMERR often leave the question out, so a response
+		// without one is matched on its ID and addresses alone.
+		if len(msg.Question) != 0 && (len(msg.Question) != 1 ||
+			msg.Question[0].Qtype != pend.question.Qtype ||
+			!strings.EqualFold(msg.Question[0].Name, pend.question.Name)) {
+			return false
+		}
+	}
+
+	sock.mutex.Lock()
+	if sock.pending[k] != pend {
+		sock.mutex.Unlock()
+		return false
+	}
+	delete(sock.pending, k)
+	pend.resp.Raw = raw
+	pend.resp.Received = received
+	if parseErr == nil {
+		pend.resp.Msg = msg
+	}
+	sock.mutex.Unlock()
+	atomic.AddUint64(&p.stats.Received, 1)
+
+	if parseErr != nil {
+		pend.done <- &Error{OpParse, parseErr}
+	} else {
+		pend.done <- nil
+	}
+
+	return true
+}
+
+func (p *Prober) runWheel() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.cfg.Tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case now := <-ticker.C:
+			for _, pend := range p.wheel.advance(now) {
+				p.expire(pend, now)
+			}
+		}
+	}
+}
+
+// Close closes the sockets, failing any queries still waiting with
+// ErrClosed.
+func (p *Prober) Close() error {
+	select {
+	case <-p.closed:
+		return nil
+	default:
+	}
+	close(p.closed)
+	var firstErr error
+	for _, fam := range []*family{p.v4, p.v6} {
+		// make sure the family can't be opened after it has been closed
+		fam.once.Do(func() { fam.err = ErrClosed })
+		for _, sock := range fam.sockets {
+			if err := sock.conn.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			sock.mutex.Lock()
+			var waiting []*pending
+			for _, pend := range sock.pending {
+				waiting = append(waiting, pend)
+			}
+			sock.mutex.Unlock()
+			for _, pend := range waiting {
+				p.finish(pend, &Error{OpRead, ErrClosed})
+			}
+		}
+	}
+	p.wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,170 @@
+package prober
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// serve answers queries on a local socket with reply, until the test ends.
+// reply returns nil to drop a query.
+func serve(t *testing.T, reply func(query *dns.Msg) []*dns.Msg) int {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query := new(dns.Msg)
+			if query.Unpack(buf[:n]) != nil {
+				continue
+			}
+			for _, resp := range reply(query) {
+				out, _ := resp.Pack()
+				conn.WriteToUDP(out, from)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func answer(query *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	rr, _ := dns.NewRR(query.Question[0].Name + " 60 IN A 127.0.0.1")
+	resp.Answer = append(resp.Answer, rr)
+
+	return resp
+}
+
+func question(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+
+	return m
+}
+
+func TestExchange(t *testing.T) {
+	port := serve(t, func(query *dns.Msg) []*dns.Msg {
+		return []*dns.Msg{answer(query)}
+	})
+	p := New(Config{Port: port, Sockets: 2, Timeout: time.Second})
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := question("example.com")
+			// every query shares an ID, so all but the first must be given
+			// new ones to be told apart
+			m.Id = 1
+			resp, err := p.Exchange(m, net.IPv4(127, 0, 0, 1))
+			require.NoError(t, err)
+			require.Equal(t, 1, resp.Attempts)
+			require.Len(t, resp.Msg.Answer, 1)
+			require.Equal(t, resp.Query[:2], resp.Raw[:2])
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, uint64(50), p.Stats().Received)
+}
+
+func TestExchangeRetransmits(t *testing.T) {
+	var mutex sync.Mutex
+	seen := 0
+	port := serve(t, func(query *dns.Msg) []*dns.Msg {
+		mutex.Lock()
+		defer mutex.Unlock()
+		seen++
+		if seen < 3 {
+			return nil
+		}
+		return []*dns.Msg{answer(query)}
+	})
+	p := New(Config{Port: port, Timeout: 50 * time.Millisecond, Retries: 2})
+	defer p.Close()
+
+	resp, err := p.Exchange(question("example.com"), net.IPv4(127, 0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, 3, resp.Attempts)
+	require.Equal(t, uint64(2), p.Stats().Retransmitted)
+}
+
+func TestExchangeTimeout(t *testing.T) {
+	port := serve(t, func(query *dns.Msg) []*dns.Msg {
+		// the wrong question, with the right ID, isn't an answer
+		wrong := answer(question("example.org"))
+		wrong.Id = query.Id
+		return []*dns.Msg{wrong}
+	})
+	var unmatched int32
+	p := New(Config{
+		Port:      port,
+		Timeout:   50 * time.Millisecond,
+		Retries:   1,
+		Unmatched: func(net.IP, []byte) { atomic.AddInt32(&unmatched, 1) },
+	})
+	defer p.Close()
+
+	start := time.Now()
+	resp, err := p.Exchange(question("example.com"), net.IPv4(127, 0, 0, 1))
+	require.True(t, errors.Is(err, ErrTimeout))
+	require.Equal(t, 2, resp.Attempts)
+	require.Nil(t, resp.Msg)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	stats := p.Stats()
+	require.Equal(t, uint64(1), stats.Timeouts)
+	require.Equal(t, uint64(2), stats.Unmatched)
+	require.Equal(t, int32(2), atomic.LoadInt32(&unmatched))
+}
+
+func TestExchangeNoQuestion(t *testing.T) {
+	port := serve(t, func(query *dns.Msg) []*dns.Msg {
+		// refusals often leave the question out, but are still the answer
+		refused := new(dns.Msg)
+		refused.SetRcode(query, dns.RcodeRefused)
+		refused.Question = nil
+		return []*dns.Msg{refused}
+	})
+	p := New(Config{Port: port, Timeout: time.Second})
+	defer p.Close()
+
+	resp, err := p.Exchange(question("example.com"), net.IPv4(127, 0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, resp.Msg.Rcode)
+	require.Empty(t, resp.Msg.Question)
+	require.Equal(t, uint64(0), p.Stats().Unmatched)
+}
+
+func TestClose(t *testing.T) {
+	port := serve(t, func(*dns.Msg) []*dns.Msg { return nil })
+	p := New(Config{Port: port, Timeout: time.Minute})
+
+	errChan := make(chan error)
+	go func() {
+		_, err := p.Exchange(question("example.com"), net.IPv4(127, 0, 0, 1))
+		errChan <- err
+	}()
+	// wait for the query to be sent
+	for p.Stats().Sent == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, p.Close())
+	require.True(t, errors.Is(<-errChan, ErrClosed))
+
+	_, err := p.Exchange(question("example.com"), net.IPv4(127, 0, 0, 1))
+	require.True(t, errors.Is(err, ErrClosed))
+}
@@ -0,0 +1,76 @@
+package prober
+
+import (
+	"sync"
+	"time"
+)
+
+// wheel is a timing wheel holding waiting queries in slots by the tick their
+// deadline falls in, so expiring them costs the same however many are
+// waiting.
+type wheel struct {
+	mutex sync.Mutex
+	tick  time.Duration
+	start time.Time
+	// ticks is the number of ticks processed since start
+	ticks int64
+	slots [][]*pending
+}
+
+// newWheel returns a wheel with enough slots that any deadline up to span
+// away lands within one turn.
+func newWheel(tick, span time.Duration, start time.Time) *wheel {
+	return &wheel{
+		tick:  tick,
+		start: start,
+		slots: make([][]*pending, int(span/tick)+2),
+	}
+}
+
+// add schedules pend to be expired at its deadline.
+func (w *wheel) add(pend *pending) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.schedule(pend)
+}
+
+// schedule puts pend in the slot of the tick its deadline falls in. The mutex
+// must be held.
+func (w *wheel) schedule(pend *pending) {
+	// round up so a query is never expired before its deadline
+	t := int64((pend.deadline.Sub(w.start) + w.tick - 1) / w.tick)
+	if t <= w.ticks {
+		t = w.ticks + 1
+	}
+	// deadlines further away than a turn are rescheduled when their slot
+	// comes up
+	if max := w.ticks + int64(len(w.slots)) - 1; t > max {
+		t = max
+	}
+	i := t % int64(len(w.slots))
+	w.slots[i] = append(w.slots[i], pend)
+}
+
+// advance processes every tick up to now, returning the queries whose
+// deadlines have passed.
+func (w *wheel) advance(now time.Time) []*pending {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var expired []*pending
+	target := int64(now.Sub(w.start) / w.tick)
+	for w.ticks < target {
+		w.ticks++
+		i := w.ticks % int64(len(w.slots))
+		slot := w.slots[i]
+		w.slots[i] = nil
+		for _, pend := range slot {
+			if pend.deadline.After(now) {
+				w.schedule(pend)
+				continue
+			}
+			expired = append(expired, pend)
+		}
+	}
+
+	return expired
+}
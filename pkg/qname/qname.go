@@ -0,0 +1,76 @@
+// Package qname encodes a resolver's address into the query names sent by
+// cmd/probe --prefix, and decodes them again from the queries that reach our
+// name servers. An address is encoded as the first label with its separators
+// replaced by dashes, optionally followed by an index from _a to _z:
+//
+//	192-0-2-1.v6.tlsfingerprint.io
+//	2001-db8--1_c.v4.tlsfingerprint.io
+package qname
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MaxIndex is the largest query index that can be encoded, indexes are the
+// letters a to z.
+const MaxIndex = 25
+
+var (
+	// ErrNotSubdomain is returned when a query name isn't under the expected
+	// base domain.
+	ErrNotSubdomain = errors.New("query name is not under base domain")
+
+	// ErrInvalidAddress is returned when the first label of a query name
+	// doesn't decode to an address.
+	ErrInvalidAddress = errors.New("query name does not encode an address")
+)
+
+// Encode returns the query name for ip under baseDomain. index is only added
+// when it is not negative, and must be at most MaxIndex.
+func Encode(ip net.IP, index int, baseDomain string) string {
+	var label string
+	if ip.To4() != nil {
+		label = strings.ReplaceAll(ip.To4().String(), ".", "-")
+	} else {
+		label = strings.ReplaceAll(ip.String(), ":", "-")
+	}
+	if index >= 0 {
+		label += "_" + string(rune('a'+index))
+	}
+
+	return label + "." + baseDomain
+}
+
+// Decode returns the address and index encoded in name. index is -1 when the
+// name has no index. If baseDomain isn't empty name must be a subdomain of it.
+// Resolvers may randomize the case of query names, so comparisons ignore case.
+func Decode(name, baseDomain string) (ip net.IP, index int, err error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	baseDomain = strings.TrimSuffix(strings.ToLower(baseDomain), ".")
+	if baseDomain != "" && !strings.HasSuffix(name, "."+baseDomain) {
+		return nil, -1, fmt.Errorf("%w: %q", ErrNotSubdomain, name)
+	}
+
+	label := name
+	if dot := strings.Index(name, "."); dot >= 0 {
+		label = name[:dot]
+	}
+	index = -1
+	if n := len(label); n > 2 && label[n-2] == '_' &&
+		label[n-1] >= 'a' && label[n-1] <= 'a'+MaxIndex {
+		index = int(label[n-1] - 'a')
+		label = label[:n-2]
+	}
+
+	if ip = net.ParseIP(strings.ReplaceAll(label, "-", ".")); ip != nil && ip.To4() != nil {
+		return ip.To4(), index, nil
+	}
+	if ip = net.ParseIP(strings.ReplaceAll(label, "-", ":")); ip != nil && ip.To4() == nil {
+		return ip, index, nil
+	}
+
+	return nil, -1, fmt.Errorf("%w: %q", ErrInvalidAddress, name)
+}
@@ -0,0 +1,48 @@
+package qname
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	for _, tc := range []struct {
+		ip    string
+		index int
+		name  string
+	}{
+		{"192.0.2.1", -1, "192-0-2-1.v6.tlsfingerprint.io"},
+		{"2001:db8::1", -1, "2001-db8--1.v4.tlsfingerprint.io"},
+		{"2001:db8::1", 2, "2001-db8--1_c.v4.tlsfingerprint.io"},
+		{"::ffff:192.0.2.1", 25, "192-0-2-1_z.v4.tlsfingerprint.io"},
+	} {
+		ip := net.ParseIP(tc.ip)
+		base := tc.name[len(tc.name)-len("v4.tlsfingerprint.io"):]
+		require.Equal(t, tc.name, Encode(ip, tc.index, base))
+
+		decoded, index, err := Decode(tc.name+".", base)
+		require.Nil(t, err, tc.name)
+		require.True(t, ip.Equal(decoded), tc.name)
+		require.Equal(t, tc.index, index)
+	}
+}
+
+func TestDecodeCaseAndErrors(t *testing.T) {
+	// 0x20 encoding randomizes case
+	ip, index, err := Decode("2001-DB8--1_B.V4.TlsFingerprint.io", "v4.tlsfingerprint.io")
+	require.Nil(t, err)
+	require.Equal(t, "2001:db8::1", ip.String())
+	require.Equal(t, 1, index)
+
+	ip, _, err = Decode("192-0-2-1.anything.example", "")
+	require.Nil(t, err)
+	require.Equal(t, "192.0.2.1", ip.String())
+
+	_, _, err = Decode("192-0-2-1.example.com", "v4.tlsfingerprint.io")
+	require.True(t, errors.Is(err, ErrNotSubdomain))
+	_, _, err = Decode("www.v4.tlsfingerprint.io", "v4.tlsfingerprint.io")
+	require.True(t, errors.Is(err, ErrInvalidAddress))
+}
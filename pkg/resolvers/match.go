@@ -0,0 +1,131 @@
+package resolvers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+)
+
+// CountryFunc returns the country code of an address, or "" if it is unknown.
+type CountryFunc func(net.IP) string
+
+// Matches collects which addresses were seen querying our name servers for
+// which encoded addresses. A resolver probed at one address that forwards the
+// query from an address of the other family reveals that both addresses
+// belong to it.
+type Matches struct {
+	seen map[string]map[string]net.IP
+}
+
+// NewMatches returns an empty Matches.
+func NewMatches() *Matches {
+	return &Matches{seen: make(map[string]map[string]net.IP)}
+}
+
+// Add records a query for encoded sent from source. Queries where both
+// addresses are the same family say nothing about pairs and are ignored.
+func (m *Matches) Add(source, encoded net.IP) {
+	if (source.To4() == nil) == (encoded.To4() == nil) {
+		return
+	}
+	if m.seen[source.String()] == nil {
+		m.seen[source.String()] = make(map[string]net.IP)
+	}
+	m.seen[source.String()][encoded.String()] = encoded
+}
+
+// Len returns the number of distinct source addresses seen.
+func (m *Matches) Len() int {
+	return len(m.seen)
+}
+
+// Pairs returns the resolver pairs that can be trusted:
+//
+//   - sources seen querying for more than one address are dropped,
+//   - pairs are dropped unless both addresses have the same, known, country,
+//   - any address left in more than one pair is dropped.
+//
+// The pairs are sorted by their v6 address.
+func (m *Matches) Pairs(country CountryFunc) []Pair {
+	var candidates []Pair
+	for sourceStr, encodedSet := range m.seen {
+		if len(encodedSet) != 1 {
+			continue
+		}
+		source := net.ParseIP(sourceStr)
+		pair := Pair{V6: source, V4: source.To4()}
+		for _, encoded := range encodedSet {
+			if source.To4() == nil {
+				pair.V4 = encoded.To4()
+			} else {
+				pair.V6 = encoded
+			}
+		}
+
+		cc6, cc4 := country(pair.V6), country(pair.V4)
+		if cc6 == "" || cc6 != cc4 {
+			continue
+		}
+		pair.CountryCode = cc6
+		candidates = append(candidates, pair)
+	}
+
+	v6Count := make(map[string]int)
+	v4Count := make(map[string]int)
+	for _, pair := range candidates {
+		v6Count[pair.V6.String()]++
+		v4Count[pair.V4.String()]++
+	}
+	var ret []Pair
+	for _, pair := range candidates {
+		if v6Count[pair.V6.String()] == 1 && v4Count[pair.V4.String()] == 1 {
+			ret = append(ret, pair)
+		}
+	}
+	sortPairs(ret)
+
+	return ret
+}
+
+// Intersect returns the pairs found in both a and b, such as the pairs found
+// probing the v4 addresses and those found probing the v6 addresses.
+func Intersect(a, b []Pair) []Pair {
+	inB := make(map[string]bool)
+	for _, pair := range b {
+		inB[pair.String()] = true
+	}
+	var ret []Pair
+	for _, pair := range a {
+		if inB[pair.String()] {
+			ret = append(ret, pair)
+		}
+	}
+	sortPairs(ret)
+
+	return ret
+}
+
+// String formats a pair as a line of a resolver pair file.
+func (p Pair) String() string {
+	return fmt.Sprintf("%s %s %s", p.V6, p.V4, p.CountryCode)
+}
+
+// WritePairs writes pairs in the resolver pair file format read by ReadPairs.
+func WritePairs(w io.Writer, pairs []Pair) error {
+	bw := bufio.NewWriter(w)
+	for _, pair := range pairs {
+		if _, err := fmt.Fprintln(bw, pair); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func sortPairs(pairs []Pair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].String() < pairs[j].String()
+	})
+}
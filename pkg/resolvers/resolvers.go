@@ -0,0 +1,118 @@
+// Package resolvers reads the resolver pair files used to pick which resolvers
+// are measured. Each line of a pair file has the IPv6 and IPv4 address of what
+// is believed to be the same resolver, followed by its country code:
+//
+//	2001:8f8:174c:90b9::1  2.50.28.71  AE
+//
+// Lines containing "!!" mark pairs whose addresses geolocate to different
+// countries and are skipped.
+package resolvers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrMismatchedCountry is returned for pairs whose v6 and v4 addresses
+	// are in different countries.
+	ErrMismatchedCountry = errors.New("resolver pair countries differ")
+
+	// ErrInvalidPair is returned for lines that don't have two valid
+	// addresses and a country code.
+	ErrInvalidPair = errors.New("invalid resolver pair")
+)
+
+// Pair is a v6 and v4 address believed to belong to the same resolver.
+type Pair struct {
+	V6          net.IP
+	V4          net.IP
+	CountryCode string
+}
+
+// ParsePair parses a single line of a resolver pair file.
+func ParsePair(line string) (Pair, error) {
+	if strings.Contains(line, "!!") {
+		return Pair{}, ErrMismatchedCountry
+	}
+	// fields may be separated by one or two spaces
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return Pair{}, fmt.Errorf("%w: %q", ErrInvalidPair, line)
+	}
+	pair := Pair{
+		V6:          net.ParseIP(fields[0]),
+		V4:          net.ParseIP(fields[1]),
+		CountryCode: fields[2],
+	}
+	if pair.V6 == nil || pair.V6.To4() != nil ||
+		pair.V4 == nil || pair.V4.To4() == nil {
+		return Pair{}, fmt.Errorf("%w: %q", ErrInvalidPair, line)
+	}
+	pair.V4 = pair.V4.To4()
+
+	return pair, nil
+}
+
+// ReadPairs reads every usable pair from r. Pairs with mismatched countries
+// are skipped, as are malformed lines, which are passed to skip with their
+// line number if it isn't nil, so one bad line doesn't lose a whole file. Only
+// failing to read r is an error.
+func ReadPairs(r io.Reader, skip func(line int, err error)) ([]Pair, error) {
+	var ret []Pair
+	scanner := bufio.NewScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		pair, err := ParsePair(scanner.Text())
+		if errors.Is(err, ErrMismatchedCountry) {
+			continue
+		}
+		if err != nil {
+			if skip != nil {
+				skip(lineNum, err)
+			}
+			continue
+		}
+		ret = append(ret, pair)
+	}
+
+	return ret, scanner.Err()
+}
+
+// ReadPairsFile reads every usable pair from the file at path, passing
+// malformed lines to skip as ReadPairs does.
+func ReadPairsFile(path string, skip func(line int, err error)) ([]Pair, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pairs, err := ReadPairs(f, skip)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return pairs, nil
+}
+
+// CountryCodes maps the string form of every address in pairs to its country
+// code.
+func CountryCodes(pairs []Pair) map[string]string {
+	ret := make(map[string]string, 2*len(pairs))
+	for _, pair := range pairs {
+		ret[pair.V6.String()] = pair.CountryCode
+		ret[pair.V4.String()] = pair.CountryCode
+	}
+
+	return ret
+}
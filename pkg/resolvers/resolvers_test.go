@@ -0,0 +1,96 @@
+package resolvers
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePair(t *testing.T) {
+	for _, line := range []string{
+		"2001:8f8:174c:90b9::1  2.50.28.71  AE",
+		"2001:8f8:174c:90b9::1 2.50.28.71 AE",
+	} {
+		pair, err := ParsePair(line)
+		require.Nil(t, err, line)
+		require.Equal(t, "2001:8f8:174c:90b9::1", pair.V6.String())
+		require.Equal(t, "2.50.28.71", pair.V4.String())
+		require.Equal(t, "AE", pair.CountryCode)
+	}
+
+	_, err := ParsePair("2.50.28.71  2001:8f8:174c:90b9::1  AE")
+	require.True(t, errors.Is(err, ErrInvalidPair))
+	_, err = ParsePair("2001:8f8:174c:90b9::1  2.50.28.71")
+	require.True(t, errors.Is(err, ErrInvalidPair))
+}
+
+func TestReadPairs(t *testing.T) {
+	input := "2001:8f8:174c:90b9::1  2.50.28.71  AE\n" +
+		"2002:536f:19da::536f:19da  83.111.25.218  AE!!US\n" +
+		"\n" +
+		"2002:6784:6287::6784:6287  103.132.98.135  AF\n"
+	pairs, err := ReadPairs(strings.NewReader(input), nil)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(pairs))
+
+	ccs := CountryCodes(pairs)
+	require.Equal(t, "AF", ccs["103.132.98.135"])
+	require.Equal(t, "AE", ccs["2001:8f8:174c:90b9::1"])
+
+	// malformed lines are skipped, not fatal
+	var skipped []int
+	pairs, err = ReadPairs(strings.NewReader(
+		"not a pair\n"+input+"2001:db8::1  192.0.2.1\n",
+	), func(line int, err error) {
+		require.True(t, errors.Is(err, ErrInvalidPair))
+		skipped = append(skipped, line)
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(pairs))
+	require.Equal(t, []int{1, 6}, skipped)
+}
+
+func TestMatchesPairs(t *testing.T) {
+	countries := map[string]string{
+		"2001:db8::1": "US", "192.0.2.1": "US",
+		"2001:db8::2": "US", "192.0.2.2": "CA",
+		"2001:db8::3": "US", "192.0.2.3": "US", "192.0.2.4": "US",
+		"2001:db8::5": "FR", "2001:db8::6": "FR", "192.0.2.5": "FR",
+	}
+	country := func(ip net.IP) string { return countries[ip.String()] }
+
+	m := NewMatches()
+	m.Add(net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1"))
+	// seen twice is still one mapping
+	m.Add(net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1"))
+	// countries differ
+	m.Add(net.ParseIP("2001:db8::2"), net.ParseIP("192.0.2.2"))
+	// one source for two addresses
+	m.Add(net.ParseIP("2001:db8::3"), net.ParseIP("192.0.2.3"))
+	m.Add(net.ParseIP("2001:db8::3"), net.ParseIP("192.0.2.4"))
+	// two sources for the same address
+	m.Add(net.ParseIP("2001:db8::5"), net.ParseIP("192.0.2.5"))
+	m.Add(net.ParseIP("2001:db8::6"), net.ParseIP("192.0.2.5"))
+	// same family
+	m.Add(net.ParseIP("2001:db8::7"), net.ParseIP("2001:db8::8"))
+
+	pairs := m.Pairs(country)
+	require.Equal(t, 1, len(pairs))
+	require.Equal(t, "2001:db8::1 192.0.2.1 US", pairs[0].String())
+
+	// the other direction, v4 sources querying for v6 names
+	other := NewMatches()
+	other.Add(net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1"))
+	other.Add(net.ParseIP("192.0.2.3"), net.ParseIP("2001:db8::3"))
+	both := Intersect(pairs, other.Pairs(country))
+	require.Equal(t, pairs, both)
+
+	var out strings.Builder
+	require.Nil(t, WritePairs(&out, both))
+	read, err := ReadPairs(strings.NewReader(out.String()), nil)
+	require.Nil(t, err)
+	require.Equal(t, both, read)
+}
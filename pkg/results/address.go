@@ -0,0 +1,136 @@
+package results
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/timartiny/v4vsv6"
+)
+
+// AddressResultReader iterates over a JSON lines stream of AddressResults.
+//
+//	rdr, err := results.OpenAddressResultReader(path)
+//	...
+//	defer rdr.Close()
+//	for rdr.Next() {
+//		ar := rdr.AddressResult()
+//		...
+//	}
+//	if err := rdr.Err(); err != nil {
+//		...
+//	}
+type AddressResultReader struct {
+	lr  *LineReader
+	ar  *v4vsv6.AddressResult
+	err error
+}
+
+// NewAddressResultReader returns an AddressResultReader reading from r. If r is
+// an io.Closer it will be closed by Close.
+func NewAddressResultReader(r io.Reader) *AddressResultReader {
+	return &AddressResultReader{lr: NewLineReader(r, "")}
+}
+
+// OpenAddressResultReader opens the (possibly compressed) file at path and
+// returns an AddressResultReader for it. Errors will include the path and line
+// number.
+func OpenAddressResultReader(path string) (*AddressResultReader, error) {
+	f, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &AddressResultReader{lr: NewLineReader(f, path)}, nil
+}
+
+// Next decodes the next AddressResult, returning false at the end of the
+// stream or on the first error, which is then available from Err.
+func (r *AddressResultReader) Next() bool {
+	if r.err != nil || !r.lr.Next() {
+		return false
+	}
+	ar := new(v4vsv6.AddressResult)
+	if err := json.Unmarshal(r.lr.Bytes(), ar); err != nil {
+		r.err = r.lr.LineError(err)
+		return false
+	}
+	r.ar = ar
+
+	return true
+}
+
+// AddressResult returns the result decoded by the last call to Next.
+func (r *AddressResultReader) AddressResult() *v4vsv6.AddressResult {
+	return r.ar
+}
+
+// Line returns the line number of the last line read.
+func (r *AddressResultReader) Line() int {
+	return r.lr.Line()
+}
+
+// Err returns the first error encountered, if any, as a *LineError.
+func (r *AddressResultReader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.lr.Err()
+}
+
+// Close closes the underlying file.
+func (r *AddressResultReader) Close() error {
+	return r.lr.Close()
+}
+
+// FanOut decodes the rest of the stream using workers goroutines and sends
+// every AddressResult to arChan, which it does not close. Results are
+// not sent in file order. It returns the first error encountered.
+func (r *AddressResultReader) FanOut(
+	workers int,
+	arChan chan<- *v4vsv6.AddressResult,
+) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.err = fanOut(r.lr, workers, func(rl rawLine) error {
+		ar := new(v4vsv6.AddressResult)
+		if err := json.Unmarshal(rl.data, ar); err != nil {
+			return err
+		}
+		arChan <- ar
+		return nil
+	})
+
+	return r.err
+}
+
+// AddressResultWriter writes AddressResults as JSON lines. It is safe for
+// concurrent use.
+type AddressResultWriter struct {
+	jw *jsonWriter
+}
+
+// NewAddressResultWriter returns an AddressResultWriter writing to w. If w is an
+// io.Closer it will be closed by Close.
+func NewAddressResultWriter(w io.Writer) *AddressResultWriter {
+	return &AddressResultWriter{jw: newJSONWriter(w)}
+}
+
+// CreateAddressResultWriter creates the file at path, compressed according to
+// its extension, and returns an AddressResultWriter for it.
+func CreateAddressResultWriter(path string) (*AddressResultWriter, error) {
+	f, err := Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewAddressResultWriter(f), nil
+}
+
+// Write writes a single AddressResult on its own line.
+func (w *AddressResultWriter) Write(ar *v4vsv6.AddressResult) error {
+	return w.jw.write(ar)
+}
+
+// Close flushes and closes the underlying file.
+func (w *AddressResultWriter) Close() error {
+	return w.jw.close()
+}
@@ -0,0 +1,135 @@
+package results
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/timartiny/v4vsv6"
+)
+
+// DRRReader iterates over a JSON lines stream of DomainResolverResults.
+//
+//	rdr, err := results.OpenDRRReader(path)
+//	...
+//	defer rdr.Close()
+//	for rdr.Next() {
+//		drr := rdr.DomainResolverResult()
+//		...
+//	}
+//	if err := rdr.Err(); err != nil {
+//		...
+//	}
+type DRRReader struct {
+	lr  *LineReader
+	drr *v4vsv6.DomainResolverResult
+	err error
+}
+
+// NewDRRReader returns a DRRReader reading from r. If r is an io.Closer it will
+// be closed by Close.
+func NewDRRReader(r io.Reader) *DRRReader {
+	return &DRRReader{lr: NewLineReader(r, "")}
+}
+
+// OpenDRRReader opens the (possibly compressed) file at path and returns a
+// DRRReader for it. Errors will include the path and line number.
+func OpenDRRReader(path string) (*DRRReader, error) {
+	f, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DRRReader{lr: NewLineReader(f, path)}, nil
+}
+
+// Next decodes the next DomainResolverResult, returning false at the end of
+// the stream or on the first error, which is then available from Err.
+func (r *DRRReader) Next() bool {
+	if r.err != nil || !r.lr.Next() {
+		return false
+	}
+	drr := new(v4vsv6.DomainResolverResult)
+	if err := json.Unmarshal(r.lr.Bytes(), drr); err != nil {
+		r.err = r.lr.LineError(err)
+		return false
+	}
+	r.drr = drr
+
+	return true
+}
+
+// DomainResolverResult returns the result decoded by the last call to Next.
+func (r *DRRReader) DomainResolverResult() *v4vsv6.DomainResolverResult {
+	return r.drr
+}
+
+// Line returns the line number of the last line read.
+func (r *DRRReader) Line() int {
+	return r.lr.Line()
+}
+
+// Err returns the first error encountered, if any, as a *LineError.
+func (r *DRRReader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.lr.Err()
+}
+
+// Close closes the underlying file.
+func (r *DRRReader) Close() error {
+	return r.lr.Close()
+}
+
+// FanOut decodes the rest of the stream using workers goroutines and sends
+// every DomainResolverResult to drrChan, which it does not close. Results are
+// not sent in file order. It returns the first error encountered.
+func (r *DRRReader) FanOut(
+	workers int,
+	drrChan chan<- *v4vsv6.DomainResolverResult,
+) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.err = fanOut(r.lr, workers, func(rl rawLine) error {
+		drr := new(v4vsv6.DomainResolverResult)
+		if err := json.Unmarshal(rl.data, drr); err != nil {
+			return err
+		}
+		drrChan <- drr
+		return nil
+	})
+
+	return r.err
+}
+
+// DRRWriter writes DomainResolverResults as JSON lines. It is safe for
+// concurrent use.
+type DRRWriter struct {
+	jw *jsonWriter
+}
+
+// NewDRRWriter returns a DRRWriter writing to w. If w is an io.Closer it will be
+// closed by Close.
+func NewDRRWriter(w io.Writer) *DRRWriter {
+	return &DRRWriter{jw: newJSONWriter(w)}
+}
+
+// CreateDRRWriter creates the file at path, compressed according to its
+// extension, and returns a DRRWriter for it.
+func CreateDRRWriter(path string) (*DRRWriter, error) {
+	f, err := Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewDRRWriter(f), nil
+}
+
+// Write writes a single DomainResolverResult on its own line.
+func (w *DRRWriter) Write(drr *v4vsv6.DomainResolverResult) error {
+	return w.jw.write(drr)
+}
+
+// Close flushes and closes the underlying file.
+func (w *DRRWriter) Close() error {
+	return w.jw.close()
+}
@@ -0,0 +1,255 @@
+// Package results reads and writes the JSON lines files of DomainResolverResults
+// and AddressResults produced and consumed by the v4vsv6 commands. Files ending
+// in ".gz" or ".zst" are transparently (de)compressed.
+package results
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// MaxLineSize is the longest line, in bytes, that a Reader will accept. A
+// single DomainResolverResult can have hundreds of answers across many rounds,
+// so this is far larger than bufio.Scanner's default of 64KB.
+const MaxLineSize = 64 * 1024 * 1024
+
+var (
+	// ErrLineTooLong is returned when a line is longer than MaxLineSize.
+	ErrLineTooLong = errors.New("line exceeds maximum line size")
+)
+
+// LineError records an error found on a particular line of a results file.
+type LineError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// multiCloser closes a decompressor (or compressor) before the underlying file
+type multiCloser struct {
+	closers []func() error
+}
+
+func (mc *multiCloser) Close() error {
+	var first error
+	for _, c := range mc.closers {
+		if err := c(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type readCloser struct {
+	io.Reader
+	multiCloser
+}
+
+type writeCloser struct {
+	io.Writer
+	multiCloser
+}
+
+// Open opens the file at path for reading, decompressing it if the name ends
+// in ".gz" or ".zst".
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{gr, multiCloser{[]func() error{gr.Close, f.Close}}}, nil
+	case strings.HasSuffix(path, ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		zClose := func() error {
+			zr.Close()
+			return nil
+		}
+		return &readCloser{zr, multiCloser{[]func() error{zClose, f.Close}}}, nil
+	}
+
+	return f, nil
+}
+
+// Create creates (or truncates) the file at path for writing, compressing it
+// if the name ends in ".gz" or ".zst". Writes are buffered, so Close must be
+// called to flush them.
+func Create(path string) (io.WriteCloser, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.Writer = f
+	closers := []func() error{}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		gw := gzip.NewWriter(f)
+		w = gw
+		closers = append(closers, gw.Close)
+	case strings.HasSuffix(path, ".zst"):
+		zw, err := zstd.NewWriter(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		w = zw
+		closers = append(closers, zw.Close)
+	}
+	bw := bufio.NewWriter(w)
+	closers = append([]func() error{bw.Flush}, closers...)
+	closers = append(closers, f.Close)
+
+	return &writeCloser{bw, multiCloser{closers}}, nil
+}
+
+// LineReader reads newline separated lines of any length up to MaxLineSize and
+// keeps track of the line number for error reporting. Empty lines are skipped.
+type LineReader struct {
+	path   string
+	rdr    *bufio.Reader
+	closer io.Closer
+	line   int
+	buf    []byte
+	err    error
+}
+
+// NewLineReader returns a LineReader reading from r. path is only used in
+// errors, and may be empty. If r is an io.Closer it will be closed by Close.
+func NewLineReader(r io.Reader, path string) *LineReader {
+	lr := &LineReader{
+		path: path,
+		rdr:  bufio.NewReaderSize(r, 1024*1024),
+	}
+	if c, ok := r.(io.Closer); ok {
+		lr.closer = c
+	}
+	return lr
+}
+
+// OpenLineReader opens the (possibly compressed) file at path and returns a
+// LineReader for it.
+func OpenLineReader(path string) (*LineReader, error) {
+	f, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLineReader(f, path), nil
+}
+
+// Next reads the next non-empty line, returning false at the end of the input
+// or on an error.
+func (lr *LineReader) Next() bool {
+	if lr.err != nil {
+		return false
+	}
+	for {
+		lr.buf = lr.buf[:0]
+		for {
+			chunk, err := lr.rdr.ReadSlice('\n')
+			lr.buf = append(lr.buf, chunk...)
+			if len(lr.buf) > MaxLineSize {
+				lr.line++
+				lr.err = lr.LineError(ErrLineTooLong)
+				return false
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil {
+				if err != io.EOF {
+					lr.err = lr.LineError(err)
+					return false
+				}
+				if len(lr.buf) == 0 {
+					return false
+				}
+				// last line has no trailing newline
+				lr.err = io.EOF
+			}
+			break
+		}
+		lr.line++
+		lr.buf = trimNewline(lr.buf)
+		if len(lr.buf) > 0 {
+			return true
+		}
+		if lr.err != nil {
+			return false
+		}
+	}
+}
+
+// Bytes returns the line read by the last call to Next. The slice is only
+// valid until the next call to Next.
+func (lr *LineReader) Bytes() []byte {
+	return lr.buf
+}
+
+// Line returns the number of the line read by the last call to Next.
+func (lr *LineReader) Line() int {
+	return lr.line
+}
+
+// LineError wraps err in a *LineError for the current line.
+func (lr *LineReader) LineError(err error) error {
+	return &LineError{Path: lr.path, Line: lr.line, Err: err}
+}
+
+// Err returns the first error encountered, ignoring the end of the input.
+func (lr *LineReader) Err() error {
+	if lr.err == io.EOF {
+		return nil
+	}
+	return lr.err
+}
+
+// Close closes the underlying file.
+func (lr *LineReader) Close() error {
+	if lr.closer == nil {
+		return nil
+	}
+	return lr.closer.Close()
+}
+
+func trimNewline(b []byte) []byte {
+	for len(b) > 0 && (b[len(b)-1] == '\n' || b[len(b)-1] == '\r') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// rawLine is a copy of a line, with its number, handed to decoding workers.
+type rawLine struct {
+	line int
+	data []byte
+}
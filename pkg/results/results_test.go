@@ -0,0 +1,142 @@
+package results
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6"
+)
+
+func testDRRs(n int) []*v4vsv6.DomainResolverResult {
+	drrs := make([]*v4vsv6.DomainResolverResult, n)
+	for i := range drrs {
+		drrs[i] = &v4vsv6.DomainResolverResult{
+			Domain:     fmt.Sprintf("domain-%d.com", i),
+			ResolverIP: "1.1.1.1",
+		}
+		drrs[i].SetRoundResults(1, []*v4vsv6.AddressResult{
+			{IP: "2.2.2.2", Domain: drrs[i].Domain},
+		})
+	}
+	return drrs
+}
+
+func TestDRRRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"drrs.json", "drrs.json.gz", "drrs.json.zst"} {
+		path := filepath.Join(dir, name)
+		w, err := CreateDRRWriter(path)
+		require.Nil(t, err)
+		for _, drr := range testDRRs(100) {
+			require.Nil(t, w.Write(drr))
+		}
+		require.Nil(t, w.Close())
+
+		r, err := OpenDRRReader(path)
+		require.Nil(t, err)
+		n := 0
+		for r.Next() {
+			require.Equal(t, fmt.Sprintf("domain-%d.com", n), r.DomainResolverResult().Domain)
+			require.Equal(t, "2.2.2.2", r.DomainResolverResult().RoundResults(1)[0].IP)
+			n++
+		}
+		require.Nil(t, r.Err())
+		require.Nil(t, r.Close())
+		require.Equal(t, 100, n, name)
+	}
+}
+
+func TestAddressResultRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ars.json", "ars.json.gz", "ars.json.zst"} {
+		path := filepath.Join(dir, name)
+		w, err := CreateAddressResultWriter(path)
+		require.Nil(t, err)
+		for i := 0; i < 100; i++ {
+			require.Nil(t, w.Write(&v4vsv6.AddressResult{
+				IP:          fmt.Sprintf("2.2.2.%d", i),
+				AddressType: "A",
+				Domain:      fmt.Sprintf("domain-%d.com", i),
+				SupportsTLS: i%2 == 0,
+			}))
+		}
+		require.Nil(t, w.Close())
+
+		r, err := OpenAddressResultReader(path)
+		require.Nil(t, err)
+		n := 0
+		for r.Next() {
+			ar := r.AddressResult()
+			require.Equal(t, fmt.Sprintf("domain-%d.com", n), ar.Domain)
+			require.Equal(t, fmt.Sprintf("2.2.2.%d", n), ar.IP)
+			require.Equal(t, n%2 == 0, ar.SupportsTLS)
+			require.Equal(t, n+1, r.Line())
+			n++
+		}
+		require.Nil(t, r.Err())
+		require.Nil(t, r.Close())
+		require.Equal(t, 100, n, name)
+	}
+}
+
+func TestDRRReaderLongLine(t *testing.T) {
+	drr := testDRRs(1)[0]
+	drr.Domain = strings.Repeat("a", 200*1024)
+	var sb strings.Builder
+	w := NewDRRWriter(&sb)
+	require.Nil(t, w.Write(drr))
+
+	r := NewDRRReader(strings.NewReader(sb.String()))
+	require.True(t, r.Next())
+	require.Equal(t, drr.Domain, r.DomainResolverResult().Domain)
+	require.False(t, r.Next())
+	require.Nil(t, r.Err())
+}
+
+func TestDRRReaderLineError(t *testing.T) {
+	input := "{\"domain\":\"a.com\"}\n\n{\"domain\":\"b.com\"}\n{\"domain\":\n"
+	r := NewDRRReader(strings.NewReader(input))
+	require.True(t, r.Next())
+	require.True(t, r.Next())
+	require.False(t, r.Next())
+
+	var lineErr *LineError
+	require.True(t, errors.As(r.Err(), &lineErr))
+	require.Equal(t, 4, lineErr.Line)
+}
+
+func TestDRRFanOut(t *testing.T) {
+	var sb strings.Builder
+	w := NewDRRWriter(&sb)
+	for _, drr := range testDRRs(1000) {
+		require.Nil(t, w.Write(drr))
+	}
+
+	r := NewDRRReader(strings.NewReader(sb.String()))
+	drrChan := make(chan *v4vsv6.DomainResolverResult)
+	seen := make(map[string]struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for drr := range drrChan {
+			seen[drr.Domain] = struct{}{}
+		}
+	}()
+	require.Nil(t, r.FanOut(8, drrChan))
+	close(drrChan)
+	wg.Wait()
+	require.Equal(t, 1000, len(seen))
+
+	r = NewDRRReader(strings.NewReader(sb.String() + "not json\n"))
+	drrChan = make(chan *v4vsv6.DomainResolverResult, 1000)
+	err := r.FanOut(8, drrChan)
+	var lineErr *LineError
+	require.True(t, errors.As(err, &lineErr))
+	require.Equal(t, 1001, lineErr.Line)
+}
@@ -0,0 +1,87 @@
+package results
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// fanOut reads every line of lr and hands copies of them to workers goroutines
+// that each call decode. It stops at the first read or decode error and
+// returns it once every worker is done.
+func fanOut(lr *LineReader, workers int, decode func(rawLine) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	lineChan := make(chan rawLine, workers*10)
+	done := make(chan struct{})
+	var once sync.Once
+	var decodeErr error
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rl := range lineChan {
+				select {
+				case <-done:
+					// another worker failed, just drain the channel
+					continue
+				default:
+				}
+				if err := decode(rl); err != nil {
+					once.Do(func() {
+						decodeErr = &LineError{Path: lr.path, Line: rl.line, Err: err}
+						close(done)
+					})
+				}
+			}
+		}()
+	}
+
+ReadLoop:
+	for lr.Next() {
+		data := make([]byte, len(lr.Bytes()))
+		copy(data, lr.Bytes())
+		select {
+		case lineChan <- rawLine{line: lr.Line(), data: data}:
+		case <-done:
+			break ReadLoop
+		}
+	}
+	close(lineChan)
+	wg.Wait()
+
+	if decodeErr != nil {
+		return decodeErr
+	}
+	return lr.Err()
+}
+
+// jsonWriter writes one JSON object per line and is safe for concurrent use.
+type jsonWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonWriter{w: w, enc: enc}
+}
+
+// write encodes v followed by a newline
+func (jw *jsonWriter) write(v interface{}) error {
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+	return jw.enc.Encode(v)
+}
+
+func (jw *jsonWriter) close() error {
+	if c, ok := jw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
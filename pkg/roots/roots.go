@@ -0,0 +1,81 @@
+// Package roots loads the root certificates TLS verification trusts. By
+// default the host's system roots are used, which differ between machines and
+// change as roots are added and removed, so results can instead be verified
+// against a pinned bundle, such as a Mozilla or CCADB snapshot from the date
+// of the scan. Each bundle has an ID, recorded with the results, so later
+// analysis can tell which roots a verdict was reached with.
+package roots
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// System is the ID of the host's system roots.
+const System = "system"
+
+// ErrNoCertificates is returned when a bundle has no certificates in it.
+var ErrNoCertificates = errors.New("no certificates in root bundle")
+
+// Bundle is a set of trusted root certificates.
+type Bundle struct {
+	// Pool is the roots to verify against, nil for the system roots.
+	Pool *x509.CertPool
+	// ID names the bundle: the file name and the SHA-256 of its contents,
+	// or System.
+	ID string
+	// Len is the number of certificates in the bundle, 0 for the system
+	// roots.
+	Len int
+}
+
+// Parse reads a bundle of PEM encoded certificates, named name. Blocks other
+// than certificates are skipped.
+func Parse(name string, bs []byte) (*Bundle, error) {
+	b := &Bundle{Pool: x509.NewCertPool()}
+	rest := bs
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s certificate %d: %w", name, b.Len+1, err)
+		}
+		b.Pool.AddCert(cert)
+		b.Len++
+	}
+	if b.Len == 0 {
+		return nil, fmt.Errorf("%s: %w", name, ErrNoCertificates)
+	}
+	sum := sha256.Sum256(bs)
+	b.ID = name + "@sha256:" + hex.EncodeToString(sum[:])
+
+	return b, nil
+}
+
+// Load reads a PEM root bundle from path. If path is empty the system roots
+// are returned.
+func Load(path string) (*Bundle, error) {
+	if path == "" {
+		return &Bundle{ID: System}, nil
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(filepath.Base(path), bs)
+}
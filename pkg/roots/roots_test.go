@@ -0,0 +1,64 @@
+package roots
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6/pkg/fakenet"
+)
+
+func TestLoad(t *testing.T) {
+	b, err := Load("")
+	require.Nil(t, err)
+	require.Nil(t, b.Pool)
+	require.Equal(t, System, b.ID)
+
+	ca, err := fakenet.NewCA()
+	require.Nil(t, err)
+	other, err := fakenet.NewCA()
+	require.Nil(t, err)
+	var bundle []byte
+	bundle = append(bundle, "# test roots\n"...)
+	for _, c := range []*fakenet.CA{ca, other} {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: c.Certificate().Raw,
+		})...)
+	}
+	path := filepath.Join(t.TempDir(), "roots-2022-02-07.pem")
+	require.Nil(t, os.WriteFile(path, bundle, 0644))
+
+	b, err = Load(path)
+	require.Nil(t, err)
+	require.Equal(t, 2, b.Len)
+	require.True(t, strings.HasPrefix(b.ID, "roots-2022-02-07.pem@sha256:"))
+
+	cert, err := ca.Issue("example.test")
+	require.Nil(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.Nil(t, err)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "example.test", Roots: b.Pool})
+	require.Nil(t, err)
+
+	// the same contents always have the same ID
+	again, err := Parse("roots-2022-02-07.pem", bundle)
+	require.Nil(t, err)
+	require.Equal(t, b.ID, again.ID)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse("empty.pem", []byte("not a bundle"))
+	require.True(t, errors.Is(err, ErrNoCertificates))
+
+	_, err = Parse("broken.pem", pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: []byte("garbage"),
+	}))
+	require.NotNil(t, err)
+}
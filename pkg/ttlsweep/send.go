@@ -0,0 +1,98 @@
+// Package ttlsweep locates on-path DNS injectors. A query is resent with
+// increasing IP TTLs (IPv6 hop limits), traceroute style: routers along the
+// path report the probes that expire at them with ICMP time exceeded, and an
+// injector answers every probe that gets as far as it, so the smallest TTL
+// that gets a response is the hop the injector sits at.
+//
+// Probes deliberately go out from ordinary UDP sockets with the TTL set on
+// each, rather than from a raw socket: sending needs no privileges, the kernel
+// builds the IPv4 and IPv6 headers, and each socket holds its source port so
+// nothing else on the host can take it while the probe is outstanding.
+package ttlsweep
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultMaxTTL is the largest TTL swept by default, as for traceroute
+const DefaultMaxTTL = 30
+
+// Probe is one query sent with a limited TTL. Every probe is sent from its
+// own socket, so its source port tells apart the ICMP errors and responses it
+// gets even when routers quote no more than the UDP header. The kernel reuses
+// ports once a socket is closed, so the sockets of a sweep must all be kept
+// open until it's over; responses are also matched on the DNS ID.
+type Probe struct {
+	Target net.IP
+	Name   string
+	QType  uint16
+	TTL    int
+	Port   uint16
+	ID     uint16
+	Sent   time.Time
+}
+
+// ttlOption returns the socket option that sets the TTL of packets to dest
+func ttlOption(dest net.IP) (network string, level, opt int) {
+	if dest.To4() != nil {
+		return "udp4", syscall.IPPROTO_IP, syscall.IP_TTL
+	}
+	return "udp6", syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+}
+
+// Send sends m to target:port from a new UDP socket, bound to source if it
+// isn't nil, whose packets leave with the IP TTL (or hop limit) ttl. The
+// socket is returned open, since closing it before the responses arrive would
+// have them answered with port unreachables; the caller closes it.
+func Send(m *dns.Msg, source, target net.IP, port, ttl int) (*Probe, net.Conn, error) {
+	network, level, opt := ttlOption(target)
+	dialer := net.Dialer{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), level, opt, ttl)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	if source != nil {
+		dialer.LocalAddr = &net.UDPAddr{IP: source}
+	}
+	conn, err := dialer.Dial(network, net.JoinHostPort(target.String(), strconv.Itoa(port)))
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := m.Pack()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	sent := time.Now()
+	if _, err := conn.Write(out); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	probe := &Probe{
+		Target: target,
+		TTL:    ttl,
+		Port:   uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		ID:     m.Id,
+		Sent:   sent,
+	}
+	if len(m.Question) > 0 {
+		probe.Name = strings.TrimSuffix(m.Question[0].Name, ".")
+		probe.QType = m.Question[0].Qtype
+	}
+
+	return probe, conn, nil
+}
@@ -0,0 +1,42 @@
+package ttlsweep
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSend(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeAAAA)
+	p, sock, err := Send(m, nil, net.IPv4(127, 0, 0, 1), port, 3)
+	require.NoError(t, err)
+	defer sock.Close()
+
+	require.Equal(t, "example.com", p.Name)
+	require.Equal(t, dns.TypeAAAA, p.QType)
+	require.Equal(t, 3, p.TTL)
+	require.Equal(t, m.Id, p.ID)
+	require.Equal(t, sock.LocalAddr().(*net.UDPAddr).Port, int(p.Port))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, dns.MaxMsgSize)
+	n, from, err := conn.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, int(p.Port), from.Port)
+	got := new(dns.Msg)
+	require.NoError(t, got.Unpack(buf[:n]))
+	require.Equal(t, m.Id, got.Id)
+
+	// TTLs only go up to 255
+	_, _, err = Send(m, nil, net.IPv4(127, 0, 0, 1), port, 256)
+	require.Error(t, err)
+}
@@ -0,0 +1,328 @@
+package ttlsweep
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"github.com/timartiny/v4vsv6/pkg/dnspcap"
+)
+
+// Address families of a Trace
+const (
+	FamilyV4 = "v4"
+	FamilyV6 = "v6"
+)
+
+// Hop is what came back for the probe sent with one TTL.
+type Hop struct {
+	TTL int `json:"ttl"`
+	// Router is the address that said the probe expired, if one did
+	Router string `json:"router,omitempty"`
+	// Reached is set when the target itself said the port was unreachable
+	Reached   bool              `json:"reached,omitempty"`
+	Responses []*dnspcap.Result `json:"responses,omitempty"`
+}
+
+// Trace is the sweep of one query to one target.
+type Trace struct {
+	Target string `json:"target"`
+	Name   string `json:"name"`
+	Record string `json:"record"`
+	Family string `json:"family"`
+	Probes int    `json:"probes"`
+	Hops   []*Hop `json:"hops"`
+	// InjectionTTL is the smallest TTL that got a response, 0 if none did
+	InjectionTTL int `json:"injection_ttl,omitempty"`
+	// InjectorAfter is the last router seen before InjectionTTL, so the
+	// injector sits between it and the next hop
+	InjectorAfter string `json:"injector_after,omitempty"`
+	// TargetTTL is the smallest TTL the target said it was reached with
+	TargetTTL int `json:"target_ttl,omitempty"`
+	// Injected is set when the responses can't have come from the target:
+	// they started at a smaller TTL than the target was reached at, or a
+	// router still reported a probe at least that far expiring
+	Injected bool `json:"injected"`
+}
+
+// hop returns the trace's hop for ttl, adding it if needed
+func (t *Trace) hop(ttl int) *Hop {
+	for _, h := range t.Hops {
+		if h.TTL == ttl {
+			return h
+		}
+	}
+	h := &Hop{TTL: ttl}
+	t.Hops = append(t.Hops, h)
+
+	return h
+}
+
+// locate sorts the hops and works out where injection starts.
+func (t *Trace) locate() {
+	sort.Slice(t.Hops, func(i, j int) bool { return t.Hops[i].TTL < t.Hops[j].TTL })
+	t.InjectionTTL, t.InjectorAfter, t.TargetTTL, t.Injected = 0, "", 0, false
+	var lastRouter int
+	for _, h := range t.Hops {
+		if t.InjectionTTL == 0 && len(h.Responses) > 0 {
+			t.InjectionTTL = h.TTL
+			t.InjectorAfter = ""
+			for _, prev := range t.Hops {
+				if prev.TTL < h.TTL && prev.Router != "" && !prev.Reached {
+					t.InjectorAfter = prev.Router
+				}
+			}
+		}
+		if t.TargetTTL == 0 && h.Reached {
+			t.TargetTTL = h.TTL
+		}
+		if h.Router != "" && !h.Reached {
+			lastRouter = h.TTL
+		}
+	}
+	if t.InjectionTTL == 0 {
+		return
+	}
+	t.Injected = (t.TargetTTL != 0 && t.InjectionTTL < t.TargetTTL) ||
+		lastRouter >= t.InjectionTTL
+}
+
+type probeKey struct {
+	target string
+	port   uint16
+	id     uint16
+}
+
+type traceKey struct {
+	target string
+	name   string
+	qtype  uint16
+}
+
+// Tracker follows the probes of sweeps through a capture of the ICMP errors
+// and DNS responses they get. It is safe for concurrent use, so probes can be
+// added while the capture is fed to it.
+type Tracker struct {
+	mu      sync.Mutex
+	matcher *dnspcap.Matcher
+	probes  map[probeKey]*Probe
+	// ports holds the last probe sent to each target from each port, for
+	// ICMP errors that don't quote the DNS ID
+	ports  map[probeKey]*Probe
+	traces map[traceKey]*Trace
+	// order holds the trace keys in the order they were started
+	order []traceKey
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		// probes are only forgotten once the sweep is over
+		matcher: dnspcap.NewMatcher(0),
+		probes:  make(map[probeKey]*Probe),
+		ports:   make(map[probeKey]*Probe),
+		traces:  make(map[traceKey]*Trace),
+	}
+}
+
+// Add records a probe that was sent, so what comes back for it is tracked.
+func (t *Tracker) Add(p *Probe) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.probes[probeKey{p.Target.String(), p.Port, p.ID}] = p
+	t.ports[probeKey{target: p.Target.String(), port: p.Port}] = p
+	trace := t.trace(p)
+	trace.Probes++
+	trace.hop(p.TTL)
+}
+
+func (t *Tracker) trace(p *Probe) *Trace {
+	key := traceKey{p.Target.String(), strings.ToLower(p.Name), p.QType}
+	trace, ok := t.traces[key]
+	if !ok {
+		trace = &Trace{
+			Target: key.target,
+			Name:   p.Name,
+			Record: dns.TypeToString[p.QType],
+			Family: FamilyV6,
+		}
+		if p.Target.To4() != nil {
+			trace.Family = FamilyV4
+		}
+		t.traces[key] = trace
+		t.order = append(t.order, key)
+	}
+
+	return trace
+}
+
+// Packet processes the next captured packet, returning the response it holds
+// if it answers one of the probes.
+func (t *Tracker) Packet(packet gopacket.Packet) *dnspcap.Result {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if r := t.matcher.Packet(packet); r != nil {
+		p, ok := t.probes[probeKey{r.Resolver, r.SourcePort, r.ID}]
+		if !ok {
+			return nil
+		}
+		hop := t.trace(p).hop(p.TTL)
+		hop.Responses = append(hop.Responses, r)
+		return r
+	}
+
+	q, ok := icmpError(packet)
+	if !ok {
+		return nil
+	}
+	var p *Probe
+	if q.hasID {
+		p, ok = t.probes[probeKey{q.target.String(), q.port, q.id}]
+	} else {
+		p, ok = t.ports[probeKey{target: q.target.String(), port: q.port}]
+	}
+	if !ok {
+		return nil
+	}
+	hop := t.trace(p).hop(p.TTL)
+	hop.Router = q.router.String()
+	hop.Reached = q.reached && q.router.Equal(p.Target)
+
+	return nil
+}
+
+// Traces returns every trace, in the order they were started, with where
+// injection starts worked out.
+func (t *Tracker) Traces() []*Trace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ret []*Trace
+	for _, key := range t.order {
+		trace := t.traces[key]
+		trace.locate()
+		ret = append(ret, trace)
+	}
+
+	return ret
+}
+
+// icmpQuote is what an ICMP error says about the probe it quotes
+type icmpQuote struct {
+	// router is the address the error came from
+	router net.IP
+	// target and port are the destination and source port of the probe
+	target net.IP
+	port   uint16
+	// id is the probe's DNS ID, if hasID says enough of it was quoted
+	id    uint16
+	hasID bool
+	// reached is set for port unreachables, which mean the probe got to its
+	// destination
+	reached bool
+}
+
+// icmpError returns what an ICMP time exceeded or port unreachable quotes of
+// the UDP packet it was sent for, or false if packet isn't one.
+func icmpError(packet gopacket.Packet) (q icmpQuote, ok bool) {
+	var quoted []byte
+	var first gopacket.LayerType
+	switch network := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		icmp, isICMP := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+		if !isICMP {
+			return
+		}
+		switch icmp.TypeCode.Type() {
+		case layers.ICMPv4TypeTimeExceeded:
+		case layers.ICMPv4TypeDestinationUnreachable:
+			if icmp.TypeCode.Code() != layers.ICMPv4CodePort {
+				return
+			}
+			q.reached = true
+		default:
+			return
+		}
+		q.router, quoted, first = network.SrcIP, icmp.Payload, layers.LayerTypeIPv4
+	case *layers.IPv6:
+		icmp, isICMP := packet.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6)
+		if !isICMP || len(icmp.Payload) < 4 {
+			return
+		}
+		switch icmp.TypeCode.Type() {
+		case layers.ICMPv6TypeTimeExceeded:
+		case layers.ICMPv6TypeDestinationUnreachable:
+			if icmp.TypeCode.Code() != layers.ICMPv6CodePortUnreachable {
+				return
+			}
+			q.reached = true
+		default:
+			return
+		}
+		// the quoted packet follows 4 unused bytes
+		q.router, quoted, first = network.SrcIP, icmp.Payload[4:], layers.LayerTypeIPv6
+	default:
+		return
+	}
+
+	inner := gopacket.NewPacket(quoted, first, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	switch network := inner.NetworkLayer().(type) {
+	case *layers.IPv4:
+		q.target = network.DstIP
+	case *layers.IPv6:
+		q.target = network.DstIP
+	default:
+		return
+	}
+	udp, isUDP := inner.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !isUDP {
+		return
+	}
+
+	q.port = uint16(udp.SrcPort)
+	// routers that quote more than the UDP header quote the DNS ID first
+	if len(udp.Payload) >= 2 {
+		q.id = binary.BigEndian.Uint16(udp.Payload)
+		q.hasID = true
+	}
+
+	return q, true
+}
+
+// FamilySummary counts the traces of one address family by the hop injection
+// started at.
+type FamilySummary struct {
+	Traces   int `json:"traces"`
+	Answered int `json:"answered"`
+	Injected int `json:"injected"`
+	// Hops counts the injected traces by their InjectionTTL
+	Hops map[int]int `json:"hops"`
+}
+
+// Summarize counts traces by family, keyed by FamilyV4 and FamilyV6.
+func Summarize(traces []*Trace) map[string]*FamilySummary {
+	ret := map[string]*FamilySummary{
+		FamilyV4: {Hops: make(map[int]int)},
+		FamilyV6: {Hops: make(map[int]int)},
+	}
+	for _, trace := range traces {
+		s := ret[trace.Family]
+		s.Traces++
+		if trace.InjectionTTL > 0 {
+			s.Answered++
+		}
+		if trace.Injected {
+			s.Injected++
+			s.Hops[trace.InjectionTTL]++
+		}
+	}
+
+	return ret
+}
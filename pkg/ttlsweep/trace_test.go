@@ -0,0 +1,298 @@
+package ttlsweep
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	client4 = net.ParseIP("192.0.2.1").To4()
+	target4 = net.ParseIP("198.51.100.7").To4()
+	client6 = net.ParseIP("2001:db8::1")
+	target6 = net.ParseIP("2001:db8:7::7")
+	start   = time.Date(2021, 5, 11, 12, 0, 0, 0, time.UTC)
+)
+
+// router returns the address of the router at hop ttl
+func router(target net.IP, ttl int) net.IP {
+	if target.To4() != nil {
+		return net.IPv4(203, 0, 113, byte(ttl)).To4()
+	}
+	ip := net.ParseIP("2001:db8:ffff::")
+	ip[15] = byte(ttl)
+	return ip
+}
+
+func serialize(t *testing.T, src, dst net.IP, hopLimit uint8, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	eth := &layers.Ethernet{
+		SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2},
+	}
+	var network gopacket.SerializableLayer
+	if src.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip := &layers.IPv4{Version: 4, IHL: 5, TTL: hopLimit, SrcIP: src, DstIP: dst}
+		switch l := ls[0].(type) {
+		case *layers.UDP:
+			ip.Protocol = layers.IPProtocolUDP
+			require.NoError(t, l.SetNetworkLayerForChecksum(ip))
+		case *layers.ICMPv4:
+			ip.Protocol = layers.IPProtocolICMPv4
+		}
+		network = ip
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip := &layers.IPv6{Version: 6, HopLimit: hopLimit, SrcIP: src, DstIP: dst}
+		switch l := ls[0].(type) {
+		case *layers.UDP:
+			ip.NextHeader = layers.IPProtocolUDP
+			require.NoError(t, l.SetNetworkLayerForChecksum(ip))
+		case *layers.ICMPv6:
+			ip.NextHeader = layers.IPProtocolICMPv6
+			require.NoError(t, l.SetNetworkLayerForChecksum(ip))
+		}
+		network = ip
+	}
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(
+		buf,
+		gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		append([]gopacket.SerializableLayer{eth, network}, ls...)...,
+	)
+	require.NoError(t, err)
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = start
+
+	return packet
+}
+
+// probe returns the bytes of the IP packet p was sent as, cut after the UDP
+// header as most routers quote it
+func probe(t *testing.T, p *Probe) []byte {
+	data := quote(t, p)
+	if p.Target.To4() != nil {
+		return data[:20+8]
+	}
+	return data[:40+8]
+}
+
+// quote returns all the bytes of the IP packet p was sent as
+func quote(t *testing.T, p *Probe) []byte {
+	client := client4
+	if p.Target.To4() == nil {
+		client = client6
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(p.Name), p.QType)
+	m.Id = p.ID
+	bs, err := m.Pack()
+	require.NoError(t, err)
+	udp := &layers.UDP{SrcPort: layers.UDPPort(p.Port), DstPort: 53}
+	return serialize(t, client, p.Target, 1, udp, gopacket.Payload(bs)).Data()[14:]
+}
+
+// expired returns the time exceeded the router at p's TTL sends for it, or
+// the port unreachable from the target if unreachable is set
+func expired(t *testing.T, p *Probe, unreachable bool) gopacket.Packet {
+	from := router(p.Target, p.TTL)
+	if unreachable {
+		from = p.Target
+	}
+	if p.Target.To4() != nil {
+		icmp := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeTimeExceeded, 0)}
+		if unreachable {
+			icmp.TypeCode = layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodePort)
+		}
+		return serialize(t, from, client4, 64, icmp, gopacket.Payload(probe(t, p)))
+	}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeTimeExceeded, 0)}
+	if unreachable {
+		icmp.TypeCode = layers.CreateICMPv6TypeCode(layers.ICMPv6TypeDestinationUnreachable, layers.ICMPv6CodePortUnreachable)
+	}
+	payload := append([]byte{0, 0, 0, 0}, probe(t, p)...)
+	return serialize(t, from, client6, 64, icmp, gopacket.Payload(payload))
+}
+
+// answer returns a response to p that appears to come from its target
+func answer(t *testing.T, p *Probe, addr string) gopacket.Packet {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(p.Name), p.QType)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	m.Id = p.ID
+	rr, err := dns.NewRR(dns.Fqdn(p.Name) + " 60 IN A " + addr)
+	require.NoError(t, err)
+	m.Answer = append(m.Answer, rr)
+	bs, err := m.Pack()
+	require.NoError(t, err)
+
+	client := client4
+	if p.Target.To4() == nil {
+		client = client6
+	}
+	udp := &layers.UDP{SrcPort: 53, DstPort: layers.UDPPort(p.Port)}
+	return serialize(t, p.Target, client, 60, udp, gopacket.Payload(bs))
+}
+
+// sweep adds probes to target for TTLs 1 to max to tr, returning them by TTL
+func sweep(tr *Tracker, target net.IP, name string, max int) map[int]*Probe {
+	ret := make(map[int]*Probe)
+	for ttl := 1; ttl <= max; ttl++ {
+		p := &Probe{
+			Target: target,
+			Name:   name,
+			QType:  dns.TypeA,
+			TTL:    ttl,
+			Port:   uint16(40000 + len(tr.probes)),
+			ID:     uint16(ttl),
+			Sent:   start,
+		}
+		tr.Add(p)
+		ret[ttl] = p
+	}
+
+	return ret
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+
+	// v4: routers at hops 1-5, an injector between hops 3 and 4 answering
+	// everything that gets past it, and no answer from the target
+	v4 := sweep(tr, target4, "blocked.test", 6)
+	for ttl := 1; ttl <= 5; ttl++ {
+		require.Nil(t, tr.Packet(expired(t, v4[ttl], false)))
+	}
+	for ttl := 4; ttl <= 6; ttl++ {
+		r := tr.Packet(answer(t, v4[ttl], "10.10.34.34"))
+		require.NotNil(t, r)
+		require.Equal(t, "blocked.test", r.Name)
+	}
+
+	// v6: a resolver 3 hops away answering for itself
+	v6 := sweep(tr, target6, "example.com", 4)
+	for ttl := 1; ttl <= 2; ttl++ {
+		require.Nil(t, tr.Packet(expired(t, v6[ttl], false)))
+	}
+	for ttl := 3; ttl <= 4; ttl++ {
+		require.NotNil(t, tr.Packet(answer(t, v6[ttl], "192.0.2.99")))
+	}
+
+	// v6: an injector at the first hop, and the target unreachable at hop 3
+	v6Blocked := sweep(tr, target6, "blocked.test", 3)
+	require.Nil(t, tr.Packet(expired(t, v6Blocked[2], false)))
+	require.Nil(t, tr.Packet(expired(t, v6Blocked[3], true)))
+	for ttl := 1; ttl <= 3; ttl++ {
+		require.NotNil(t, tr.Packet(answer(t, v6Blocked[ttl], "10.10.34.34")))
+	}
+
+	// from a port that wasn't probed
+	stray := *v4[1]
+	stray.Port = 1
+	require.Nil(t, tr.Packet(answer(t, &stray, "10.10.34.34")))
+	require.Nil(t, tr.Packet(expired(t, &stray, false)))
+
+	traces := tr.Traces()
+	require.Len(t, traces, 3)
+
+	require.Equal(t, "198.51.100.7", traces[0].Target)
+	require.Equal(t, FamilyV4, traces[0].Family)
+	require.Equal(t, "A", traces[0].Record)
+	require.Equal(t, 6, traces[0].Probes)
+	require.Len(t, traces[0].Hops, 6)
+	require.Equal(t, "203.0.113.1", traces[0].Hops[0].Router)
+	require.Empty(t, traces[0].Hops[0].Responses)
+	require.Len(t, traces[0].Hops[3].Responses, 1)
+	require.Equal(t, 4, traces[0].InjectionTTL)
+	require.Equal(t, "203.0.113.3", traces[0].InjectorAfter)
+	require.Equal(t, 0, traces[0].TargetTTL)
+	require.True(t, traces[0].Injected)
+
+	require.Equal(t, FamilyV6, traces[1].Family)
+	require.Equal(t, 3, traces[1].InjectionTTL)
+	require.Equal(t, "2001:db8:ffff::2", traces[1].InjectorAfter)
+	require.False(t, traces[1].Injected)
+
+	require.Equal(t, 1, traces[2].InjectionTTL)
+	require.Empty(t, traces[2].InjectorAfter)
+	require.Equal(t, 3, traces[2].TargetTTL)
+	require.True(t, traces[2].Hops[2].Reached)
+	require.True(t, traces[2].Injected)
+
+	summary := Summarize(traces)
+	require.Equal(t, &FamilySummary{Traces: 1, Answered: 1, Injected: 1, Hops: map[int]int{4: 1}}, summary[FamilyV4])
+	require.Equal(t, &FamilySummary{Traces: 2, Answered: 2, Injected: 1, Hops: map[int]int{1: 1}}, summary[FamilyV6])
+}
+
+func TestTrackerReusedPort(t *testing.T) {
+	tr := NewTracker()
+
+	// the kernel handed the same port to two probes of the sweep, so what
+	// comes back for the first mustn't be credited to the second
+	first := &Probe{Target: target4, Name: "blocked.test", QType: dns.TypeA, TTL: 2, Port: 40000, ID: 1, Sent: start}
+	second := &Probe{Target: target4, Name: "blocked.test", QType: dns.TypeA, TTL: 5, Port: 40000, ID: 2, Sent: start}
+	tr.Add(first)
+	tr.Add(second)
+
+	require.NotNil(t, tr.Packet(answer(t, first, "10.10.34.34")))
+	// a router quoting the DNS ID is matched on it
+	from := router(target4, first.TTL)
+	icmp := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeTimeExceeded, 0)}
+	require.Nil(t, tr.Packet(serialize(t, from, client4, 64, icmp, gopacket.Payload(quote(t, first)))))
+
+	traces := tr.Traces()
+	require.Len(t, traces, 1)
+	require.Len(t, traces[0].Hops, 2)
+	require.Equal(t, 2, traces[0].Hops[0].TTL)
+	require.Len(t, traces[0].Hops[0].Responses, 1)
+	require.Equal(t, "203.0.113.2", traces[0].Hops[0].Router)
+	require.Equal(t, 5, traces[0].Hops[1].TTL)
+	require.Empty(t, traces[0].Hops[1].Responses)
+	require.Empty(t, traces[0].Hops[1].Router)
+	require.Equal(t, 2, traces[0].InjectionTTL)
+
+	// an answer with an ID that was never sent is ignored
+	stray := *first
+	stray.ID = 3
+	require.Nil(t, tr.Packet(answer(t, &stray, "10.10.34.34")))
+}
+
+func TestIcmpErrorQuotedID(t *testing.T) {
+	p := &Probe{Target: target6, Name: "example.com", QType: dns.TypeA, TTL: 1, Port: 40000, ID: 0xbeef}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeTimeExceeded, 0)}
+	payload := append([]byte{0, 0, 0, 0}, quote(t, p)...)
+	q, ok := icmpError(serialize(t, router(target6, 1), client6, 64, icmp, gopacket.Payload(payload)))
+	require.True(t, ok)
+	require.True(t, q.hasID)
+	require.Equal(t, uint16(0xbeef), q.id)
+	require.Equal(t, uint16(40000), q.port)
+	require.Equal(t, "2001:db8:7::7", q.target.String())
+
+	q, ok = icmpError(expired(t, p, false))
+	require.True(t, ok)
+	require.False(t, q.hasID)
+}
+
+func TestIcmpErrorIgnoresOthers(t *testing.T) {
+	p := &Probe{Target: target4, Name: "example.com", QType: dns.TypeA, TTL: 1, Port: 40000}
+	echo := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0)}
+	_, ok := icmpError(serialize(t, target4, client4, 64, echo, gopacket.Payload(probe(t, p))))
+	require.False(t, ok)
+
+	// a host unreachable from a router doesn't mean the target was reached
+	host := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodeHost)}
+	_, ok = icmpError(serialize(t, router(target4, 1), client4, 64, host, gopacket.Payload(probe(t, p))))
+	require.False(t, ok)
+
+	// too short to quote the UDP header
+	truncated := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeTimeExceeded, 0)}
+	_, ok = icmpError(serialize(t, router(target4, 1), client4, 64, truncated, gopacket.Payload(probe(t, p)[:20])))
+	require.False(t, ok)
+}
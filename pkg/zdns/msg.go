@@ -0,0 +1,63 @@
+package zdns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// AnswersFromRRs converts resource records from a DNS message into Answers,
+// formatted the way ZDNS would, skipping the record types ZDNS doesn't report
+// (like OPT).
+func AnswersFromRRs(rrs []dns.RR) []Answer {
+	var ret []Answer
+	for _, rr := range rrs {
+		answer := Answer{
+			TTL:   rr.Header().Ttl,
+			Type:  dns.TypeToString[rr.Header().Rrtype],
+			Class: dns.ClassToString[rr.Header().Class],
+			Name:  strings.TrimSuffix(rr.Header().Name, "."),
+		}
+		switch v := rr.(type) {
+		case *dns.A:
+			answer.Answer = v.A.String()
+		case *dns.AAAA:
+			answer.Answer = v.AAAA.String()
+		case *dns.CNAME:
+			answer.Answer = v.Target
+		case *dns.NS:
+			answer.Answer = v.Ns
+		case *dns.OPT:
+			continue
+		default:
+			answer.Answer = strings.TrimPrefix(rr.String(), rr.Header().String())
+		}
+		ret = append(ret, answer)
+	}
+
+	return ret
+}
+
+// FlagsFromMsg returns the header flags of a DNS message.
+func FlagsFromMsg(msg *dns.Msg) *Flags {
+	return &Flags{
+		Response:           msg.Response,
+		Opcode:             msg.Opcode,
+		Authoritative:      msg.Authoritative,
+		Truncated:          msg.Truncated,
+		RecursionDesired:   msg.RecursionDesired,
+		RecursionAvailable: msg.RecursionAvailable,
+		Authenticated:      msg.AuthenticatedData,
+		CheckingDisabled:   msg.CheckingDisabled,
+		ErrorCode:          msg.Rcode,
+	}
+}
+
+// FromMsg fills in the status and data of r from a resolver's response.
+func (r *Result) FromMsg(msg *dns.Msg) {
+	r.Status = dns.RcodeToString[msg.Rcode]
+	r.Data.Answers = AnswersFromRRs(msg.Answer)
+	r.Data.Additional = AnswersFromRRs(msg.Extra)
+	r.Data.Authorities = AnswersFromRRs(msg.Ns)
+	r.Data.Flags = FlagsFromMsg(msg)
+}
@@ -0,0 +1,271 @@
+// Package zdns decodes the JSON output of ZDNS (https://github.com/zmap/zdns)
+// lookups into typed structs. Only the fields the v4vsv6 commands use are
+// decoded: A, AAAA, NS and CNAME answers, the resolver used and, for --trace
+// runs, the iterative lookup steps. Results can also be built from DNS
+// messages, so commands that query resolvers themselves produce the same
+// structs.
+package zdns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/timartiny/v4vsv6/pkg/results"
+)
+
+var (
+	// ErrNoResolver is returned when a result doesn't say which resolver was
+	// queried.
+	ErrNoResolver = errors.New("zdns result has no resolver")
+
+	// ErrInvalidResolver is returned when the resolver can't be parsed as an
+	// IP address, with or without a port.
+	ErrInvalidResolver = errors.New("invalid zdns resolver")
+
+	// ErrInvalidAddress is returned when an A or AAAA answer isn't an IP
+	// address.
+	ErrInvalidAddress = errors.New("invalid address in zdns answer")
+
+	// ErrNotAddress is returned when asking for the address of an answer that
+	// isn't an A or AAAA record.
+	ErrNotAddress = errors.New("zdns answer is not an A or AAAA record")
+)
+
+// StatusNoError is the status of a successful lookup.
+const StatusNoError = "NOERROR"
+
+// Result is a single line of ZDNS output.
+type Result struct {
+	AlteredName string      `json:"altered_name,omitempty"`
+	Name        string      `json:"name,omitempty"`
+	Nameserver  string      `json:"nameserver,omitempty"`
+	Class       string      `json:"class,omitempty"`
+	AlexaRank   int         `json:"alexa_rank,omitempty"`
+	Metadata    string      `json:"metadata,omitempty"`
+	Status      string      `json:"status,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Timestamp   string      `json:"timestamp,omitempty"`
+	Data        Data        `json:"data,omitempty"`
+	Trace       []TraceStep `json:"trace,omitempty"`
+}
+
+// Data holds the response a resolver gave.
+type Data struct {
+	Answers     []Answer `json:"answers,omitempty"`
+	Additional  []Answer `json:"additionals,omitempty"`
+	Authorities []Answer `json:"authorities,omitempty"`
+	Protocol    string   `json:"protocol,omitempty"`
+	Resolver    string   `json:"resolver,omitempty"`
+	Flags       *Flags   `json:"flags,omitempty"`
+}
+
+// Flags are the DNS header flags of the response.
+type Flags struct {
+	Response           bool `json:"response"`
+	Opcode             int  `json:"opcode"`
+	Authoritative      bool `json:"authoritative"`
+	Truncated          bool `json:"truncated"`
+	RecursionDesired   bool `json:"recursion_desired"`
+	RecursionAvailable bool `json:"recursion_available"`
+	Authenticated      bool `json:"authenticated"`
+	CheckingDisabled   bool `json:"checking_disabled"`
+	ErrorCode          int  `json:"error_code"`
+}
+
+// Answer is a single resource record. For A and AAAA records Answer is the
+// address, for CNAME and NS records it is the target name.
+type Answer struct {
+	TTL    uint32 `json:"ttl"`
+	Type   string `json:"type,omitempty"`
+	Class  string `json:"class,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Answer string `json:"answer,omitempty"`
+}
+
+// TraceStep is one query made during an iterative (--trace) lookup.
+type TraceStep struct {
+	Results    Data   `json:"results"`
+	Type       int    `json:"type"`
+	Class      int    `json:"class"`
+	Name       string `json:"name"`
+	NameServer string `json:"name_server"`
+	Depth      int    `json:"depth"`
+	Layer      string `json:"layer"`
+	Cached     bool   `json:"cached"`
+	Try        int    `json:"try"`
+}
+
+// Decode decodes a single line of ZDNS output.
+func Decode(line []byte) (*Result, error) {
+	r := new(Result)
+	if err := json.Unmarshal(line, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// OK returns true if the lookup finished without error.
+func (r *Result) OK() bool {
+	return r.Status == StatusNoError
+}
+
+// ResolverIP returns the address of the resolver queried. ZDNS writes this as
+// "1.2.3.4:53" or "[2001:db8::1]:53", and bare addresses are also accepted.
+func (r *Result) ResolverIP() (net.IP, error) {
+	return ParseResolver(r.Data.Resolver)
+}
+
+// ParseResolver parses a resolver address as written by ZDNS, with or without
+// a port and brackets.
+func ParseResolver(resolver string) (net.IP, error) {
+	if resolver == "" {
+		return nil, ErrNoResolver
+	}
+	host := resolver
+	if h, _, err := net.SplitHostPort(resolver); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidResolver, resolver)
+	}
+
+	return ip, nil
+}
+
+// IP returns the address of an A or AAAA answer.
+func (a Answer) IP() (net.IP, error) {
+	if a.Type != "A" && a.Type != "AAAA" {
+		return nil, ErrNotAddress
+	}
+	ip := net.ParseIP(a.Answer)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, a.Answer)
+	}
+
+	return ip, nil
+}
+
+// AnswersOfType returns the answers with the given record type, e.g. "NS".
+func (r *Result) AnswersOfType(rrType string) []Answer {
+	var ret []Answer
+	for _, a := range r.Data.Answers {
+		if a.Type == rrType {
+			ret = append(ret, a)
+		}
+	}
+
+	return ret
+}
+
+// Addresses returns the addresses of every A and AAAA answer. Answers that
+// aren't valid addresses are skipped and returned in invalid.
+func (r *Result) Addresses() (ips []net.IP, invalid []Answer) {
+	for _, a := range r.Data.Answers {
+		if a.Type != "A" && a.Type != "AAAA" {
+			continue
+		}
+		ip, err := a.IP()
+		if err != nil {
+			invalid = append(invalid, a)
+			continue
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, invalid
+}
+
+// CNAMEChain follows the CNAME answers starting from the queried name and
+// returns each target in order. Loops are cut off at the first repeat.
+func (r *Result) CNAMEChain() []string {
+	targets := make(map[string]string)
+	for _, a := range r.AnswersOfType("CNAME") {
+		targets[normalizeName(a.Name)] = a.Answer
+	}
+
+	var chain []string
+	seen := make(map[string]bool)
+	name := normalizeName(r.Name)
+	for {
+		target, ok := targets[name]
+		if !ok || seen[name] {
+			break
+		}
+		seen[name] = true
+		chain = append(chain, target)
+		name = normalizeName(target)
+	}
+
+	return chain
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// Reader iterates over a (possibly compressed) file of ZDNS output.
+type Reader struct {
+	lr     *results.LineReader
+	result *Result
+	err    error
+}
+
+// NewReader returns a Reader over lr.
+func NewReader(lr *results.LineReader) *Reader {
+	return &Reader{lr: lr}
+}
+
+// OpenReader opens the ZDNS output at path.
+func OpenReader(path string) (*Reader, error) {
+	lr, err := results.OpenLineReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReader(lr), nil
+}
+
+// Next decodes the next line, returning false at the end of the file or on the
+// first error, which is then available from Err.
+func (r *Reader) Next() bool {
+	if r.err != nil || !r.lr.Next() {
+		return false
+	}
+	result, err := Decode(r.lr.Bytes())
+	if err != nil {
+		r.err = r.lr.LineError(err)
+		return false
+	}
+	r.result = result
+
+	return true
+}
+
+// Result returns the Result decoded by the last call to Next.
+func (r *Reader) Result() *Result {
+	return r.result
+}
+
+// Line returns the line number of the last line read.
+func (r *Reader) Line() int {
+	return r.lr.Line()
+}
+
+// Err returns the first error encountered, if any, as a *results.LineError.
+func (r *Reader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.lr.Err()
+}
+
+// Close closes the underlying file.
+func (r *Reader) Close() error {
+	return r.lr.Close()
+}
@@ -0,0 +1,120 @@
+package zdns
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"github.com/timartiny/v4vsv6/pkg/results"
+)
+
+const cnameLine = `{"data":{"answers":[` +
+	`{"answer":"www.example.com.cdn.net.","class":"IN","name":"www.example.com","ttl":300,"type":"CNAME"},` +
+	`{"answer":"edge.cdn.net.","class":"IN","name":"www.example.com.cdn.net","ttl":300,"type":"CNAME"},` +
+	`{"answer":"192.0.2.1","class":"IN","name":"edge.cdn.net","ttl":60,"type":"A"},` +
+	`{"answer":"not-an-ip","class":"IN","name":"edge.cdn.net","ttl":60,"type":"A"}],` +
+	`"protocol":"udp","resolver":"[2001:db8::53]:53"},` +
+	`"name":"www.example.com","status":"NOERROR","timestamp":"2022-02-07T10:00:00-07:00"}`
+
+func TestDecodeCNAMEChain(t *testing.T) {
+	r, err := Decode([]byte(cnameLine))
+	require.Nil(t, err)
+	require.True(t, r.OK())
+
+	resolver, err := r.ResolverIP()
+	require.Nil(t, err)
+	require.Equal(t, "2001:db8::53", resolver.String())
+
+	require.Equal(
+		t,
+		[]string{"www.example.com.cdn.net.", "edge.cdn.net."},
+		r.CNAMEChain(),
+	)
+
+	ips, invalid := r.Addresses()
+	require.Equal(t, 1, len(ips))
+	require.Equal(t, "192.0.2.1", ips[0].String())
+	require.Equal(t, 1, len(invalid))
+
+	_, err = invalid[0].IP()
+	require.True(t, errors.Is(err, ErrInvalidAddress))
+	_, err = r.AnswersOfType("CNAME")[0].IP()
+	require.True(t, errors.Is(err, ErrNotAddress))
+}
+
+func TestParseResolver(t *testing.T) {
+	for in, want := range map[string]string{
+		"8.8.8.8:53":       "8.8.8.8",
+		"[2001:db8::1]:53": "2001:db8::1",
+		"2001:db8::1":      "2001:db8::1",
+		"8.8.8.8":          "8.8.8.8",
+	} {
+		ip, err := ParseResolver(in)
+		require.Nil(t, err, in)
+		require.Equal(t, want, ip.String())
+	}
+
+	_, err := ParseResolver("")
+	require.True(t, errors.Is(err, ErrNoResolver))
+	_, err = ParseResolver("resolver:53")
+	require.True(t, errors.Is(err, ErrInvalidResolver))
+
+	// errors, not panics, for results missing a resolver
+	r, err := Decode([]byte(`{"name":"example.com","status":"TIMEOUT","error":"timeout"}`))
+	require.Nil(t, err)
+	require.False(t, r.OK())
+	_, err = r.ResolverIP()
+	require.True(t, errors.Is(err, ErrNoResolver))
+}
+
+func TestDecodeTrace(t *testing.T) {
+	line := `{"data":{"answers":[{"answer":"ns1.example.com.","class":"IN","name":"example.com","ttl":3600,"type":"NS"}],` +
+		`"resolver":"192.0.2.53:53"},"name":"example.com","status":"NOERROR",` +
+		`"trace":[{"cached":false,"class":1,"depth":1,"layer":".","name":"example.com","name_server":"a.root-servers.net",` +
+		`"results":{"authorities":[{"answer":"a.gtld-servers.net.","class":"IN","name":"com","ttl":172800,"type":"NS"}],` +
+		`"protocol":"udp","resolver":"198.41.0.4:53"},"try":1,"type":2}]}`
+	r, err := Decode([]byte(line))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(r.Trace))
+	require.Equal(t, "a.root-servers.net", r.Trace[0].NameServer)
+	require.Equal(t, "a.gtld-servers.net.", r.Trace[0].Results.Authorities[0].Answer)
+	require.Equal(t, "ns1.example.com.", r.AnswersOfType("NS")[0].Answer)
+}
+
+func TestReader(t *testing.T) {
+	input := cnameLine + "\n" + `{"name":"bad","data":"not an object"}` + "\n"
+	rdr := NewReader(results.NewLineReader(strings.NewReader(input), "zdns.json"))
+	require.True(t, rdr.Next())
+	require.Equal(t, "www.example.com", rdr.Result().Name)
+	require.False(t, rdr.Next())
+
+	var lineErr *results.LineError
+	require.True(t, errors.As(rdr.Err(), &lineErr))
+	require.Equal(t, 2, lineErr.Line)
+}
+
+func TestFromMsg(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Response = true
+	msg.RecursionAvailable = true
+	cname, err := dns.NewRR("www.example.com. 300 IN CNAME edge.cdn.net.")
+	require.Nil(t, err)
+	a, err := dns.NewRR("edge.cdn.net. 60 IN A 192.0.2.1")
+	require.Nil(t, err)
+	msg.Answer = []dns.RR{cname, a}
+	msg.SetEdns0(4096, false)
+
+	r := &Result{Name: "www.example.com"}
+	r.FromMsg(msg)
+	require.True(t, r.OK())
+	require.True(t, r.Data.Flags.RecursionAvailable)
+	require.Equal(t, 0, len(r.Data.Additional))
+	require.Equal(t, []string{"edge.cdn.net."}, r.CNAMEChain())
+	ips, invalid := r.Addresses()
+	require.Equal(t, 0, len(invalid))
+	require.Equal(t, "192.0.2.1", ips[0].String())
+	require.Equal(t, uint32(60), r.AnswersOfType("A")[0].TTL)
+}
@@ -5,32 +5,8 @@
 echo "Make sure to grab the ${DATESTR}-v4.pcap file from the v4 NS before running this."
 echo "Matching v4 IPs to v6 IPs from PCAP"
 date
-tcpdump -n -r ${OUTPUTFOLDER}/${DATESTR}-v4.pcap | python3 /home/timartiny/v6censorship/probe-dns/data/match.py 4to6 > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6
-echo "Removing duplicate IPs"
-grep -v ',' ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6 > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers
-echo "Applying Country codes to IPs"
-python3 /home/timartiny/v6censorship/probe-dns/data/cc.py ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country-tmp
-echo "Removing Non matching Country Codes"
-grep -v '!!' ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country-tmp | grep -v 'None' | grep -v 'missing' > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country
-echo "Finding duplicate resolvers"
-cat ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country | awk '{print $1}' | sort | uniq -d > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-dup-v4
-cat ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country | awk '{print $2}' | sort | uniq -d > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-dup-v6
-echo "Removing duplicate resolvers"
-grep -vF -f ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-dup-v6 ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country-tmp
-grep -vF -f ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-dup-v4 ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country-tmp > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country
-sort ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country > ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country-sorted
-rm ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country-tmp
-
-echo "Create a list of all resolver pairs"
-cp ${OUTPUTFOLDER}/${DATESTR}-v6-to-v4-single-resolvers-country ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all
-awk '{print $2, $1, $3}' ${OUTPUTFOLDER}/${DATESTR}-v4-to-v6-single-resolvers-country >> ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all
-awk '{print $1}' ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all | sort | uniq -d > ${OUTPUTFOLDER}/${DATESTR}-dup-v6
-awk '{print $2}' ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all | sort | uniq -d > ${OUTPUTFOLDER}/${DATESTR}-dup-v4
-awk '{if ($3 != "") print $0}' ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all | sort > ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all-sorted
-echo "Remove duplicate space"
-sed 's/  / /g' ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all-sorted > tmp
-mv tmp ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all-sorted
-uniq -d ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-all-sorted > ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-sorted
+echo "Keeping only the resolver pairs found in both PCAPs"
+/home/timartiny/v4vsv6/cmd/pairResolvers/pairResolvers --pcap ${OUTPUTFOLDER}/${DATESTR}-v6.pcap --pcap ${OUTPUTFOLDER}/${DATESTR}-v4.pcap --country-db ${COUNTRYDB} --output ${OUTPUTFOLDER}/${DATESTR}-single-resolvers-country-sorted
 date
 
 echo "Starting Control Probes"
@@ -40,7 +40,7 @@ echo "Adding control domains"
 echo '{"domain":"v4vsv6.com","has_v4":true,"has_v6":true,"has_v4_tls":true,"has_v6_tls":true,"citizen_lab_global_list":false,"citizen_lab_country_list":null}' >> ${OUTPUTFOLDER}/full-details-v4-and-v6-and-tls-${DATESTR}.json
 echo '{"domain":"test1.v4vsv6.com","has_v4":true,"has_v6":true,"has_v4_tls":true,"has_v6_tls":true,"citizen_lab_global_list":false,"citizen_lab_country_list":null}' >> ${OUTPUTFOLDER}/full-details-v4-and-v6-and-tls-${DATESTR}.json
 echo '{"domain":"test2.v4vsv6.com","has_v4":true,"has_v6":true,"has_v4_tls":true,"has_v6_tls":true,"citizen_lab_global_list":false,"citizen_lab_country_list":null}' >> ${OUTPUTFOLDER}/full-details-v4-and-v6-and-tls-${DATESTR}.json
-/home/timartiny/v4vsv6/domain_resolver_pairs.py ${OUTPUTFOLDER}/full-details-v4-and-v6-and-tls-${DATESTR}.json ${DATESTR}-single-resolvers-country-correct-sorted ${OUTPUTFOLDER}/v4_cartesian_file ${OUTPUTFOLDER}/v6_cartesian_file
+/home/timartiny/v4vsv6/cmd/cartesian/cartesian --domain-file ${OUTPUTFOLDER}/full-details-v4-and-v6-and-tls-${DATESTR}.json --resolver-file ${DATESTR}-single-resolvers-country-correct-sorted --v4-output ${OUTPUTFOLDER}/v4_cartesian_file --v6-output ${OUTPUTFOLDER}/v6_cartesian_file
 
 echo "Running v4 resolvers A Scan"
 date
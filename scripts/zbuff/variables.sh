@@ -1,3 +1,4 @@
 #! /usr/bin/env bash
 DATESTR="mar-14"
 OUTPUTFOLDER=/data/timartiny/v4vsv6/mar-run/1
+COUNTRYDB=/data/timartiny/GeoLite2/GeoLite2-Country/GeoLite2-Country.mmdb
@@ -1,5 +1,10 @@
 package v4vsv6
 
+import (
+	"encoding/json"
+	"sort"
+)
+
 // AddressResult will store information about a specific IP address.
 type AddressResult struct {
 	IP             string `json:"ip,omitempty"`
@@ -9,36 +14,250 @@ type AddressResult struct {
 	ValidControlIP bool   `json:"valid_control_ip,omitempty"`
 	Timestamp      string `json:"timestamp,omitempty"`
 	Error          string `json:"error,omitempty"`
+	// Blockpage is the blockpage fingerprint the address's HTTP response
+	// matched, if any
+	Blockpage *Blockpage `json:"blockpage,omitempty"`
+	// TLS is what was found checking the address's certificate, and why it
+	// failed if it did
+	TLS *TLSResult `json:"tls,omitempty"`
+	// ASN and Prefix are the autonomous system and subnet the address is
+	// allocated from, filled in by checkASNs
+	ASN    uint   `json:"asn,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	// UnexpectedASN is true if none of the control resolvers' answers for the
+	// domain were in the address's ASN
+	UnexpectedASN bool `json:"unexpected_asn,omitempty"`
+}
+
+// Reasons a TLSResult can give for failing, in the order they are checked
+const (
+	TLSFailureStatus   = "status"
+	TLSFailureParse    = "parse"
+	TLSFailureHostname = "hostname"
+	TLSFailureExpired  = "expired"
+	TLSFailureChain    = "untrusted-chain"
+)
+
+// TLSResult is the outcome of checking the certificate an address presented
+// for a domain. The certificate fields are only filled in when one was parsed.
+type TLSResult struct {
+	// Status is the Zgrab2 scan status, e.g. success, connection-timeout or
+	// protocol-error
+	Status string `json:"status"`
+	// Failure is the first reason the certificate wasn't valid, empty if it
+	// was
+	Failure       string `json:"failure,omitempty"`
+	Error         string `json:"error,omitempty"`
+	HostnameValid bool   `json:"hostname_valid"`
+	ChainValid    bool   `json:"chain_valid"`
+	// ChainError is why a chain to a trusted root couldn't be built
+	ChainError string `json:"chain_error,omitempty"`
+	// Roots is the ID of the root bundle the chain was checked against
+	Roots     string   `json:"roots,omitempty"`
+	NotBefore string   `json:"not_before,omitempty"`
+	NotAfter  string   `json:"not_after,omitempty"`
+	Expired   bool     `json:"expired,omitempty"`
+	Subject   string   `json:"subject,omitempty"`
+	Issuer    string   `json:"issuer,omitempty"`
+	SANs      []string `json:"sans,omitempty"`
+	// Fingerprint is the hex encoded SHA-256 of the leaf certificate
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// Valid returns true if the certificate was valid for the domain.
+func (t *TLSResult) Valid() bool {
+	return t != nil && t.Failure == "" && t.HostnameValid && t.ChainValid
+}
+
+// Blockpage identifies the blockpage an address served over HTTP for a domain.
+type Blockpage struct {
+	Fingerprint string `json:"fingerprint"`
+	Vendor      string `json:"vendor,omitempty"`
+	Country     string `json:"country,omitempty"`
+	StatusCode  int    `json:"status_code,omitempty"`
+}
+
+// RoundResult stores the answers a resolver gave for a domain during a single
+// round of measurement. Rounds are numbered from 1, the first round being the
+// initial scan and every following round a re-test.
+type RoundResult struct {
+	Round        int              `json:"round"`
+	Timestamp    string           `json:"timestamp,omitempty"`
+	VantagePoint string           `json:"vantage_point,omitempty"`
+	Results      []*AddressResult `json:"results,omitempty"`
+	// Status is the resolver's response status, e.g. NOERROR, NXDOMAIN or
+	// TIMEOUT
+	Status   string `json:"status,omitempty"`
+	Censored bool   `json:"censored"`
+	// Rule and Reason record which classifier decided Censored, and why
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
 }
 
 // DomainResolverResult stores information on how a particular resolver
 // responded to queries for a particular domain, including A and AAAA record
 // requests
 type DomainResolverResult struct {
-	Domain                   string           `json:"domain"`
-	ResolverIP               string           `json:"resolver_ip"`
-	ResolverCountry          string           `json:"resolver_country"`
-	RequestedAddressType     string           `json:"requested_address_type"`
-	Day1Results              []*AddressResult `json:"day_1_results,omitempty"`
-	Day2Results              []*AddressResult `json:"day_2_results,omitempty"`
-	Day3Results              []*AddressResult `json:"day_3_results,omitempty"`
-	CorrectControlResolution bool             `json:"correct_control_resolution"`
-	CensoredQuery            bool             `json:"censored_query"`
+	Domain                   string         `json:"domain"`
+	ResolverIP               string         `json:"resolver_ip"`
+	ResolverCountry          string         `json:"resolver_country"`
+	RequestedAddressType     string         `json:"requested_address_type"`
+	Rounds                   []*RoundResult `json:"rounds,omitempty"`
+	CorrectControlResolution bool           `json:"correct_control_resolution"`
+	CensoredQuery            bool           `json:"censored_query"`
 }
 
-// AppendResults will take a slice of AddressResults and add non-duplicates to
-// the Results and return the slice, not updating the current Results
-func (drr *DomainResolverResult) AppendResults(newARs []*AddressResult, day int) []*AddressResult {
+// legacyDomainResolverResult is the format DomainResolverResults were written
+// in when only three days of measurements were supported. It is only used to
+// decode old results files.
+type legacyDomainResolverResult struct {
+	Day1Results []*AddressResult `json:"day_1_results,omitempty"`
+	Day2Results []*AddressResult `json:"day_2_results,omitempty"`
+	Day3Results []*AddressResult `json:"day_3_results,omitempty"`
+}
+
+// UnmarshalJSON decodes a DomainResolverResult, converting the old
+// day_1_results, day_2_results and day_3_results fields into Rounds when they
+// are present.
+func (drr *DomainResolverResult) UnmarshalJSON(b []byte) error {
+	// the alias drops the methods so we don't recurse back into UnmarshalJSON
+	type domainResolverResultAlias DomainResolverResult
+	var alias domainResolverResultAlias
+	if err := json.Unmarshal(b, &alias); err != nil {
+		return err
+	}
+	var legacy legacyDomainResolverResult
+	if err := json.Unmarshal(b, &legacy); err != nil {
+		return err
+	}
+	*drr = DomainResolverResult(alias)
+
+	days := [][]*AddressResult{
+		legacy.Day1Results,
+		legacy.Day2Results,
+		legacy.Day3Results,
+	}
+	var last *RoundResult
+	for i, results := range days {
+		if len(results) == 0 || drr.Round(i+1) != nil {
+			continue
+		}
+		// a later day was only ever run when the earlier days were censored,
+		// so only the final day's censorship can differ from true
+		if last != nil {
+			last.Censored = true
+		}
+		last = drr.SetRoundResults(i+1, results)
+	}
+	if last != nil {
+		last.Censored = drr.CensoredQuery
+	}
+
+	return nil
+}
+
+// Round returns the RoundResult for the given round number, or nil if that
+// round has not been recorded.
+func (drr *DomainResolverResult) Round(round int) *RoundResult {
+	for _, rr := range drr.Rounds {
+		if rr.Round == round {
+			return rr
+		}
+	}
+
+	return nil
+}
+
+// RoundResults returns the AddressResults recorded for the given round, or nil
+// if that round has not been recorded.
+func (drr *DomainResolverResult) RoundResults(round int) []*AddressResult {
+	rr := drr.Round(round)
+	if rr == nil {
+		return nil
+	}
+
+	return rr.Results
+}
+
+// SetRoundResults will replace the results of the given round, creating the
+// round if it doesn't exist yet, and return the updated RoundResult. Rounds are
+// kept sorted by round number.
+func (drr *DomainResolverResult) SetRoundResults(
+	round int,
+	results []*AddressResult,
+) *RoundResult {
+	rr := drr.Round(round)
+	if rr == nil {
+		rr = &RoundResult{Round: round}
+		drr.AddRound(rr)
+	}
+	rr.Results = results
+
+	return rr
+}
+
+// AddRound will add a RoundResult to the history, replacing any existing round
+// with the same number. Rounds are kept sorted by round number.
+func (drr *DomainResolverResult) AddRound(rr *RoundResult) {
+	for i, existing := range drr.Rounds {
+		if existing.Round == rr.Round {
+			drr.Rounds[i] = rr
+			return
+		}
+	}
+	drr.Rounds = append(drr.Rounds, rr)
+	sort.Slice(drr.Rounds, func(i, j int) bool {
+		return drr.Rounds[i].Round < drr.Rounds[j].Round
+	})
+}
+
+// LastRound returns the highest numbered round recorded, or nil if there are
+// no rounds.
+func (drr *DomainResolverResult) LastRound() *RoundResult {
+	if len(drr.Rounds) == 0 {
+		return nil
+	}
+
+	return drr.Rounds[len(drr.Rounds)-1]
+}
+
+// AllResults returns the AddressResults of every round in round order.
+func (drr *DomainResolverResult) AllResults() []*AddressResult {
 	var ret []*AddressResult
-	switch day {
-	case 1:
-		ret = drr.Day1Results
-	case 2:
-		ret = drr.Day2Results
-	default:
-		ret = drr.Day3Results
+	for _, rr := range drr.Rounds {
+		ret = append(ret, rr.Results...)
+	}
+
+	return ret
+}
+
+// CensoredRounds returns the number of rounds that were considered censored
+// and the total number of rounds recorded.
+func (drr *DomainResolverResult) CensoredRounds() (int, int) {
+	var censored int
+	for _, rr := range drr.Rounds {
+		if rr.Censored {
+			censored++
+		}
 	}
 
+	return censored, len(drr.Rounds)
+}
+
+// CensoredInAtLeast returns true if at least k of the recorded rounds were
+// considered censored.
+func (drr *DomainResolverResult) CensoredInAtLeast(k int) bool {
+	censored, _ := drr.CensoredRounds()
+	return censored >= k
+}
+
+// AppendResults will take a slice of AddressResults and add non-duplicates to
+// the given round's Results and return the slice, not updating the current
+// Results
+func (drr *DomainResolverResult) AppendResults(newARs []*AddressResult, round int) []*AddressResult {
+	var ret []*AddressResult
+	ret = append(ret, drr.RoundResults(round)...)
+
 	existingIPs := make(map[string]bool)
 
 	for _, ar := range ret {
@@ -1,6 +1,7 @@
 package v4vsv6
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 )
@@ -17,7 +18,7 @@ func TestAppendAResults(t *testing.T) {
 		ar.Domain = "fake-domain"
 		ars = append(ars, ar)
 	}
-	drr.Day1Results = ars
+	drr.SetRoundResults(1, ars)
 
 	newArs := make([]*AddressResult, 0)
 	for i := 0; i < 1; i++ {
@@ -36,7 +37,7 @@ func TestAppendAResults(t *testing.T) {
 		}
 		t.Fatalf("")
 	}
-	drr.Day1Results = newAs
+	drr.SetRoundResults(1, newAs)
 
 	oldArs := make([]*AddressResult, 0)
 	for i := 0; i < 4; i++ {
@@ -47,8 +48,43 @@ func TestAppendAResults(t *testing.T) {
 	}
 
 	oldAs := drr.AppendResults(oldArs, 1)
-	if len(oldAs) != len(drr.Day1Results) {
+	if len(oldAs) != len(drr.RoundResults(1)) {
 		t.Fatalf("Appending old AddressResults shouldn't add anythign new\n")
 	}
 
 }
+
+// TestUnmarshalLegacyDays will make sure results files written with the old
+// day_N_results fields are decoded into rounds
+func TestUnmarshalLegacyDays(t *testing.T) {
+	line := `{"domain":"fake-domain","resolver_ip":"1.1.1.1",` +
+		`"day_1_results":[{"ip":"2.2.2.2","domain":"fake-domain"}],` +
+		`"day_2_results":[{"ip":"3.3.3.3","domain":"fake-domain"}],` +
+		`"censored_query":false}`
+	var drr DomainResolverResult
+	if err := json.Unmarshal([]byte(line), &drr); err != nil {
+		t.Fatalf("Error unmarshaling legacy line: %v\n", err)
+	}
+	if len(drr.Rounds) != 2 {
+		t.Fatalf("Expected 2 rounds, got %d\n", len(drr.Rounds))
+	}
+	if drr.RoundResults(2)[0].IP != "3.3.3.3" {
+		t.Fatalf("Round 2 has the wrong results: %+v\n", drr.RoundResults(2)[0])
+	}
+	censored, total := drr.CensoredRounds()
+	if censored != 1 || total != 2 {
+		t.Fatalf("Expected censorship in 1 of 2 rounds, got %d of %d\n", censored, total)
+	}
+
+	bs, err := json.Marshal(&drr)
+	if err != nil {
+		t.Fatalf("Error marshaling drr: %v\n", err)
+	}
+	var again DomainResolverResult
+	if err = json.Unmarshal(bs, &again); err != nil {
+		t.Fatalf("Error unmarshaling rounds line: %v\n", err)
+	}
+	if len(again.Rounds) != 2 || !again.Round(1).Censored {
+		t.Fatalf("Rounds didn't survive a round trip: %s\n", bs)
+	}
+}